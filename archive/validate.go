@@ -0,0 +1,220 @@
+package archive
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+const (
+	ManifestName = "manifest.json"
+
+	maxManifestSize = 1 << 20
+)
+
+// Limits bound the unpacked contents of an archive. Sizes declared in zip headers are
+// not trusted: entries are decompressed and counted.
+type Limits struct {
+	MaxEntrySize    int64
+	MaxUnpackedSize int64
+	MaxEntries      int
+}
+
+// Manifest is the required manifest.json at the root of every quest archive.
+type Manifest struct {
+	FormatVersion int    `json:"format_version"`
+	Name          string `json:"name"`
+	Entry         string `json:"entry"`
+}
+
+// File describes a regular file of the archive by its canonical name, unpacked size and SHA-256 (hex).
+type File struct {
+	Path   string
+	Size   int64
+	SHA256 string
+}
+
+// ValidationError is returned when the archive itself is unacceptable,
+// as opposed to errors of reading it.
+type ValidationError struct {
+	msg      string
+	TooLarge bool
+}
+
+func (err *ValidationError) Error() string {
+	return err.msg
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &ValidationError{msg: fmt.Sprintf(format, args...)}
+}
+
+func tooLarge(format string, args ...interface{}) error {
+	return &ValidationError{msg: fmt.Sprintf(format, args...), TooLarge: true}
+}
+
+// Validate checks that r holds a well-formed zip archive with safe entry names,
+// entries within limits and a valid manifest. Every entry is decompressed, so
+// checksum errors are detected as well; the regular files are returned with their hashes.
+func Validate(r io.ReaderAt, size int64, limits Limits) (*Manifest, []File, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, nil, invalid("archive is not a valid zip file: %v", err)
+	}
+	if limits.MaxEntries > 0 && len(zr.File) > limits.MaxEntries {
+		return nil, nil, tooLarge("archive has %d entries, at most %d allowed", len(zr.File), limits.MaxEntries)
+	}
+
+	var manifest *Manifest
+	var unpacked int64
+	files := make([]File, 0, len(zr.File))
+	seen := make(map[string]bool, len(zr.File))
+	for _, f := range zr.File {
+		name, err := CleanName(f.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if f.Mode()&^0777 != 0 && !f.FileInfo().IsDir() {
+			return nil, nil, invalid("entry %q is not a regular file", f.Name)
+		}
+		if seen[name] {
+			return nil, nil, invalid("entry %q is duplicated", f.Name)
+		}
+		seen[name] = true
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		limit := limits.MaxEntrySize
+		if name == ManifestName && (limit <= 0 || limit > maxManifestSize) {
+			limit = maxManifestSize
+		}
+		content, file, err := readEntry(f, limit, name == ManifestName)
+		if err != nil {
+			return nil, nil, err
+		}
+		file.Path = name
+		files = append(files, file)
+		unpacked += file.Size
+		if limits.MaxUnpackedSize > 0 && unpacked > limits.MaxUnpackedSize {
+			return nil, nil, tooLarge("unpacked archive is larger than %d bytes", limits.MaxUnpackedSize)
+		}
+
+		if name == ManifestName {
+			if manifest, err = parseManifest(content); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if manifest == nil {
+		return nil, nil, invalid("archive has no %s", ManifestName)
+	}
+	if manifest.Entry != "" && !seen[manifest.Entry] {
+		return nil, nil, invalid("manifest entry %q is not in the archive", manifest.Entry)
+	}
+	return manifest, files, nil
+}
+
+// Walk calls fn with the canonical name and the unpacked content of every regular file
+// of an archive which has already passed Validate.
+func Walk(r io.ReaderAt, size int64, fn func(name string, content io.Reader) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name, err := CleanName(f.Name)
+		if err != nil {
+			return err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CleanName rejects absolute names, names escaping the archive root and
+// Windows-style names, and returns the name in canonical form.
+func CleanName(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, "\\\x00") || strings.Contains(name, ":") {
+		return "", invalid("entry name %q is not allowed", name)
+	}
+	if path.IsAbs(name) {
+		return "", invalid("entry %q has an absolute path", name)
+	}
+	for _, element := range strings.Split(strings.TrimSuffix(name, "/"), "/") {
+		if element == ".." {
+			return "", invalid("entry %q points outside of the archive", name)
+		}
+	}
+	return path.Clean(name), nil
+}
+
+// readEntry decompresses the entry counting its real size and hash; the content is kept only if keep is set.
+func readEntry(f *zip.File, limit int64, keep bool) ([]byte, File, error) {
+	if limit > 0 && f.UncompressedSize64 > uint64(limit) {
+		return nil, File{}, tooLarge("entry %q is larger than %d bytes", f.Name, limit)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, File{}, invalid("entry %q can not be read: %v", f.Name, err)
+	}
+	defer rc.Close()
+
+	var src io.Reader = rc
+	if limit > 0 {
+		src = io.LimitReader(rc, limit+1)
+	}
+	hash := sha256.New()
+	src = io.TeeReader(src, hash)
+	var content []byte
+	var n int64
+	if keep {
+		content, err = ioutil.ReadAll(src)
+		n = int64(len(content))
+	} else {
+		n, err = io.Copy(ioutil.Discard, src)
+	}
+	if err != nil {
+		return nil, File{}, invalid("entry %q is corrupted: %v", f.Name, err)
+	}
+	if limit > 0 && n > limit {
+		return nil, File{}, tooLarge("entry %q is larger than %d bytes", f.Name, limit)
+	}
+	return content, File{Size: n, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+func parseManifest(data []byte) (*Manifest, error) {
+	manifest := new(Manifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, invalid("%s is not valid JSON: %v", ManifestName, err)
+	}
+	if manifest.FormatVersion <= 0 {
+		return nil, invalid("%s must contain positive \"format_version\"", ManifestName)
+	}
+	if manifest.Entry != "" {
+		entry, err := CleanName(manifest.Entry)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Entry = entry
+	}
+	return manifest, nil
+}
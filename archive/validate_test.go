@@ -0,0 +1,145 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"strings"
+	"testing"
+)
+
+const (
+	manifestSample = `{"format_version": 1, "name": "quest", "entry": "scene/main.json"}`
+)
+
+type entry struct {
+	name    string
+	content string
+}
+
+type ValidateTestSuite struct {
+	suite.Suite
+	limits Limits
+}
+
+func (s *ValidateTestSuite) SetupTest() {
+	s.limits = Limits{MaxEntrySize: 100, MaxUnpackedSize: 200, MaxEntries: 5}
+}
+
+func (s *ValidateTestSuite) zip(entries ...entry) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, e := range entries {
+		f, err := w.Create(e.name)
+		s.Require().NoError(err)
+		_, err = f.Write([]byte(e.content))
+		s.Require().NoError(err)
+	}
+	s.Require().NoError(w.Close())
+	return buf.Bytes()
+}
+
+func (s *ValidateTestSuite) validate(data []byte) (*Manifest, error) {
+	manifest, _, err := Validate(bytes.NewReader(data), int64(len(data)), s.limits)
+	return manifest, err
+}
+
+func (s *ValidateTestSuite) requireInvalid(err error, tooLarge bool) {
+	s.Require().Error(err)
+	validationErr, ok := err.(*ValidationError)
+	s.Require().True(ok, err.Error())
+	s.Equal(tooLarge, validationErr.TooLarge, err.Error())
+}
+
+func (s *ValidateTestSuite) TestValid() {
+	manifest, err := s.validate(s.zip(
+		entry{ManifestName, manifestSample},
+		entry{"scene/", ""},
+		entry{"scene/main.json", "{}"},
+	))
+	s.Require().NoError(err)
+	s.Equal(&Manifest{FormatVersion: 1, Name: "quest", Entry: "scene/main.json"}, manifest)
+}
+
+func (s *ValidateTestSuite) TestFiles() {
+	data := s.zip(
+		entry{ManifestName, manifestSample},
+		entry{"scene/", ""},
+		entry{"./scene/main.json", "{}"},
+	)
+	_, files, err := Validate(bytes.NewReader(data), int64(len(data)), s.limits)
+	s.Require().NoError(err)
+	s.Equal([]File{
+		{Path: ManifestName, Size: int64(len(manifestSample)), SHA256: "677cb12805574ac0c2a35e7013be1e4f90a616aa6f41f7322a442b12aa55541d"},
+		{Path: "scene/main.json", Size: 2, SHA256: "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"},
+	}, files)
+
+	var names []string
+	err = Walk(bytes.NewReader(data), int64(len(data)), func(name string, content io.Reader) error {
+		names = append(names, name)
+		return nil
+	})
+	s.Require().NoError(err)
+	s.Equal([]string{ManifestName, "scene/main.json"}, names)
+}
+
+func (s *ValidateTestSuite) TestNotZip() {
+	_, err := s.validate([]byte("definitely not a zip"))
+	s.requireInvalid(err, false)
+}
+
+func (s *ValidateTestSuite) TestNoManifest() {
+	_, err := s.validate(s.zip(entry{"scene/main.json", "{}"}))
+	s.requireInvalid(err, false)
+}
+
+func (s *ValidateTestSuite) TestInvalidManifest() {
+	_, err := s.validate(s.zip(entry{ManifestName, "{"}))
+	s.requireInvalid(err, false)
+
+	_, err = s.validate(s.zip(entry{ManifestName, `{"name": "quest"}`}))
+	s.requireInvalid(err, false)
+
+	_, err = s.validate(s.zip(entry{ManifestName, `{"format_version": 1, "entry": "missing.json"}`}))
+	s.requireInvalid(err, false)
+}
+
+func (s *ValidateTestSuite) TestPathTraversal() {
+	for _, name := range []string{"../evil", "scene/../../evil", "/etc/passwd", "..\\evil", "C:/evil", "a/.."} {
+		_, err := s.validate(s.zip(entry{ManifestName, manifestSample}, entry{name, "x"}))
+		s.requireInvalid(err, false)
+	}
+}
+
+func (s *ValidateTestSuite) TestDuplicate() {
+	_, err := s.validate(s.zip(entry{ManifestName, manifestSample}, entry{"a", "x"}, entry{"./a", "y"}))
+	s.requireInvalid(err, false)
+}
+
+func (s *ValidateTestSuite) TestEntryTooLarge() {
+	_, err := s.validate(s.zip(entry{ManifestName, manifestSample}, entry{"big", strings.Repeat("x", 101)}))
+	s.requireInvalid(err, true)
+}
+
+func (s *ValidateTestSuite) TestUnpackedTooLarge() {
+	_, err := s.validate(s.zip(
+		entry{ManifestName, manifestSample},
+		entry{"a", strings.Repeat("x", 90)},
+		entry{"b", strings.Repeat("x", 90)},
+	))
+	s.requireInvalid(err, true)
+}
+
+func (s *ValidateTestSuite) TestTooManyEntries() {
+	entries := []entry{{ManifestName, manifestSample}}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		entries = append(entries, entry{name, "x"})
+	}
+	_, err := s.validate(s.zip(entries...))
+	s.requireInvalid(err, true)
+}
+
+func TestValidateTestSuite(t *testing.T) {
+	suite.Run(t, new(ValidateTestSuite))
+}
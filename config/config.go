@@ -5,6 +5,45 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
+)
+
+const (
+	defaultAccessExpireMinutes = 15
+	defaultRefreshExpireDays   = 30
+
+	defaultMaxArchiveMB      = 200
+	defaultMaxArchiveEntryMB = 100
+	defaultMaxUnpackedMB     = 1024
+	defaultMaxArchiveEntries = 10000
+
+	defaultPresignMinutes = 60
+
+	defaultNearbyRadiusM   = 5000
+	defaultMaxNearbyRadius = 50000
+	defaultMaxNearbyCount  = 100
+
+	defaultMaxFixAccuracyM = 50
+	defaultMaxSpeedMS      = 50
+	defaultMaxFixAgeS      = 300
+	defaultMaxClockSkewS   = 30
+
+	defaultMaxQuestScore           = 1000
+	defaultHintPenalty             = 100
+	defaultTimePenaltyPerMinute    = 5
+	defaultOptionalCheckpointBonus = 50
+
+	defaultRatingPriorMean = 3
+	defaultMinMark         = 1
+	defaultMaxMark         = 5
+	defaultMarkStep        = 0.5
+
+	defaultEventPollIntervalMS = 1000
+	defaultEventHeartbeatS     = 15
+
+	defaultMaxPhotoMB = 5
+
+	megabyte = 1 << 20
 )
 
 func ReadConf(r io.Reader) (*Conf, error) {
@@ -14,17 +53,44 @@ func ReadConf(r io.Reader) (*Conf, error) {
 }
 
 type Conf struct {
-	Log         string      `json:"log"`
-	PortEnvVar  string      `json:"port_env_var"`
-	DefaultPort int         `json:"default_port"`
-	Auth        AuthConfig  `json:"auth"`
-	DB          DBConfig    `json:"db"`
-	Logic       LogicConfig `json:"logic"`
+	Log         string        `json:"log"`
+	PortEnvVar  string        `json:"port_env_var"`
+	DefaultPort int           `json:"default_port"`
+	Auth        AuthConfig    `json:"auth"`
+	DB          DBConfig      `json:"db"`
+	Logic       LogicConfig   `json:"logic"`
+	Storage     StorageConfig `json:"storage"`
 }
 
 type AuthConfig struct {
-	TokenKey   string `json:"token_key"`
-	ExpireDays int    `json:"expire_days"`
+	TokenKey string `json:"token_key"`
+	// ExpireDays used to be the lifetime of access tokens. Access tokens are short-lived now
+	// (AccessExpireMinutes), and the old value only serves as RefreshExpireDays when that is not set.
+	ExpireDays          int                `json:"expire_days"`
+	RefreshExpireDays   int                `json:"refresh_expire_days"`
+	AccessExpireMinutes int                `json:"access_expire_minutes"`
+	PasswordHash        PasswordHashConfig `json:"password_hash"`
+	SigningKeys         []SigningKeyConfig `json:"signing_keys"`
+	ActiveKeyID         string             `json:"active_kid"`
+	HS256GraceUntil     string             `json:"hs256_grace_until"` // RFC3339, HS256 tokens are accepted until this moment
+}
+
+// SigningKeyConfig describes an asymmetric JWT key. Every configured key is published
+// and accepted for verification, only the one named by AuthConfig.ActiveKeyID signs new tokens.
+// Retired keys may be given by PublicKeyFile alone; the active key needs PrivateKeyFile.
+type SigningKeyConfig struct {
+	ID             string `json:"kid"`
+	Algorithm      string `json:"alg"`
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKeyFile  string `json:"public_key_file"`
+}
+
+type PasswordHashConfig struct {
+	Algorithm     string `json:"algorithm"`
+	BcryptCost    int    `json:"bcrypt_cost"`
+	Argon2Time    uint32 `json:"argon2_time"`
+	Argon2Memory  uint32 `json:"argon2_memory"`
+	Argon2Threads uint8  `json:"argon2_threads"`
 }
 
 type DBConfig struct {
@@ -36,16 +102,147 @@ type DBConfig struct {
 	Password           string `json:"password"`
 	DBName             string `json:"db_name"`
 	AuthStringTemplate string `json:"auth_string_template"`
+	CheckSchemaVersion bool   `json:"check_schema_version"`
+	// Spatial selects how nearby quests are searched: "postgis", "index" (in-memory)
+	// or "auto" (default), which uses PostGIS when the extension is installed.
+	Spatial string `json:"spatial"`
 }
 
 type LogicConfig struct {
-	QuestDataTemplate string `json:"quest_data_template"`
+	Archive    ArchiveConfig    `json:"archive"`
+	Nearby     NearbyConfig     `json:"nearby"`
+	Proof      ProofConfig      `json:"proof"`
+	Hints      HintConfig       `json:"hints"`
+	Score      ScoreConfig      `json:"score"`
+	Events     EventConfig      `json:"events"`
+	Rating     RatingConfig     `json:"rating"`
+	Reviews    ReviewConfig     `json:"reviews"`
+	Moderation ModerationConfig `json:"moderation"`
+}
+
+// StorageConfig selects where quest assets are kept: "fs" (default) or "s3".
+// Archives are served by the server itself unless RedirectDownloads is set, in which case
+// authorized clients are redirected to the URL provided by the backend.
+type StorageConfig struct {
+	Type              string          `json:"type"`
+	FS                FSStorageConfig `json:"fs"`
+	S3                S3StorageConfig `json:"s3"`
+	RedirectDownloads bool            `json:"redirect_downloads"`
+}
+
+type FSStorageConfig struct {
+	Dir     string `json:"dir"`
+	BaseURL string `json:"base_url"`
+}
+
+// S3StorageConfig describes any S3-compatible service. Clients download objects
+// by presigned URLs valid for PresignMinutes.
+type S3StorageConfig struct {
+	Endpoint       string `json:"endpoint"`
+	Region         string `json:"region"`
+	Bucket         string `json:"bucket"`
+	Prefix         string `json:"prefix"`
+	AccessKey      string `json:"access_key"`
+	SecretKey      string `json:"secret_key"`
+	PathStyle      bool   `json:"path_style"`
+	PresignMinutes int    `json:"presign_minutes"`
+}
+
+// ArchiveConfig limits uploaded quest archives. Zero values mean defaults.
+type ArchiveConfig struct {
+	MaxSizeMB     int64 `json:"max_size_mb"`
+	MaxEntryMB    int64 `json:"max_entry_mb"`
+	MaxUnpackedMB int64 `json:"max_unpacked_mb"`
+	MaxEntries    int   `json:"max_entries"`
+}
+
+// NearbyConfig limits nearby quest search. Zero values mean defaults.
+type NearbyConfig struct {
+	DefaultRadiusM int `json:"default_radius_m"`
+	MaxRadiusM     int `json:"max_radius_m"`
+	MaxCount       int `json:"max_count"`
+}
+
+// ProofConfig sets plausibility limits for GPS fixes sent as checkpoint proofs:
+// the fix accuracy, the speed between consecutive fixes of a player, how old a fix
+// may be and how far in the future the player clock may run. Zero values mean defaults.
+type ProofConfig struct {
+	MaxAccuracyM  int `json:"max_accuracy_m"`
+	MaxSpeedMS    int `json:"max_speed_m_s"`
+	MaxFixAgeS    int `json:"max_fix_age_s"`
+	MaxClockSkewS int `json:"max_clock_skew_s"`
+}
+
+// HintConfig sets the penalty of hints whose authors did not set one. Zero values mean defaults.
+type HintConfig struct {
+	DefaultPenalty int `json:"default_penalty"`
+}
+
+// ScoreConfig sets how a finished quest run is scored: MaxScore less hint penalties and
+// TimePenaltyPerMinute for every full minute of the run plus OptionalCheckpointBonus for
+// every optional checkpoint reached. Zero values mean defaults.
+type ScoreConfig struct {
+	MaxScore                int `json:"max_score"`
+	TimePenaltyPerMinute    int `json:"time_penalty_per_minute"`
+	OptionalCheckpointBonus int `json:"optional_checkpoint_bonus"`
+}
+
+// EventConfig sets how often team event streams look for new events and how long a stream
+// may stay silent before a heartbeat comment is sent. Zero values mean defaults.
+type EventConfig struct {
+	PollIntervalMS int `json:"poll_interval_ms"`
+	HeartbeatS     int `json:"heartbeat_s"`
+}
+
+// RatingConfig sets the weighted rating of quests: PriorWeight imaginary marks equal to
+// PriorMean are added to the real ones. Zero PriorWeight gives the plain mean of the marks;
+// zero PriorMean means the default. Ratings are stored, so run `rating recompute` after
+// changing these values.
+// Marks must lie in [MinMark, MaxMark] and differ from MinMark by a multiple of MarkStep;
+// the bounds are used only if MaxMark is greater than MinMark.
+type RatingConfig struct {
+	PriorWeight float64 `json:"prior_weight"`
+	PriorMean   float64 `json:"prior_mean"`
+	MinMark     float64 `json:"min_mark"`
+	MaxMark     float64 `json:"max_mark"`
+	MarkStep    float64 `json:"mark_step"`
+}
+
+// ModerationConfig sets the automatic filter of user texts. WordLists maps language tags to
+// files with one banned word per line; the list under "*" applies to texts in every language.
+// Links are flagged unless AllowLinks is set.
+type ModerationConfig struct {
+	WordLists  map[string]string `json:"word_lists"`
+	AllowLinks bool              `json:"allow_links"`
+}
+
+// ReviewConfig limits the size of photos attached to quest reviews. Zero values mean defaults.
+type ReviewConfig struct {
+	MaxPhotoMB int64 `json:"max_photo_mb"`
 }
 
 func (conf AuthConfig) GetTokenKey() []byte {
 	return []byte(conf.TokenKey) // TODO use secure service instead of bicycles
 }
 
+func (conf AuthConfig) GetAccessTTL() time.Duration {
+	if conf.AccessExpireMinutes <= 0 {
+		return defaultAccessExpireMinutes * time.Minute
+	}
+	return time.Duration(conf.AccessExpireMinutes) * time.Minute
+}
+
+func (conf AuthConfig) GetRefreshTTL() time.Duration {
+	days := conf.RefreshExpireDays
+	if days <= 0 {
+		days = conf.ExpireDays
+	}
+	if days <= 0 {
+		days = defaultRefreshExpireDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
 func (conf DBConfig) GetAuthStr() string {
 	return fmt.Sprintf(conf.AuthStringTemplate, conf.Host, conf.Port, conf.User, conf.Password, conf.DBName)
 }
@@ -53,3 +250,127 @@ func (conf DBConfig) GetAuthStr() string {
 func (conf DBConfig) GetEnvAuthString() string {
 	return os.Getenv(conf.EnvVar)
 }
+
+func (conf ArchiveConfig) GetMaxSize() int64 {
+	return megabytesOrDefault(conf.MaxSizeMB, defaultMaxArchiveMB)
+}
+
+func (conf ArchiveConfig) GetMaxEntrySize() int64 {
+	return megabytesOrDefault(conf.MaxEntryMB, defaultMaxArchiveEntryMB)
+}
+
+func (conf ArchiveConfig) GetMaxUnpackedSize() int64 {
+	return megabytesOrDefault(conf.MaxUnpackedMB, defaultMaxUnpackedMB)
+}
+
+func (conf ArchiveConfig) GetMaxEntries() int {
+	if conf.MaxEntries <= 0 {
+		return defaultMaxArchiveEntries
+	}
+	return conf.MaxEntries
+}
+
+func (conf NearbyConfig) GetDefaultRadius() float64 {
+	return float64(intOrDefault(conf.DefaultRadiusM, defaultNearbyRadiusM))
+}
+
+func (conf NearbyConfig) GetMaxRadius() float64 {
+	return float64(intOrDefault(conf.MaxRadiusM, defaultMaxNearbyRadius))
+}
+
+func (conf NearbyConfig) GetMaxCount() int {
+	return intOrDefault(conf.MaxCount, defaultMaxNearbyCount)
+}
+
+func (conf ProofConfig) GetMaxAccuracy() float64 {
+	return float64(intOrDefault(conf.MaxAccuracyM, defaultMaxFixAccuracyM))
+}
+
+func (conf ProofConfig) GetMaxSpeed() float64 {
+	return float64(intOrDefault(conf.MaxSpeedMS, defaultMaxSpeedMS))
+}
+
+func (conf ProofConfig) GetMaxFixAge() time.Duration {
+	return time.Duration(intOrDefault(conf.MaxFixAgeS, defaultMaxFixAgeS)) * time.Second
+}
+
+func (conf ProofConfig) GetMaxClockSkew() time.Duration {
+	return time.Duration(intOrDefault(conf.MaxClockSkewS, defaultMaxClockSkewS)) * time.Second
+}
+
+func (conf HintConfig) GetDefaultPenalty() int {
+	return intOrDefault(conf.DefaultPenalty, defaultHintPenalty)
+}
+
+func (conf EventConfig) GetPollInterval() time.Duration {
+	return time.Duration(intOrDefault(conf.PollIntervalMS, defaultEventPollIntervalMS)) * time.Millisecond
+}
+
+func (conf EventConfig) GetHeartbeat() time.Duration {
+	return time.Duration(intOrDefault(conf.HeartbeatS, defaultEventHeartbeatS)) * time.Second
+}
+
+func (conf RatingConfig) GetPriorWeight() float64 {
+	if conf.PriorWeight < 0 {
+		return 0
+	}
+	return conf.PriorWeight
+}
+
+func (conf RatingConfig) GetPriorMean() float64 {
+	if conf.PriorMean == 0 {
+		return defaultRatingPriorMean
+	}
+	return conf.PriorMean
+}
+
+func (conf RatingConfig) GetMarkBounds() (float64, float64) {
+	if conf.MaxMark <= conf.MinMark {
+		return defaultMinMark, defaultMaxMark
+	}
+	return conf.MinMark, conf.MaxMark
+}
+
+func (conf RatingConfig) GetMarkStep() float64 {
+	if conf.MarkStep <= 0 {
+		return defaultMarkStep
+	}
+	return conf.MarkStep
+}
+
+func (conf ReviewConfig) GetMaxPhotoSize() int64 {
+	return megabytesOrDefault(conf.MaxPhotoMB, defaultMaxPhotoMB)
+}
+
+func (conf ScoreConfig) GetMaxScore() int {
+	return intOrDefault(conf.MaxScore, defaultMaxQuestScore)
+}
+
+func (conf ScoreConfig) GetTimePenaltyPerMinute() int {
+	return intOrDefault(conf.TimePenaltyPerMinute, defaultTimePenaltyPerMinute)
+}
+
+func (conf ScoreConfig) GetOptionalCheckpointBonus() int {
+	return intOrDefault(conf.OptionalCheckpointBonus, defaultOptionalCheckpointBonus)
+}
+
+func intOrDefault(value, defaultValue int) int {
+	if value <= 0 {
+		return defaultValue
+	}
+	return value
+}
+
+func megabytesOrDefault(value, defaultValue int64) int64 {
+	if value <= 0 {
+		value = defaultValue
+	}
+	return value * megabyte
+}
+
+func (conf S3StorageConfig) GetPresignTTL() time.Duration {
+	if conf.PresignMinutes <= 0 {
+		return defaultPresignMinutes * time.Minute
+	}
+	return time.Duration(conf.PresignMinutes) * time.Minute
+}
@@ -0,0 +1,378 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/lib/pq"
+	"net/http"
+	"time"
+)
+
+const (
+	// runColumns are the columns of quest_attempt read by runScanner
+	runColumns = `
+		started_at, CASE WHEN outcome = 'finished' THEN ended_at END, COALESCE(elapsed_s, 0),
+		hints_used, hint_penalty, optional_reached, COALESCE(score, 0)
+	`
+	// attemptColumns are the columns read by scanAttempt
+	attemptColumns = `id, quest_id, COALESCE(team_id, 0), outcome, ended_at, ` + runColumns
+	// ownAttempt matches the attempts of user $1 in quest $2: the solo attempts of the user and
+	// the team attempts the user has played in
+	ownAttempt = `
+		quest_id = $2 AND (
+			team_id IS NULL AND user_id = $1 OR id IN (SELECT attempt_id FROM quest_attempt_member WHERE user_id = $1)
+		)
+	`
+	// latestAttempt selects the latest attempt of user $1 in quest $2; players see the
+	// progress of this attempt until they start a new one
+	latestAttempt = `(SELECT id FROM quest_attempt WHERE ` + ownAttempt + ` ORDER BY id DESC LIMIT 1)`
+
+	getActiveAttempt = `
+		SELECT id FROM quest_attempt WHERE ` + ownAttempt + ` AND outcome = 'active' ORDER BY id DESC LIMIT 1 FOR UPDATE
+	`
+	getActiveAttemptInfo = `
+		SELECT ` + attemptColumns + ` FROM quest_attempt WHERE ` + ownAttempt + ` AND outcome = 'active'
+		ORDER BY id DESC LIMIT 1
+	`
+	// insertAttempt also links the user to the quest so that the quest can be marked; nothing
+	// is inserted while the user plays the quest in a team
+	insertAttempt = `
+		WITH link AS (
+			INSERT INTO quest_user_link (user_id, quest_id)
+			SELECT $1, id FROM quest WHERE id = $2 AND deleted_at IS NULL
+			ON CONFLICT ON CONSTRAINT ux_user_id_quest_id DO NOTHING
+		)
+		INSERT INTO quest_attempt (user_id, quest_id)
+		SELECT $1, id FROM quest WHERE id = $2 AND deleted_at IS NULL AND NOT EXISTS (
+			SELECT 1 FROM quest_attempt WHERE ` + ownAttempt + ` AND team_id IS NOT NULL AND outcome = 'active'
+		)
+	`
+	// an active attempt is returned as is
+	onActiveAttempt = `
+		ON CONFLICT (user_id, quest_id) WHERE outcome = 'active' AND team_id IS NULL
+		DO UPDATE SET outcome = quest_attempt.outcome
+	`
+	startAttempt = insertAttempt + onActiveAttempt + `RETURNING ` + attemptColumns
+	// nothing is inserted if the user has ever attempted the quest or the quest needs a team
+	startFirstAttempt = insertAttempt + `
+		AND NOT EXISTS (SELECT 1 FROM quest_attempt WHERE ` + ownAttempt + `)
+		AND (min_team_size IS NULL OR min_team_size <= 1)
+	` + onActiveAttempt + `RETURNING id`
+
+	// the team row is locked so that members do not change while a team attempt is started
+	lockTeamOfMember = `
+		SELECT t.id FROM team AS t JOIN team_member AS m ON m.team_id = t.id
+		WHERE t.id = $1 AND m.user_id = $2
+		FOR UPDATE OF t
+	`
+	getTeamActiveAttempt = `
+		SELECT ` + attemptColumns + ` FROM quest_attempt WHERE team_id = $1 AND quest_id = $2 AND outcome = 'active'
+	`
+	// countTeamPlayers returns the size of team $1 and how many of its members play quest $2 already
+	countTeamPlayers = `
+		SELECT count(*), count(*) FILTER (WHERE EXISTS (
+			SELECT 1 FROM quest_attempt AS a
+			WHERE a.quest_id = $2 AND a.outcome = 'active' AND (
+				a.team_id IS NULL AND a.user_id = m.user_id
+				OR a.id IN (SELECT attempt_id FROM quest_attempt_member WHERE user_id = m.user_id)
+			)
+		))
+		FROM team_member AS m WHERE m.team_id = $1
+	`
+	startTeamAttempt = `
+		WITH attempt AS (
+			INSERT INTO quest_attempt (user_id, quest_id, team_id) VALUES ($1, $2, $3)
+			RETURNING *
+		), member AS (
+			INSERT INTO quest_attempt_member (attempt_id, user_id)
+			SELECT attempt.id, m.user_id FROM attempt, team_member AS m WHERE m.team_id = $3
+		), link AS (
+			INSERT INTO quest_user_link (user_id, quest_id)
+			SELECT user_id, $2 FROM team_member WHERE team_id = $3
+			ON CONFLICT ON CONSTRAINT ux_user_id_quest_id DO NOTHING
+		)
+		SELECT ` + attemptColumns + ` FROM attempt
+	`
+
+	abandonAttempt = `
+		UPDATE quest_attempt SET outcome = 'abandoned', ended_at = now()
+		WHERE id = $3 AND ` + ownAttempt + ` AND outcome = 'active'
+		RETURNING ` + attemptColumns
+	getAttemptOutcome = `SELECT outcome FROM quest_attempt WHERE id = $3 AND ` + ownAttempt
+	getAttempts       = `SELECT ` + attemptColumns + ` FROM quest_attempt WHERE ` + ownAttempt + ` ORDER BY id DESC`
+	// only the best finished solo attempt of every player is ranked; runs with equal score and
+	// time share the rank and the caller's run is returned also when it is below the limit. Runs
+	// started before their start time was recorded are not ranked.
+	getLeaderboard = `
+		WITH best AS (
+			SELECT DISTINCT ON (user_id)
+				user_id, outcome, started_at, ended_at, elapsed_s, hints_used, hint_penalty, optional_reached, score
+			FROM quest_attempt
+			WHERE quest_id = $1 AND team_id IS NULL AND outcome = 'finished' AND elapsed_s IS NOT NULL AND score IS NOT NULL
+				AND ($2::TIMESTAMPTZ IS NULL OR ended_at >= $2)
+				AND (NOT $3 OR user_id = $4 OR user_id IN (SELECT friend_id FROM user_friend WHERE user_id = $4))
+			ORDER BY user_id, score DESC, elapsed_s, ended_at
+		), ranked AS (
+			SELECT
+				b.*, u.login,
+				RANK() OVER (ORDER BY b.score DESC, b.elapsed_s) AS rank,
+				ROW_NUMBER() OVER (ORDER BY b.score DESC, b.elapsed_s, b.ended_at, b.user_id) AS position
+			FROM best AS b JOIN users AS u ON u.id = b.user_id
+		)
+		SELECT rank, user_id, login, ` + runColumns + `, user_id = $4 FROM ranked
+		WHERE position <= $5 OR user_id = $4
+		ORDER BY position
+	`
+	// the same for the best attempt of every team; with friends only the attempts played by
+	// the user or their friends are ranked
+	getTeamLeaderboard = `
+		WITH played AS (
+			SELECT attempt_id FROM quest_attempt_member WHERE user_id = $4
+		), best AS (
+			SELECT DISTINCT ON (team_id)
+				id, team_id, outcome, started_at, ended_at, elapsed_s, hints_used, hint_penalty, optional_reached, score
+			FROM quest_attempt
+			WHERE quest_id = $1 AND team_id IS NOT NULL AND outcome = 'finished' AND elapsed_s IS NOT NULL AND score IS NOT NULL
+				AND ($2::TIMESTAMPTZ IS NULL OR ended_at >= $2)
+				AND (NOT $3 OR id IN (
+					SELECT attempt_id FROM quest_attempt_member
+					WHERE user_id = $4 OR user_id IN (SELECT friend_id FROM user_friend WHERE user_id = $4)
+				))
+			ORDER BY team_id, score DESC, elapsed_s, ended_at
+		), ranked AS (
+			SELECT
+				b.*, t.name,
+				RANK() OVER (ORDER BY b.score DESC, b.elapsed_s) AS rank,
+				ROW_NUMBER() OVER (ORDER BY b.score DESC, b.elapsed_s, b.ended_at, b.team_id) AS position
+			FROM best AS b JOIN team AS t ON t.id = b.team_id
+		)
+		SELECT rank, team_id, name, ` + runColumns + `, id IN (SELECT attempt_id FROM played) FROM ranked
+		WHERE position <= $5 OR id IN (SELECT attempt_id FROM played)
+		ORDER BY position
+	`
+)
+
+type AttemptDAO interface {
+	// StartAttempt returns the active attempt of the user, solo or in a team, or starts a new
+	// solo one.
+	StartAttempt(userID, questID int) (model.Attempt, DBError)
+	// StartTeamAttempt returns the active attempt of the team or starts a new one shared by
+	// all its members if the team size is within limits.
+	StartTeamAttempt(userID, questID, teamID int, limits model.TeamLimits) (model.Attempt, DBError)
+	// AbandonAttempt ends the active attempt without a score; any member of a team may
+	// abandon the team attempt.
+	AbandonAttempt(userID, questID, attemptID int) (model.Attempt, DBError)
+	// GetAttempts returns the solo and team attempts of the user from the latest to the first.
+	GetAttempts(userID, questID int) ([]model.Attempt, DBError)
+	// GetLeaderboard returns the best limit solo runs of the quest finished since since (all
+	// runs for the zero time) followed by the run of the user if it is not among them.
+	GetLeaderboard(questID, userID int, since time.Time, friends bool, limit int) ([]model.LeaderboardEntry, DBError)
+	// GetTeamLeaderboard is GetLeaderboard for team runs followed by the runs of the teams
+	// the user has played in.
+	GetTeamLeaderboard(questID, userID int, since time.Time, friends bool, limit int) ([]model.LeaderboardEntry, DBError)
+}
+
+func NewAttemptDAO(db *sql.DB) AttemptDAO {
+	return &dbAttemptDAO{db: db}
+}
+
+type dbAttemptDAO struct {
+	db *sql.DB
+}
+
+func (dao *dbAttemptDAO) StartAttempt(userID, questID int) (model.Attempt, DBError) {
+	attempt, err := scanAttempt(dao.db.QueryRow(startAttempt, userID, questID))
+	if err == sql.ErrNoRows {
+		// the quest is missing or the user plays it in a team
+		attempt, err = scanAttempt(dao.db.QueryRow(getActiveAttemptInfo, userID, questID))
+	}
+	if err == sql.ErrNoRows {
+		return attempt, NewDBErr(http.StatusNotFound, "quest not found")
+	} else if err != nil {
+		return attempt, NewCrashDBErr(err)
+	}
+	return attempt, nil
+}
+
+// StartTeamAttempt gives http.StatusNotFound if the user is not a member of the team,
+// http.StatusUnprocessableEntity if the team size is out of limits and http.StatusConflict
+// if a member plays the quest in another attempt.
+func (dao *dbAttemptDAO) StartTeamAttempt(
+	userID, questID, teamID int, limits model.TeamLimits,
+) (model.Attempt, DBError) {
+	var attempt model.Attempt
+	dbErr := inTransaction(dao.db, func(tx *sql.Tx) error {
+		var lockedID int
+		if err := tx.QueryRow(lockTeamOfMember, teamID, userID).Scan(&lockedID); err == sql.ErrNoRows {
+			return NewDBErr(http.StatusNotFound, "team not found")
+		} else if err != nil {
+			return err
+		}
+
+		var err error
+		attempt, err = scanAttempt(tx.QueryRow(getTeamActiveAttempt, teamID, questID))
+		if err != sql.ErrNoRows {
+			return err
+		}
+
+		var size, playing int
+		if err := tx.QueryRow(countTeamPlayers, teamID, questID).Scan(&size, &playing); err != nil {
+			return err
+		}
+		if !limits.Allows(size) {
+			return NewDBErr(http.StatusUnprocessableEntity, fmt.Sprintf(
+				"the quest is played by teams of %d to %d members, the team has %d", limits.MinSize, limits.MaxSize, size,
+			))
+		}
+		if playing > 0 {
+			return NewDBErr(http.StatusConflict, "a team member plays the quest in another attempt")
+		}
+		attempt, err = scanAttempt(tx.QueryRow(startTeamAttempt, userID, questID, teamID))
+		return err
+	})
+	return attempt, dbErr
+}
+
+// AbandonAttempt gives http.StatusConflict for attempts that are already over.
+func (dao *dbAttemptDAO) AbandonAttempt(userID, questID, attemptID int) (model.Attempt, DBError) {
+	attempt, err := scanAttempt(dao.db.QueryRow(abandonAttempt, userID, questID, attemptID))
+	if err == nil {
+		return attempt, nil
+	} else if err != sql.ErrNoRows {
+		return attempt, NewCrashDBErr(err)
+	}
+
+	var outcome string
+	err = dao.db.QueryRow(getAttemptOutcome, userID, questID, attemptID).Scan(&outcome)
+	if err == sql.ErrNoRows {
+		return attempt, NewDBErr(http.StatusNotFound, "attempt not found")
+	} else if err != nil {
+		return attempt, NewCrashDBErr(err)
+	}
+	return attempt, NewDBErr(http.StatusConflict, fmt.Sprintf("the attempt is already %s", outcome))
+}
+
+func (dao *dbAttemptDAO) GetAttempts(userID, questID int) ([]model.Attempt, DBError) {
+	rows, err := dao.db.Query(getAttempts, userID, questID)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.Attempt, 0)
+	for rows.Next() {
+		attempt, err := scanAttempt(rows)
+		if err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		result = append(result, attempt)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+func (dao *dbAttemptDAO) GetLeaderboard(
+	questID, userID int, since time.Time, friends bool, limit int,
+) ([]model.LeaderboardEntry, DBError) {
+	var sinceArg interface{}
+	if !since.IsZero() {
+		sinceArg = since
+	}
+	return dao.getLeaderboard(false, getLeaderboard, questID, sinceArg, friends, userID, limit)
+}
+
+func (dao *dbAttemptDAO) GetTeamLeaderboard(
+	questID, userID int, since time.Time, friends bool, limit int,
+) ([]model.LeaderboardEntry, DBError) {
+	var sinceArg interface{}
+	if !since.IsZero() {
+		sinceArg = since
+	}
+	return dao.getLeaderboard(true, getTeamLeaderboard, questID, sinceArg, friends, userID, limit)
+}
+
+// getLeaderboard reads the entries of players or of teams.
+func (dao *dbAttemptDAO) getLeaderboard(teams bool, query string, args ...interface{}) ([]model.LeaderboardEntry, DBError) {
+	rows, err := dao.db.Query(query, args...)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.LeaderboardEntry, 0)
+	for rows.Next() {
+		entry := model.LeaderboardEntry{}
+		targets := []interface{}{&entry.Rank, &entry.UserID, &entry.Login}
+		if teams {
+			targets = []interface{}{&entry.Rank, &entry.TeamID, &entry.Team}
+		}
+		run := &runScanner{}
+		targets = append(append(targets, run.targets()...), &entry.Own)
+		if err := rows.Scan(targets...); err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		entry.Run = run.run()
+		result = append(result, entry)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+// activeAttempt returns the id of the active solo or team attempt of the user and locks it.
+// The first solo attempt of the quest is started on demand; once the user has ended an
+// attempt, the next one must be started explicitly and http.StatusConflict is returned until then.
+func activeAttempt(tx *sql.Tx, userID, questID int) (int, error) {
+	var attemptID int
+	err := tx.QueryRow(getActiveAttempt, userID, questID).Scan(&attemptID)
+	if err != sql.ErrNoRows {
+		return attemptID, err
+	}
+	err = tx.QueryRow(startFirstAttempt, userID, questID).Scan(&attemptID)
+	if err == sql.ErrNoRows {
+		return 0, NewDBErr(http.StatusConflict, "the quest has no active attempt, start a new one")
+	}
+	return attemptID, err
+}
+
+func scanAttempt(row scanner) (model.Attempt, error) {
+	attempt := model.Attempt{}
+	var endedAt pq.NullTime
+	run := &runScanner{}
+	err := row.Scan(append(
+		[]interface{}{&attempt.ID, &attempt.QuestID, &attempt.TeamID, &attempt.Outcome, &endedAt}, run.targets()...,
+	)...)
+	if err != nil {
+		return attempt, err
+	}
+	attempt.Run = run.run()
+	if endedAt.Valid {
+		t := model.QuotedTime(endedAt.Time)
+		attempt.EndedAt = &t
+	}
+	return attempt, nil
+}
+
+// runScanner holds the destinations of runColumns.
+type runScanner struct {
+	startedAt, finishedAt pq.NullTime
+	result                model.Run
+}
+
+func (s *runScanner) targets() []interface{} {
+	return []interface{}{
+		&s.startedAt, &s.finishedAt, &s.result.ElapsedSeconds, &s.result.HintsUsed, &s.result.HintPenalty,
+		&s.result.OptionalReached, &s.result.Score,
+	}
+}
+
+func (s *runScanner) run() model.Run {
+	run := s.result
+	if s.startedAt.Valid {
+		t := model.QuotedTime(s.startedAt.Time)
+		run.StartedAt = &t
+	}
+	if s.finishedAt.Valid {
+		t := model.QuotedTime(s.finishedAt.Time)
+		run.FinishedAt = &t
+	}
+	return run
+}
@@ -0,0 +1,258 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var runColumnNames = []string{
+	"started_at", "finished_at", "elapsed_s", "hints_used", "hint_penalty", "optional_reached", "score",
+}
+
+var attemptColumnNames = append([]string{"id", "quest_id", "team_id", "outcome", "ended_at"}, runColumnNames...)
+
+var leaderboardColumnNames = append(append([]string{"rank", "user_id", "login"}, runColumnNames...), "own")
+
+type AttemptTestSuite struct {
+	suite.Suite
+	db         *sql.DB
+	mock       sqlmock.Sqlmock
+	attemptDAO AttemptDAO
+}
+
+func (s *AttemptTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.attemptDAO = NewAttemptDAO(s.db)
+}
+
+func (s *AttemptTestSuite) TestStartAttempt() {
+	startedAt := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.mock.
+		ExpectQuery("INSERT INTO quest_attempt .+ ON CONFLICT \\(user_id, quest_id\\) WHERE outcome = 'active'").
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows(attemptColumnNames).AddRow(7, 2, 0, "active", nil, startedAt, nil, 0, 0, 0, 0, 0))
+
+	attempt, err := s.attemptDAO.StartAttempt(1, 2)
+	s.Require().NoError(err)
+	s.Equal(7, attempt.ID)
+	s.Equal(model.AttemptActive, attempt.Outcome)
+	s.Equal(model.QuotedTime(startedAt), *attempt.StartedAt)
+	s.Nil(attempt.EndedAt)
+}
+
+func (s *AttemptTestSuite) TestStartAttemptInTeam() {
+	s.mock.ExpectQuery("INSERT INTO quest_attempt").WithArgs(1, 2).WillReturnRows(sqlmock.NewRows(attemptColumnNames))
+	s.mock.
+		ExpectQuery("SELECT id, quest_id, COALESCE\\(team_id, 0\\), .+ outcome = 'active'").
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows(attemptColumnNames).AddRow(9, 2, 4, "active", nil, time.Now(), nil, 0, 0, 0, 0, 0))
+
+	attempt, err := s.attemptDAO.StartAttempt(1, 2)
+	s.Require().NoError(err)
+	s.Equal(9, attempt.ID)
+	s.Equal(4, attempt.TeamID)
+}
+
+func (s *AttemptTestSuite) TestStartAttemptOfMissingQuest() {
+	s.mock.
+		ExpectQuery("INSERT INTO quest_attempt").
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows(attemptColumnNames))
+	s.mock.ExpectQuery("SELECT id, quest_id").WithArgs(1, 2).WillReturnRows(sqlmock.NewRows(attemptColumnNames))
+
+	_, err := s.attemptDAO.StartAttempt(1, 2)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *AttemptTestSuite) TestAbandonAttempt() {
+	endedAt := time.Now()
+	s.mock.
+		ExpectQuery("UPDATE quest_attempt SET outcome = 'abandoned'").
+		WithArgs(1, 2, 7).
+		WillReturnRows(sqlmock.NewRows(attemptColumnNames).AddRow(7, 2, 0, "abandoned", endedAt, nil, nil, 0, 0, 0, 0, 0))
+
+	attempt, err := s.attemptDAO.AbandonAttempt(1, 2, 7)
+	s.Require().NoError(err)
+	s.Equal(model.AttemptAbandoned, attempt.Outcome)
+	s.NotNil(attempt.EndedAt)
+	s.Nil(attempt.FinishedAt)
+}
+
+func (s *AttemptTestSuite) TestAbandonEndedAttempt() {
+	s.mock.ExpectQuery("UPDATE quest_attempt").WillReturnRows(sqlmock.NewRows(attemptColumnNames))
+	s.mock.
+		ExpectQuery("SELECT outcome FROM quest_attempt").
+		WithArgs(1, 2, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"outcome"}).AddRow("finished"))
+
+	_, err := s.attemptDAO.AbandonAttempt(1, 2, 7)
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.Equal("the attempt is already finished", err.Error())
+}
+
+func (s *AttemptTestSuite) TestAbandonMissingAttempt() {
+	s.mock.ExpectQuery("UPDATE quest_attempt").WillReturnRows(sqlmock.NewRows(attemptColumnNames))
+	s.mock.ExpectQuery("SELECT outcome FROM quest_attempt").WillReturnRows(sqlmock.NewRows([]string{"outcome"}))
+
+	_, err := s.attemptDAO.AbandonAttempt(1, 2, 7)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *AttemptTestSuite) TestGetAttempts() {
+	startedAt := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	endedAt := startedAt.Add(10 * time.Minute)
+	s.mock.
+		ExpectQuery("SELECT id, quest_id, .+ FROM quest_attempt .+ quest_attempt_member").
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows(attemptColumnNames).
+			AddRow(8, 2, 4, "active", nil, endedAt, nil, 0, 0, 0, 0, 0).
+			AddRow(7, 2, 0, "finished", endedAt, startedAt, endedAt, 600, 1, 100, 0, 850))
+
+	attempts, err := s.attemptDAO.GetAttempts(1, 2)
+	s.Require().NoError(err)
+	s.Require().Len(attempts, 2)
+	s.Equal(8, attempts[0].ID)
+	s.Equal(4, attempts[0].TeamID)
+	s.Equal(model.QuotedTime(endedAt), *attempts[1].FinishedAt)
+	s.Equal(850, attempts[1].Score)
+}
+
+func (s *AttemptTestSuite) TestGetLeaderboard() {
+	since := time.Date(2018, 4, 30, 0, 0, 0, 0, time.UTC)
+	finishedAt := since.Add(time.Hour)
+	s.mock.
+		ExpectQuery("WITH best AS .+ DISTINCT ON \\(user_id\\) .+ RANK\\(\\) OVER").
+		WithArgs(3, since, true, 20, 2).
+		WillReturnRows(sqlmock.NewRows(leaderboardColumnNames).
+			AddRow(1, 21, "first", since, finishedAt, 600, 0, 0, 0, 950, false).
+			AddRow(1, 22, "second", since, finishedAt, 600, 0, 0, 0, 950, false).
+			AddRow(5, 20, "own", nil, finishedAt, 900, 1, 100, 0, 825, true))
+
+	entries, err := s.attemptDAO.GetLeaderboard(3, 20, since, true, 2)
+	s.Require().NoError(err)
+	s.Require().Len(entries, 3)
+	s.Equal(1, entries[1].Rank)
+	s.Equal("second", entries[1].Login)
+	s.Equal(5, entries[2].Rank)
+	s.True(entries[2].Own)
+	s.Nil(entries[2].StartedAt)
+	s.Equal(825, entries[2].Score)
+}
+
+func (s *AttemptTestSuite) TestGetLeaderboardAllTime() {
+	s.mock.
+		ExpectQuery("WITH best AS").
+		WithArgs(3, nil, false, 20, 10).
+		WillReturnRows(sqlmock.NewRows(leaderboardColumnNames))
+
+	entries, err := s.attemptDAO.GetLeaderboard(3, 20, time.Time{}, false, 10)
+	s.Require().NoError(err)
+	s.Empty(entries)
+}
+
+func (s *AttemptTestSuite) TestGetTeamLeaderboard() {
+	finishedAt := time.Now()
+	s.mock.
+		ExpectQuery("WITH played AS .+ DISTINCT ON \\(team_id\\) .+ JOIN team").
+		WithArgs(3, nil, false, 20, 10).
+		WillReturnRows(sqlmock.NewRows(append(append([]string{"rank", "team_id", "name"}, runColumnNames...), "own")).
+			AddRow(1, 4, "owls", nil, finishedAt, 600, 0, 0, 0, 950, true))
+
+	entries, err := s.attemptDAO.GetTeamLeaderboard(3, 20, time.Time{}, false, 10)
+	s.Require().NoError(err)
+	s.Require().Len(entries, 1)
+	s.Equal(4, entries[0].TeamID)
+	s.Equal("owls", entries[0].Team)
+	s.Zero(entries[0].UserID)
+	s.True(entries[0].Own)
+}
+
+func (s *AttemptTestSuite) expectTeamLocked() {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT t.id FROM team .+ FOR UPDATE OF t").
+		WithArgs(4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	s.mock.
+		ExpectQuery("SELECT id, quest_id, .+ WHERE team_id = \\$1 AND quest_id = \\$2 AND outcome = 'active'").
+		WithArgs(4, 2).
+		WillReturnRows(sqlmock.NewRows(attemptColumnNames))
+}
+
+func (s *AttemptTestSuite) TestStartTeamAttempt() {
+	s.expectTeamLocked()
+	s.mock.
+		ExpectQuery("SELECT count\\(\\*\\), count\\(\\*\\) FILTER").
+		WithArgs(4, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"size", "playing"}).AddRow(3, 0))
+	s.mock.
+		ExpectQuery("INSERT INTO quest_attempt \\(user_id, quest_id, team_id\\) .+ INSERT INTO quest_attempt_member").
+		WithArgs(1, 2, 4).
+		WillReturnRows(sqlmock.NewRows(attemptColumnNames).AddRow(9, 2, 4, "active", nil, time.Now(), nil, 0, 0, 0, 0, 0))
+	s.mock.ExpectCommit()
+
+	attempt, err := s.attemptDAO.StartTeamAttempt(1, 2, 4, model.TeamLimits{MinSize: 2, MaxSize: 4})
+	s.Require().NoError(err)
+	s.Equal(9, attempt.ID)
+	s.Equal(4, attempt.TeamID)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *AttemptTestSuite) TestStartTeamAttemptActive() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT t.id FROM team").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	s.mock.
+		ExpectQuery("SELECT id, quest_id, .+ WHERE team_id = \\$1").
+		WillReturnRows(sqlmock.NewRows(attemptColumnNames).AddRow(9, 2, 4, "active", nil, time.Now(), nil, 0, 0, 0, 0, 0))
+	s.mock.ExpectCommit()
+
+	attempt, err := s.attemptDAO.StartTeamAttempt(1, 2, 4, model.TeamLimits{MinSize: 2, MaxSize: 4})
+	s.Require().NoError(err)
+	s.Equal(9, attempt.ID)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *AttemptTestSuite) TestStartTeamAttemptOutOfLimits() {
+	s.expectTeamLocked()
+	s.mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"size", "playing"}).AddRow(5, 0))
+	s.mock.ExpectRollback()
+
+	_, err := s.attemptDAO.StartTeamAttempt(1, 2, 4, model.TeamLimits{MinSize: 2, MaxSize: 4})
+	s.Require().Error(err)
+	s.Equal(http.StatusUnprocessableEntity, err.Code())
+	s.Equal("the quest is played by teams of 2 to 4 members, the team has 5", err.Error())
+}
+
+func (s *AttemptTestSuite) TestStartTeamAttemptMemberPlaying() {
+	s.expectTeamLocked()
+	s.mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"size", "playing"}).AddRow(3, 1))
+	s.mock.ExpectRollback()
+
+	_, err := s.attemptDAO.StartTeamAttempt(1, 2, 4, model.TeamLimits{MinSize: 2, MaxSize: 4})
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+}
+
+func (s *AttemptTestSuite) TestStartTeamAttemptNotMember() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT t.id FROM team").WithArgs(4, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectRollback()
+
+	_, err := s.attemptDAO.StartTeamAttempt(1, 2, 4, model.TeamLimits{MinSize: 2, MaxSize: 4})
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func TestAttemptTestSuite(t *testing.T) {
+	suite.Run(t, new(AttemptTestSuite))
+}
@@ -0,0 +1,539 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/Sovianum/arquest-server/geo"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/lib/pq"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// checkpointColumns are the columns read by scanCheckpoint
+	checkpointColumns = `
+		id, quest_id, position, name, description, type, optional, lat, lon, radius, COALESCE(marker_id, '')
+	`
+	getCheckpoints = `SELECT ` + checkpointColumns + ` FROM quest_checkpoint WHERE quest_id = $1 ORDER BY position`
+	getCheckpoint  = `
+		SELECT ` + checkpointColumns + `, qr_secret, answer_salt, answer_hashes
+		FROM quest_checkpoint WHERE id = $1 AND quest_id = $2
+	`
+	getQRCheckpoints = `
+		SELECT ` + checkpointColumns + `, qr_secret, answer_salt, answer_hashes
+		FROM quest_checkpoint WHERE quest_id = $1 AND type = 'qr'
+		ORDER BY position
+	`
+	lockCheckpoints   = `SELECT id, type FROM quest_checkpoint WHERE quest_id = $1 FOR UPDATE`
+	deleteCheckpoints = `DELETE FROM quest_checkpoint WHERE quest_id = $1 AND NOT (id = ANY($2))`
+	// a QR checkpoint keeps its secret so that printed codes stay valid; answer hashes
+	// are only replaced when new answers are given
+	updateCheckpoint = `
+		UPDATE quest_checkpoint SET
+			position = $3, name = $4, description = $5, type = $6, optional = $7,
+			lat = $8, lon = $9, radius = $10, marker_id = $11,
+			qr_secret = CASE WHEN $6 = 'qr' THEN COALESCE(qr_secret, $12) END,
+			answer_salt = CASE WHEN $6 <> 'answer' THEN NULL WHEN $14::BYTEA[] IS NULL THEN answer_salt ELSE $13 END,
+			answer_hashes = CASE WHEN $6 <> 'answer' THEN NULL WHEN $14::BYTEA[] IS NULL THEN answer_hashes ELSE $14 END
+		WHERE id = $1 AND quest_id = $2
+	`
+	createCheckpoint = `
+		INSERT INTO quest_checkpoint (
+			quest_id, position, name, description, type, optional, lat, lon, radius, marker_id,
+			qr_secret, answer_salt, answer_hashes
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id
+	`
+
+	// getDroppedScenarioCheckpoints selects the checkpoints of the scenario missing from the kept ids $2
+	getDroppedScenarioCheckpoints = `
+		SELECT id FROM quest_scenario, unnest(checkpoint_ids) AS id WHERE quest_id = $1 AND NOT id = ANY($2)
+	`
+
+	deleteHints = `DELETE FROM checkpoint_hint WHERE checkpoint_id = $1`
+	createHint  = `INSERT INTO checkpoint_hint (checkpoint_id, tier, text, penalty) VALUES ($1, $2, $3, $4)`
+
+	getLatestAttempt = `
+		SELECT id, COALESCE(team_id, 0), outcome FROM quest_attempt WHERE ` + ownAttempt + ` ORDER BY id DESC LIMIT 1
+	`
+	getCheckpointProgress = `
+		SELECT ` + checkpointColumns + `, reached_at, COALESCE(h.used, 0), COALESCE(h.penalty, 0)
+		FROM quest_checkpoint AS c
+		LEFT JOIN (SELECT checkpoint_id, reached_at FROM checkpoint_progress WHERE attempt_id = $2) AS p
+			ON p.checkpoint_id = c.id
+		LEFT JOIN (
+			SELECT checkpoint_id, count(*) AS used, sum(penalty) AS penalty FROM hint_usage
+			WHERE attempt_id = $2 GROUP BY checkpoint_id
+		) AS h ON h.checkpoint_id = c.id
+		WHERE c.quest_id = $1
+		ORDER BY position
+	`
+	getCheckpointPosition  = `SELECT position FROM quest_checkpoint WHERE id = $1 AND quest_id = $2`
+	countMissedCheckpoints = `
+		SELECT count(*) FROM quest_checkpoint AS c
+		WHERE c.quest_id = $1 AND c.position < $2 AND NOT c.optional AND NOT EXISTS (
+			SELECT 1 FROM checkpoint_progress AS p WHERE p.checkpoint_id = c.id AND p.attempt_id = $3
+		)
+	`
+	// countMissedInActive counts the required checkpoints before checkpoint $3 not reached in
+	// the active attempt of user $1; all of them are missed without an active attempt
+	countMissedInActive = `
+		SELECT count(*) FROM quest_checkpoint AS c
+		JOIN quest_checkpoint AS t ON t.id = $3 AND t.quest_id = c.quest_id
+		WHERE c.quest_id = $2 AND c.position < t.position AND NOT c.optional AND NOT EXISTS (
+			SELECT 1 FROM checkpoint_progress AS p
+			WHERE p.checkpoint_id = c.id AND p.attempt_id = (
+				SELECT id FROM quest_attempt WHERE ` + ownAttempt + ` AND outcome = 'active' ORDER BY id DESC LIMIT 1
+			)
+		)
+	`
+	reachCheckpoint = `
+		INSERT INTO checkpoint_progress (
+			attempt_id, user_id, checkpoint_id, quest_id, fix_lat, fix_lon, fix_accuracy, fix_time
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT DO NOTHING
+	`
+	// a one-time code may be scanned again by the player who used it
+	useQRCode = `
+		INSERT INTO qr_code_use (nonce, checkpoint_id, user_id) VALUES ($1, $2, $3)
+		ON CONFLICT (nonce) DO UPDATE SET used_at = qr_code_use.used_at
+		WHERE qr_code_use.user_id = EXCLUDED.user_id
+	`
+	getQRCodeUser = `SELECT user_id FROM qr_code_use WHERE nonce = $1`
+	getLastFix    = `
+		SELECT fix_lat, fix_lon, fix_accuracy, fix_time FROM checkpoint_progress
+		WHERE user_id = $1 AND quest_id = $2 AND fix_time IS NOT NULL
+		ORDER BY fix_time DESC
+		LIMIT 1
+	`
+
+	addCheckpointFailure = `
+		INSERT INTO checkpoint_failure (user_id, quest_id, checkpoint_id, reason, details) VALUES ($1, $2, $3, $4, $5)
+	`
+	// zero filters match everything; beforeID pages from the newest failures to the oldest
+	getCheckpointFailures = `
+		SELECT id, user_id, quest_id, COALESCE(checkpoint_id, 0), reason, details, created_at
+		FROM checkpoint_failure
+		WHERE ($1 = 0 OR quest_id = $1) AND ($2 = 0 OR user_id = $2) AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4
+	`
+)
+
+type CheckpointDAO interface {
+	GetCheckpoints(questID int) ([]model.Checkpoint, DBError)
+	// GetCheckpoint returns the checkpoint together with the secrets to verify its proofs.
+	GetCheckpoint(questID, checkpointID int) (model.Checkpoint, DBError)
+	// GetQRCheckpoints returns the QR checkpoints of the quest with their secrets.
+	GetQRCheckpoints(questID int) ([]model.Checkpoint, DBError)
+	SetCheckpoints(questID int, checkpoints []model.Checkpoint) ([]model.Checkpoint, DBError)
+	// CheckOrder fails with http.StatusConflict while required checkpoints before the checkpoint
+	// are not reached by the user, so that proofs are only verified for reachable checkpoints.
+	// ReachCheckpoint checks the order again under lock.
+	CheckOrder(userID, questID, checkpointID int) DBError
+	// ReachCheckpoint records the reached checkpoint; fix is the accepted GPS fix and qrNonce
+	// the nonce of the one-time QR code, if any.
+	ReachCheckpoint(userID, questID, checkpointID int, fix *model.GPSFix, qrNonce string) DBError
+	// GetQRCodeUser returns the id of the user who used the one-time QR code or 0.
+	GetQRCodeUser(nonce string) (int, DBError)
+	GetProgress(userID, questID int) (model.QuestProgress, DBError)
+	// GetLastFix returns the last GPS fix accepted from the user in the quest or nil.
+	GetLastFix(userID, questID int) (*model.GPSFix, DBError)
+	AddFailure(failure model.CheckpointFailure) DBError
+	GetFailures(questID, userID, beforeID, limit int) ([]model.CheckpointFailure, DBError)
+}
+
+func NewCheckpointDAO(db *sql.DB) CheckpointDAO {
+	return &dbCheckpointDAO{db: db}
+}
+
+type dbCheckpointDAO struct {
+	db *sql.DB
+}
+
+func (dao *dbCheckpointDAO) GetCheckpoints(questID int) ([]model.Checkpoint, DBError) {
+	rows, err := dao.db.Query(getCheckpoints, questID)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.Checkpoint, 0)
+	for rows.Next() {
+		checkpoint, err := scanCheckpoint(rows)
+		if err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		result = append(result, checkpoint)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+func (dao *dbCheckpointDAO) GetCheckpoint(questID, checkpointID int) (model.Checkpoint, DBError) {
+	checkpoint, err := scanSecretCheckpoint(dao.db.QueryRow(getCheckpoint, checkpointID, questID))
+	if err == sql.ErrNoRows {
+		return checkpoint, NewDBErr(http.StatusNotFound, "checkpoint not found")
+	} else if err != nil {
+		return checkpoint, NewCrashDBErr(err)
+	}
+	return checkpoint, nil
+}
+
+func (dao *dbCheckpointDAO) GetQRCheckpoints(questID int) ([]model.Checkpoint, DBError) {
+	rows, err := dao.db.Query(getQRCheckpoints, questID)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.Checkpoint, 0)
+	for rows.Next() {
+		checkpoint, err := scanSecretCheckpoint(rows)
+		if err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		result = append(result, checkpoint)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+// SetCheckpoints replaces the checkpoint list of the quest keeping the order of checkpoints.
+// Checkpoints with an id are updated and keep the progress of players, checkpoints without
+// an id are created and stored checkpoints missing from the list are deleted unless the quest
+// scenario refers to them, which gives http.StatusConflict.
+// Answer checkpoints without AnswerHashes keep their stored answers, so new ones must have them.
+// Checkpoints with nil Hints keep their stored hints.
+func (dao *dbCheckpointDAO) SetCheckpoints(questID int, checkpoints []model.Checkpoint) ([]model.Checkpoint, DBError) {
+	result := make([]model.Checkpoint, len(checkpoints))
+	copy(result, checkpoints)
+
+	err := inTransaction(dao.db, func(tx *sql.Tx) error {
+		var lockedID int
+		if err := tx.QueryRow(lockQuest, questID).Scan(&lockedID); err == sql.ErrNoRows {
+			return NewDBErr(http.StatusNotFound, "quest not found")
+		} else if err != nil {
+			return err
+		}
+
+		stored, err := queryTypes(tx, lockCheckpoints, questID)
+		if err != nil {
+			return err
+		}
+		kept := make([]int64, 0, len(result))
+		for i, checkpoint := range result {
+			storedType, ok := stored[checkpoint.ID]
+			if checkpoint.ID != 0 && !ok {
+				return NewDBErr(
+					http.StatusUnprocessableEntity, fmt.Sprintf("checkpoint %d does not belong to the quest", checkpoint.ID),
+				)
+			}
+			if checkpoint.Type == model.CheckpointAnswer && checkpoint.AnswerHashes == nil && storedType != model.CheckpointAnswer {
+				return NewDBErr(
+					http.StatusUnprocessableEntity, fmt.Sprintf("checkpoint %d: answer checkpoint requires \"answers\"", i+1),
+				)
+			}
+			if checkpoint.ID != 0 {
+				kept = append(kept, int64(checkpoint.ID))
+			}
+		}
+		dropped, err := queryIDs(tx, getDroppedScenarioCheckpoints, questID, pq.Array(kept))
+		if err != nil {
+			return err
+		}
+		if len(dropped) > 0 {
+			return NewDBErr(http.StatusConflict, fmt.Sprintf(
+				"checkpoints %v are used by the quest scenario, change the scenario first", sortedIDs(dropped),
+			))
+		}
+		if _, err := tx.Exec(deleteCheckpoints, questID, pq.Array(kept)); err != nil {
+			return err
+		}
+
+		for i := range result {
+			checkpoint := &result[i]
+			checkpoint.QuestID, checkpoint.Position = questID, i+1
+			args := append(checkpointArgs(*checkpoint), secretArgs(*checkpoint)...)
+			if checkpoint.ID != 0 {
+				_, err = tx.Exec(updateCheckpoint, append([]interface{}{checkpoint.ID, questID}, args...)...)
+			} else {
+				err = tx.QueryRow(createCheckpoint, append([]interface{}{questID}, args...)...).Scan(&checkpoint.ID)
+			}
+			if err != nil {
+				return err
+			}
+			if err := setHints(tx, *checkpoint); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (dao *dbCheckpointDAO) CheckOrder(userID, questID, checkpointID int) DBError {
+	var missed int
+	if err := dao.db.QueryRow(countMissedInActive, userID, questID, checkpointID).Scan(&missed); err != nil {
+		return NewCrashDBErr(err)
+	} else if missed > 0 {
+		return missedCheckpointsErr(missed)
+	}
+	return nil
+}
+
+func missedCheckpointsErr(missed int) DBError {
+	return NewDBErr(http.StatusConflict, fmt.Sprintf("%d previous required checkpoints are not reached", missed))
+}
+
+// ReachCheckpoint records that the user has reached the checkpoint in the active attempt,
+// starting the first attempt of the quest if needed. A checkpoint can only be reached after
+// all required checkpoints before it; reaching a checkpoint again is not an error. A one-time
+// QR code used by another player gives 409.
+func (dao *dbCheckpointDAO) ReachCheckpoint(userID, questID, checkpointID int, fix *model.GPSFix, qrNonce string) DBError {
+	return inTransaction(dao.db, func(tx *sql.Tx) error {
+		var position int
+		if err := tx.QueryRow(getCheckpointPosition, checkpointID, questID).Scan(&position); err == sql.ErrNoRows {
+			return NewDBErr(http.StatusNotFound, "checkpoint not found")
+		} else if err != nil {
+			return err
+		}
+
+		attemptID, err := activeAttempt(tx, userID, questID)
+		if err != nil {
+			return err
+		}
+		var missed int
+		if err := tx.QueryRow(countMissedCheckpoints, questID, position, attemptID).Scan(&missed); err != nil {
+			return err
+		} else if missed > 0 {
+			return missedCheckpointsErr(missed)
+		}
+
+		if qrNonce != "" {
+			result, err := tx.Exec(useQRCode, qrNonce, checkpointID, userID)
+			if err != nil {
+				return err
+			}
+			if affected, err := result.RowsAffected(); err != nil {
+				return err
+			} else if affected == 0 {
+				return NewDBErr(http.StatusConflict, "the one-time QR code is already used")
+			}
+		}
+		fixArgs := make([]interface{}, 4)
+		if fix != nil {
+			fixArgs = []interface{}{fix.Lat, fix.Lon, fix.Accuracy, time.Time(fix.Time)}
+		}
+		_, err = tx.Exec(reachCheckpoint, append([]interface{}{attemptID, userID, checkpointID, questID}, fixArgs...)...)
+		return err
+	})
+}
+
+func (dao *dbCheckpointDAO) GetProgress(userID, questID int) (model.QuestProgress, DBError) {
+	progress := model.QuestProgress{QuestID: questID, Checkpoints: make([]model.CheckpointProgress, 0)}
+	var outcome string
+	err := dao.db.QueryRow(getLatestAttempt, userID, questID).Scan(&progress.AttemptID, &progress.TeamID, &outcome)
+	if err != nil && err != sql.ErrNoRows {
+		return progress, NewCrashDBErr(err)
+	}
+	progress.Started = progress.AttemptID != 0
+	progress.Completed = outcome == model.AttemptFinished
+
+	rows, err := dao.db.Query(getCheckpointProgress, questID, progress.AttemptID)
+	if err != nil {
+		return progress, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var reachedAt pq.NullTime
+		var hintsUsed, hintPenalty int
+		checkpoint, err := scanCheckpoint(&extraScanner{row: rows, extra: []interface{}{&reachedAt, &hintsUsed, &hintPenalty}})
+		if err != nil {
+			return progress, NewCrashDBErr(err)
+		}
+		item := model.CheckpointProgress{
+			Checkpoint: checkpoint, Reached: reachedAt.Valid, HintsUsed: hintsUsed, HintPenalty: hintPenalty,
+		}
+		if reachedAt.Valid {
+			t := model.QuotedTime(reachedAt.Time)
+			item.ReachedAt = &t
+		}
+		progress.Checkpoints = append(progress.Checkpoints, item)
+	}
+	if err := rows.Err(); err != nil {
+		return progress, NewCrashDBErr(err)
+	}
+	progress.Count()
+	return progress, nil
+}
+
+func (dao *dbCheckpointDAO) GetLastFix(userID, questID int) (*model.GPSFix, DBError) {
+	fix := &model.GPSFix{}
+	var fixTime time.Time
+	err := dao.db.QueryRow(getLastFix, userID, questID).Scan(&fix.Lat, &fix.Lon, &fix.Accuracy, &fixTime)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	fix.Time = model.QuotedTime(fixTime)
+	return fix, nil
+}
+
+func (dao *dbCheckpointDAO) GetQRCodeUser(nonce string) (int, DBError) {
+	var userID int
+	if err := dao.db.QueryRow(getQRCodeUser, nonce).Scan(&userID); err != nil && err != sql.ErrNoRows {
+		return 0, NewCrashDBErr(err)
+	}
+	return userID, nil
+}
+
+func (dao *dbCheckpointDAO) AddFailure(failure model.CheckpointFailure) DBError {
+	_, err := dao.db.Exec(
+		addCheckpointFailure, failure.UserID, failure.QuestID, failure.CheckpointID, failure.Reason, failure.Details,
+	)
+	return NewCrashDBErr(err)
+}
+
+func (dao *dbCheckpointDAO) GetFailures(questID, userID, beforeID, limit int) ([]model.CheckpointFailure, DBError) {
+	rows, err := dao.db.Query(getCheckpointFailures, questID, userID, beforeID, limit)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.CheckpointFailure, 0)
+	for rows.Next() {
+		var failure model.CheckpointFailure
+		var createdAt time.Time
+		err := rows.Scan(
+			&failure.ID, &failure.UserID, &failure.QuestID, &failure.CheckpointID, &failure.Reason, &failure.Details, &createdAt,
+		)
+		if err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		failure.CreatedAt = model.QuotedTime(createdAt)
+		result = append(result, failure)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+// extraScanner reads the columns following the checkpoint columns into extra.
+type extraScanner struct {
+	row   scanner
+	extra []interface{}
+}
+
+func (s *extraScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
+
+func scanCheckpoint(row scanner) (model.Checkpoint, error) {
+	checkpoint := model.Checkpoint{}
+	var lat, lon, radius sql.NullFloat64
+	err := row.Scan(
+		&checkpoint.ID, &checkpoint.QuestID, &checkpoint.Position, &checkpoint.Name, &checkpoint.Description,
+		&checkpoint.Type, &checkpoint.Optional, &lat, &lon, &radius, &checkpoint.MarkerID,
+	)
+	if err != nil {
+		return checkpoint, err
+	}
+	if lat.Valid && lon.Valid {
+		checkpoint.Location = &geo.Point{Lat: lat.Float64, Lon: lon.Float64}
+	}
+	checkpoint.Radius = radius.Float64
+	return checkpoint, nil
+}
+
+// scanSecretCheckpoint reads a checkpoint followed by qr_secret, answer_salt and answer_hashes.
+func scanSecretCheckpoint(row scanner) (model.Checkpoint, error) {
+	var qrSecret, answerSalt []byte
+	var answerHashes pq.ByteaArray
+	checkpoint, err := scanCheckpoint(&extraScanner{row: row, extra: []interface{}{&qrSecret, &answerSalt, &answerHashes}})
+	if err != nil {
+		return checkpoint, err
+	}
+	checkpoint.QRSecret, checkpoint.AnswerSalt, checkpoint.AnswerHashes = qrSecret, answerSalt, answerHashes
+	return checkpoint, nil
+}
+
+// checkpointArgs returns the columns of checkpoint from position on as query arguments.
+func checkpointArgs(checkpoint model.Checkpoint) []interface{} {
+	args := []interface{}{
+		checkpoint.Position, checkpoint.Name, checkpoint.Description, checkpoint.Type, checkpoint.Optional,
+		nil, nil, nil, nil,
+	}
+	if checkpoint.Location != nil {
+		args[5], args[6] = checkpoint.Location.Lat, checkpoint.Location.Lon
+	}
+	if checkpoint.Radius > 0 {
+		args[7] = checkpoint.Radius
+	}
+	if checkpoint.MarkerID != "" {
+		args[8] = checkpoint.MarkerID
+	}
+	return args
+}
+
+// secretArgs returns QR secret, answer salt and answer hashes of checkpoint as query arguments.
+func secretArgs(checkpoint model.Checkpoint) []interface{} {
+	args := []interface{}{nil, nil, nil}
+	if checkpoint.QRSecret != nil {
+		args[0] = checkpoint.QRSecret
+	}
+	if checkpoint.AnswerHashes != nil {
+		args[1], args[2] = checkpoint.AnswerSalt, pq.ByteaArray(checkpoint.AnswerHashes)
+	}
+	return args
+}
+
+// setHints replaces the hints of the saved checkpoint unless its Hints are nil.
+func setHints(tx *sql.Tx, checkpoint model.Checkpoint) error {
+	if checkpoint.Hints == nil {
+		return nil
+	}
+	if _, err := tx.Exec(deleteHints, checkpoint.ID); err != nil {
+		return err
+	}
+	for i, hint := range checkpoint.Hints {
+		if _, err := tx.Exec(createHint, checkpoint.ID, i+1, hint.Text, hint.Penalty); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortedIDs returns the ids of the set in ascending order.
+func sortedIDs(set map[int]bool) []int {
+	ids := make([]int, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// queryTypes maps ids of the selected checkpoints to their types.
+func queryTypes(tx *sql.Tx, query string, args ...interface{}) (map[int]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var checkpointType string
+		if err := rows.Scan(&id, &checkpointType); err != nil {
+			return nil, err
+		}
+		result[id] = checkpointType
+	}
+	return result, rows.Err()
+}
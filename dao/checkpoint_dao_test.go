@@ -0,0 +1,441 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/geo"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var checkpointColumnNames = []string{
+	"id", "quest_id", "position", "name", "description", "type", "optional", "lat", "lon", "radius", "marker_id",
+}
+
+type CheckpointTestSuite struct {
+	suite.Suite
+	db            *sql.DB
+	mock          sqlmock.Sqlmock
+	checkpointDAO CheckpointDAO
+}
+
+func (s *CheckpointTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.checkpointDAO = NewCheckpointDAO(s.db)
+}
+
+func (s *CheckpointTestSuite) TestGetCheckpoints() {
+	s.mock.
+		ExpectQuery("SELECT id, quest_id, position").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(checkpointColumnNames).
+			AddRow(1, 3, 1, "start", "", model.CheckpointGPS, false, 55.75, 37.61, 30., "").
+			AddRow(2, 3, 2, "statue", "", model.CheckpointARMarker, true, nil, nil, nil, "statue-1"))
+
+	checkpoints, err := s.checkpointDAO.GetCheckpoints(3)
+	s.Require().NoError(err)
+	s.Equal([]model.Checkpoint{
+		{
+			ID: 1, QuestID: 3, Position: 1, Name: "start", Type: model.CheckpointGPS,
+			Location: &geo.Point{Lat: 55.75, Lon: 37.61}, Radius: 30,
+		},
+		{ID: 2, QuestID: 3, Position: 2, Name: "statue", Type: model.CheckpointARMarker, Optional: true, MarkerID: "statue-1"},
+	}, checkpoints)
+}
+
+func (s *CheckpointTestSuite) TestSetCheckpoints() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest WHERE").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.
+		ExpectQuery("SELECT id, type FROM quest_checkpoint").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type"}).
+			AddRow(1, model.CheckpointGPS).
+			AddRow(2, model.CheckpointAnswer))
+	s.mock.
+		ExpectQuery("SELECT id FROM quest_scenario").
+		WithArgs(3, pq.Array([]int64{2})).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.
+		ExpectExec("DELETE FROM quest_checkpoint").
+		WithArgs(3, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectQuery("INSERT INTO quest_checkpoint").
+		WithArgs(3, 1, "new", "", model.CheckpointQR, false, nil, nil, nil, nil, []byte("secret"), nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	s.mock.
+		ExpectExec("UPDATE quest_checkpoint SET").
+		WithArgs(2, 3, 2, "moved", "", model.CheckpointAnswer, true, nil, nil, nil, nil, nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	saved, err := s.checkpointDAO.SetCheckpoints(3, []model.Checkpoint{
+		{Name: "new", Type: model.CheckpointQR, QRSecret: []byte("secret")},
+		{ID: 2, Name: "moved", Type: model.CheckpointAnswer, Optional: true},
+	})
+	s.Require().NoError(err)
+	s.Equal(5, saved[0].ID)
+	s.Equal(1, saved[0].Position)
+	s.Equal(2, saved[1].Position)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestSetCheckpointHints() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest WHERE").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.
+		ExpectQuery("SELECT id, type FROM quest_checkpoint").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type"}).AddRow(1, model.CheckpointQR).AddRow(2, model.CheckpointQR))
+	s.mock.ExpectQuery("SELECT id FROM quest_scenario").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectExec("DELETE FROM quest_checkpoint").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.
+		ExpectExec("UPDATE quest_checkpoint SET").
+		WithArgs(1, 3, 1, "a", "", model.CheckpointQR, false, nil, nil, nil, nil, nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("DELETE FROM checkpoint_hint").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 3))
+	s.mock.ExpectExec("INSERT INTO checkpoint_hint").WithArgs(1, 1, "north", 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("INSERT INTO checkpoint_hint").WithArgs(1, 2, "behind the statue", 150).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("UPDATE quest_checkpoint SET").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	free, penalty := 0, 150
+	_, err := s.checkpointDAO.SetCheckpoints(3, []model.Checkpoint{
+		{ID: 1, Name: "a", Type: model.CheckpointQR, Hints: []model.Hint{{Text: "north", Penalty: &free}, {Text: "behind the statue", Penalty: &penalty}}},
+		{ID: 2, Name: "b", Type: model.CheckpointQR},
+	})
+	s.Require().NoError(err)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestSetCheckpointsDropScenarioCheckpoint() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest WHERE").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.
+		ExpectQuery("SELECT id, type FROM quest_checkpoint").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type"}).AddRow(1, model.CheckpointQR).AddRow(2, model.CheckpointQR))
+	s.mock.
+		ExpectQuery("SELECT id FROM quest_scenario").
+		WithArgs(3, pq.Array([]int64{1})).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	s.mock.ExpectRollback()
+
+	_, err := s.checkpointDAO.SetCheckpoints(3, []model.Checkpoint{{ID: 1, Name: "a", Type: model.CheckpointQR}})
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.Contains(err.Error(), "checkpoints [2]")
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestSetForeignCheckpoint() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest WHERE").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.
+		ExpectQuery("SELECT id, type FROM quest_checkpoint").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type"}).AddRow(1, model.CheckpointQR))
+	s.mock.ExpectRollback()
+
+	_, err := s.checkpointDAO.SetCheckpoints(3, []model.Checkpoint{{ID: 7, Name: "a", Type: model.CheckpointQR}})
+	s.Require().Error(err)
+	s.Equal(http.StatusUnprocessableEntity, err.Code())
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestSetAnswerCheckpointWithoutAnswers() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest WHERE").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.
+		ExpectQuery("SELECT id, type FROM quest_checkpoint").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "type"}).AddRow(1, model.CheckpointQR))
+	s.mock.ExpectRollback()
+
+	_, err := s.checkpointDAO.SetCheckpoints(3, []model.Checkpoint{{ID: 1, Name: "a", Type: model.CheckpointAnswer}})
+	s.Require().Error(err)
+	s.Equal(http.StatusUnprocessableEntity, err.Code())
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestGetCheckpoint() {
+	s.mock.
+		ExpectQuery("SELECT .+ qr_secret, answer_salt, answer_hashes").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows(append(append([]string{}, checkpointColumnNames...), "qr_secret", "answer_salt", "answer_hashes")).
+			AddRow(2, 3, 1, "a", "", model.CheckpointAnswer, false, nil, nil, nil, "", nil, []byte("salt"), `{"\\x6869"}`))
+
+	checkpoint, err := s.checkpointDAO.GetCheckpoint(3, 2)
+	s.Require().NoError(err)
+	s.Nil(checkpoint.QRSecret)
+	s.Equal([]byte("salt"), checkpoint.AnswerSalt)
+	s.Equal([][]byte{[]byte("hi")}, checkpoint.AnswerHashes)
+}
+
+func (s *CheckpointTestSuite) TestGetCheckpointNotFound() {
+	s.mock.ExpectQuery("SELECT .+ qr_secret").WithArgs(2, 3).WillReturnError(sql.ErrNoRows)
+
+	_, err := s.checkpointDAO.GetCheckpoint(3, 2)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+// expectActiveAttempt expects the active attempt 7 of user 20 in quest 3 to be locked.
+func (s *CheckpointTestSuite) expectActiveAttempt() {
+	s.mock.
+		ExpectQuery("SELECT id FROM quest_attempt .+ FOR UPDATE").
+		WithArgs(20, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+}
+
+func (s *CheckpointTestSuite) TestReachCheckpoint() {
+	fixTime := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT position FROM quest_checkpoint").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow(2))
+	s.mock.
+		ExpectQuery("SELECT id FROM quest_attempt .+ outcome = 'active'").
+		WithArgs(20, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.
+		ExpectQuery("INSERT INTO quest_user_link .+ INSERT INTO quest_attempt .+ NOT EXISTS").
+		WithArgs(20, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs(3, 2, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.
+		ExpectExec("INSERT INTO checkpoint_progress").
+		WithArgs(7, 20, 2, 3, 55.75, 37.61, 10., fixTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	fix := &model.GPSFix{Point: geo.Point{Lat: 55.75, Lon: 37.61}, Accuracy: 10, Time: model.QuotedTime(fixTime)}
+	s.NoError(s.checkpointDAO.ReachCheckpoint(20, 3, 2, fix, ""))
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestReachCheckpointOutOfOrder() {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT position FROM quest_checkpoint").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow(2))
+	s.expectActiveAttempt()
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs(3, 2, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.ExpectRollback()
+
+	err := s.checkpointDAO.ReachCheckpoint(20, 3, 2, nil, "")
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestCheckOrder() {
+	s.mock.
+		ExpectQuery("SELECT count\\(\\*\\) FROM quest_checkpoint AS c\\s+JOIN quest_checkpoint AS t").
+		WithArgs(20, 3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	s.NoError(s.checkpointDAO.CheckOrder(20, 3, 2))
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestCheckOrderMissed() {
+	s.mock.
+		ExpectQuery("SELECT count\\(\\*\\) FROM quest_checkpoint AS c\\s+JOIN quest_checkpoint AS t").
+		WithArgs(20, 3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	err := s.checkpointDAO.CheckOrder(20, 3, 2)
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestReachCheckpointAfterEndedAttempt() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT position FROM quest_checkpoint").WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow(1))
+	s.mock.ExpectQuery("SELECT id FROM quest_attempt").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectQuery("INSERT INTO quest_attempt").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectRollback()
+
+	err := s.checkpointDAO.ReachCheckpoint(20, 3, 2, nil, "")
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.Contains(err.Error(), "start a new one")
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestReachUnknownCheckpoint() {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT position FROM quest_checkpoint").
+		WithArgs(9, 3).
+		WillReturnError(sql.ErrNoRows)
+	s.mock.ExpectRollback()
+
+	err := s.checkpointDAO.ReachCheckpoint(20, 3, 9, nil, "")
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *CheckpointTestSuite) TestGetProgress() {
+	reachedAt := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.mock.
+		ExpectQuery("SELECT id, COALESCE\\(team_id, 0\\), outcome FROM quest_attempt .+ ORDER BY id DESC LIMIT 1").
+		WithArgs(20, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "team_id", "outcome"}).AddRow(7, 4, model.AttemptActive))
+	s.mock.
+		ExpectQuery("SELECT .+ reached_at .+ attempt_id = \\$2").
+		WithArgs(3, 7).
+		WillReturnRows(sqlmock.NewRows(append(append([]string{}, checkpointColumnNames...), "reached_at", "hints_used", "hint_penalty")).
+			AddRow(1, 3, 1, "a", "", model.CheckpointQR, false, nil, nil, nil, "", reachedAt, 2, 150).
+			AddRow(2, 3, 2, "b", "", model.CheckpointQR, true, nil, nil, nil, "", nil, 0, 0).
+			AddRow(3, 3, 3, "c", "", model.CheckpointQR, false, nil, nil, nil, "", nil, 1, 100))
+
+	progress, err := s.checkpointDAO.GetProgress(20, 3)
+	s.Require().NoError(err)
+	s.True(progress.Started)
+	s.False(progress.Completed)
+	s.Equal(7, progress.AttemptID)
+	s.Equal(4, progress.TeamID)
+	s.Equal(1, progress.Reached)
+	s.Equal(2, progress.Required)
+	s.False(progress.CanFinish)
+	s.Require().Len(progress.Checkpoints, 3)
+	s.True(progress.Checkpoints[0].Reached)
+	s.Equal(model.QuotedTime(reachedAt), *progress.Checkpoints[0].ReachedAt)
+	s.Nil(progress.Checkpoints[1].ReachedAt)
+	s.Equal(2, progress.Checkpoints[0].HintsUsed)
+	s.Equal(3, progress.HintsUsed)
+	s.Equal(250, progress.HintPenalty)
+}
+
+func (s *CheckpointTestSuite) TestGetProgressNotStarted() {
+	s.mock.
+		ExpectQuery("SELECT id, COALESCE\\(team_id, 0\\), outcome FROM quest_attempt").
+		WithArgs(20, 3).
+		WillReturnError(sql.ErrNoRows)
+	s.mock.
+		ExpectQuery("SELECT .+ reached_at").
+		WithArgs(3, 0).
+		WillReturnRows(sqlmock.NewRows(append(append([]string{}, checkpointColumnNames...), "reached_at", "hints_used", "hint_penalty")))
+
+	progress, err := s.checkpointDAO.GetProgress(20, 3)
+	s.Require().NoError(err)
+	s.False(progress.Started)
+	s.True(progress.CanFinish)
+	s.Empty(progress.Checkpoints)
+}
+
+func (s *CheckpointTestSuite) TestReachCheckpointWithOneTimeCode() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT position FROM quest_checkpoint").WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow(1))
+	s.expectActiveAttempt()
+	s.mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.ExpectExec("INSERT INTO qr_code_use").WithArgs("nonce", 2, 20).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("INSERT INTO checkpoint_progress").WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	s.NoError(s.checkpointDAO.ReachCheckpoint(20, 3, 2, nil, "nonce"))
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestReachCheckpointWithUsedCode() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT position FROM quest_checkpoint").WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow(1))
+	s.expectActiveAttempt()
+	s.mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.ExpectExec("INSERT INTO qr_code_use").WithArgs("nonce", 2, 20).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectRollback()
+
+	err := s.checkpointDAO.ReachCheckpoint(20, 3, 2, nil, "nonce")
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestGetQRCodeUser() {
+	s.mock.ExpectQuery("SELECT user_id FROM qr_code_use").WithArgs("used").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(21))
+	s.mock.ExpectQuery("SELECT user_id FROM qr_code_use").WithArgs("fresh").WillReturnError(sql.ErrNoRows)
+
+	userID, err := s.checkpointDAO.GetQRCodeUser("used")
+	s.Require().NoError(err)
+	s.Equal(21, userID)
+	userID, err = s.checkpointDAO.GetQRCodeUser("fresh")
+	s.Require().NoError(err)
+	s.Equal(0, userID)
+}
+
+func (s *CheckpointTestSuite) TestGetQRCheckpoints() {
+	s.mock.
+		ExpectQuery("SELECT .+ FROM quest_checkpoint WHERE quest_id = \\$1 AND type = 'qr'").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(append(append([]string{}, checkpointColumnNames...), "qr_secret", "answer_salt", "answer_hashes")).
+			AddRow(2, 3, 1, "gate", "", model.CheckpointQR, false, nil, nil, nil, "", []byte("secret"), nil, nil))
+
+	checkpoints, err := s.checkpointDAO.GetQRCheckpoints(3)
+	s.Require().NoError(err)
+	s.Require().Len(checkpoints, 1)
+	s.Equal([]byte("secret"), checkpoints[0].QRSecret)
+}
+
+func (s *CheckpointTestSuite) TestGetLastFix() {
+	fixTime := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.mock.
+		ExpectQuery("SELECT fix_lat, fix_lon, fix_accuracy, fix_time FROM checkpoint_progress").
+		WithArgs(20, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"fix_lat", "fix_lon", "fix_accuracy", "fix_time"}).
+			AddRow(55.75, 37.61, 10., fixTime))
+
+	fix, err := s.checkpointDAO.GetLastFix(20, 3)
+	s.Require().NoError(err)
+	s.Equal(&model.GPSFix{Point: geo.Point{Lat: 55.75, Lon: 37.61}, Accuracy: 10, Time: model.QuotedTime(fixTime)}, fix)
+}
+
+func (s *CheckpointTestSuite) TestGetLastFixNone() {
+	s.mock.ExpectQuery("SELECT fix_lat").WithArgs(20, 3).WillReturnError(sql.ErrNoRows)
+
+	fix, err := s.checkpointDAO.GetLastFix(20, 3)
+	s.Require().NoError(err)
+	s.Nil(fix)
+}
+
+func (s *CheckpointTestSuite) TestFailures() {
+	createdAt := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.mock.
+		ExpectExec("INSERT INTO checkpoint_failure").
+		WithArgs(20, 3, 2, "wrong_answer", "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.
+		ExpectQuery("SELECT .+ FROM checkpoint_failure").
+		WithArgs(3, 0, 10, 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "quest_id", "checkpoint_id", "reason", "details", "created_at"}).
+			AddRow(1, 20, 3, 2, "wrong_answer", "", createdAt))
+
+	failure := model.CheckpointFailure{UserID: 20, QuestID: 3, CheckpointID: 2, Reason: "wrong_answer"}
+	s.Require().NoError(s.checkpointDAO.AddFailure(failure))
+
+	failures, err := s.checkpointDAO.GetFailures(3, 0, 10, 50)
+	s.Require().NoError(err)
+	failure.ID, failure.CreatedAt = 1, model.QuotedTime(createdAt)
+	s.Equal([]model.CheckpointFailure{failure}, failures)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestCheckpointTestSuite(t *testing.T) {
+	suite.Run(t, new(CheckpointTestSuite))
+}
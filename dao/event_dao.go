@@ -0,0 +1,67 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"time"
+)
+
+const (
+	getLastTeamEventID = `SELECT COALESCE((SELECT last_event_seq FROM team WHERE id = $1), 0)`
+	getTeamEvents      = `
+		SELECT
+			e.seq, e.type, e.team_id, COALESCE(e.attempt_id, 0), COALESCE(e.user_id, 0), COALESCE(u.login, ''),
+			COALESCE(e.checkpoint_id, 0), e.created_at
+		FROM team_event AS e LEFT JOIN users AS u ON u.id = e.user_id
+		WHERE e.team_id = $1 AND e.seq > $2
+		ORDER BY e.seq LIMIT $3
+	`
+)
+
+// EventDAO reads the team events written by the triggers of migration 16. Events are
+// identified by their number within the team (migration 22), which follows the commit order.
+type EventDAO interface {
+	// GetLastTeamEventID returns the number of the latest event of the team or 0 if it has none.
+	GetLastTeamEventID(teamID int) (int64, DBError)
+	// GetTeamEvents returns at most limit events of the team following the event afterID, oldest first.
+	GetTeamEvents(teamID int, afterID int64, limit int) ([]model.TeamEvent, DBError)
+}
+
+func NewEventDAO(db *sql.DB) EventDAO {
+	return &dbEventDAO{db: db}
+}
+
+type dbEventDAO struct {
+	db *sql.DB
+}
+
+func (dao *dbEventDAO) GetLastTeamEventID(teamID int) (int64, DBError) {
+	var id int64
+	if err := dao.db.QueryRow(getLastTeamEventID, teamID).Scan(&id); err != nil {
+		return 0, NewCrashDBErr(err)
+	}
+	return id, nil
+}
+
+func (dao *dbEventDAO) GetTeamEvents(teamID int, afterID int64, limit int) ([]model.TeamEvent, DBError) {
+	rows, err := dao.db.Query(getTeamEvents, teamID, afterID, limit)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.TeamEvent, 0)
+	for rows.Next() {
+		event := model.TeamEvent{}
+		var createdAt time.Time
+		if err := rows.Scan(
+			&event.ID, &event.Type, &event.TeamID, &event.AttemptID, &event.UserID, &event.Login,
+			&event.CheckpointID, &createdAt,
+		); err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		event.CreatedAt = model.QuotedTime(createdAt)
+		result = append(result, event)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
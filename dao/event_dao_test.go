@@ -0,0 +1,57 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+type EventTestSuite struct {
+	suite.Suite
+	db       *sql.DB
+	mock     sqlmock.Sqlmock
+	eventDAO EventDAO
+}
+
+func (s *EventTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.eventDAO = NewEventDAO(s.db)
+}
+
+func (s *EventTestSuite) TestGetLastTeamEventID() {
+	s.mock.
+		ExpectQuery("SELECT COALESCE\\(\\(SELECT last_event_seq FROM team WHERE id = \\$1\\), 0\\)").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"last_event_seq"}).AddRow(12))
+
+	id, err := s.eventDAO.GetLastTeamEventID(4)
+	s.Require().NoError(err)
+	s.Equal(int64(12), id)
+}
+
+func (s *EventTestSuite) TestGetTeamEvents() {
+	s.mock.
+		ExpectQuery("SELECT\\s+e.seq, .+ FROM team_event AS e LEFT JOIN users .+ e.seq > \\$2\\s+ORDER BY e.seq").
+		WithArgs(4, int64(10), 100).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "type", "team_id", "attempt_id", "user_id", "login", "checkpoint_id", "created_at",
+		}).
+			AddRow(11, model.EventCheckpointReached, 4, 7, 1, "first", 3, time.Now()).
+			AddRow(12, model.EventAttemptFinished, 4, 7, 0, "", 0, time.Now()))
+
+	events, err := s.eventDAO.GetTeamEvents(4, 10, 100)
+	s.Require().NoError(err)
+	s.Require().Len(events, 2)
+	s.Equal("first", events[0].Login)
+	s.Equal(3, events[0].CheckpointID)
+	s.Equal(model.EventAttemptFinished, events[1].Type)
+}
+
+func TestEventTestSuite(t *testing.T) {
+	suite.Run(t, new(EventTestSuite))
+}
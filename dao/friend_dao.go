@@ -0,0 +1,72 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"net/http"
+	"time"
+)
+
+const (
+	getFriends = `
+		SELECT u.id, u.login, f.created_at FROM user_friend AS f JOIN users AS u ON u.id = f.friend_id
+		WHERE f.user_id = $1 ORDER BY u.login
+	`
+	addFriend    = `INSERT INTO user_friend (user_id, friend_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	removeFriend = `DELETE FROM user_friend WHERE user_id = $1 AND friend_id = $2`
+)
+
+// FriendDAO keeps the users each user follows in friends-only leaderboards. Friendship is
+// one-way: adding a friend does not make the user a friend of the friend.
+type FriendDAO interface {
+	GetFriends(userID int) ([]model.Friend, DBError)
+	AddFriend(userID, friendID int) DBError
+	RemoveFriend(userID, friendID int) DBError
+}
+
+func NewFriendDAO(db *sql.DB) FriendDAO {
+	return &dbFriendDAO{db: db}
+}
+
+type dbFriendDAO struct {
+	db *sql.DB
+}
+
+func (dao *dbFriendDAO) GetFriends(userID int) ([]model.Friend, DBError) {
+	rows, err := dao.db.Query(getFriends, userID)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.Friend, 0)
+	for rows.Next() {
+		friend := model.Friend{}
+		var createdAt time.Time
+		if err := rows.Scan(&friend.ID, &friend.Login, &createdAt); err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		friend.CreatedAt = model.QuotedTime(createdAt)
+		result = append(result, friend)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+// AddFriend is idempotent: adding a friend twice is not an error.
+func (dao *dbFriendDAO) AddFriend(userID, friendID int) DBError {
+	_, err := dao.db.Exec(addFriend, userID, friendID)
+	return NewCrashDBErr(err)
+}
+
+func (dao *dbFriendDAO) RemoveFriend(userID, friendID int) DBError {
+	result, err := dao.db.Exec(removeFriend, userID, friendID)
+	if err != nil {
+		return NewCrashDBErr(err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return NewCrashDBErr(err)
+	} else if affected == 0 {
+		return NewDBErr(http.StatusNotFound, "user is not a friend")
+	}
+	return nil
+}
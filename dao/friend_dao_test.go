@@ -0,0 +1,54 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type FriendTestSuite struct {
+	suite.Suite
+	db        *sql.DB
+	mock      sqlmock.Sqlmock
+	friendDAO FriendDAO
+}
+
+func (s *FriendTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.friendDAO = NewFriendDAO(s.db)
+}
+
+func (s *FriendTestSuite) TestGetFriends() {
+	createdAt := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.mock.
+		ExpectQuery("SELECT u.id, u.login, f.created_at FROM user_friend").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "login", "created_at"}).AddRow(2, "friend", createdAt))
+
+	friends, err := s.friendDAO.GetFriends(1)
+	s.Require().NoError(err)
+	s.Equal([]model.Friend{{ID: 2, Login: "friend", CreatedAt: model.QuotedTime(createdAt)}}, friends)
+}
+
+func (s *FriendTestSuite) TestAddFriend() {
+	s.mock.ExpectExec("INSERT INTO user_friend").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.NoError(s.friendDAO.AddFriend(1, 2))
+}
+
+func (s *FriendTestSuite) TestRemoveMissingFriend() {
+	s.mock.ExpectExec("DELETE FROM user_friend").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := s.friendDAO.RemoveFriend(1, 2)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func TestFriendTestSuite(t *testing.T) {
+	suite.Run(t, new(FriendTestSuite))
+}
@@ -0,0 +1,114 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"net/http"
+	"time"
+)
+
+const (
+	countHints = `
+		SELECT (SELECT count(*) FROM checkpoint_hint WHERE checkpoint_id = c.id)
+		FROM quest_checkpoint AS c WHERE c.id = $1 AND c.quest_id = $2
+	`
+	getUsedHints = `
+		SELECT u.tier, COALESCE(h.text, ''), u.penalty, u.used_at
+		FROM hint_usage AS u
+		LEFT JOIN checkpoint_hint AS h ON h.checkpoint_id = u.checkpoint_id AND h.tier = u.tier
+		WHERE u.attempt_id = ` + latestAttempt + ` AND u.checkpoint_id = $3
+		ORDER BY u.tier
+	`
+	// the next tier is the one after the last taken in the attempt
+	getNextHintTier = `
+		SELECT COALESCE(MAX(tier), 0) + 1 FROM hint_usage WHERE attempt_id = $1 AND checkpoint_id = $2
+	`
+	// nothing is inserted when the tier does not exist
+	useHint = `
+		INSERT INTO hint_usage (attempt_id, user_id, quest_id, checkpoint_id, tier, penalty)
+		SELECT $1, $2, c.quest_id, h.checkpoint_id, h.tier, h.penalty
+		FROM checkpoint_hint AS h JOIN quest_checkpoint AS c ON c.id = h.checkpoint_id
+		WHERE h.checkpoint_id = $3 AND c.quest_id = $4 AND h.tier = $5
+	`
+)
+
+type HintDAO interface {
+	// GetHints returns the hints of the checkpoint taken by the user in the latest attempt.
+	GetHints(userID, questID, checkpointID int) (model.CheckpointHints, DBError)
+	// UseHint gives the user the next hint of the checkpoint in the active attempt and returns
+	// all hints taken in it.
+	UseHint(userID, questID, checkpointID int) (model.CheckpointHints, DBError)
+}
+
+func NewHintDAO(db *sql.DB) HintDAO {
+	return &dbHintDAO{db: db}
+}
+
+type dbHintDAO struct {
+	db *sql.DB
+}
+
+func (dao *dbHintDAO) GetHints(userID, questID, checkpointID int) (model.CheckpointHints, DBError) {
+	hints := model.CheckpointHints{CheckpointID: checkpointID, Used: make([]model.UsedHint, 0)}
+	err := dao.db.QueryRow(countHints, checkpointID, questID).Scan(&hints.Total)
+	if err == sql.ErrNoRows {
+		return hints, NewDBErr(http.StatusNotFound, "checkpoint not found")
+	} else if err != nil {
+		return hints, NewCrashDBErr(err)
+	}
+
+	rows, err := dao.db.Query(getUsedHints, userID, questID, checkpointID)
+	if err != nil {
+		return hints, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		hint := model.UsedHint{}
+		var usedAt time.Time
+		if err := rows.Scan(&hint.Tier, &hint.Text, &hint.Penalty, &usedAt); err != nil {
+			return hints, NewCrashDBErr(err)
+		}
+		hint.UsedAt = model.QuotedTime(usedAt)
+		hints.Used = append(hints.Used, hint)
+	}
+	return hints, NewCrashDBErr(rows.Err())
+}
+
+// UseHint fails with http.StatusConflict when all hints of the checkpoint are taken. The
+// attempt row stays locked until the hint is stored, so concurrent requests of the attempt
+// take the following tiers one by one.
+func (dao *dbHintDAO) UseHint(userID, questID, checkpointID int) (model.CheckpointHints, DBError) {
+	var affected int64
+	dbErr := inTransaction(dao.db, func(tx *sql.Tx) error {
+		attemptID, err := activeAttempt(tx, userID, questID)
+		if err != nil {
+			return err
+		}
+		var tier int
+		if err := tx.QueryRow(getNextHintTier, attemptID, checkpointID).Scan(&tier); err != nil {
+			return err
+		}
+		result, err := tx.Exec(useHint, attemptID, userID, checkpointID, questID, tier)
+		if err != nil {
+			return err
+		}
+		affected, err = result.RowsAffected()
+		return err
+	})
+	if dbErr != nil {
+		return model.CheckpointHints{}, dbErr
+	}
+
+	hints, dbErr := dao.GetHints(userID, questID, checkpointID)
+	if dbErr != nil {
+		return hints, dbErr
+	}
+	if affected == 0 {
+		if hints.Total == 0 {
+			return hints, NewDBErr(http.StatusNotFound, "checkpoint has no hints")
+		}
+		return hints, NewDBErr(http.StatusConflict, "all hints of the checkpoint are used")
+	}
+	return hints, nil
+}
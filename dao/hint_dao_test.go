@@ -0,0 +1,117 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type HintTestSuite struct {
+	suite.Suite
+	db      *sql.DB
+	mock    sqlmock.Sqlmock
+	hintDAO HintDAO
+}
+
+func (s *HintTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.hintDAO = NewHintDAO(s.db)
+}
+
+func (s *HintTestSuite) expectHints(total int, used ...model.UsedHint) {
+	s.mock.
+		ExpectQuery("SELECT .+ FROM checkpoint_hint").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(total))
+	rows := sqlmock.NewRows([]string{"tier", "text", "penalty", "used_at"})
+	for _, hint := range used {
+		rows.AddRow(hint.Tier, hint.Text, hint.Penalty, time.Time(hint.UsedAt))
+	}
+	s.mock.ExpectQuery("SELECT u.tier").WithArgs(20, 3, 2).WillReturnRows(rows)
+}
+
+// expectUse expects the second hint to be taken in the locked active attempt 7.
+func (s *HintTestSuite) expectUse(affected int64) {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT id FROM quest_attempt .+ FOR UPDATE").
+		WithArgs(20, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	s.mock.
+		ExpectQuery("SELECT COALESCE\\(MAX\\(tier\\), 0\\) \\+ 1 FROM hint_usage").
+		WithArgs(7, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"tier"}).AddRow(2))
+	s.mock.ExpectExec("INSERT INTO hint_usage").WithArgs(7, 20, 2, 3, 2).WillReturnResult(sqlmock.NewResult(0, affected))
+	s.mock.ExpectCommit()
+}
+
+func (s *HintTestSuite) TestGetHints() {
+	usedAt := model.QuotedTime(time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC))
+	s.expectHints(3, model.UsedHint{Tier: 1, Text: "north", Penalty: 50, UsedAt: usedAt})
+
+	hints, err := s.hintDAO.GetHints(20, 3, 2)
+	s.Require().NoError(err)
+	s.Equal(model.CheckpointHints{
+		CheckpointID: 2,
+		Total:        3,
+		Used:         []model.UsedHint{{Tier: 1, Text: "north", Penalty: 50, UsedAt: usedAt}},
+	}, hints)
+}
+
+func (s *HintTestSuite) TestGetHintsUnknownCheckpoint() {
+	s.mock.ExpectQuery("SELECT .+ FROM checkpoint_hint").WithArgs(2, 3).WillReturnError(sql.ErrNoRows)
+
+	_, err := s.hintDAO.GetHints(20, 3, 2)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *HintTestSuite) TestUseHint() {
+	s.expectUse(1)
+	s.expectHints(2, model.UsedHint{Tier: 1, Text: "north", Penalty: 50})
+
+	hints, err := s.hintDAO.UseHint(20, 3, 2)
+	s.Require().NoError(err)
+	s.Len(hints.Used, 1)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *HintTestSuite) TestUseHintAllUsed() {
+	s.expectUse(0)
+	s.expectHints(1, model.UsedHint{Tier: 1, Text: "north", Penalty: 50})
+
+	_, err := s.hintDAO.UseHint(20, 3, 2)
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+}
+
+func (s *HintTestSuite) TestUseHintAfterEndedAttempt() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest_attempt").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectQuery("INSERT INTO quest_attempt").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectRollback()
+
+	_, err := s.hintDAO.UseHint(20, 3, 2)
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *HintTestSuite) TestUseHintWithoutHints() {
+	s.expectUse(0)
+	s.expectHints(0)
+
+	_, err := s.hintDAO.UseHint(20, 3, 2)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func TestHintTestSuite(t *testing.T) {
+	suite.Run(t, new(HintTestSuite))
+}
@@ -4,20 +4,91 @@ import (
 	"database/sql"
 	"github.com/Sovianum/arquest-server/model"
 	"net/http"
+	"time"
 )
 
 const (
 	getUserVotes = `
 		SELECT user_id, quest_id, mark FROM quest_user_link WHERE user_id = $1
 	`
+	lockMark = `
+		SELECT COALESCE(completed, FALSE), COALESCE(marked, FALSE), COALESCE(mark, 0) FROM quest_user_link
+		WHERE user_id = $1 AND quest_id = $2 FOR UPDATE
+	`
 	markQuest = `
 		UPDATE quest_user_link SET mark = $1, marked = TRUE WHERE user_id = $2 AND quest_id = $3
 	`
+	addMarkChange = `
+		INSERT INTO mark_history (user_id, quest_id, mark, previous_mark) VALUES ($1, $2, $3, $4)
+	`
+	getMarkHistory = `
+		SELECT id, quest_id, mark, previous_mark, created_at FROM mark_history
+		WHERE user_id = $1 AND ($2 = 0 OR quest_id = $2) AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC LIMIT $4
+	`
+	// the rating moves by the change of the sum of marks: $2 is the new mark less the previous
+	// one and $3 is 1 if the user marks the quest for the first time. The mean of the marks is
+	// pulled towards the prior mean $5 as if there were $4 more marks equal to it.
 	updateRating = `
-		UPDATE quest SET (rating, mark_count) = (SELECT sum(mark) / count(*) AS rating, count(*) AS mark_count FROM quest_user_link) WHERE id = $1
+		UPDATE quest SET
+			mark_sum = mark_sum + $2,
+			mark_count = mark_count + $3,
+			rating = CASE WHEN mark_count + $3 > 0 THEN ($4 * $5 + mark_sum + $2) / ($4 + mark_count + $3) ELSE 0 END
+		WHERE id = $1
+	`
+	recomputeRatings = `
+		WITH marks AS (
+			SELECT quest_id, sum(mark) AS mark_sum, count(*) AS mark_count
+			FROM quest_user_link WHERE marked GROUP BY quest_id
+		)
+		UPDATE quest AS q SET
+			mark_sum = COALESCE(m.mark_sum, 0),
+			mark_count = COALESCE(m.mark_count, 0),
+			rating = COALESCE(($1 * $2 + m.mark_sum) / ($1 + m.mark_count), 0)
+		FROM quest AS a LEFT JOIN marks AS m ON m.quest_id = a.id
+		WHERE q.id = a.id
+	`
+	// the attempt is not finished while required checkpoints of the quest are not reached in it
+	// or the player has not come to a final state of the quest scenario. Completion must be
+	// proven: a quest without checkpoints and scenario can not be finished, and neither can an
+	// attempt of a quest with only optional checkpoints where none of them is reached.
+	finishAttempt = `
+		UPDATE quest_attempt AS a SET outcome = 'finished', ended_at = now()
+		WHERE a.id = $1 AND (
+			EXISTS (SELECT 1 FROM checkpoint_progress AS p WHERE p.attempt_id = a.id)
+			OR EXISTS (SELECT 1 FROM scenario_state AS st WHERE st.attempt_id = a.id AND st.final)
+		) AND NOT EXISTS (
+			SELECT 1 FROM quest_checkpoint AS c
+			WHERE c.quest_id = a.quest_id AND NOT c.optional AND NOT EXISTS (
+				SELECT 1 FROM checkpoint_progress AS p WHERE p.checkpoint_id = c.id AND p.attempt_id = a.id
+			)
+		) AND NOT EXISTS (
+			SELECT 1 FROM quest_scenario AS s
+			WHERE s.quest_id = a.quest_id AND NOT EXISTS (
+				SELECT 1 FROM scenario_state AS st WHERE st.attempt_id = a.id AND st.final
+			)
+		)
+		RETURNING ` + attemptColumns + `
+	`
+	getRunStats = `
+		SELECT
+			(SELECT count(*) FROM hint_usage WHERE attempt_id = $1),
+			(SELECT COALESCE(sum(penalty), 0) FROM hint_usage WHERE attempt_id = $1),
+			(
+				SELECT count(*) FROM checkpoint_progress AS p JOIN quest_checkpoint AS c ON c.id = p.checkpoint_id
+				WHERE p.attempt_id = $1 AND c.optional
+			)
 	`
-	finishQuest = `
-		INSERT INTO quest_user_link (user_id, quest_id, completed) VALUES ($1, $2, TRUE) ON CONFLICT ON CONSTRAINT ux_user_id_quest_id DO UPDATE SET completed = TRUE 
+	// every member of a team attempt completes the quest
+	scoreAttempt = `
+		WITH link AS (
+			UPDATE quest_user_link SET completed = TRUE
+			WHERE quest_id = $3 AND (
+				user_id = $2 OR user_id IN (SELECT user_id FROM quest_attempt_member WHERE attempt_id = $1)
+			)
+		)
+		UPDATE quest_attempt SET elapsed_s = $4, hints_used = $5, hint_penalty = $6, optional_reached = $7, score = $8
+		WHERE id = $1
 	`
 )
 
@@ -26,36 +97,119 @@ func NewMarkDAO(db *sql.DB) MarkDAO {
 }
 
 type MarkDAO interface {
-	FinishQuest(userID, questID int) DBError
-	MarkQuest(userID, questID int, mark float32) DBError
+	// FinishQuest finishes the active attempt of the user and returns it scored by rules.
+	FinishQuest(userID, questID int, rules model.ScoreRules) (model.Attempt, DBError)
+	// MarkQuest saves the mark of the user and updates the rating of the quest by rules.
+	MarkQuest(userID, questID int, mark float32, rules model.RatingRules) DBError
 	GetUserMarks(userID int) ([]model.Mark, DBError)
+	GetMarkHistory(userID int, filter model.MarkHistoryFilter) ([]model.MarkChange, DBError)
+	// RecomputeRatings recomputes the ratings of all quests by rules from their marks and
+	// returns the number of quests.
+	RecomputeRatings(rules model.RatingRules) (int, DBError)
 }
 
 type dbMarkDAO struct {
 	db *sql.DB
 }
 
-func (dao *dbMarkDAO) FinishQuest(userID, questID int) DBError {
-	result, err := dao.db.Exec(finishQuest, userID, questID)
-	if err != nil {
-		return NewCrashDBErr(err)
-	}
-	return getResultErr(result)
+// FinishQuest completes the quest for the user. It fails with http.StatusConflict when
+// the user has no active attempt of the quest and until all required checkpoints of the
+// quest are reached and the scenario of the quest, if any, is finished in the attempt.
+// At least one checkpoint or a final scenario state must be reached in the attempt.
+// The run is timed from the start of the attempt.
+func (dao *dbMarkDAO) FinishQuest(userID, questID int, rules model.ScoreRules) (model.Attempt, DBError) {
+	attempt := model.Attempt{}
+	err := inTransaction(dao.db, func(tx *sql.Tx) error {
+		var attemptID int
+		err := tx.QueryRow(getActiveAttempt, userID, questID).Scan(&attemptID)
+		if err == sql.ErrNoRows {
+			return NewDBErr(http.StatusConflict, "the quest has no active attempt, start a new one")
+		} else if err != nil {
+			return err
+		}
+		attempt, err = scanAttempt(tx.QueryRow(finishAttempt, attemptID))
+		if err == sql.ErrNoRows {
+			return NewDBErr(
+				http.StatusConflict,
+				"not all required checkpoints of the quest are reached or its scenario is not finished; "+
+					"a quest without checkpoints and scenario can not be finished",
+			)
+		} else if err != nil {
+			return err
+		}
+
+		run := &attempt.Run
+		var elapsed interface{}
+		if run.StartedAt != nil {
+			run.ElapsedSeconds = int(time.Time(*attempt.EndedAt).Sub(time.Time(*run.StartedAt)).Seconds())
+			elapsed = run.ElapsedSeconds
+		}
+		err = tx.QueryRow(getRunStats, attemptID).Scan(&run.HintsUsed, &run.HintPenalty, &run.OptionalReached)
+		if err != nil {
+			return err
+		}
+		run.Score = rules.Score(*run)
+
+		_, err = tx.Exec(
+			scoreAttempt, attemptID, userID, questID, elapsed, run.HintsUsed, run.HintPenalty, run.OptionalReached, run.Score,
+		)
+		return err
+	})
+	return attempt, err
 }
 
-func (dao *dbMarkDAO) MarkQuest(userID, questID int, mark float32) DBError {
-	if r, err := dao.db.Exec(markQuest, mark, userID, questID); err != nil {
-		return NewCrashDBErr(err)
-	} else if rErr := getResultErr(r); rErr != nil {
-		return rErr
-	}
+// MarkQuest updates the rating in the transaction that saves the mark, adding the change of the
+// mark instead of aggregating all marks of the quest. Only the quests the user has completed
+// can be marked: it fails with http.StatusConflict for quests the user has not started or
+// not finished. Every new or changed mark is recorded in the mark history.
+func (dao *dbMarkDAO) MarkQuest(userID, questID int, mark float32, rules model.RatingRules) DBError {
+	return inTransaction(dao.db, func(tx *sql.Tx) error {
+		var completed, marked bool
+		var previous float64
+		err := tx.QueryRow(lockMark, userID, questID).Scan(&completed, &marked, &previous)
+		if err == sql.ErrNoRows {
+			return NewDBErr(http.StatusConflict, "the quest is not started, start and finish it before marking")
+		} else if err != nil {
+			return err
+		}
+		if !completed {
+			return NewDBErr(http.StatusConflict, "the quest is not finished, finish it before marking")
+		}
+		if _, err := tx.Exec(markQuest, mark, userID, questID); err != nil {
+			return err
+		}
 
-	if r, err := dao.db.Exec(updateRating, questID); err != nil {
-		return NewCrashDBErr(err)
-	} else if rErr := getResultErr(r); rErr != nil {
-		return rErr
+		var previousMark interface{}
+		if marked {
+			previousMark = previous
+		}
+		if !marked || float32(previous) != mark {
+			if _, err := tx.Exec(addMarkChange, userID, questID, mark, previousMark); err != nil {
+				return err
+			}
+		}
+
+		delta, added := float64(mark), 0
+		if marked {
+			delta -= previous
+		} else {
+			added = 1
+		}
+		r, err := tx.Exec(updateRating, questID, delta, added, rules.PriorWeight, rules.PriorMean)
+		if err != nil {
+			return err
+		}
+		return getResultErr(r)
+	})
+}
+
+func (dao *dbMarkDAO) RecomputeRatings(rules model.RatingRules) (int, DBError) {
+	r, err := dao.db.Exec(recomputeRatings, rules.PriorWeight, rules.PriorMean)
+	if err != nil {
+		return 0, NewCrashDBErr(err)
 	}
-	return nil
+	affected, err := r.RowsAffected()
+	return int(affected), NewCrashDBErr(err)
 }
 
 func (dao *dbMarkDAO) GetUserMarks(userID int) ([]model.Mark, DBError) {
@@ -63,6 +217,31 @@ func (dao *dbMarkDAO) GetUserMarks(userID int) ([]model.Mark, DBError) {
 	return marks, NewCrashDBErr(err)
 }
 
+func (dao *dbMarkDAO) GetMarkHistory(userID int, filter model.MarkHistoryFilter) ([]model.MarkChange, DBError) {
+	rows, err := dao.db.Query(getMarkHistory, userID, filter.QuestID, filter.BeforeID, filter.Limit)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.MarkChange, 0)
+	for rows.Next() {
+		var change model.MarkChange
+		var previous sql.NullFloat64
+		var createdAt time.Time
+		if err := rows.Scan(&change.ID, &change.QuestID, &change.Mark, &previous, &createdAt); err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		if previous.Valid {
+			previousMark := float32(previous.Float64)
+			change.PreviousMark = &previousMark
+		}
+		change.CreatedAt = model.QuotedTime(createdAt)
+		result = append(result, change)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
 func (dao *dbMarkDAO) getMarks(sql string, args ...interface{}) ([]model.Mark, error) {
 	var rows, err = dao.db.Query(sql, args...)
 	if err != nil {
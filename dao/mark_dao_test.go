@@ -6,7 +6,9 @@ import (
 	"github.com/Sovianum/arquest-server/model"
 	"github.com/stretchr/testify/suite"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
 	"testing"
+	"time"
 )
 
 type MarkTestSuite struct {
@@ -71,88 +73,251 @@ func (s *MarkTestSuite) TestGetQuestsError() {
 	s.Equal("fail", err.Error())
 }
 
+var ratingRules = model.RatingRules{PriorWeight: 2, PriorMean: 3}
+
+func (s *MarkTestSuite) expectMarkLocked(rows *sqlmock.Rows) {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT .+ FROM quest_user_link .+ FOR UPDATE").
+		WithArgs(1, 2).
+		WillReturnRows(rows)
+}
+
+var lockedMarkColumns = []string{"completed", "marked", "mark"}
+
 func (s *MarkTestSuite) TestMarkQuestOk() {
 	var mark float32 = 3.
-	userID := 1
-	questID := 2
-
+	s.expectMarkLocked(sqlmock.NewRows(lockedMarkColumns).AddRow(true, false, 0))
 	s.mock.
 		ExpectExec("UPDATE quest_user_link").
-		WithArgs(mark, userID, questID).
+		WithArgs(mark, 1, 2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.
+		ExpectExec("INSERT INTO mark_history \\(user_id, quest_id, mark, previous_mark\\)").
+		WithArgs(1, 2, mark, nil).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.
+		ExpectExec("UPDATE quest SET\\s+mark_sum = mark_sum \\+ \\$2").
+		WithArgs(2, 3., 1, 2., 3.).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
 
+	s.NoError(s.markDAO.MarkQuest(1, 2, mark, ratingRules))
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *MarkTestSuite) TestMarkQuestChangeMark() {
+	var mark float32 = 2.
+	s.expectMarkLocked(sqlmock.NewRows(lockedMarkColumns).AddRow(true, true, 5))
+	s.mock.ExpectExec("UPDATE quest_user_link").WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectExec("INSERT INTO mark_history").WithArgs(1, 2, mark, 5.).WillReturnResult(sqlmock.NewResult(1, 1))
 	s.mock.
 		ExpectExec("UPDATE quest SET").
-		WithArgs(questID).
+		WithArgs(2, -3., 0, 2., 3.).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
 
-	err := s.markDAO.MarkQuest(userID, questID, mark)
-	s.NoError(err)
+	s.NoError(s.markDAO.MarkQuest(1, 2, mark, ratingRules))
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *MarkTestSuite) TestMarkQuestSameMark() {
+	s.expectMarkLocked(sqlmock.NewRows(lockedMarkColumns).AddRow(true, true, 4))
+	s.mock.ExpectExec("UPDATE quest_user_link").WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectExec("UPDATE quest SET").WithArgs(2, 0., 0, 2., 3.).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	s.NoError(s.markDAO.MarkQuest(1, 2, 4, ratingRules))
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *MarkTestSuite) TestMarkQuestNotStarted() {
+	s.expectMarkLocked(sqlmock.NewRows(lockedMarkColumns))
+	s.mock.ExpectRollback()
+
+	err := s.markDAO.MarkQuest(1, 2, 3, ratingRules)
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.Contains(err.Error(), "not started")
+}
+
+func (s *MarkTestSuite) TestMarkQuestNotFinished() {
+	s.expectMarkLocked(sqlmock.NewRows(lockedMarkColumns).AddRow(false, false, 0))
+	s.mock.ExpectRollback()
+
+	err := s.markDAO.MarkQuest(1, 2, 3, ratingRules)
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.Contains(err.Error(), "not finished")
 }
 
 func (s *MarkTestSuite) TestMarkQuestFailMark() {
 	var mark float32 = 3.
-	userID := 1
-	questID := 2
-
+	s.expectMarkLocked(sqlmock.NewRows(lockedMarkColumns).AddRow(true, false, 0))
 	s.mock.
 		ExpectExec("UPDATE quest_user_link").
-		WithArgs(mark, userID, questID).
+		WithArgs(mark, 1, 2).
 		WillReturnError(fmt.Errorf("fail mark"))
+	s.mock.ExpectRollback()
 
-	s.mock.
-		ExpectExec("UPDATE quest SET rating").
-		WithArgs(mark, questID).
-		WillReturnError(fmt.Errorf("fail rating"))
-
-	err := s.markDAO.MarkQuest(userID, questID, mark)
+	err := s.markDAO.MarkQuest(1, 2, mark, ratingRules)
 	s.Require().Error(err)
 	s.Equal("fail mark", err.Error())
 }
 
 func (s *MarkTestSuite) TestMarkQuestFailRating() {
 	var mark float32 = 3.
-	userID := 1
-	questID := 2
-
+	s.expectMarkLocked(sqlmock.NewRows(lockedMarkColumns).AddRow(true, false, 0))
 	s.mock.
 		ExpectExec("UPDATE quest_user_link").
-		WithArgs(mark, userID, questID).
+		WithArgs(mark, 1, 2).
 		WillReturnResult(sqlmock.NewResult(1, 1))
-
+	s.mock.ExpectExec("INSERT INTO mark_history").WillReturnResult(sqlmock.NewResult(1, 1))
 	s.mock.
 		ExpectExec("UPDATE quest SET").
-		WithArgs(questID).
 		WillReturnError(fmt.Errorf("fail rating"))
+	s.mock.ExpectRollback()
 
-	err := s.markDAO.MarkQuest(userID, questID, mark)
+	err := s.markDAO.MarkQuest(1, 2, mark, ratingRules)
 	s.Require().Error(err)
 	s.Equal("fail rating", err.Error())
 }
 
+func (s *MarkTestSuite) TestGetMarkHistory() {
+	s.mock.
+		ExpectQuery("SELECT id, quest_id, mark, previous_mark, created_at FROM mark_history .+ ORDER BY id DESC LIMIT \\$4").
+		WithArgs(1, 2, 0, 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "quest_id", "mark", "previous_mark", "created_at"}).
+			AddRow(8, 2, 2., 5., time.Now()).
+			AddRow(3, 2, 5., nil, time.Now()))
+
+	history, err := s.markDAO.GetMarkHistory(1, model.MarkHistoryFilter{QuestID: 2, Limit: 50})
+	s.Require().NoError(err)
+	s.Require().Len(history, 2)
+	s.Equal(float32(2), history[0].Mark)
+	s.Require().NotNil(history[0].PreviousMark)
+	s.Equal(float32(5), *history[0].PreviousMark)
+	s.Nil(history[1].PreviousMark)
+}
+
+func (s *MarkTestSuite) TestRecomputeRatings() {
+	s.mock.
+		ExpectExec("WITH marks AS \\(.+ FROM quest_user_link WHERE marked GROUP BY quest_id").
+		WithArgs(2., 3.).
+		WillReturnResult(sqlmock.NewResult(0, 5))
+
+	count, err := s.markDAO.RecomputeRatings(ratingRules)
+	s.Require().NoError(err)
+	s.Equal(5, count)
+}
+
+func (s *MarkTestSuite) expectActiveAttempt(rows *sqlmock.Rows) {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT id FROM quest_attempt .+ outcome = 'active' .+ FOR UPDATE").
+		WithArgs(1, 2).
+		WillReturnRows(rows)
+}
+
 func (s *MarkTestSuite) TestFinishOk() {
-	userID := 1
-	questID := 2
+	startedAt := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	endedAt := startedAt.Add(10*time.Minute + 30*time.Second)
 
+	s.expectActiveAttempt(sqlmock.NewRows([]string{"id"}).AddRow(7))
 	s.mock.
-		ExpectExec("INSERT INTO quest_user_link").
-		WithArgs(userID, questID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		ExpectQuery("UPDATE quest_attempt AS a SET outcome = 'finished'").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(attemptColumnNames).AddRow(7, 2, 0, "finished", endedAt, startedAt, endedAt, 0, 0, 0, 0, 0))
+	s.mock.
+		ExpectQuery("SELECT .+ FROM hint_usage").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"hints_used", "hint_penalty", "optional_reached"}).AddRow(2, 300, 1))
+	s.mock.
+		ExpectExec("UPDATE quest_user_link SET completed = TRUE .+ UPDATE quest_attempt SET elapsed_s").
+		WithArgs(7, 1, 2, 630, 2, 300, 1, 700).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
 
-	err := s.markDAO.FinishQuest(userID, questID)
-	s.NoError(err)
+	attempt, err := s.markDAO.FinishQuest(1, 2, model.ScoreRules{MaxScore: 1000, TimePenaltyPerMinute: 5, OptionalBonus: 50})
+	s.Require().NoError(err)
+	s.Equal(7, attempt.ID)
+	s.Equal(model.AttemptFinished, attempt.Outcome)
+	s.Equal(630, attempt.ElapsedSeconds)
+	s.Equal(2, attempt.HintsUsed)
+	s.Equal(1, attempt.OptionalReached)
+	s.Equal(700, attempt.Score)
+	s.Equal(model.QuotedTime(endedAt), *attempt.FinishedAt)
+	s.NoError(s.mock.ExpectationsWereMet())
 }
 
-func (s *MarkTestSuite) TestFinishError() {
-	userID := 1
-	questID := 2
+func (s *MarkTestSuite) TestFinishUntimed() {
+	s.expectActiveAttempt(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	s.mock.
+		ExpectQuery("UPDATE quest_attempt").
+		WillReturnRows(sqlmock.NewRows(attemptColumnNames).AddRow(7, 2, 0, "finished", time.Now(), nil, time.Now(), 0, 0, 0, 0, 0))
+	s.mock.
+		ExpectQuery("SELECT .+ FROM hint_usage").
+		WillReturnRows(sqlmock.NewRows([]string{"hints_used", "hint_penalty", "optional_reached"}).AddRow(0, 0, 0))
+	s.mock.
+		ExpectExec("UPDATE quest_attempt SET elapsed_s").
+		WithArgs(7, 1, 2, nil, 0, 0, 0, 1000).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
 
+	attempt, err := s.markDAO.FinishQuest(1, 2, model.ScoreRules{MaxScore: 1000, TimePenaltyPerMinute: 5})
+	s.Require().NoError(err)
+	s.Nil(attempt.StartedAt)
+	s.Equal(1000, attempt.Score)
+}
+
+func (s *MarkTestSuite) TestFinishWithoutActiveAttempt() {
+	s.expectActiveAttempt(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectRollback()
+
+	_, err := s.markDAO.FinishQuest(1, 2, model.ScoreRules{})
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.Contains(err.Error(), "no active attempt")
+}
+
+func (s *MarkTestSuite) TestFinishCheckpointsLeft() {
+	s.expectActiveAttempt(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	s.mock.
+		ExpectQuery("UPDATE quest_attempt .+ NOT EXISTS").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(attemptColumnNames))
+	s.mock.ExpectRollback()
+
+	_, err := s.markDAO.FinishQuest(1, 2, model.ScoreRules{})
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.Contains(err.Error(), "not all required checkpoints")
+}
+
+func (s *MarkTestSuite) TestFinishWithoutProgress() {
+	s.expectActiveAttempt(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	s.mock.
+		ExpectQuery("UPDATE quest_attempt .+ EXISTS \\(SELECT 1 FROM checkpoint_progress AS p WHERE p.attempt_id = a.id\\)" +
+			"\\s+OR EXISTS \\(SELECT 1 FROM scenario_state AS st WHERE st.attempt_id = a.id AND st.final\\)").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(attemptColumnNames))
+	s.mock.ExpectRollback()
+
+	_, err := s.markDAO.FinishQuest(1, 2, model.ScoreRules{})
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.Contains(err.Error(), "without checkpoints and scenario can not be finished")
+}
+
+func (s *MarkTestSuite) TestFinishError() {
+	s.mock.ExpectBegin()
 	s.mock.
-		ExpectExec("INSERT INTO quest_user_link").
-		WithArgs(userID, questID).
+		ExpectQuery("SELECT id FROM quest_attempt").
+		WithArgs(1, 2).
 		WillReturnError(fmt.Errorf("fail"))
+	s.mock.ExpectRollback()
 
-	err := s.markDAO.FinishQuest(userID, questID)
+	_, err := s.markDAO.FinishQuest(1, 2, model.ScoreRules{})
 	s.Require().Error(err)
 	s.Equal("fail", err.Error())
 }
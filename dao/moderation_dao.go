@@ -0,0 +1,300 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/lib/pq"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	moderationItemColumns = `
+		id, content_type, content_id, COALESCE(author_id, 0), text, flags, report_count, status, created_at, updated_at
+	`
+	flagContent = `
+		INSERT INTO moderation_item (content_type, content_id, author_id, text, flags) VALUES ($1, $2, NULLIF($3, 0), $4, $5)
+		ON CONFLICT ON CONSTRAINT ux_moderation_item_content DO UPDATE
+		SET author_id = EXCLUDED.author_id, text = EXCLUDED.text, flags = EXCLUDED.flags, status = 'pending', updated_at = now()
+		RETURNING id
+	`
+	addReport = `
+		INSERT INTO content_report (content_type, content_id, reporter_id, reason) VALUES ($1, $2, $3, $4)
+		ON CONFLICT DO NOTHING
+	`
+	queueReported = `
+		INSERT INTO moderation_item (content_type, content_id, author_id, text, report_count) VALUES ($1, $2, $3, $4, 1)
+		ON CONFLICT ON CONSTRAINT ux_moderation_item_content DO UPDATE
+		SET author_id = EXCLUDED.author_id, text = EXCLUDED.text, report_count = moderation_item.report_count + 1,
+			status = 'pending', updated_at = now()
+	`
+	getModerationQueue = `
+		SELECT ` + moderationItemColumns + ` FROM moderation_item
+		WHERE status = $1 AND ($2 = '' OR content_type = $2) AND id > $3
+		ORDER BY id LIMIT $4
+	`
+	lockModerationItem  = `SELECT ` + moderationItemColumns + ` FROM moderation_item WHERE id = $1 FOR UPDATE`
+	setItemStatus       = `UPDATE moderation_item SET status = $2, updated_at = now() WHERE id = $1 RETURNING updated_at`
+	addModerationAction = `
+		INSERT INTO moderation_action (item_id, user_id, moderator_id, action, reason)
+		VALUES (NULLIF($1, 0), NULLIF($2, 0), NULLIF($3, 0), $4, $5)
+	`
+	banUser = `
+		INSERT INTO user_ban (user_id, moderator_id, reason) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET moderator_id = EXCLUDED.moderator_id, reason = EXCLUDED.reason, created_at = now()
+	`
+	unbanUser            = `DELETE FROM user_ban WHERE user_id = $1`
+	getBan               = `SELECT user_id, COALESCE(moderator_id, 0), reason, created_at FROM user_ban WHERE user_id = $1`
+	getModerationActions = `
+		SELECT id, COALESCE(item_id, 0), COALESCE(user_id, 0), COALESCE(moderator_id, 0), action, reason, created_at
+		FROM moderation_action
+		WHERE ($1 = 0 OR item_id = $1) AND ($2 = 0 OR user_id = $2) AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC LIMIT $4
+	`
+)
+
+// contentQueries read and change one kind of moderated content; all of them take the content id.
+type contentQueries struct {
+	// get returns the author (NULL if the content has no single author) and the text
+	get     string
+	approve string
+	reject  string
+}
+
+var moderatedContent = map[string]contentQueries{
+	model.ContentReview: {
+		get:     `SELECT user_id, text FROM review WHERE id = $1`,
+		approve: `UPDATE review SET hidden = FALSE WHERE id = $1`,
+		reject:  `UPDATE review SET hidden = TRUE WHERE id = $1`,
+	},
+	model.ContentReply: {
+		get:     `SELECT user_id, text FROM review_reply WHERE id = $1`,
+		approve: `UPDATE review_reply SET hidden = FALSE WHERE id = $1`,
+		reject:  `UPDATE review_reply SET hidden = TRUE WHERE id = $1`,
+	},
+	model.ContentAbout: {
+		get:    `SELECT id, about FROM users WHERE id = $1`,
+		reject: `UPDATE users SET about = '' WHERE id = $1`,
+	},
+	// teams are named by all their members together, so team names have no author
+	model.ContentTeamName: {
+		get:    `SELECT NULL::INT, name FROM team WHERE id = $1`,
+		reject: `UPDATE team SET name = 'team ' || id WHERE id = $1`,
+	},
+}
+
+// ModerationDAO keeps the moderation queue, user reports, bans and the audit trail.
+type ModerationDAO interface {
+	// Flag puts the content flagged by the automatic filter in the queue and records the flags.
+	Flag(item model.ModerationItem) DBError
+	// Report records the complaint and puts the reported content in the queue. Every user
+	// reports a piece of content once; repeated reports are ignored.
+	Report(report model.ContentReport) DBError
+	GetQueue(filter model.ModerationFilter) ([]model.ModerationItem, DBError)
+	// Decide applies the approve, reject or ban decision to the item and its content.
+	// Rejected reviews and replies are hidden, about texts are cleared and teams are renamed.
+	// Banning also rejects the content.
+	Decide(decision model.ModerationDecision) (model.ModerationItem, DBError)
+	Unban(userID, moderatorID int, reason string) DBError
+	// GetBan returns nil if the user is not banned.
+	GetBan(userID int) (*model.Ban, DBError)
+	GetActions(filter model.ModerationActionFilter) ([]model.ModerationAction, DBError)
+}
+
+func NewModerationDAO(db *sql.DB) ModerationDAO {
+	return &dbModerationDAO{db: db}
+}
+
+type dbModerationDAO struct {
+	db *sql.DB
+}
+
+func (dao *dbModerationDAO) Flag(item model.ModerationItem) DBError {
+	return inTransaction(dao.db, func(tx *sql.Tx) error {
+		var itemID int
+		err := tx.QueryRow(
+			flagContent, item.ContentType, item.ContentID, item.AuthorID, item.Text, pq.Array(item.Flags),
+		).Scan(&itemID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(addModerationAction, itemID, item.AuthorID, 0, model.ActionFlag, strings.Join(item.Flags, "; "))
+		return err
+	})
+}
+
+// Report gives http.StatusNotFound for missing content and http.StatusUnprocessableEntity
+// for the own content of the reporter.
+func (dao *dbModerationDAO) Report(report model.ContentReport) DBError {
+	queries, ok := moderatedContent[report.ContentType]
+	if !ok {
+		return NewDBErr(http.StatusUnprocessableEntity, "unknown content type")
+	}
+	return inTransaction(dao.db, func(tx *sql.Tx) error {
+		var authorID sql.NullInt64
+		var text string
+		if err := tx.QueryRow(queries.get, report.ContentID).Scan(&authorID, &text); err == sql.ErrNoRows {
+			return NewDBErr(http.StatusNotFound, "content not found")
+		} else if err != nil {
+			return err
+		}
+		if authorID.Valid && int(authorID.Int64) == report.ReporterID {
+			return NewDBErr(http.StatusUnprocessableEntity, "you can not report your own content")
+		}
+
+		r, err := tx.Exec(addReport, report.ContentType, report.ContentID, report.ReporterID, report.Reason)
+		if err != nil {
+			return err
+		}
+		affected, err := r.RowsAffected()
+		if err != nil || affected == 0 { // the user has reported the content before
+			return err
+		}
+		_, err = tx.Exec(queueReported, report.ContentType, report.ContentID, authorID, text)
+		return err
+	})
+}
+
+func (dao *dbModerationDAO) GetQueue(filter model.ModerationFilter) ([]model.ModerationItem, DBError) {
+	rows, err := dao.db.Query(getModerationQueue, filter.Status, filter.ContentType, filter.AfterID, filter.Limit)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.ModerationItem, 0)
+	for rows.Next() {
+		item, err := scanModerationItem(rows)
+		if err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		result = append(result, item)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+// Decide gives http.StatusNotFound for a missing item and http.StatusUnprocessableEntity for
+// banning the author of content without one or banning oneself. A ban revokes all tokens of
+// the user in the same transaction, so that the ban takes effect on the next request.
+func (dao *dbModerationDAO) Decide(decision model.ModerationDecision) (model.ModerationItem, DBError) {
+	var item model.ModerationItem
+	dbErr := inTransaction(dao.db, func(tx *sql.Tx) error {
+		var err error
+		item, err = scanModerationItem(tx.QueryRow(lockModerationItem, decision.ItemID))
+		if err == sql.ErrNoRows {
+			return NewDBErr(http.StatusNotFound, "moderation item not found")
+		} else if err != nil {
+			return err
+		}
+
+		queries := moderatedContent[item.ContentType]
+		status, query := model.ModerationRejected, queries.reject
+		if decision.Action == model.ActionApprove {
+			status, query = model.ModerationApproved, queries.approve
+		}
+		if decision.Action == model.ActionBan {
+			if item.AuthorID == 0 {
+				return NewDBErr(http.StatusUnprocessableEntity, "the content has no author to ban")
+			}
+			if item.AuthorID == decision.ModeratorID {
+				return NewDBErr(http.StatusUnprocessableEntity, "you can not ban yourself")
+			}
+			if _, err := tx.Exec(banUser, item.AuthorID, decision.ModeratorID, decision.Reason); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(revokeUserAccess, item.AuthorID); err != nil {
+				return err
+			}
+			if _, err := tx.Exec(revokeUserFamilies, item.AuthorID); err != nil {
+				return err
+			}
+		}
+
+		if query != "" {
+			if _, err := tx.Exec(query, item.ContentID); err != nil {
+				return err
+			}
+		}
+		var updatedAt time.Time
+		if err := tx.QueryRow(setItemStatus, item.ID, status).Scan(&updatedAt); err != nil {
+			return err
+		}
+		item.Status, item.UpdatedAt = status, model.QuotedTime(updatedAt)
+		_, err = tx.Exec(addModerationAction, item.ID, item.AuthorID, decision.ModeratorID, decision.Action, decision.Reason)
+		return err
+	})
+	return item, dbErr
+}
+
+func (dao *dbModerationDAO) Unban(userID, moderatorID int, reason string) DBError {
+	return inTransaction(dao.db, func(tx *sql.Tx) error {
+		r, err := tx.Exec(unbanUser, userID)
+		if err != nil {
+			return err
+		}
+		if affected, err := r.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return NewDBErr(http.StatusNotFound, "user is not banned")
+		}
+		_, err = tx.Exec(addModerationAction, 0, userID, moderatorID, model.ActionUnban, reason)
+		return err
+	})
+}
+
+func (dao *dbModerationDAO) GetBan(userID int) (*model.Ban, DBError) {
+	ban := new(model.Ban)
+	var createdAt time.Time
+	err := dao.db.QueryRow(getBan, userID).Scan(&ban.UserID, &ban.ModeratorID, &ban.Reason, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	ban.CreatedAt = model.QuotedTime(createdAt)
+	return ban, nil
+}
+
+func (dao *dbModerationDAO) GetActions(filter model.ModerationActionFilter) ([]model.ModerationAction, DBError) {
+	rows, err := dao.db.Query(getModerationActions, filter.ItemID, filter.UserID, filter.BeforeID, filter.Limit)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.ModerationAction, 0)
+	for rows.Next() {
+		var action model.ModerationAction
+		var createdAt time.Time
+		err := rows.Scan(
+			&action.ID, &action.ItemID, &action.UserID, &action.ModeratorID, &action.Action, &action.Reason, &createdAt,
+		)
+		if err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		action.CreatedAt = model.QuotedTime(createdAt)
+		result = append(result, action)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+func scanModerationItem(row scanner) (model.ModerationItem, error) {
+	item := model.ModerationItem{}
+	var flags pq.StringArray
+	var createdAt, updatedAt time.Time
+	err := row.Scan(
+		&item.ID, &item.ContentType, &item.ContentID, &item.AuthorID, &item.Text, &flags, &item.ReportCount,
+		&item.Status, &createdAt, &updatedAt,
+	)
+	if err != nil {
+		return item, err
+	}
+	item.Flags = []string(flags)
+	if item.Flags == nil {
+		item.Flags = []string{}
+	}
+	item.CreatedAt = model.QuotedTime(createdAt)
+	item.UpdatedAt = model.QuotedTime(updatedAt)
+	return item, nil
+}
@@ -0,0 +1,255 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var moderationItemColumnNames = []string{
+	"id", "content_type", "content_id", "author_id", "text", "flags", "report_count", "status", "created_at", "updated_at",
+}
+
+type ModerationTestSuite struct {
+	suite.Suite
+	db            *sql.DB
+	mock          sqlmock.Sqlmock
+	moderationDAO ModerationDAO
+}
+
+func (s *ModerationTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.moderationDAO = NewModerationDAO(s.db)
+}
+
+func (s *ModerationTestSuite) expectLockedItem(contentType string, authorID int) {
+	s.mock.
+		ExpectQuery("SELECT .+ FROM moderation_item WHERE id = \\$1 FOR UPDATE").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(moderationItemColumnNames).
+			AddRow(7, contentType, 5, authorID, "text", "{link: x.com}", 2, model.ModerationPending, time.Now(), time.Now()))
+}
+
+func (s *ModerationTestSuite) TestFlag() {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("INSERT INTO moderation_item \\(content_type, content_id, author_id, text, flags\\)").
+		WithArgs(model.ContentReview, 5, 2, "see x.com", pq.Array([]string{"link: x.com"})).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	s.mock.
+		ExpectExec("INSERT INTO moderation_action").
+		WithArgs(7, 2, 0, model.ActionFlag, "link: x.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	s.NoError(s.moderationDAO.Flag(model.ModerationItem{
+		ContentType: model.ContentReview, ContentID: 5, AuthorID: 2, Text: "see x.com", Flags: []string{"link: x.com"},
+	}))
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ModerationTestSuite) TestReport() {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT user_id, text FROM review WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "text"}).AddRow(2, "bad"))
+	s.mock.
+		ExpectExec("INSERT INTO content_report").
+		WithArgs(model.ContentReview, 5, 3, "rude").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectExec("INSERT INTO moderation_item .+ report_count = moderation_item.report_count \\+ 1").
+		WithArgs(model.ContentReview, 5, sql.NullInt64{Int64: 2, Valid: true}, "bad").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	s.NoError(s.moderationDAO.Report(model.ContentReport{ContentType: model.ContentReview, ContentID: 5, ReporterID: 3, Reason: "rude"}))
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ModerationTestSuite) TestReportTwice() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT NULL::INT, name FROM team").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"author", "name"}).AddRow(nil, "owls"))
+	s.mock.ExpectExec("INSERT INTO content_report").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+
+	s.NoError(s.moderationDAO.Report(model.ContentReport{ContentType: model.ContentTeamName, ContentID: 5, ReporterID: 3}))
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ModerationTestSuite) TestReportOwnContent() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id, about FROM users").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "about"}).AddRow(3, "me"))
+	s.mock.ExpectRollback()
+
+	err := s.moderationDAO.Report(model.ContentReport{ContentType: model.ContentAbout, ContentID: 3, ReporterID: 3})
+	s.Require().Error(err)
+	s.Equal(http.StatusUnprocessableEntity, err.Code())
+}
+
+func (s *ModerationTestSuite) TestReportMissingContent() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT user_id, text FROM review_reply").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"user_id", "text"}))
+	s.mock.ExpectRollback()
+
+	err := s.moderationDAO.Report(model.ContentReport{ContentType: model.ContentReply, ContentID: 5, ReporterID: 3})
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *ModerationTestSuite) TestGetQueue() {
+	s.mock.
+		ExpectQuery("SELECT .+ FROM moderation_item WHERE status = \\$1").
+		WithArgs(model.ModerationPending, model.ContentReview, 6, 10).
+		WillReturnRows(sqlmock.NewRows(moderationItemColumnNames).
+			AddRow(7, model.ContentReview, 5, 2, "text", "{\"link: x.com\"}", 0, model.ModerationPending, time.Now(), time.Now()).
+			AddRow(8, model.ContentReview, 6, 2, "text", "{}", 1, model.ModerationPending, time.Now(), time.Now()))
+
+	items, err := s.moderationDAO.GetQueue(model.ModerationFilter{
+		Status: model.ModerationPending, ContentType: model.ContentReview, AfterID: 6, Limit: 10,
+	})
+	s.Require().NoError(err)
+	s.Require().Len(items, 2)
+	s.Equal([]string{"link: x.com"}, items[0].Flags)
+	s.NotNil(items[1].Flags)
+	s.Empty(items[1].Flags)
+}
+
+func (s *ModerationTestSuite) TestApprove() {
+	s.mock.ExpectBegin()
+	s.expectLockedItem(model.ContentReview, 2)
+	s.mock.ExpectExec("UPDATE review SET hidden = FALSE").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectQuery("UPDATE moderation_item SET status = \\$2").
+		WithArgs(7, model.ModerationApproved).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+	s.mock.
+		ExpectExec("INSERT INTO moderation_action").
+		WithArgs(7, 2, 9, model.ActionApprove, "ok").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	item, err := s.moderationDAO.Decide(model.ModerationDecision{ItemID: 7, ModeratorID: 9, Action: model.ActionApprove, Reason: "ok"})
+	s.Require().NoError(err)
+	s.Equal(model.ModerationApproved, item.Status)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ModerationTestSuite) TestApproveAbout() {
+	s.mock.ExpectBegin()
+	s.expectLockedItem(model.ContentAbout, 5)
+	s.mock.ExpectQuery("UPDATE moderation_item").WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+	s.mock.ExpectExec("INSERT INTO moderation_action").WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	_, err := s.moderationDAO.Decide(model.ModerationDecision{ItemID: 7, ModeratorID: 9, Action: model.ActionApprove})
+	s.Require().NoError(err)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ModerationTestSuite) TestBan() {
+	s.mock.ExpectBegin()
+	s.expectLockedItem(model.ContentReply, 2)
+	s.mock.ExpectExec("INSERT INTO user_ban").WithArgs(2, 9, "spam").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("INSERT INTO revoked_token").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("UPDATE refresh_token SET revoked = TRUE").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("UPDATE review_reply SET hidden = TRUE").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectQuery("UPDATE moderation_item").
+		WithArgs(7, model.ModerationRejected).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+	s.mock.
+		ExpectExec("INSERT INTO moderation_action").
+		WithArgs(7, 2, 9, model.ActionBan, "spam").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	item, err := s.moderationDAO.Decide(model.ModerationDecision{ItemID: 7, ModeratorID: 9, Action: model.ActionBan, Reason: "spam"})
+	s.Require().NoError(err)
+	s.Equal(model.ModerationRejected, item.Status)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ModerationTestSuite) TestBanTeamName() {
+	s.mock.ExpectBegin()
+	s.expectLockedItem(model.ContentTeamName, 0)
+	s.mock.ExpectRollback()
+
+	_, err := s.moderationDAO.Decide(model.ModerationDecision{ItemID: 7, ModeratorID: 9, Action: model.ActionBan})
+	s.Require().Error(err)
+	s.Equal(http.StatusUnprocessableEntity, err.Code())
+}
+
+func (s *ModerationTestSuite) TestDecideMissingItem() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT .+ FROM moderation_item").WithArgs(7).WillReturnRows(sqlmock.NewRows(moderationItemColumnNames))
+	s.mock.ExpectRollback()
+
+	_, err := s.moderationDAO.Decide(model.ModerationDecision{ItemID: 7, ModeratorID: 9, Action: model.ActionReject})
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *ModerationTestSuite) TestUnban() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("DELETE FROM user_ban WHERE user_id = \\$1").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.
+		ExpectExec("INSERT INTO moderation_action").
+		WithArgs(0, 2, 9, model.ActionUnban, "appeal").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	s.NoError(s.moderationDAO.Unban(2, 9, "appeal"))
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ModerationTestSuite) TestUnbanNotBanned() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("DELETE FROM user_ban").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectRollback()
+
+	err := s.moderationDAO.Unban(2, 9, "")
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *ModerationTestSuite) TestGetBan() {
+	columns := []string{"user_id", "moderator_id", "reason", "created_at"}
+	s.mock.ExpectQuery("SELECT user_id, .+ FROM user_ban").WithArgs(2).WillReturnRows(sqlmock.NewRows(columns).AddRow(2, 9, "spam", time.Now()))
+	s.mock.ExpectQuery("SELECT user_id, .+ FROM user_ban").WithArgs(3).WillReturnRows(sqlmock.NewRows(columns))
+
+	ban, err := s.moderationDAO.GetBan(2)
+	s.Require().NoError(err)
+	s.Require().NotNil(ban)
+	s.Equal("spam", ban.Reason)
+
+	ban, err = s.moderationDAO.GetBan(3)
+	s.Require().NoError(err)
+	s.Nil(ban)
+}
+
+func (s *ModerationTestSuite) TestGetActions() {
+	s.mock.
+		ExpectQuery("SELECT .+ FROM moderation_action .+ ORDER BY id DESC LIMIT \\$4").
+		WithArgs(7, 0, 0, 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "item_id", "user_id", "moderator_id", "action", "reason", "created_at"}).
+			AddRow(2, 7, 2, 9, model.ActionReject, "rude", time.Now()).
+			AddRow(1, 7, 2, 0, model.ActionFlag, "banned_word: fool", time.Now()))
+
+	actions, err := s.moderationDAO.GetActions(model.ModerationActionFilter{ItemID: 7, Limit: 50})
+	s.Require().NoError(err)
+	s.Require().Len(actions, 2)
+	s.Equal(0, actions[1].ModeratorID)
+}
+
+func TestModerationTestSuite(t *testing.T) {
+	suite.Run(t, new(ModerationTestSuite))
+}
@@ -0,0 +1,202 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/Sovianum/arquest-server/geo"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/lib/pq"
+	"sync"
+	"time"
+)
+
+const (
+	SpatialAuto    = "auto"
+	SpatialPostGIS = "postgis"
+	SpatialIndex   = "index"
+
+	hasPostGIS = `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'postgis')`
+
+	// the geography expression matches ix_quest_start_geog
+	getNearbyQuests = `
+		SELECT ` + questColumns + `,
+			ST_Distance(
+				ST_SetSRID(ST_MakePoint(start_lon, start_lat), 4326)::geography,
+				ST_SetSRID(ST_MakePoint($2, $1), 4326)::geography
+			) AS distance
+		FROM quest
+		WHERE deleted_at IS NULL AND start_lat IS NOT NULL AND ST_DWithin(
+			ST_SetSRID(ST_MakePoint(start_lon, start_lat), 4326)::geography,
+			ST_SetSRID(ST_MakePoint($2, $1), 4326)::geography,
+			$3
+		)
+		ORDER BY distance
+		LIMIT $4
+	`
+
+	getQuestsStamp    = `SELECT count(*), COALESCE(max(updated_at), 'epoch') FROM quest WHERE deleted_at IS NULL`
+	getQuestLocations = `
+		SELECT id, start_lat, start_lon FROM quest WHERE deleted_at IS NULL AND start_lat IS NOT NULL
+	`
+	getQuestsByIDs = `SELECT ` + questColumns + ` FROM quest WHERE id = ANY($1) AND deleted_at IS NULL`
+)
+
+type NearbyQuestDAO interface {
+	// GetNearbyQuests returns up to limit quests starting within radius meters of center, nearest first.
+	GetNearbyQuests(center geo.Point, radius float64, limit int) ([]model.NearbyQuest, DBError)
+}
+
+// NewNearbyQuestDAO picks the search implementation according to spatial, one of the
+// Spatial* constants. In auto mode PostGIS is used when the extension is installed,
+// otherwise (including databases other than PostgreSQL) the in-memory index is used.
+func NewNearbyQuestDAO(db *sql.DB, spatial string) (NearbyQuestDAO, error) {
+	switch spatial {
+	case SpatialPostGIS:
+		var installed bool
+		if err := db.QueryRow(hasPostGIS).Scan(&installed); err != nil {
+			return nil, err
+		} else if !installed {
+			return nil, fmt.Errorf("postgis extension is not installed")
+		}
+		return NewPostGISNearbyQuestDAO(db), nil
+	case SpatialIndex:
+		return NewIndexNearbyQuestDAO(db), nil
+	case SpatialAuto, "":
+		var installed bool
+		if err := db.QueryRow(hasPostGIS).Scan(&installed); err == nil && installed {
+			return NewPostGISNearbyQuestDAO(db), nil
+		}
+		return NewIndexNearbyQuestDAO(db), nil
+	default:
+		return nil, fmt.Errorf("unknown spatial search %q", spatial)
+	}
+}
+
+func NewPostGISNearbyQuestDAO(db *sql.DB) NearbyQuestDAO {
+	return &postGISNearbyQuestDAO{db: db}
+}
+
+type postGISNearbyQuestDAO struct {
+	db *sql.DB
+}
+
+func (dao *postGISNearbyQuestDAO) GetNearbyQuests(center geo.Point, radius float64, limit int) ([]model.NearbyQuest, DBError) {
+	rows, err := dao.db.Query(getNearbyQuests, center.Lat, center.Lon, radius, limit)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.NearbyQuest, 0)
+	for rows.Next() {
+		var distance float64
+		quest, err := scanQuest(&distanceScanner{row: rows, distance: &distance})
+		if err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		result = append(result, model.NearbyQuest{Quest: quest, Distance: distance})
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+// distanceScanner reads the distance column following the quest columns.
+type distanceScanner struct {
+	row      scanner
+	distance *float64
+}
+
+func (s *distanceScanner) Scan(dest ...interface{}) error {
+	return s.row.Scan(append(dest, s.distance)...)
+}
+
+// NewIndexNearbyQuestDAO searches quest start points in an in-memory geo.Index. The index
+// is rebuilt whenever the number of quests or their last update time changes, so it never
+// serves stale locations.
+func NewIndexNearbyQuestDAO(db *sql.DB) NearbyQuestDAO {
+	return &indexNearbyQuestDAO{db: db}
+}
+
+type indexNearbyQuestDAO struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	index     *geo.Index
+	count     int
+	updatedAt time.Time
+}
+
+func (dao *indexNearbyQuestDAO) GetNearbyQuests(center geo.Point, radius float64, limit int) ([]model.NearbyQuest, DBError) {
+	index, err := dao.getIndex()
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	hits := index.Nearby(center, radius, limit)
+	result := make([]model.NearbyQuest, 0, len(hits))
+	if len(hits) == 0 {
+		return result, nil
+	}
+
+	ids := make([]int64, len(hits))
+	for i, hit := range hits {
+		ids[i] = int64(hit.ID)
+	}
+	rows, err := dao.db.Query(getQuestsByIDs, pq.Array(ids))
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	quests := make(map[int]model.Quest, len(hits))
+	for rows.Next() {
+		quest, err := scanQuest(rows)
+		if err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		quests[quest.ID] = quest
+	}
+	if err := rows.Err(); err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+
+	for _, hit := range hits {
+		if quest, ok := quests[hit.ID]; ok { // may have been deleted since the index was built
+			result = append(result, model.NearbyQuest{Quest: quest, Distance: hit.Distance})
+		}
+	}
+	return result, nil
+}
+
+func (dao *indexNearbyQuestDAO) getIndex() (*geo.Index, error) {
+	var count int
+	var updatedAt time.Time
+	if err := dao.db.QueryRow(getQuestsStamp).Scan(&count, &updatedAt); err != nil {
+		return nil, err
+	}
+
+	dao.mu.Lock()
+	defer dao.mu.Unlock()
+	if dao.index != nil && dao.count == count && dao.updatedAt.Equal(updatedAt) {
+		return dao.index, nil
+	}
+
+	rows, err := dao.db.Query(getQuestLocations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	index := geo.NewIndex(geo.DefaultCellSize)
+	for rows.Next() {
+		var id int
+		var p geo.Point
+		if err := rows.Scan(&id, &p.Lat, &p.Lon); err != nil {
+			return nil, err
+		}
+		index.Insert(id, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	dao.index, dao.count, dao.updatedAt = index, count, updatedAt
+	return index, nil
+}
@@ -0,0 +1,114 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/geo"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+type NearbyTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	mock sqlmock.Sqlmock
+}
+
+func (s *NearbyTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+}
+
+func (s *NearbyTestSuite) expectStamp(count int, updatedAt time.Time) {
+	s.mock.
+		ExpectQuery("SELECT count\\(\\*\\), COALESCE\\(max\\(updated_at\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "max"}).AddRow(count, updatedAt))
+}
+
+func (s *NearbyTestSuite) TestPostGIS() {
+	columns := append(append([]string{}, questColumnNames...), "distance")
+	row := append(questRow(1, "n1", "d1", 1), 120.5)
+	s.mock.
+		ExpectQuery("ST_DWithin").
+		WithArgs(55.75, 37.61, 1000., 10).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(row...))
+
+	quests, err := NewPostGISNearbyQuestDAO(s.db).GetNearbyQuests(geo.Point{Lat: 55.75, Lon: 37.61}, 1000, 10)
+	s.Require().NoError(err)
+	s.Require().Len(quests, 1)
+	s.Equal(1, quests[0].ID)
+	s.Equal(120.5, quests[0].Distance)
+}
+
+func (s *NearbyTestSuite) TestIndexRebuildsOnChange() {
+	dao := NewIndexNearbyQuestDAO(s.db)
+	center := geo.Point{Lat: 55.75, Lon: 37.61}
+	stamp := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	s.expectStamp(2, stamp)
+	s.mock.
+		ExpectQuery("SELECT id, start_lat, start_lon").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start_lat", "start_lon"}).
+			AddRow(1, 55.751, 37.61).
+			AddRow(2, 59.93, 30.31))
+	s.mock.
+		ExpectQuery("id = ANY").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(questColumnNames).AddRow(questRow(1, "n1", "d1", 1)...))
+
+	quests, err := dao.GetNearbyQuests(center, 1000, 10)
+	s.Require().NoError(err)
+	s.Require().Len(quests, 1)
+	s.Equal(1, quests[0].ID)
+	s.InDelta(111, quests[0].Distance, 1)
+
+	// unchanged stamp reuses the index
+	s.expectStamp(2, stamp)
+	quests, err = dao.GetNearbyQuests(geo.Point{Lat: 0, Lon: 0}, 1000, 10)
+	s.Require().NoError(err)
+	s.Empty(quests)
+
+	// a moved quest changes the stamp and the index is rebuilt
+	s.expectStamp(2, stamp.Add(time.Minute))
+	s.mock.
+		ExpectQuery("SELECT id, start_lat, start_lon").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "start_lat", "start_lon"}).
+			AddRow(1, 0., 0.).
+			AddRow(2, 59.93, 30.31))
+	quests, err = dao.GetNearbyQuests(center, 1000, 10)
+	s.Require().NoError(err)
+	s.Empty(quests)
+
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *NearbyTestSuite) TestAutoDetection() {
+	s.mock.
+		ExpectQuery("SELECT EXISTS").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	dao, err := NewNearbyQuestDAO(s.db, SpatialAuto)
+	s.Require().NoError(err)
+	s.IsType(&postGISNearbyQuestDAO{}, dao)
+
+	s.mock.
+		ExpectQuery("SELECT EXISTS").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	dao, err = NewNearbyQuestDAO(s.db, SpatialAuto)
+	s.Require().NoError(err)
+	s.IsType(&indexNearbyQuestDAO{}, dao)
+
+	s.mock.
+		ExpectQuery("SELECT EXISTS").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	_, err = NewNearbyQuestDAO(s.db, SpatialPostGIS)
+	s.Error(err)
+
+	_, err = NewNearbyQuestDAO(s.db, "rtree")
+	s.Error(err)
+}
+
+func TestNearbyTestSuite(t *testing.T) {
+	suite.Run(t, new(NearbyTestSuite))
+}
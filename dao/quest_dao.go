@@ -2,19 +2,98 @@ package dao
 
 import (
 	"database/sql"
+	"github.com/Sovianum/arquest-server/geo"
 	"github.com/Sovianum/arquest-server/model"
+	"github.com/lib/pq"
+	"net/http"
 )
 
 const (
-	getAllQuests     = `SELECT id, name, description, rating FROM quest`
+	// questColumns are the columns read by scanQuest
+	questColumns = `
+		id, name, description, rating, author_id, version, COALESCE(published_version, 0),
+		COALESCE(archive_size, 0), COALESCE(archive_sha256, ''),
+		start_lat, start_lon, area_min_lat, area_min_lon, area_max_lat, area_max_lon, min_team_size, max_team_size,
+		tags, COALESCE(difficulty, ''), COALESCE(duration_minutes, 0)
+	`
+	// questSearchVector matches ix_quest_search
+	questSearchVector = `(
+		setweight(to_tsvector('russian', COALESCE(name, '')), 'A') ||
+		setweight(to_tsvector('russian', COALESCE(description, '')), 'B') ||
+		setweight(to_tsvector('english', COALESCE(name, '')), 'A') ||
+		setweight(to_tsvector('english', COALESCE(description, '')), 'B')
+	)`
+	// listQuests sorts every listing by a single key in descending order, so that one keyset
+	// condition pages all of them: distance is negated to list the nearest quests first.
+	// The distance to ($7, $8) is NULL without the point or the start of the quest; the
+	// radius of the Earth is geo.EarthRadius.
+	listQuests = `
+		SELECT ` + questColumns + `, d.distance, k.sort_key
+		FROM quest
+		CROSS JOIN LATERAL (
+			SELECT 2 * 6371008.8 * asin(LEAST(1, sqrt(
+				power(sin(radians(start_lat - $7) / 2), 2) +
+				cos(radians($7)) * cos(radians(start_lat)) * power(sin(radians(start_lon - $8) / 2), 2)
+			))) AS distance
+		) AS d
+		CROSS JOIN (SELECT plainto_tsquery('russian', $6) || plainto_tsquery('english', $6) AS query) AS s
+		CROSS JOIN LATERAL (
+			SELECT CASE $9
+				WHEN 'rating' THEN rating::FLOAT8
+				WHEN 'popularity' THEN (SELECT count(*) FROM quest_user_link AS l WHERE l.quest_id = quest.id)::FLOAT8
+				WHEN 'distance' THEN -d.distance
+				WHEN 'relevance' THEN ts_rank(` + questSearchVector + `, s.query)::FLOAT8
+				ELSE extract(EPOCH FROM created_at)::FLOAT8
+			END AS sort_key
+		) AS k
+		WHERE deleted_at IS NULL
+			AND tags @> $1
+			AND (cardinality($2::TEXT[]) = 0 OR difficulty = ANY($2))
+			AND ($3 = 0 OR duration_minutes >= $3)
+			AND ($4 = 0 OR duration_minutes <= $4)
+			AND rating >= $5
+			AND ($6 = '' OR ` + questSearchVector + ` @@ s.query)
+			AND ($9 <> 'distance' OR d.distance IS NOT NULL)
+			AND (NOT $10 OR (k.sort_key, quest.id) < ($11, $12))
+		ORDER BY k.sort_key DESC, quest.id DESC
+		LIMIT $13
+	`
 	getFinishedQuest = `
-		SELECT q.id AS id, q.name AS name, q.description AS description, q.rating AS rating
-		FROM 
-			quest AS q 
-			JOIN quest_user_link AS link ON q.id = link.quest_id 
-		WHERE link.user_id = $1 AND link.completed
+		SELECT ` + questColumns + `, ` + runColumns + ` FROM quest
+		JOIN (
+			SELECT DISTINCT ON (quest_id)
+				quest_id, outcome, started_at, ended_at, elapsed_s, hints_used, hint_penalty, optional_reached, score
+			FROM quest_attempt
+			WHERE outcome = 'finished' AND (
+				team_id IS NULL AND user_id = $1 OR id IN (SELECT attempt_id FROM quest_attempt_member WHERE user_id = $1)
+			)
+			ORDER BY quest_id, score DESC NULLS LAST, elapsed_s NULLS LAST, id
+		) AS a ON a.quest_id = quest.id
+	`
+	getAllQuests = `SELECT ` + questColumns + ` FROM quest WHERE deleted_at IS NULL`
+
+	existQuest  = `SELECT count(*) FROM quest WHERE id = $1 AND deleted_at IS NULL`
+	getQuest    = `SELECT ` + questColumns + ` FROM quest WHERE id = $1 AND deleted_at IS NULL`
+	createQuest = `
+		INSERT INTO quest (
+			name, description, author_id, start_lat, start_lon, area_min_lat, area_min_lon, area_max_lat, area_max_lon,
+			min_team_size, max_team_size, tags, difficulty, duration_minutes
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, NULLIF($13, ''), NULLIF($14, 0))
+		RETURNING id, version
+	`
+	updateQuest = `
+		UPDATE quest SET
+			name = $1, description = $2, start_lat = $5, start_lon = $6,
+			area_min_lat = $7, area_min_lon = $8, area_max_lat = $9, area_max_lon = $10,
+			min_team_size = $11, max_team_size = $12,
+			tags = $13, difficulty = NULLIF($14, ''), duration_minutes = NULLIF($15, 0),
+			version = version + 1, updated_at = now()
+		WHERE id = $3 AND version = $4 AND deleted_at IS NULL
+		RETURNING version
+	`
+	deleteQuest = `
+		UPDATE quest SET deleted_at = now(), version = version + 1 WHERE id = $1 AND deleted_at IS NULL
 	`
-	existQuest = `SELECT count(*) FROM quest WHERE id = $1`
 )
 
 func NewQuestDAO(db *sql.DB) QuestDAO {
@@ -22,21 +101,113 @@ func NewQuestDAO(db *sql.DB) QuestDAO {
 }
 
 type QuestDAO interface {
-	GetFinishedQuests(userID int) ([]model.Quest, DBError)
+	// GetFinishedQuests returns the quests completed by the user with the best solo or team run
+	// of the user in each.
+	GetFinishedQuests(userID int) ([]model.FinishedQuest, DBError)
+	// GetAllQuests returns every quest unpaged; it backs the listing requested without parameters.
 	GetAllQuests() ([]model.Quest, DBError)
+	// ListQuests returns a page of the quests selected by filter.
+	ListQuests(filter model.QuestFilter) (model.QuestPage, DBError)
 	ExistsByID(questID int) (bool, DBError)
+	GetQuest(questID int) (model.Quest, DBError)
+	CreateQuest(quest model.Quest) (model.Quest, DBError)
+	UpdateQuest(quest model.Quest) (model.Quest, DBError)
+	DeleteQuest(questID int) DBError
 }
 
 type dbQuestDAO struct {
 	db *sql.DB
 }
 
-func (dao *dbQuestDAO) GetFinishedQuests(userID int) ([]model.Quest, DBError) {
-	return dao.getQuests(getFinishedQuest, userID)
+func (dao *dbQuestDAO) GetFinishedQuests(userID int) ([]model.FinishedQuest, DBError) {
+	rows, err := dao.db.Query(getFinishedQuest, userID)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.FinishedQuest, 0)
+	for rows.Next() {
+		finished := model.FinishedQuest{}
+		run := &runScanner{}
+		finished.Quest, err = scanQuest(&extraScanner{row: rows, extra: run.targets()})
+		if err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		finished.Run = run.run()
+		result = append(result, finished)
+	}
+	return result, NewCrashDBErr(rows.Err())
 }
 
 func (dao *dbQuestDAO) GetAllQuests() ([]model.Quest, DBError) {
-	return dao.getQuests(getAllQuests)
+	rows, err := dao.db.Query(getAllQuests)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.Quest, 0)
+	for rows.Next() {
+		quest, err := scanQuest(rows)
+		if err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		result = append(result, quest)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+// ListQuests reads one quest more than the limit to learn whether the page is the last one.
+func (dao *dbQuestDAO) ListQuests(filter model.QuestFilter) (model.QuestPage, DBError) {
+	page := model.QuestPage{Quests: make([]model.ListedQuest, 0)}
+	tags, difficulties := filter.Tags, filter.Difficulties
+	if tags == nil {
+		tags = []string{}
+	}
+	if difficulties == nil {
+		difficulties = []string{}
+	}
+	var lat, lon interface{}
+	if filter.Center != nil {
+		lat, lon = filter.Center.Lat, filter.Center.Lon
+	}
+	var cursorKey float64
+	var cursorID int
+	if filter.Cursor != nil {
+		cursorKey, cursorID = filter.Cursor.Key, filter.Cursor.ID
+	}
+
+	rows, err := dao.db.Query(
+		listQuests, pq.Array(tags), pq.Array(difficulties), filter.MinDuration, filter.MaxDuration, filter.MinRating,
+		filter.Query, lat, lon, filter.Sort, filter.Cursor != nil, cursorKey, cursorID, filter.Limit+1,
+	)
+	if err != nil {
+		return page, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	var lastKey float64
+	for rows.Next() {
+		if len(page.Quests) == filter.Limit {
+			last := page.Quests[len(page.Quests)-1]
+			page.NextCursor = model.QuestCursor{Sort: filter.Sort, Key: lastKey, ID: last.ID}.Encode()
+			break
+		}
+		var distance sql.NullFloat64
+		var key float64
+		quest, err := scanQuest(&extraScanner{row: rows, extra: []interface{}{&distance, &key}})
+		if err != nil {
+			return page, NewCrashDBErr(err)
+		}
+		listed := model.ListedQuest{Quest: quest}
+		if distance.Valid {
+			listed.Distance = &distance.Float64
+		}
+		page.Quests = append(page.Quests, listed)
+		lastKey = key
+	}
+	return page, NewCrashDBErr(rows.Err())
 }
 
 func (dao *dbQuestDAO) ExistsByID(questID int) (bool, DBError) {
@@ -49,31 +220,122 @@ func (dao *dbQuestDAO) ExistsByID(questID int) (bool, DBError) {
 	return cnt > 0, nil
 }
 
-func (dao *dbQuestDAO) getQuests(sql string, args ...interface{}) ([]model.Quest, DBError) {
-	rows, err := dao.db.Query(sql, args...)
+func (dao *dbQuestDAO) GetQuest(questID int) (model.Quest, DBError) {
+	quest, err := scanQuest(dao.db.QueryRow(getQuest, questID))
+	if err == sql.ErrNoRows {
+		return quest, NewDBErr(http.StatusNotFound, "quest not found")
+	} else if err != nil {
+		return quest, NewCrashDBErr(err)
+	}
+	return quest, nil
+}
+
+// CreateQuest saves a new quest of quest.AuthorID and returns it with the assigned id and version.
+func (dao *dbQuestDAO) CreateQuest(quest model.Quest) (model.Quest, DBError) {
+	args := append([]interface{}{quest.Name, quest.Description, quest.AuthorID}, locationArgs(quest)...)
+	args = append(args, teamArgs(quest)...)
+	args = append(args, listingArgs(quest)...)
+	err := dao.db.QueryRow(createQuest, args...).Scan(&quest.ID, &quest.Version)
 	if err != nil {
-		return nil, NewCrashDBErr(err)
+		return quest, NewCrashDBErr(err)
 	}
-	defer rows.Close()
+	quest.Rating = 0
+	return quest, nil
+}
 
-	result := make([]model.Quest, 0)
-	for rows.Next() {
-		quest := model.Quest{}
-		err = rows.Scan(
-			&quest.ID,
-			&quest.Name,
-			&quest.Description,
-			&quest.Rating,
-		)
-		if err != nil {
-			return nil, NewCrashDBErr(err)
+// UpdateQuest overwrites name, description, location, team limits and listing fields only if quest.Version is still the current
+// version of the quest. A stale version yields http.StatusConflict.
+func (dao *dbQuestDAO) UpdateQuest(quest model.Quest) (model.Quest, DBError) {
+	args := append([]interface{}{quest.Name, quest.Description, quest.ID, quest.Version}, locationArgs(quest)...)
+	args = append(args, teamArgs(quest)...)
+	args = append(args, listingArgs(quest)...)
+	err := dao.db.QueryRow(updateQuest, args...).Scan(&quest.Version)
+	if err == sql.ErrNoRows {
+		if exists, existsErr := dao.ExistsByID(quest.ID); existsErr != nil {
+			return quest, existsErr
+		} else if !exists {
+			return quest, NewDBErr(http.StatusNotFound, "quest not found")
 		}
-		result = append(result, quest)
+		return quest, NewDBErr(http.StatusConflict, "quest has been modified by someone else, reload it and try again")
+	} else if err != nil {
+		return quest, NewCrashDBErr(err)
 	}
+	return quest, nil
+}
 
-	err = rows.Err()
+// DeleteQuest hides the quest from listings; progress and marks of its players are kept.
+func (dao *dbQuestDAO) DeleteQuest(questID int) DBError {
+	result, err := dao.db.Exec(deleteQuest, questID)
 	if err != nil {
-		return nil, NewCrashDBErr(err)
+		return NewCrashDBErr(err)
+	}
+	return getResultErr(result)
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanQuest(row scanner) (model.Quest, error) {
+	quest := model.Quest{}
+	var authorID sql.NullInt64
+	var start [2]sql.NullFloat64
+	var area [4]sql.NullFloat64
+	var teamSize [2]sql.NullInt64
+	var tags pq.StringArray
+	err := row.Scan(
+		&quest.ID, &quest.Name, &quest.Description, &quest.Rating, &authorID, &quest.Version,
+		&quest.PublishedVersion, &quest.ArchiveSize, &quest.ArchiveSHA256,
+		&start[0], &start[1], &area[0], &area[1], &area[2], &area[3], &teamSize[0], &teamSize[1],
+		&tags, &quest.Difficulty, &quest.DurationMinutes,
+	)
+	if err != nil {
+		return quest, err
+	}
+	quest.AuthorID = int(authorID.Int64)
+	if len(tags) > 0 {
+		quest.Tags = []string(tags)
+	}
+	if start[0].Valid && start[1].Valid {
+		quest.Location = &geo.Point{Lat: start[0].Float64, Lon: start[1].Float64}
+	}
+	if area[0].Valid && area[1].Valid && area[2].Valid && area[3].Valid {
+		quest.Area = &geo.Rect{
+			MinLat: area[0].Float64, MinLon: area[1].Float64, MaxLat: area[2].Float64, MaxLon: area[3].Float64,
+		}
+	}
+	if teamSize[0].Valid && teamSize[1].Valid {
+		quest.Teams = &model.TeamLimits{MinSize: int(teamSize[0].Int64), MaxSize: int(teamSize[1].Int64)}
+	}
+	return quest, nil
+}
+
+// locationArgs returns start point and area of the quest as nullable query arguments.
+func locationArgs(quest model.Quest) []interface{} {
+	args := make([]interface{}, 6)
+	if quest.Location != nil {
+		args[0], args[1] = quest.Location.Lat, quest.Location.Lon
+	}
+	if quest.Area != nil {
+		args[2], args[3], args[4], args[5] = quest.Area.MinLat, quest.Area.MinLon, quest.Area.MaxLat, quest.Area.MaxLon
+	}
+	return args
+}
+
+// listingArgs returns the tags, difficulty and duration of the quest; the last two are stored
+// as NULL when empty.
+func listingArgs(quest model.Quest) []interface{} {
+	tags := quest.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return []interface{}{pq.Array(tags), quest.Difficulty, quest.DurationMinutes}
+}
+
+// teamArgs returns the team limits of the quest as nullable query arguments.
+func teamArgs(quest model.Quest) []interface{} {
+	if quest.Teams == nil {
+		return []interface{}{nil, nil}
 	}
-	return result, nil
+	return []interface{}{quest.Teams.MinSize, quest.Teams.MaxSize}
 }
@@ -2,13 +2,28 @@ package dao
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"github.com/Sovianum/arquest-server/geo"
 	"github.com/Sovianum/arquest-server/model"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/suite"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
 	"testing"
+	"time"
 )
 
+var questColumnNames = []string{
+	"id", "name", "description", "rating", "author_id", "version", "published_version", "archive_size", "archive_sha256",
+	"start_lat", "start_lon", "area_min_lat", "area_min_lon", "area_max_lat", "area_max_lon",
+	"min_team_size", "max_team_size", "tags", "difficulty", "duration_minutes",
+}
+
+func questRow(id int, name, description string, rating float64) []driver.Value {
+	return []driver.Value{id, name, description, rating, nil, 1, 0, 0, "", nil, nil, nil, nil, nil, nil, nil, nil, nil, "", 0}
+}
+
 type QuestTestSuite struct {
 	suite.Suite
 	db       *sql.DB
@@ -24,38 +39,33 @@ func (s *QuestTestSuite) SetupTest() {
 }
 
 func (s *QuestTestSuite) TestAllOk() {
-	rows := sqlmock.NewRows([]string{"id", "name", "description", "rating"}).
-		AddRow(1, "n1", "d1", 1).
-		AddRow(2, "n2", "d2", 2)
+	rows := sqlmock.NewRows(questColumnNames).
+		AddRow(questRow(1, "n1", "d1", 1)...).
+		AddRow(questRow(2, "n2", "d2", 2)...)
 
 	s.mock.
-		ExpectQuery("SELECT id").
+		ExpectQuery("SELECT id.+ FROM quest WHERE deleted_at IS NULL").
 		WillReturnRows(rows)
 
 	quests, err := s.questDAO.GetAllQuests()
 	s.Require().NoError(err)
 	s.Equal(
 		[]model.Quest{
-			{ID: 1, Name: "n1", Description: "d1", Rating: 1},
-			{ID: 2, Name: "n2", Description: "d2", Rating: 2},
+			{ID: 1, Name: "n1", Description: "d1", Rating: 1, Version: 1},
+			{ID: 2, Name: "n2", Description: "d2", Rating: 2, Version: 1},
 		},
 		quests,
 	)
 }
 
 func (s *QuestTestSuite) TestAllEmpty() {
-	rows := sqlmock.NewRows([]string{"id", "name", "description", "rating"})
-
 	s.mock.
 		ExpectQuery("SELECT id").
-		WillReturnRows(rows)
+		WillReturnRows(sqlmock.NewRows(questColumnNames))
 
 	quests, err := s.questDAO.GetAllQuests()
 	s.Require().NoError(err)
-	s.Equal(
-		[]model.Quest{},
-		quests,
-	)
+	s.Equal([]model.Quest{}, quests)
 }
 
 func (s *QuestTestSuite) TestAllError() {
@@ -68,46 +78,125 @@ func (s *QuestTestSuite) TestAllError() {
 	s.Equal("fail", err.Error())
 }
 
+var listedQuestColumnNames = append(append([]string{}, questColumnNames...), "distance", "sort_key")
+
+func (s *QuestTestSuite) TestListQuests() {
+	rows := sqlmock.NewRows(listedQuestColumnNames).
+		AddRow(append(questRow(1, "n1", "d1", 1), nil, 200.)...).
+		AddRow(append(questRow(2, "n2", "d2", 2), nil, 100.)...)
+
+	s.mock.
+		ExpectQuery("SELECT id.+ FROM quest").
+		WithArgs(
+			pq.Array([]string{}), pq.Array([]string{}), 0, 0, 0., "", nil, nil, model.QuestSortNewest, false, 0., 0, 21,
+		).
+		WillReturnRows(rows)
+
+	page, err := s.questDAO.ListQuests(model.QuestFilter{Sort: model.QuestSortNewest, Limit: 20})
+	s.Require().NoError(err)
+	s.Equal(
+		model.QuestPage{Quests: []model.ListedQuest{
+			{Quest: model.Quest{ID: 1, Name: "n1", Description: "d1", Rating: 1, Version: 1}},
+			{Quest: model.Quest{ID: 2, Name: "n2", Description: "d2", Rating: 2, Version: 1}},
+		}},
+		page,
+	)
+}
+
+func (s *QuestTestSuite) TestListQuestsNextPage() {
+	rows := sqlmock.NewRows(listedQuestColumnNames).
+		AddRow(append(questRow(5, "n5", "d5", 4), 300., -300.)...).
+		AddRow(append(questRow(4, "n4", "d4", 3), 700., -700.)...).
+		AddRow(append(questRow(3, "n3", "d3", 5), 900., -900.)...)
+
+	s.mock.
+		ExpectQuery("SELECT id.+ FROM quest").
+		WithArgs(
+			pq.Array([]string{"walk"}), pq.Array([]string{model.DifficultyEasy}), 30, 90, 3.5, "музей",
+			55.75, 37.61, model.QuestSortDistance, true, -200., 6, 3,
+		).
+		WillReturnRows(rows)
+
+	page, err := s.questDAO.ListQuests(model.QuestFilter{
+		Tags: []string{"walk"}, Difficulties: []string{model.DifficultyEasy}, MinDuration: 30, MaxDuration: 90,
+		MinRating: 3.5, Query: "музей", Center: &geo.Point{Lat: 55.75, Lon: 37.61}, Sort: model.QuestSortDistance,
+		Cursor: &model.QuestCursor{Sort: model.QuestSortDistance, Key: -200, ID: 6}, Limit: 2,
+	})
+	s.Require().NoError(err)
+	s.Require().Len(page.Quests, 2)
+	s.Equal(5, page.Quests[0].ID)
+	s.Equal(300., *page.Quests[0].Distance)
+	s.Equal(4, page.Quests[1].ID)
+
+	cursor, parseErr := model.ParseQuestCursor(page.NextCursor)
+	s.Require().NoError(parseErr)
+	s.Equal(&model.QuestCursor{Sort: model.QuestSortDistance, Key: -700, ID: 4}, cursor)
+}
+
+func (s *QuestTestSuite) TestListQuestsError() {
+	s.mock.
+		ExpectQuery("SELECT id.+ FROM quest").
+		WillReturnError(fmt.Errorf("fail"))
+
+	_, err := s.questDAO.ListQuests(model.QuestFilter{Sort: model.QuestSortNewest, Limit: 20})
+	s.Require().Error(err)
+	s.Equal("fail", err.Error())
+}
+
+var finishedQuestColumnNames = append(append([]string{}, questColumnNames...), runColumnNames...)
+
 func (s *QuestTestSuite) TestFinishedOk() {
-	rows := sqlmock.NewRows([]string{"id", "name", "description", "rating"}).
-		AddRow(1, "n1", "d1", 1).
-		AddRow(2, "n2", "d2", 2)
+	startedAt := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	finishedAt := startedAt.Add(10 * time.Minute)
+	started, finished := model.QuotedTime(startedAt), model.QuotedTime(finishedAt)
+	rows := sqlmock.NewRows(finishedQuestColumnNames).
+		AddRow(append(questRow(1, "n1", "d1", 1), nil, finishedAt, 0, 0, 0, 0, 0)...).
+		AddRow(append(questRow(2, "n2", "d2", 2), startedAt, finishedAt, 600, 3, 250, 1, 750)...)
 
 	s.mock.
-		ExpectQuery("SELECT q.id .+ quest_user_link").
+		ExpectQuery("SELECT id.+ DISTINCT ON \\(quest_id\\) .+ FROM quest_attempt").
 		WithArgs(10).
 		WillReturnRows(rows)
 
 	quests, err := s.questDAO.GetFinishedQuests(10)
 	s.Require().NoError(err)
 	s.Equal(
-		[]model.Quest{
-			{ID: 1, Name: "n1", Description: "d1", Rating: 1},
-			{ID: 2, Name: "n2", Description: "d2", Rating: 2},
+		[]model.FinishedQuest{
+			{
+				Quest: model.Quest{ID: 1, Name: "n1", Description: "d1", Rating: 1, Version: 1},
+				Run:   model.Run{FinishedAt: &finished},
+			},
+			{
+				Quest: model.Quest{ID: 2, Name: "n2", Description: "d2", Rating: 2, Version: 1},
+				Run: model.Run{
+					StartedAt: &started, FinishedAt: &finished, ElapsedSeconds: 600, HintsUsed: 3, HintPenalty: 250,
+					OptionalReached: 1, Score: 750,
+				},
+			},
 		},
 		quests,
 	)
 }
 
 func (s *QuestTestSuite) TestFinishedEmpty() {
-	rows := sqlmock.NewRows([]string{"id", "name", "description", "rating"})
+	rows := sqlmock.NewRows(finishedQuestColumnNames)
 
 	s.mock.
-		ExpectQuery("SELECT q.id .+ quest_user_link").
+		ExpectQuery("SELECT id.+ quest_attempt").
 		WithArgs(10).
 		WillReturnRows(rows)
 
 	quests, err := s.questDAO.GetFinishedQuests(10)
 	s.Require().NoError(err)
 	s.Equal(
-		[]model.Quest{},
+		[]model.FinishedQuest{},
 		quests,
 	)
 }
 
 func (s *QuestTestSuite) TestFinishedError() {
 	s.mock.
-		ExpectQuery("SELECT q.id .+ quest_user_link").
+		ExpectQuery("SELECT id.+ quest_attempt").
 		WithArgs(10).
 		WillReturnError(fmt.Errorf("fail"))
 
@@ -116,6 +205,104 @@ func (s *QuestTestSuite) TestFinishedError() {
 	s.Equal("fail", err.Error())
 }
 
+func (s *QuestTestSuite) TestGetQuestNotFound() {
+	s.mock.
+		ExpectQuery("SELECT id, name, description, rating, author_id, version").
+		WithArgs(1).
+		WillReturnError(sql.ErrNoRows)
+
+	_, err := s.questDAO.GetQuest(1)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *QuestTestSuite) TestCreateQuest() {
+	s.mock.
+		ExpectQuery("INSERT INTO quest").
+		WithArgs("n1", "d1", 20, nil, nil, nil, nil, nil, nil, nil, nil, pq.Array([]string{}), "", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}).AddRow(3, 1))
+
+	quest, err := s.questDAO.CreateQuest(model.Quest{Name: "n1", Description: "d1", AuthorID: 20})
+	s.Require().NoError(err)
+	s.Equal(model.Quest{ID: 3, Name: "n1", Description: "d1", AuthorID: 20, Version: 1}, quest)
+}
+
+func (s *QuestTestSuite) TestUpdateQuest() {
+	s.mock.
+		ExpectQuery("UPDATE quest SET\\s+name").
+		WithArgs(
+			"n1", "d1", 3, 1, 55.75, 37.61, nil, nil, nil, nil, 2, 4,
+			pq.Array([]string{"walk", "history"}), model.DifficultyMedium, 90,
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+
+	quest, err := s.questDAO.UpdateQuest(model.Quest{
+		ID: 3, Name: "n1", Description: "d1", Version: 1, Location: &geo.Point{Lat: 55.75, Lon: 37.61},
+		Teams: &model.TeamLimits{MinSize: 2, MaxSize: 4}, Tags: []string{"walk", "history"},
+		Difficulty: model.DifficultyMedium, DurationMinutes: 90,
+	})
+	s.Require().NoError(err)
+	s.Equal(2, quest.Version)
+}
+
+func (s *QuestTestSuite) TestUpdateQuestStaleVersion() {
+	s.mock.
+		ExpectQuery("UPDATE quest SET\\s+name").
+		WithArgs("n1", "d1", 3, 1, nil, nil, nil, nil, nil, nil, nil, nil, pq.Array([]string{}), "", 0).
+		WillReturnError(sql.ErrNoRows)
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+
+	_, err := s.questDAO.UpdateQuest(model.Quest{ID: 3, Name: "n1", Description: "d1", Version: 1})
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+}
+
+func (s *QuestTestSuite) TestUpdateQuestDeleted() {
+	s.mock.
+		ExpectQuery("UPDATE quest SET\\s+name").
+		WillReturnError(sql.ErrNoRows)
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(0))
+
+	_, err := s.questDAO.UpdateQuest(model.Quest{ID: 3, Name: "n1", Version: 1})
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *QuestTestSuite) TestDeleteQuest() {
+	s.mock.
+		ExpectExec("UPDATE quest SET deleted_at").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	s.NoError(s.questDAO.DeleteQuest(3))
+}
+
+func (s *QuestTestSuite) TestGetQuestLocation() {
+	s.mock.
+		ExpectQuery("SELECT id, name, description, rating, author_id, version").
+		WithArgs(3).
+		WillReturnRows(
+			sqlmock.NewRows(questColumnNames).
+				AddRow(3, "n1", "d1", 4., 20, 2, 1, 10, "sha", 55.75, 37.61, 55.7, 37.5, 55.8, 37.7, 1, 3, "{walk,history}", "hard", 120),
+		)
+
+	quest, err := s.questDAO.GetQuest(3)
+	s.Require().NoError(err)
+	s.Equal(&geo.Point{Lat: 55.75, Lon: 37.61}, quest.Location)
+	s.Equal(&geo.Rect{MinLat: 55.7, MinLon: 37.5, MaxLat: 55.8, MaxLon: 37.7}, quest.Area)
+	s.Equal(20, quest.AuthorID)
+	s.Equal(&model.TeamLimits{MinSize: 1, MaxSize: 3}, quest.Teams)
+	s.Equal([]string{"walk", "history"}, quest.Tags)
+	s.Equal(model.DifficultyHard, quest.Difficulty)
+	s.Equal(120, quest.DurationMinutes)
+}
+
 func TestQuestTestSuite(t *testing.T) {
 	suite.Run(t, new(QuestTestSuite))
 }
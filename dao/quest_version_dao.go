@@ -0,0 +1,210 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"net/http"
+	"time"
+)
+
+const (
+	lockQuest     = `SELECT id FROM quest WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`
+	createVersion = `
+		INSERT INTO quest_version (quest_id, version, changelog, archive_key, archive_size, archive_sha256, author_id)
+		SELECT $1, COALESCE(MAX(version), 0) + 1, $2, $3, $4, $5, $6 FROM quest_version WHERE quest_id = $1
+		RETURNING version, created_at
+	`
+	addVersionFile = `
+		INSERT INTO quest_version_file (quest_id, version, path, size, sha256) VALUES ($1, $2, $3, $4, $5)
+	`
+	selectVersions = `
+		SELECT v.quest_id, v.version, v.changelog, v.archive_key, v.archive_size, v.archive_sha256,
+			COALESCE(v.author_id, 0), v.created_at, v.version = COALESCE(q.published_version, 0)
+		FROM quest_version AS v JOIN quest AS q ON q.id = v.quest_id
+		WHERE v.quest_id = $1 AND q.deleted_at IS NULL
+	`
+	getVersions         = selectVersions + ` ORDER BY v.version DESC`
+	getVersion          = selectVersions + ` AND v.version = $2`
+	getPublishedVersion = selectVersions + ` AND v.version = q.published_version`
+	getVersionFiles     = `
+		SELECT path, size, sha256 FROM quest_version_file WHERE quest_id = $1 AND version = $2 ORDER BY path
+	`
+	publishVersion = `
+		UPDATE quest AS q SET
+			published_version = v.version, archive_size = v.archive_size, archive_sha256 = v.archive_sha256,
+			archive_uploaded_at = v.created_at, updated_at = now()
+		FROM quest_version AS v
+		WHERE q.id = $1 AND v.quest_id = q.id AND v.version = $2 AND q.deleted_at IS NULL
+	`
+	unpublishQuest = `
+		UPDATE quest SET
+			published_version = NULL, archive_size = NULL, archive_sha256 = NULL, archive_uploaded_at = NULL,
+			updated_at = now()
+		WHERE id = $1 AND deleted_at IS NULL
+	`
+	hasQuestFile = `
+		SELECT EXISTS (
+			SELECT 1 FROM quest_version_file AS f JOIN quest AS q ON q.id = f.quest_id
+			WHERE f.quest_id = $1 AND f.sha256 = $2 AND (NOT $3 OR f.version = q.published_version)
+		)
+	`
+)
+
+type QuestVersionDAO interface {
+	CreateVersion(version model.QuestVersion, publish bool) (model.QuestVersion, DBError)
+	GetVersions(questID int) ([]model.QuestVersion, DBError)
+	GetVersion(questID int, version int) (model.QuestVersion, DBError)
+	GetPublishedVersion(questID int) (model.QuestVersion, DBError)
+	PublishVersion(questID int, version int) DBError
+	Unpublish(questID int) DBError
+	// HasFile reports whether a file with the hash belongs to a version of the quest;
+	// with publishedOnly set only the published version is looked at.
+	HasFile(questID int, sha256 string, publishedOnly bool) (bool, DBError)
+}
+
+func NewQuestVersionDAO(db *sql.DB) QuestVersionDAO {
+	return &dbQuestVersionDAO{db: db}
+}
+
+type dbQuestVersionDAO struct {
+	db *sql.DB
+}
+
+// CreateVersion saves version with the next version number of the quest and its file list.
+// With publish set the new version becomes the published one in the same transaction.
+func (dao *dbQuestVersionDAO) CreateVersion(version model.QuestVersion, publish bool) (model.QuestVersion, DBError) {
+	err := inTransaction(dao.db, func(tx *sql.Tx) error {
+		var questID int
+		if err := tx.QueryRow(lockQuest, version.QuestID).Scan(&questID); err == sql.ErrNoRows {
+			return NewDBErr(http.StatusNotFound, "quest not found")
+		} else if err != nil {
+			return err
+		}
+
+		var createdAt time.Time
+		err := tx.QueryRow(
+			createVersion,
+			version.QuestID, version.Changelog, version.ArchiveKey, version.ArchiveSize, version.ArchiveSHA256, version.AuthorID,
+		).Scan(&version.Version, &createdAt)
+		if err != nil {
+			return err
+		}
+		version.CreatedAt = model.QuotedTime(createdAt)
+
+		stmt, err := tx.Prepare(addVersionFile)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, file := range version.Files {
+			if _, err := stmt.Exec(version.QuestID, version.Version, file.Path, file.Size, file.SHA256); err != nil {
+				return err
+			}
+		}
+
+		if publish {
+			if _, err := tx.Exec(publishVersion, version.QuestID, version.Version); err != nil {
+				return err
+			}
+		}
+		version.Published = publish
+		return nil
+	})
+	return version, err
+}
+
+func (dao *dbQuestVersionDAO) GetVersions(questID int) ([]model.QuestVersion, DBError) {
+	rows, err := dao.db.Query(getVersions, questID)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.QuestVersion, 0)
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		result = append(result, version)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+// GetVersion returns the version together with its file list.
+func (dao *dbQuestVersionDAO) GetVersion(questID int, version int) (model.QuestVersion, DBError) {
+	return dao.getVersion(getVersion, questID, version)
+}
+
+// GetPublishedVersion returns the published version with its file list or
+// http.StatusNotFound if the quest has no published content.
+func (dao *dbQuestVersionDAO) GetPublishedVersion(questID int) (model.QuestVersion, DBError) {
+	return dao.getVersion(getPublishedVersion, questID)
+}
+
+// PublishVersion points the quest to one of its versions; publishing an older version is a rollback.
+func (dao *dbQuestVersionDAO) PublishVersion(questID int, version int) DBError {
+	result, err := dao.db.Exec(publishVersion, questID, version)
+	if err != nil {
+		return NewCrashDBErr(err)
+	}
+	if rowCnt, err := result.RowsAffected(); err != nil {
+		return NewCrashDBErr(err)
+	} else if rowCnt == 0 {
+		return NewDBErr(http.StatusNotFound, "quest version not found")
+	}
+	return nil
+}
+
+// Unpublish leaves the quest without downloadable content; its versions are kept.
+func (dao *dbQuestVersionDAO) Unpublish(questID int) DBError {
+	result, err := dao.db.Exec(unpublishQuest, questID)
+	if err != nil {
+		return NewCrashDBErr(err)
+	}
+	return getResultErr(result)
+}
+
+func (dao *dbQuestVersionDAO) HasFile(questID int, sha256 string, publishedOnly bool) (bool, DBError) {
+	var exists bool
+	if err := dao.db.QueryRow(hasQuestFile, questID, sha256, publishedOnly).Scan(&exists); err != nil {
+		return false, NewCrashDBErr(err)
+	}
+	return exists, nil
+}
+
+func (dao *dbQuestVersionDAO) getVersion(query string, args ...interface{}) (model.QuestVersion, DBError) {
+	version, err := scanVersion(dao.db.QueryRow(query, args...))
+	if err == sql.ErrNoRows {
+		return version, NewDBErr(http.StatusNotFound, "quest version not found")
+	} else if err != nil {
+		return version, NewCrashDBErr(err)
+	}
+
+	rows, err := dao.db.Query(getVersionFiles, version.QuestID, version.Version)
+	if err != nil {
+		return version, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	version.Files = make([]model.QuestFile, 0)
+	for rows.Next() {
+		file := model.QuestFile{}
+		if err := rows.Scan(&file.Path, &file.Size, &file.SHA256); err != nil {
+			return version, NewCrashDBErr(err)
+		}
+		version.Files = append(version.Files, file)
+	}
+	return version, NewCrashDBErr(rows.Err())
+}
+
+func scanVersion(row scanner) (model.QuestVersion, error) {
+	version := model.QuestVersion{}
+	var createdAt time.Time
+	err := row.Scan(
+		&version.QuestID, &version.Version, &version.Changelog, &version.ArchiveKey, &version.ArchiveSize,
+		&version.ArchiveSHA256, &version.AuthorID, &createdAt, &version.Published,
+	)
+	version.CreatedAt = model.QuotedTime(createdAt)
+	return version, err
+}
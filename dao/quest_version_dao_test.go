@@ -0,0 +1,166 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var versionColumns = []string{
+	"quest_id", "version", "changelog", "archive_key", "archive_size", "archive_sha256", "author_id", "created_at", "published",
+}
+
+type QuestVersionTestSuite struct {
+	suite.Suite
+	db         *sql.DB
+	mock       sqlmock.Sqlmock
+	versionDAO QuestVersionDAO
+}
+
+func (s *QuestVersionTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.versionDAO = NewQuestVersionDAO(s.db)
+}
+
+func (s *QuestVersionTestSuite) newVersion() model.QuestVersion {
+	return model.QuestVersion{
+		QuestID:       3,
+		Changelog:     "changes",
+		ArchiveKey:    "archives/sha",
+		ArchiveSize:   10,
+		ArchiveSHA256: "sha",
+		AuthorID:      20,
+		Files:         []model.QuestFile{{Path: "a", Size: 1, SHA256: "1"}, {Path: "b", Size: 2, SHA256: "2"}},
+	}
+}
+
+func (s *QuestVersionTestSuite) TestCreateVersion() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest .+ FOR UPDATE").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.
+		ExpectQuery("INSERT INTO quest_version").
+		WithArgs(3, "changes", "archives/sha", 10, "sha", 20).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(2, time.Now()))
+	prepared := s.mock.ExpectPrepare("INSERT INTO quest_version_file")
+	prepared.ExpectExec().WithArgs(3, 2, "a", 1, "1").WillReturnResult(sqlmock.NewResult(0, 1))
+	prepared.ExpectExec().WithArgs(3, 2, "b", 2, "2").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("UPDATE quest AS q SET").WithArgs(3, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	version, err := s.versionDAO.CreateVersion(s.newVersion(), true)
+	s.Require().NoError(err)
+	s.Equal(2, version.Version)
+	s.True(version.Published)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *QuestVersionTestSuite) TestCreateVersionQuestDeleted() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest").WithArgs(3).WillReturnError(sql.ErrNoRows)
+	s.mock.ExpectRollback()
+
+	_, err := s.versionDAO.CreateVersion(s.newVersion(), true)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *QuestVersionTestSuite) TestCreateVersionRollback() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.ExpectQuery("INSERT INTO quest_version").WillReturnError(fmt.Errorf("fail"))
+	s.mock.ExpectRollback()
+
+	_, err := s.versionDAO.CreateVersion(s.newVersion(), false)
+	s.Require().Error(err)
+	s.Equal("fail", err.Error())
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *QuestVersionTestSuite) TestGetVersion() {
+	s.mock.
+		ExpectQuery("SELECT v.quest_id.+AND v.version = \\$2").
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows(versionColumns).AddRow(3, 1, "first", "3", 10, "sha", 20, time.Now(), false))
+	s.mock.
+		ExpectQuery("SELECT path, size, sha256 FROM quest_version_file").
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"path", "size", "sha256"}).AddRow("a", 1, "1"))
+
+	version, err := s.versionDAO.GetVersion(3, 1)
+	s.Require().NoError(err)
+	s.Equal("first", version.Changelog)
+	s.Equal("3", version.ArchiveKey)
+	s.False(version.Published)
+	s.Equal([]model.QuestFile{{Path: "a", Size: 1, SHA256: "1"}}, version.Files)
+}
+
+func (s *QuestVersionTestSuite) TestGetPublishedVersionNotFound() {
+	s.mock.
+		ExpectQuery("SELECT v.quest_id.+q.published_version").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(versionColumns))
+
+	_, err := s.versionDAO.GetPublishedVersion(3)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *QuestVersionTestSuite) TestGetVersions() {
+	s.mock.
+		ExpectQuery("SELECT v.quest_id.+ORDER BY v.version DESC").
+		WithArgs(3).
+		WillReturnRows(
+			sqlmock.NewRows(versionColumns).
+				AddRow(3, 2, "second", "archives/2", 10, "2", 20, time.Now(), true).
+				AddRow(3, 1, "first", "archives/1", 10, "1", 20, time.Now(), false),
+		)
+
+	versions, err := s.versionDAO.GetVersions(3)
+	s.Require().NoError(err)
+	s.Require().Len(versions, 2)
+	s.True(versions[0].Published)
+	s.Nil(versions[0].Files)
+}
+
+func (s *QuestVersionTestSuite) TestPublishUnknownVersion() {
+	s.mock.
+		ExpectExec("UPDATE quest AS q SET").
+		WithArgs(3, 7).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := s.versionDAO.PublishVersion(3, 7)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *QuestVersionTestSuite) TestUnpublish() {
+	s.mock.
+		ExpectExec("UPDATE quest SET\\s+published_version = NULL").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	s.NoError(s.versionDAO.Unpublish(3))
+}
+
+func (s *QuestVersionTestSuite) TestHasFile() {
+	s.mock.
+		ExpectQuery("SELECT EXISTS").
+		WithArgs(3, "sha", true).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	exists, err := s.versionDAO.HasFile(3, "sha", true)
+	s.Require().NoError(err)
+	s.True(exists)
+}
+
+func TestQuestVersionTestSuite(t *testing.T) {
+	suite.Run(t, new(QuestVersionTestSuite))
+}
@@ -0,0 +1,300 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/lib/pq"
+	"net/http"
+	"time"
+)
+
+const (
+	reviewColumns = `
+		r.id, r.quest_id, r.user_id, u.login, COALESCE(l.mark, 0), r.text, COALESCE(r.language, ''),
+		(SELECT count(*) FROM review_vote WHERE review_id = r.id), r.created_at, r.updated_at, r.hidden
+	`
+	reviewTables = `
+		review AS r JOIN users AS u ON u.id = r.user_id
+		LEFT JOIN quest_user_link AS l ON l.user_id = r.user_id AND l.quest_id = r.quest_id
+	`
+	countReviews = `SELECT count(*) FROM review WHERE quest_id = $1 AND ($2 = '' OR language = $2) AND NOT hidden`
+	// helpful sorting puts the reviews with most votes first; ties and recent sorting go from new to old
+	getReviews = `
+		SELECT ` + reviewColumns + ` FROM ` + reviewTables + `
+		WHERE r.quest_id = $1 AND ($2 = '' OR r.language = $2) AND NOT r.hidden
+		ORDER BY CASE WHEN $3 THEN (SELECT count(*) FROM review_vote WHERE review_id = r.id) ELSE 0 END DESC,
+			r.created_at DESC, r.id DESC
+		LIMIT $4 OFFSET $5
+	`
+	// getReview returns hidden reviews too: their authors see them held for moderation
+	getReview       = `SELECT ` + reviewColumns + ` FROM ` + reviewTables + ` WHERE r.id = $1`
+	getReviewPhotos = `
+		SELECT rp.review_id, p.id, p.content_type, p.size, p.sha256
+		FROM review_photo AS rp JOIN photo AS p ON p.id = rp.photo_id
+		WHERE rp.review_id = ANY($1) ORDER BY rp.review_id, rp.position
+	`
+	getReviewReplies = `
+		SELECT rr.id, rr.review_id, rr.user_id, u.login, rr.text, rr.created_at, rr.hidden
+		FROM review_reply AS rr JOIN users AS u ON u.id = rr.user_id
+		WHERE rr.review_id = ANY($1) AND NOT rr.hidden ORDER BY rr.review_id, rr.id
+	`
+	getMarked       = `SELECT COALESCE(marked, FALSE) FROM quest_user_link WHERE user_id = $1 AND quest_id = $2 FOR UPDATE`
+	countUserPhotos = `SELECT count(*) FROM photo WHERE user_id = $1 AND id = ANY($2)`
+	saveReview      = `
+		INSERT INTO review (quest_id, user_id, text, language, hidden) VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		ON CONFLICT ON CONSTRAINT ux_review_quest_user DO UPDATE
+		SET text = EXCLUDED.text, language = EXCLUDED.language, hidden = EXCLUDED.hidden, updated_at = now()
+		RETURNING id
+	`
+	deleteReviewPhotos = `DELETE FROM review_photo WHERE review_id = $1`
+	addReviewPhoto     = `INSERT INTO review_photo (review_id, photo_id, position) VALUES ($1, $2, $3)`
+	deleteReview       = `DELETE FROM review WHERE quest_id = $1 AND user_id = $2`
+	getReviewAuthor    = `SELECT user_id FROM review WHERE id = $1 AND NOT hidden`
+	voteReview         = `INSERT INTO review_vote (review_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	unvoteReview       = `DELETE FROM review_vote WHERE review_id = $1 AND user_id = $2`
+	addReply           = `
+		WITH rr AS (INSERT INTO review_reply (review_id, user_id, text, hidden) VALUES ($1, $2, $3, $4) RETURNING *)
+		SELECT rr.id, rr.review_id, rr.user_id, u.login, rr.text, rr.created_at, rr.hidden
+		FROM rr JOIN users AS u ON u.id = rr.user_id
+	`
+	createPhoto = `INSERT INTO photo (user_id, sha256, content_type, size) VALUES ($1, $2, $3, $4) RETURNING id`
+	getPhoto    = `SELECT id, user_id, sha256, content_type, size FROM photo WHERE id = $1`
+)
+
+// ReviewDAO keeps written reviews of quests together with their photos, helpful votes and
+// reply threads.
+type ReviewDAO interface {
+	// GetReviews returns a page of the reviews of the quest with their photos and replies.
+	GetReviews(questID int, filter model.ReviewFilter) (model.ReviewPage, DBError)
+	GetReview(reviewID int) (model.Review, DBError)
+	// SaveReview creates the review of the user or replaces its text, language and photos.
+	// Hidden reviews are held for moderation and are not listed.
+	SaveReview(review model.Review) (model.Review, DBError)
+	DeleteReview(userID, questID int) DBError
+	// SetHelpful adds or withdraws the helpful vote of the user for the review of another user.
+	SetHelpful(userID, reviewID int, helpful bool) DBError
+	AddReply(reply model.ReviewReply) (model.ReviewReply, DBError)
+	CreatePhoto(photo model.Photo) (model.Photo, DBError)
+	GetPhoto(photoID int) (model.Photo, DBError)
+}
+
+func NewReviewDAO(db *sql.DB) ReviewDAO {
+	return &dbReviewDAO{db: db}
+}
+
+type dbReviewDAO struct {
+	db *sql.DB
+}
+
+func (dao *dbReviewDAO) GetReviews(questID int, filter model.ReviewFilter) (model.ReviewPage, DBError) {
+	page := model.ReviewPage{QuestID: questID, Limit: filter.Limit, Offset: filter.Offset}
+	if err := dao.db.QueryRow(countReviews, questID, filter.Language).Scan(&page.Total); err != nil {
+		return page, NewCrashDBErr(err)
+	}
+
+	rows, err := dao.db.Query(
+		getReviews, questID, filter.Language, filter.Sort == model.ReviewSortHelpful, filter.Limit, filter.Offset,
+	)
+	if err != nil {
+		return page, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	page.Reviews = make([]model.Review, 0)
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return page, NewCrashDBErr(err)
+		}
+		page.Reviews = append(page.Reviews, review)
+	}
+	if err := rows.Err(); err != nil {
+		return page, NewCrashDBErr(err)
+	}
+	return page, NewCrashDBErr(dao.loadThreads(page.Reviews))
+}
+
+// GetReview gives http.StatusNotFound if there is no such review.
+func (dao *dbReviewDAO) GetReview(reviewID int) (model.Review, DBError) {
+	review, err := scanReview(dao.db.QueryRow(getReview, reviewID))
+	if err == sql.ErrNoRows {
+		return review, NewDBErr(http.StatusNotFound, "review not found")
+	} else if err != nil {
+		return review, NewCrashDBErr(err)
+	}
+	reviews := []model.Review{review}
+	if err := dao.loadThreads(reviews); err != nil {
+		return review, NewCrashDBErr(err)
+	}
+	return reviews[0], nil
+}
+
+// SaveReview gives http.StatusConflict until the user marks the quest and
+// http.StatusUnprocessableEntity for photos the user has not uploaded.
+func (dao *dbReviewDAO) SaveReview(review model.Review) (model.Review, DBError) {
+	var reviewID int
+	dbErr := inTransaction(dao.db, func(tx *sql.Tx) error {
+		var marked bool
+		err := tx.QueryRow(getMarked, review.UserID, review.QuestID).Scan(&marked)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if !marked {
+			return NewDBErr(http.StatusConflict, "mark the quest before reviewing it")
+		}
+
+		photoIDs := review.PhotoIDs()
+		if len(photoIDs) > 0 {
+			var owned int
+			if err := tx.QueryRow(countUserPhotos, review.UserID, pq.Array(photoIDs)).Scan(&owned); err != nil {
+				return err
+			}
+			if owned != len(photoIDs) {
+				return NewDBErr(http.StatusUnprocessableEntity, "photos must be uploaded by the author of the review")
+			}
+		}
+
+		err = tx.QueryRow(saveReview, review.QuestID, review.UserID, review.Text, review.Language, review.Hidden).Scan(&reviewID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(deleteReviewPhotos, reviewID); err != nil {
+			return err
+		}
+		for i, photoID := range photoIDs {
+			if _, err := tx.Exec(addReviewPhoto, reviewID, photoID, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if dbErr != nil {
+		return review, dbErr
+	}
+	return dao.GetReview(reviewID)
+}
+
+func (dao *dbReviewDAO) DeleteReview(userID, questID int) DBError {
+	r, err := dao.db.Exec(deleteReview, questID, userID)
+	if err != nil {
+		return NewCrashDBErr(err)
+	}
+	if affected, err := r.RowsAffected(); err != nil {
+		return NewCrashDBErr(err)
+	} else if affected == 0 {
+		return NewDBErr(http.StatusNotFound, "review not found")
+	}
+	return nil
+}
+
+// SetHelpful is idempotent; it gives http.StatusUnprocessableEntity for the own review of the user.
+func (dao *dbReviewDAO) SetHelpful(userID, reviewID int, helpful bool) DBError {
+	var authorID int
+	if err := dao.db.QueryRow(getReviewAuthor, reviewID).Scan(&authorID); err == sql.ErrNoRows {
+		return NewDBErr(http.StatusNotFound, "review not found")
+	} else if err != nil {
+		return NewCrashDBErr(err)
+	}
+	if authorID == userID {
+		return NewDBErr(http.StatusUnprocessableEntity, "you can not vote for your own review")
+	}
+
+	query := unvoteReview
+	if helpful {
+		query = voteReview
+	}
+	_, err := dao.db.Exec(query, reviewID, userID)
+	return NewCrashDBErr(err)
+}
+
+func (dao *dbReviewDAO) AddReply(reply model.ReviewReply) (model.ReviewReply, DBError) {
+	created, err := scanReply(dao.db.QueryRow(addReply, reply.ReviewID, reply.UserID, reply.Text, reply.Hidden))
+	return created, NewCrashDBErr(err)
+}
+
+func (dao *dbReviewDAO) CreatePhoto(photo model.Photo) (model.Photo, DBError) {
+	err := dao.db.QueryRow(createPhoto, photo.UserID, photo.SHA256, photo.ContentType, photo.Size).Scan(&photo.ID)
+	return photo, NewCrashDBErr(err)
+}
+
+func (dao *dbReviewDAO) GetPhoto(photoID int) (model.Photo, DBError) {
+	photo := model.Photo{}
+	err := dao.db.QueryRow(getPhoto, photoID).Scan(&photo.ID, &photo.UserID, &photo.SHA256, &photo.ContentType, &photo.Size)
+	if err == sql.ErrNoRows {
+		return photo, NewDBErr(http.StatusNotFound, "photo not found")
+	}
+	return photo, NewCrashDBErr(err)
+}
+
+// loadThreads fills the photos and replies of the reviews with one query for each.
+func (dao *dbReviewDAO) loadThreads(reviews []model.Review) error {
+	if len(reviews) == 0 {
+		return nil
+	}
+	ids := make([]int, len(reviews))
+	index := make(map[int]int, len(reviews))
+	for i := range reviews {
+		ids[i] = reviews[i].ID
+		index[reviews[i].ID] = i
+		reviews[i].Photos = make([]model.Photo, 0)
+		reviews[i].Replies = make([]model.ReviewReply, 0)
+	}
+
+	rows, err := dao.db.Query(getReviewPhotos, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reviewID int
+		photo := model.Photo{}
+		if err := rows.Scan(&reviewID, &photo.ID, &photo.ContentType, &photo.Size, &photo.SHA256); err != nil {
+			return err
+		}
+		review := &reviews[index[reviewID]]
+		review.Photos = append(review.Photos, photo)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	replyRows, err := dao.db.Query(getReviewReplies, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer replyRows.Close()
+	for replyRows.Next() {
+		reply, err := scanReply(replyRows)
+		if err != nil {
+			return err
+		}
+		review := &reviews[index[reply.ReviewID]]
+		review.Replies = append(review.Replies, reply)
+	}
+	return replyRows.Err()
+}
+
+func scanReview(row scanner) (model.Review, error) {
+	review := model.Review{}
+	var createdAt, updatedAt time.Time
+	err := row.Scan(
+		&review.ID, &review.QuestID, &review.UserID, &review.Login, &review.Mark, &review.Text, &review.Language,
+		&review.HelpfulCount, &createdAt, &updatedAt, &review.Hidden,
+	)
+	if err != nil {
+		return review, err
+	}
+	review.CreatedAt = model.QuotedTime(createdAt)
+	review.UpdatedAt = model.QuotedTime(updatedAt)
+	return review, nil
+}
+
+func scanReply(row scanner) (model.ReviewReply, error) {
+	reply := model.ReviewReply{}
+	var createdAt time.Time
+	if err := row.Scan(&reply.ID, &reply.ReviewID, &reply.UserID, &reply.Login, &reply.Text, &createdAt, &reply.Hidden); err != nil {
+		return reply, err
+	}
+	reply.CreatedAt = model.QuotedTime(createdAt)
+	return reply, nil
+}
@@ -0,0 +1,193 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var reviewColumnNames = []string{
+	"id", "quest_id", "user_id", "login", "mark", "text", "language", "helpful", "created_at", "updated_at", "hidden",
+}
+
+type ReviewTestSuite struct {
+	suite.Suite
+	db        *sql.DB
+	mock      sqlmock.Sqlmock
+	reviewDAO ReviewDAO
+}
+
+func (s *ReviewTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.reviewDAO = NewReviewDAO(s.db)
+}
+
+func (s *ReviewTestSuite) expectThreads(reviewIDs ...int) {
+	s.mock.
+		ExpectQuery("SELECT rp.review_id, p.id, p.content_type, p.size, p.sha256 FROM review_photo").
+		WithArgs(pq.Array(reviewIDs)).
+		WillReturnRows(sqlmock.NewRows([]string{"review_id", "id", "content_type", "size", "sha256"}).
+			AddRow(reviewIDs[0], 8, "image/png", 100, "abc"))
+	s.mock.
+		ExpectQuery("SELECT rr.id, rr.review_id, rr.user_id, u.login, rr.text, rr.created_at, rr.hidden FROM review_reply .+ AND NOT rr.hidden").
+		WithArgs(pq.Array(reviewIDs)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "review_id", "user_id", "login", "text", "created_at", "hidden"}).
+			AddRow(9, reviewIDs[len(reviewIDs)-1], 1, "author", "thanks", time.Now(), false))
+}
+
+func (s *ReviewTestSuite) TestGetReviews() {
+	s.mock.
+		ExpectQuery("SELECT count\\(\\*\\) FROM review WHERE quest_id = \\$1").
+		WithArgs(3, "ru").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+	s.mock.
+		ExpectQuery("SELECT .+ FROM review AS r JOIN users AS u .+ LIMIT \\$4 OFFSET \\$5").
+		WithArgs(3, "ru", true, 2, 4).
+		WillReturnRows(sqlmock.NewRows(reviewColumnNames).
+			AddRow(5, 3, 2, "first", 5., "great", "ru", 3, time.Now(), time.Now(), false).
+			AddRow(6, 3, 4, "second", 4., "good", "ru", 1, time.Now(), time.Now(), false))
+	s.expectThreads(5, 6)
+
+	page, err := s.reviewDAO.GetReviews(3, model.ReviewFilter{Language: "ru", Sort: model.ReviewSortHelpful, Limit: 2, Offset: 4})
+	s.Require().NoError(err)
+	s.Equal(7, page.Total)
+	s.Require().Len(page.Reviews, 2)
+	s.Equal(3, page.Reviews[0].HelpfulCount)
+	s.Len(page.Reviews[0].Photos, 1)
+	s.Empty(page.Reviews[0].Replies)
+	s.Empty(page.Reviews[1].Photos)
+	s.Equal("thanks", page.Reviews[1].Replies[0].Text)
+}
+
+func (s *ReviewTestSuite) TestGetReviewsEmpty() {
+	s.mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.ExpectQuery("SELECT .+ FROM review AS r").WillReturnRows(sqlmock.NewRows(reviewColumnNames))
+
+	page, err := s.reviewDAO.GetReviews(3, model.ReviewFilter{Sort: model.ReviewSortRecent, Limit: 20})
+	s.Require().NoError(err)
+	s.NotNil(page.Reviews)
+	s.Empty(page.Reviews)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ReviewTestSuite) TestGetReviewNotFound() {
+	s.mock.ExpectQuery("SELECT .+ WHERE r.id = \\$1").WithArgs(5).WillReturnRows(sqlmock.NewRows(reviewColumnNames))
+
+	_, err := s.reviewDAO.GetReview(5)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *ReviewTestSuite) TestSaveReview() {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT COALESCE\\(marked, FALSE\\) FROM quest_user_link WHERE user_id = \\$1 AND quest_id = \\$2 FOR UPDATE").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"marked"}).AddRow(true))
+	s.mock.
+		ExpectQuery("SELECT count\\(\\*\\) FROM photo WHERE user_id = \\$1 AND id = ANY\\(\\$2\\)").
+		WithArgs(2, pq.Array([]int{8})).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.
+		ExpectQuery("INSERT INTO review \\(quest_id, user_id, text, language, hidden\\)").
+		WithArgs(3, 2, "great", "en", false).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	s.mock.ExpectExec("DELETE FROM review_photo WHERE review_id = \\$1").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec("INSERT INTO review_photo").WithArgs(5, 8, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.mock.
+		ExpectQuery("SELECT .+ WHERE r.id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(reviewColumnNames).AddRow(5, 3, 2, "first", 5., "great", "en", 0, time.Now(), time.Now(), false))
+	s.expectThreads(5)
+
+	review, err := s.reviewDAO.SaveReview(model.Review{
+		QuestID: 3, UserID: 2, Text: "great", Language: "en", Photos: []model.Photo{{ID: 8}},
+	})
+	s.Require().NoError(err)
+	s.Equal(5, review.ID)
+	s.Equal(float32(5), review.Mark)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ReviewTestSuite) TestSaveReviewNotMarked() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT COALESCE\\(marked, FALSE\\)").WithArgs(2, 3).WillReturnRows(sqlmock.NewRows([]string{"marked"}))
+	s.mock.ExpectRollback()
+
+	_, err := s.reviewDAO.SaveReview(model.Review{QuestID: 3, UserID: 2, Text: "great"})
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+}
+
+func (s *ReviewTestSuite) TestSaveReviewForeignPhoto() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT COALESCE\\(marked, FALSE\\)").WillReturnRows(sqlmock.NewRows([]string{"marked"}).AddRow(true))
+	s.mock.ExpectQuery("SELECT count\\(\\*\\) FROM photo").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.ExpectRollback()
+
+	_, err := s.reviewDAO.SaveReview(model.Review{QuestID: 3, UserID: 2, Text: "great", Photos: []model.Photo{{ID: 8}, {ID: 9}}})
+	s.Require().Error(err)
+	s.Equal(http.StatusUnprocessableEntity, err.Code())
+}
+
+func (s *ReviewTestSuite) TestDeleteReviewNotFound() {
+	s.mock.ExpectExec("DELETE FROM review WHERE quest_id = \\$1 AND user_id = \\$2").WithArgs(3, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := s.reviewDAO.DeleteReview(2, 3)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *ReviewTestSuite) TestSetHelpful() {
+	s.mock.ExpectQuery("SELECT user_id FROM review WHERE id = \\$1").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(4))
+	s.mock.ExpectExec("INSERT INTO review_vote .+ ON CONFLICT DO NOTHING").WithArgs(5, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectQuery("SELECT user_id FROM review").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(4))
+	s.mock.ExpectExec("DELETE FROM review_vote").WithArgs(5, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	s.NoError(s.reviewDAO.SetHelpful(2, 5, true))
+	s.NoError(s.reviewDAO.SetHelpful(2, 5, false))
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ReviewTestSuite) TestSetHelpfulOwnReview() {
+	s.mock.ExpectQuery("SELECT user_id FROM review").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(2))
+
+	err := s.reviewDAO.SetHelpful(2, 5, true)
+	s.Require().Error(err)
+	s.Equal(http.StatusUnprocessableEntity, err.Code())
+}
+
+func (s *ReviewTestSuite) TestAddReply() {
+	s.mock.
+		ExpectQuery("WITH rr AS \\(INSERT INTO review_reply \\(review_id, user_id, text, hidden\\)").
+		WithArgs(5, 1, "thanks", false).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "review_id", "user_id", "login", "text", "created_at", "hidden"}).
+			AddRow(9, 5, 1, "author", "thanks", time.Now(), false))
+
+	reply, err := s.reviewDAO.AddReply(model.ReviewReply{ReviewID: 5, UserID: 1, Text: "thanks"})
+	s.Require().NoError(err)
+	s.Equal(9, reply.ID)
+	s.Equal("author", reply.Login)
+}
+
+func (s *ReviewTestSuite) TestGetPhotoNotFound() {
+	s.mock.ExpectQuery("SELECT id, user_id, sha256, content_type, size FROM photo").WithArgs(8).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "user_id", "sha256", "content_type", "size"}),
+	)
+
+	_, err := s.reviewDAO.GetPhoto(8)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func TestReviewTestSuite(t *testing.T) {
+	suite.Run(t, new(ReviewTestSuite))
+}
@@ -0,0 +1,65 @@
+package dao
+
+import (
+	"database/sql"
+	"net/http"
+)
+
+const (
+	getRoles   = `SELECT role FROM user_role WHERE user_id = $1 ORDER BY role`
+	grantRole  = `INSERT INTO user_role (user_id, role) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	revokeRole = `DELETE FROM user_role WHERE user_id = $1 AND role = $2`
+)
+
+type RoleDAO interface {
+	GetRoles(userID int) ([]string, DBError)
+	GrantRole(userID int, role string) DBError
+	RevokeRole(userID int, role string) DBError
+}
+
+func NewRoleDAO(db *sql.DB) RoleDAO {
+	result := new(dbRoleDAO)
+	result.db = db
+	return result
+}
+
+type dbRoleDAO struct {
+	db *sql.DB
+}
+
+func (dao *dbRoleDAO) GetRoles(userID int) ([]string, DBError) {
+	rows, err := dao.db.Query(getRoles, userID)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]string, 0)
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		result = append(result, role)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+// GrantRole is idempotent: granting a role the user already has is not an error.
+func (dao *dbRoleDAO) GrantRole(userID int, role string) DBError {
+	_, err := dao.db.Exec(grantRole, userID, role)
+	return NewCrashDBErr(err)
+}
+
+func (dao *dbRoleDAO) RevokeRole(userID int, role string) DBError {
+	result, err := dao.db.Exec(revokeRole, userID, role)
+	if err != nil {
+		return NewCrashDBErr(err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return NewCrashDBErr(err)
+	} else if affected == 0 {
+		return NewDBErr(http.StatusNotFound, "user does not have this role")
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"testing"
+)
+
+type RoleTestSuite struct {
+	suite.Suite
+	db      *sql.DB
+	mock    sqlmock.Sqlmock
+	roleDAO RoleDAO
+}
+
+func (s *RoleTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.roleDAO = NewRoleDAO(s.db)
+}
+
+func (s *RoleTestSuite) TestGetRoles() {
+	s.mock.
+		ExpectQuery("SELECT role FROM user_role").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(model.RoleAuthor).AddRow(model.RolePlayer))
+
+	roles, err := s.roleDAO.GetRoles(1)
+	s.Require().NoError(err)
+	s.Equal([]string{model.RoleAuthor, model.RolePlayer}, roles)
+}
+
+func (s *RoleTestSuite) TestGetRolesEmpty() {
+	s.mock.
+		ExpectQuery("SELECT role FROM user_role").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}))
+
+	roles, err := s.roleDAO.GetRoles(1)
+	s.Require().NoError(err)
+	s.NotNil(roles)
+	s.Empty(roles)
+}
+
+func (s *RoleTestSuite) TestGrantRole() {
+	s.mock.
+		ExpectExec("INSERT INTO user_role").
+		WithArgs(1, model.RoleAdmin).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	s.NoError(s.roleDAO.GrantRole(1, model.RoleAdmin))
+}
+
+func (s *RoleTestSuite) TestRevokeMissingRole() {
+	s.mock.
+		ExpectExec("DELETE FROM user_role").
+		WithArgs(1, model.RoleAdmin).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := s.roleDAO.RevokeRole(1, model.RoleAdmin)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func TestRoleTestSuite(t *testing.T) {
+	suite.Run(t, new(RoleTestSuite))
+}
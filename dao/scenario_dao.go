@@ -0,0 +1,186 @@
+package dao
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/lib/pq"
+	"net/http"
+	"time"
+)
+
+const (
+	getScenario = `SELECT quest_id, format, source, updated_at FROM quest_scenario WHERE quest_id = $1`
+	setScenario = `
+		INSERT INTO quest_scenario (quest_id, format, source, checkpoint_ids) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (quest_id) DO UPDATE SET format = $2, source = $3, checkpoint_ids = $4, updated_at = now()
+		RETURNING updated_at
+	`
+	deleteScenario         = `DELETE FROM quest_scenario WHERE quest_id = $1`
+	getScenarioCheckpoints = `SELECT id FROM quest_checkpoint WHERE quest_id = $1 AND id = ANY($2)`
+	getScenarioState       = `
+		SELECT state, items, timers, final, version FROM scenario_state WHERE attempt_id = ` + latestAttempt + `
+	`
+	createScenarioState = `
+		INSERT INTO scenario_state (attempt_id, user_id, quest_id, state, items, timers, final, version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 1)
+		ON CONFLICT DO NOTHING
+	`
+	updateScenarioState = `
+		UPDATE scenario_state SET state = $2, items = $3, timers = $4, final = $5, version = version + 1, updated_at = now()
+		WHERE attempt_id = $1 AND version = $6
+	`
+)
+
+type ScenarioDAO interface {
+	GetScenario(questID int) (model.QuestScenario, DBError)
+	// SetScenario stores the scenario of the quest; checkpointIDs are the checkpoints its
+	// conditions refer to, all of them must belong to the quest. They are stored with the
+	// scenario and can not be deleted while it refers to them.
+	SetScenario(scenario model.QuestScenario, checkpointIDs []int) (model.QuestScenario, DBError)
+	DeleteScenario(questID int) DBError
+	// GetState returns the stored state of the player in the latest attempt or a zero state
+	// if the player has not made any transition in it yet.
+	GetState(userID, questID int) (model.ScenarioState, DBError)
+	// SaveState stores the state of the player in the active attempt if it was not changed
+	// since it was read.
+	SaveState(userID, questID int, state model.ScenarioState) DBError
+}
+
+func NewScenarioDAO(db *sql.DB) ScenarioDAO {
+	return &dbScenarioDAO{db: db}
+}
+
+type dbScenarioDAO struct {
+	db *sql.DB
+}
+
+func (dao *dbScenarioDAO) GetScenario(questID int) (model.QuestScenario, DBError) {
+	scenario := model.QuestScenario{}
+	var updatedAt time.Time
+	err := dao.db.QueryRow(getScenario, questID).Scan(&scenario.QuestID, &scenario.Format, &scenario.Source, &updatedAt)
+	if err == sql.ErrNoRows {
+		return scenario, NewDBErr(http.StatusNotFound, "quest has no scenario")
+	} else if err != nil {
+		return scenario, NewCrashDBErr(err)
+	}
+	scenario.UpdatedAt = model.QuotedTime(updatedAt)
+	return scenario, nil
+}
+
+func (dao *dbScenarioDAO) SetScenario(scenario model.QuestScenario, checkpointIDs []int) (model.QuestScenario, DBError) {
+	err := inTransaction(dao.db, func(tx *sql.Tx) error {
+		var lockedID int
+		if err := tx.QueryRow(lockQuest, scenario.QuestID).Scan(&lockedID); err == sql.ErrNoRows {
+			return NewDBErr(http.StatusNotFound, "quest not found")
+		} else if err != nil {
+			return err
+		}
+
+		ids := make([]int64, len(checkpointIDs))
+		for i, id := range checkpointIDs {
+			ids[i] = int64(id)
+		}
+		found, err := queryIDs(tx, getScenarioCheckpoints, scenario.QuestID, pq.Array(ids))
+		if err != nil {
+			return err
+		}
+		for _, id := range checkpointIDs {
+			if !found[id] {
+				return NewDBErr(
+					http.StatusUnprocessableEntity, fmt.Sprintf("checkpoint %d does not belong to the quest", id),
+				)
+			}
+		}
+
+		var updatedAt time.Time
+		err = tx.QueryRow(
+			setScenario, scenario.QuestID, scenario.Format, scenario.Source, pq.Array(ids),
+		).Scan(&updatedAt)
+		scenario.UpdatedAt = model.QuotedTime(updatedAt)
+		return err
+	})
+	return scenario, err
+}
+
+func (dao *dbScenarioDAO) DeleteScenario(questID int) DBError {
+	result, err := dao.db.Exec(deleteScenario, questID)
+	if err != nil {
+		return NewCrashDBErr(err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return NewCrashDBErr(err)
+	} else if affected == 0 {
+		return NewDBErr(http.StatusNotFound, "quest has no scenario")
+	}
+	return nil
+}
+
+func (dao *dbScenarioDAO) GetState(userID, questID int) (model.ScenarioState, DBError) {
+	state := model.ScenarioState{}
+	var items pq.StringArray
+	var timers []byte
+	err := dao.db.QueryRow(getScenarioState, userID, questID).Scan(
+		&state.State, &items, &timers, &state.Final, &state.Version,
+	)
+	if err == sql.ErrNoRows {
+		return state, nil
+	} else if err != nil {
+		return state, NewCrashDBErr(err)
+	}
+	state.Items = items
+	if err := json.Unmarshal(timers, &state.Timers); err != nil {
+		return state, NewCrashDBErr(err)
+	}
+	return state, nil
+}
+
+// SaveState gives http.StatusConflict if another transition of the player was saved first.
+func (dao *dbScenarioDAO) SaveState(userID, questID int, state model.ScenarioState) DBError {
+	timers, err := json.Marshal(state.Timers)
+	if err != nil {
+		return NewCrashDBErr(err)
+	}
+	return inTransaction(dao.db, func(tx *sql.Tx) error {
+		attemptID, err := activeAttempt(tx, userID, questID)
+		if err != nil {
+			return err
+		}
+		values := []interface{}{state.State, pq.Array(state.Items), timers, state.Final}
+		var result sql.Result
+		if state.Version == 0 {
+			result, err = tx.Exec(createScenarioState, append([]interface{}{attemptID, userID, questID}, values...)...)
+		} else {
+			result, err = tx.Exec(updateScenarioState, append(append([]interface{}{attemptID}, values...), state.Version)...)
+		}
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return NewDBErr(http.StatusConflict, "the scenario state was changed by another request, try again")
+		}
+		return nil
+	})
+}
+
+// queryIDs returns the set of ids selected by the query.
+func queryIDs(tx *sql.Tx, query string, args ...interface{}) (map[int]bool, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		result[id] = true
+	}
+	return result, rows.Err()
+}
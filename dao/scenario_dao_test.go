@@ -0,0 +1,125 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type ScenarioTestSuite struct {
+	suite.Suite
+	db          *sql.DB
+	mock        sqlmock.Sqlmock
+	scenarioDAO ScenarioDAO
+}
+
+func (s *ScenarioTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.scenarioDAO = NewScenarioDAO(s.db)
+}
+
+func (s *ScenarioTestSuite) TestGetScenarioMissing() {
+	s.mock.ExpectQuery("SELECT quest_id, format, source").WithArgs(3).WillReturnError(sql.ErrNoRows)
+
+	_, err := s.scenarioDAO.GetScenario(3)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *ScenarioTestSuite) TestSetScenario() {
+	updatedAt := time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest WHERE").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.
+		ExpectQuery("SELECT id FROM quest_checkpoint").
+		WithArgs(3, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2).AddRow(5))
+	s.mock.
+		ExpectQuery("INSERT INTO quest_scenario").
+		WithArgs(3, "yaml", "version: 1", pq.Array([]int64{2, 5})).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(updatedAt))
+	s.mock.ExpectCommit()
+
+	saved, err := s.scenarioDAO.SetScenario(model.QuestScenario{QuestID: 3, Format: "yaml", Source: "version: 1"}, []int{2, 5})
+	s.Require().NoError(err)
+	s.Equal(model.QuotedTime(updatedAt), saved.UpdatedAt)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ScenarioTestSuite) TestSetScenarioForeignCheckpoint() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest WHERE").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.
+		ExpectQuery("SELECT id FROM quest_checkpoint").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	s.mock.ExpectRollback()
+
+	_, err := s.scenarioDAO.SetScenario(model.QuestScenario{QuestID: 3, Format: "yaml"}, []int{2, 7})
+	s.Require().Error(err)
+	s.Equal(http.StatusUnprocessableEntity, err.Code())
+	s.Contains(err.Error(), "checkpoint 7")
+}
+
+func (s *ScenarioTestSuite) TestGetState() {
+	s.mock.
+		ExpectQuery("SELECT state, items, timers").
+		WithArgs(20, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"state", "items", "timers", "final", "version"}).
+			AddRow("hall", "{key}", `{"escape": "2018-05-01T12:00:00Z"}`, false, 2))
+
+	state, err := s.scenarioDAO.GetState(20, 3)
+	s.Require().NoError(err)
+	s.Equal(model.ScenarioState{
+		State:   "hall",
+		Items:   []string{"key"},
+		Timers:  map[string]time.Time{"escape": time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)},
+		Version: 2,
+	}, state)
+}
+
+func (s *ScenarioTestSuite) TestGetStateNotStarted() {
+	s.mock.ExpectQuery("SELECT state, items, timers").WithArgs(20, 3).WillReturnError(sql.ErrNoRows)
+
+	state, err := s.scenarioDAO.GetState(20, 3)
+	s.Require().NoError(err)
+	s.Equal(0, state.Version)
+}
+
+func (s *ScenarioTestSuite) expectActiveAttempt() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest_attempt").WithArgs(20, 3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+}
+
+func (s *ScenarioTestSuite) TestSaveState() {
+	state := model.ScenarioState{State: "out", Items: []string{}, Timers: map[string]time.Time{}, Final: true}
+	s.expectActiveAttempt()
+	s.mock.
+		ExpectExec("INSERT INTO scenario_state").
+		WithArgs(7, 20, 3, "out", sqlmock.AnyArg(), []byte("{}"), true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.NoError(s.scenarioDAO.SaveState(20, 3, state))
+
+	state.Version = 4
+	s.expectActiveAttempt()
+	s.mock.
+		ExpectExec("UPDATE scenario_state SET").
+		WithArgs(7, "out", sqlmock.AnyArg(), []byte("{}"), true, 4).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectRollback()
+	err := s.scenarioDAO.SaveState(20, 3, state)
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestScenarioTestSuite(t *testing.T) {
+	suite.Run(t, new(ScenarioTestSuite))
+}
@@ -0,0 +1,179 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"net/http"
+	"time"
+)
+
+const (
+	teamColumns = `t.id, t.name, t.invite_code, t.created_at`
+	getTeams    = `
+		SELECT ` + teamColumns + ` FROM team AS t JOIN team_member AS m ON m.team_id = t.id
+		WHERE m.user_id = $1 ORDER BY t.name, t.id
+	`
+	getTeam = `
+		SELECT ` + teamColumns + ` FROM team AS t
+		WHERE t.id = $1 AND EXISTS (SELECT 1 FROM team_member WHERE team_id = t.id AND user_id = $2)
+	`
+	getTeamMembers = `
+		SELECT u.id, u.login, m.joined_at FROM team_member AS m JOIN users AS u ON u.id = m.user_id
+		WHERE m.team_id = $1 ORDER BY m.joined_at, u.id
+	`
+	createTeam = `
+		WITH t AS (INSERT INTO team (name, invite_code) VALUES ($1, $2) RETURNING id)
+		INSERT INTO team_member (team_id, user_id) SELECT id, $3 FROM t
+		RETURNING team_id
+	`
+	lockTeamByCode   = `SELECT id FROM team WHERE invite_code = $1 FOR UPDATE`
+	lockTeam         = `SELECT id FROM team WHERE id = $1 FOR UPDATE`
+	countTeamPlaying = `SELECT count(*) FROM quest_attempt WHERE team_id = $1 AND outcome = 'active'`
+	joinTeam         = `INSERT INTO team_member (team_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`
+	leaveTeam        = `DELETE FROM team_member WHERE team_id = $1 AND user_id = $2`
+)
+
+// TeamDAO keeps teams and their members. Teams are never deleted so that their runs stay on
+// leaderboards after all members have left.
+type TeamDAO interface {
+	// GetTeams returns the teams of the user without members.
+	GetTeams(userID int) ([]model.Team, DBError)
+	// GetTeam returns the team with its members if the user is one of them.
+	GetTeam(userID, teamID int) (model.Team, DBError)
+	// CreateTeam saves a new team with the user as its only member.
+	CreateTeam(userID int, team model.Team) (model.Team, DBError)
+	// JoinTeam adds the user to the team with the invite code.
+	JoinTeam(userID int, inviteCode string) (model.Team, DBError)
+	LeaveTeam(userID, teamID int) DBError
+}
+
+func NewTeamDAO(db *sql.DB) TeamDAO {
+	return &dbTeamDAO{db: db}
+}
+
+type dbTeamDAO struct {
+	db *sql.DB
+}
+
+func (dao *dbTeamDAO) GetTeams(userID int) ([]model.Team, DBError) {
+	rows, err := dao.db.Query(getTeams, userID)
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	result := make([]model.Team, 0)
+	for rows.Next() {
+		team, err := scanTeam(rows)
+		if err != nil {
+			return nil, NewCrashDBErr(err)
+		}
+		result = append(result, team)
+	}
+	return result, NewCrashDBErr(rows.Err())
+}
+
+// GetTeam gives http.StatusNotFound also for the teams the user is not a member of.
+func (dao *dbTeamDAO) GetTeam(userID, teamID int) (model.Team, DBError) {
+	team, err := scanTeam(dao.db.QueryRow(getTeam, teamID, userID))
+	if err == sql.ErrNoRows {
+		return team, NewDBErr(http.StatusNotFound, "team not found")
+	} else if err != nil {
+		return team, NewCrashDBErr(err)
+	}
+
+	rows, err := dao.db.Query(getTeamMembers, teamID)
+	if err != nil {
+		return team, NewCrashDBErr(err)
+	}
+	defer rows.Close()
+
+	team.Members = make([]model.TeamMember, 0)
+	for rows.Next() {
+		member := model.TeamMember{}
+		var joinedAt time.Time
+		if err := rows.Scan(&member.UserID, &member.Login, &joinedAt); err != nil {
+			return team, NewCrashDBErr(err)
+		}
+		member.JoinedAt = model.QuotedTime(joinedAt)
+		team.Members = append(team.Members, member)
+	}
+	return team, NewCrashDBErr(rows.Err())
+}
+
+func (dao *dbTeamDAO) CreateTeam(userID int, team model.Team) (model.Team, DBError) {
+	var teamID int
+	if err := dao.db.QueryRow(createTeam, team.Name, team.InviteCode, userID).Scan(&teamID); err != nil {
+		return team, NewCrashDBErr(err)
+	}
+	return dao.GetTeam(userID, teamID)
+}
+
+// JoinTeam gives http.StatusConflict while the team plays a quest; joining twice is not an error.
+func (dao *dbTeamDAO) JoinTeam(userID int, inviteCode string) (model.Team, DBError) {
+	var teamID int
+	dbErr := inTransaction(dao.db, func(tx *sql.Tx) error {
+		if err := tx.QueryRow(lockTeamByCode, inviteCode).Scan(&teamID); err == sql.ErrNoRows {
+			return NewDBErr(http.StatusNotFound, "team not found")
+		} else if err != nil {
+			return err
+		}
+		if err := checkTeamIdle(tx, teamID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(joinTeam, teamID, userID)
+		return err
+	})
+	if dbErr != nil {
+		return model.Team{}, dbErr
+	}
+	return dao.GetTeam(userID, teamID)
+}
+
+// LeaveTeam gives http.StatusConflict while the team plays a quest.
+func (dao *dbTeamDAO) LeaveTeam(userID, teamID int) DBError {
+	return inTransaction(dao.db, func(tx *sql.Tx) error {
+		var lockedID int
+		if err := tx.QueryRow(lockTeam, teamID).Scan(&lockedID); err == sql.ErrNoRows {
+			return NewDBErr(http.StatusNotFound, "team not found")
+		} else if err != nil {
+			return err
+		}
+		if err := checkTeamIdle(tx, teamID); err != nil {
+			return err
+		}
+		result, err := tx.Exec(leaveTeam, teamID, userID)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return err
+		} else if affected == 0 {
+			return NewDBErr(http.StatusNotFound, "team not found")
+		}
+		return nil
+	})
+}
+
+// checkTeamIdle fails with http.StatusConflict if the locked team has an active attempt:
+// the members of a team attempt are fixed when it starts.
+func checkTeamIdle(tx *sql.Tx, teamID int) error {
+	var playing int
+	if err := tx.QueryRow(countTeamPlaying, teamID).Scan(&playing); err != nil {
+		return err
+	}
+	if playing > 0 {
+		return NewDBErr(http.StatusConflict, "the team is playing a quest, try again when the attempt is over")
+	}
+	return nil
+}
+
+func scanTeam(row scanner) (model.Team, error) {
+	team := model.Team{}
+	var createdAt time.Time
+	if err := row.Scan(&team.ID, &team.Name, &team.InviteCode, &createdAt); err != nil {
+		return team, err
+	}
+	team.CreatedAt = model.QuotedTime(createdAt)
+	return team, nil
+}
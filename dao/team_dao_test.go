@@ -0,0 +1,163 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var teamColumnNames = []string{"id", "name", "invite_code", "created_at"}
+
+type TeamTestSuite struct {
+	suite.Suite
+	db      *sql.DB
+	mock    sqlmock.Sqlmock
+	teamDAO TeamDAO
+}
+
+func (s *TeamTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.teamDAO = NewTeamDAO(s.db)
+}
+
+func (s *TeamTestSuite) expectTeam(userID int) {
+	s.mock.
+		ExpectQuery("SELECT t.id, t.name, t.invite_code, t.created_at FROM team AS t\\s+WHERE t.id = \\$1").
+		WithArgs(4, userID).
+		WillReturnRows(sqlmock.NewRows(teamColumnNames).AddRow(4, "owls", "abcdefgh", time.Now()))
+	s.mock.
+		ExpectQuery("SELECT u.id, u.login, m.joined_at FROM team_member").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "login", "joined_at"}).
+			AddRow(1, "first", time.Now()).
+			AddRow(userID, "second", time.Now()))
+}
+
+func (s *TeamTestSuite) TestGetTeams() {
+	s.mock.
+		ExpectQuery("SELECT t.id, t.name, t.invite_code, t.created_at FROM team AS t JOIN team_member").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows(teamColumnNames).
+			AddRow(4, "owls", "abcdefgh", time.Now()).
+			AddRow(5, "wolves", "ijklmnop", time.Now()))
+
+	teams, err := s.teamDAO.GetTeams(1)
+	s.Require().NoError(err)
+	s.Len(teams, 2)
+	s.Equal("wolves", teams[1].Name)
+	s.Nil(teams[0].Members)
+}
+
+func (s *TeamTestSuite) TestGetTeam() {
+	s.expectTeam(2)
+
+	team, err := s.teamDAO.GetTeam(2, 4)
+	s.Require().NoError(err)
+	s.Equal("abcdefgh", team.InviteCode)
+	s.Len(team.Members, 2)
+	s.Equal("second", team.Members[1].Login)
+}
+
+func (s *TeamTestSuite) TestGetTeamNotMember() {
+	s.mock.
+		ExpectQuery("SELECT t.id, t.name, t.invite_code, t.created_at FROM team").
+		WithArgs(4, 2).
+		WillReturnRows(sqlmock.NewRows(teamColumnNames))
+
+	_, err := s.teamDAO.GetTeam(2, 4)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *TeamTestSuite) TestCreateTeam() {
+	s.mock.
+		ExpectQuery("WITH t AS \\(INSERT INTO team \\(name, invite_code\\)").
+		WithArgs("owls", "abcdefgh", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"team_id"}).AddRow(4))
+	s.expectTeam(2)
+
+	team, err := s.teamDAO.CreateTeam(2, model.Team{Name: "owls", InviteCode: "abcdefgh"})
+	s.Require().NoError(err)
+	s.Equal(4, team.ID)
+}
+
+func (s *TeamTestSuite) TestJoinTeam() {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT id FROM team WHERE invite_code = \\$1 FOR UPDATE").
+		WithArgs("abcdefgh").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	s.mock.
+		ExpectQuery("SELECT count\\(\\*\\) FROM quest_attempt WHERE team_id = \\$1").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.
+		ExpectExec("INSERT INTO team_member").
+		WithArgs(4, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.expectTeam(2)
+
+	team, err := s.teamDAO.JoinTeam(2, "abcdefgh")
+	s.Require().NoError(err)
+	s.Equal(4, team.ID)
+}
+
+func (s *TeamTestSuite) TestJoinTeamBadCode() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM team WHERE invite_code").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectRollback()
+
+	_, err := s.teamDAO.JoinTeam(2, "abcdefgh")
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *TeamTestSuite) TestJoinTeamPlaying() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM team WHERE invite_code").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	s.mock.ExpectQuery("SELECT count\\(\\*\\) FROM quest_attempt").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.ExpectRollback()
+
+	_, err := s.teamDAO.JoinTeam(2, "abcdefgh")
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+}
+
+func (s *TeamTestSuite) TestLeaveTeam() {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT id FROM team WHERE id = \\$1 FOR UPDATE").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	s.mock.ExpectQuery("SELECT count\\(\\*\\) FROM quest_attempt").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.
+		ExpectExec("DELETE FROM team_member").
+		WithArgs(4, 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	s.NoError(s.teamDAO.LeaveTeam(2, 4))
+}
+
+func (s *TeamTestSuite) TestLeaveTeamNotMember() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM team WHERE id").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	s.mock.ExpectQuery("SELECT count\\(\\*\\) FROM quest_attempt").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.ExpectExec("DELETE FROM team_member").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectRollback()
+
+	err := s.teamDAO.LeaveTeam(2, 4)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func TestTeamTestSuite(t *testing.T) {
+	suite.Run(t, new(TeamTestSuite))
+}
@@ -0,0 +1,142 @@
+package dao
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/model"
+	"net/http"
+	"time"
+)
+
+const (
+	saveRefreshToken = `
+		INSERT INTO refresh_token (user_id, family_id, token_hash, access_jti, access_expires_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	getRefreshToken = `
+		SELECT id, user_id, family_id, token_hash, access_jti, access_expires_at, expires_at, used_at IS NOT NULL, revoked
+		FROM refresh_token WHERE token_hash = $1
+	`
+	markRefreshTokenUsed = `UPDATE refresh_token SET used_at = now() WHERE id = $1 AND used_at IS NULL`
+	revokeFamilyAccess   = `
+		INSERT INTO revoked_token (jti, expires_at)
+		SELECT access_jti, access_expires_at FROM refresh_token WHERE family_id = $1 AND access_expires_at > now()
+		ON CONFLICT (jti) DO NOTHING
+	`
+	revokeFamily     = `UPDATE refresh_token SET revoked = TRUE WHERE family_id = $1`
+	revokeUserAccess = `
+		INSERT INTO revoked_token (jti, expires_at)
+		SELECT access_jti, access_expires_at FROM refresh_token WHERE user_id = $1 AND access_expires_at > now()
+		ON CONFLICT (jti) DO NOTHING
+	`
+	revokeUserFamilies = `UPDATE refresh_token SET revoked = TRUE WHERE user_id = $1`
+	revokeAccessToken  = `INSERT INTO revoked_token (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`
+	checkRevoked       = `SELECT count(*) cnt FROM revoked_token WHERE jti = $1`
+	purgeRevoked       = `DELETE FROM revoked_token WHERE expires_at < now()`
+	purgeRefresh       = `DELETE FROM refresh_token WHERE expires_at < now()`
+)
+
+type TokenDAO interface {
+	SaveRefreshToken(token model.RefreshToken) DBError
+	GetRefreshToken(hash []byte) (*model.RefreshToken, DBError)
+	// MarkRefreshTokenUsed returns an error with http.StatusConflict if the token has already been used
+	MarkRefreshTokenUsed(id int) DBError
+	RevokeFamily(familyID string) DBError
+	RevokeUserTokens(userID int) DBError
+	RevokeAccessToken(jti string, expiresAt time.Time) DBError
+	IsRevoked(jti string) (bool, DBError)
+	PurgeExpired() DBError
+}
+
+func NewTokenDAO(db *sql.DB) TokenDAO {
+	return &dbTokenDAO{db: db}
+}
+
+type dbTokenDAO struct {
+	db *sql.DB
+}
+
+func (dao *dbTokenDAO) SaveRefreshToken(token model.RefreshToken) DBError {
+	_, err := dao.db.Exec(
+		saveRefreshToken,
+		token.UserID, token.FamilyID, token.TokenHash, token.AccessJTI, token.AccessExpiresAt, token.ExpiresAt,
+	)
+	return NewCrashDBErr(err)
+}
+
+func (dao *dbTokenDAO) GetRefreshToken(hash []byte) (*model.RefreshToken, DBError) {
+	token := new(model.RefreshToken)
+	err := dao.db.QueryRow(getRefreshToken, hash).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.FamilyID,
+		&token.TokenHash,
+		&token.AccessJTI,
+		&token.AccessExpiresAt,
+		&token.ExpiresAt,
+		&token.Used,
+		&token.Revoked,
+	)
+	if err == sql.ErrNoRows {
+		return nil, NewDBErr(http.StatusNotFound, "refresh token not found")
+	}
+	if err != nil {
+		return nil, NewCrashDBErr(err)
+	}
+	return token, nil
+}
+
+func (dao *dbTokenDAO) MarkRefreshTokenUsed(id int) DBError {
+	result, err := dao.db.Exec(markRefreshTokenUsed, id)
+	if err != nil {
+		return NewCrashDBErr(err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return NewCrashDBErr(err)
+	} else if affected == 0 {
+		return NewDBErr(http.StatusConflict, "refresh token already used")
+	}
+	return nil
+}
+
+func (dao *dbTokenDAO) RevokeFamily(familyID string) DBError {
+	return inTransaction(dao.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(revokeFamilyAccess, familyID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(revokeFamily, familyID)
+		return err
+	})
+}
+
+func (dao *dbTokenDAO) RevokeUserTokens(userID int) DBError {
+	return inTransaction(dao.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(revokeUserAccess, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(revokeUserFamilies, userID)
+		return err
+	})
+}
+
+func (dao *dbTokenDAO) RevokeAccessToken(jti string, expiresAt time.Time) DBError {
+	_, err := dao.db.Exec(revokeAccessToken, jti, expiresAt)
+	return NewCrashDBErr(err)
+}
+
+func (dao *dbTokenDAO) IsRevoked(jti string) (bool, DBError) {
+	cnt := 0
+	if err := dao.db.QueryRow(checkRevoked, jti).Scan(&cnt); err != nil {
+		return false, NewCrashDBErr(err)
+	}
+	return cnt > 0, nil
+}
+
+func (dao *dbTokenDAO) PurgeExpired() DBError {
+	return inTransaction(dao.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(purgeRevoked); err != nil {
+			return err
+		}
+		_, err := tx.Exec(purgeRefresh)
+		return err
+	})
+}
@@ -0,0 +1,95 @@
+package dao
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type TokenTestSuite struct {
+	suite.Suite
+	db       *sql.DB
+	mock     sqlmock.Sqlmock
+	tokenDAO TokenDAO
+}
+
+func (s *TokenTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.tokenDAO = NewTokenDAO(s.db)
+}
+
+func (s *TokenTestSuite) TestSaveRefreshToken() {
+	now := time.Now()
+	token := model.RefreshToken{
+		UserID:          1,
+		FamilyID:        "family",
+		TokenHash:       []byte("hash"),
+		AccessJTI:       "jti",
+		AccessExpiresAt: now,
+		ExpiresAt:       now,
+	}
+	s.mock.
+		ExpectExec("INSERT INTO refresh_token").
+		WithArgs(1, "family", []byte("hash"), "jti", now, now).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	s.NoError(s.tokenDAO.SaveRefreshToken(token))
+}
+
+func (s *TokenTestSuite) TestGetRefreshTokenNotFound() {
+	s.mock.
+		ExpectQuery("SELECT id, user_id").
+		WithArgs([]byte("hash")).
+		WillReturnError(sql.ErrNoRows)
+
+	_, err := s.tokenDAO.GetRefreshToken([]byte("hash"))
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
+func (s *TokenTestSuite) TestMarkUsedConflict() {
+	s.mock.
+		ExpectExec("UPDATE refresh_token SET used_at").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := s.tokenDAO.MarkRefreshTokenUsed(1)
+	s.Require().Error(err)
+	s.Equal(http.StatusConflict, err.Code())
+}
+
+func (s *TokenTestSuite) TestRevokeFamilyRollback() {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectExec("INSERT INTO revoked_token").
+		WithArgs("family").
+		WillReturnError(fmt.Errorf("fail"))
+	s.mock.ExpectRollback()
+
+	err := s.tokenDAO.RevokeFamily("family")
+	s.Require().Error(err)
+	s.Equal("fail", err.Error())
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TokenTestSuite) TestIsRevoked() {
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs("jti").
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+
+	revoked, err := s.tokenDAO.IsRevoked("jti")
+	s.Require().NoError(err)
+	s.True(revoked)
+}
+
+func TestTokenTestSuite(t *testing.T) {
+	suite.Run(t, new(TokenTestSuite))
+}
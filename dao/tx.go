@@ -0,0 +1,18 @@
+package dao
+
+import "database/sql"
+
+func inTransaction(db *sql.DB, f func(tx *sql.Tx) error) DBError {
+	tx, err := db.Begin()
+	if err != nil {
+		return NewCrashDBErr(err)
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		if dbErr, ok := err.(DBError); ok {
+			return dbErr
+		}
+		return NewCrashDBErr(err)
+	}
+	return NewCrashDBErr(tx.Commit())
+}
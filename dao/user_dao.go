@@ -3,6 +3,7 @@ package dao
 import (
 	"database/sql"
 	"github.com/Sovianum/arquest-server/model"
+	"net/http"
 )
 
 const (
@@ -12,15 +13,20 @@ const (
 	getIdByLogin     = `SELECT id FROM users WHERE login = $1`
 	checkUserById    = `SELECT count(*) cnt FROM users u WHERE u.id = $1`
 	checkUserByLogin = `SELECT count(*) cnt FROM users u WHERE u.login = $1`
+	updatePassword   = `UPDATE users SET password = $1 WHERE id = $2`
 )
 
 type UserDAO interface {
 	Save(user model.User) (int, DBError)
+	// SaveWithRole saves the user and grants it the role in one transaction, so that no user
+	// is left without a role.
+	SaveWithRole(user model.User, role string) (int, DBError)
 	GetUserById(id int) (model.User, DBError)
 	GetUserByLogin(login string) (*model.User, DBError)
 	GetIdByLogin(login string) (int, DBError)
 	ExistsById(id int) (bool, DBError)
 	ExistsByLogin(login string) (bool, DBError)
+	UpdatePassword(id int, hash []byte) DBError
 }
 
 type dbUserDAO struct {
@@ -48,6 +54,24 @@ func (dao *dbUserDAO) Save(user model.User) (int, DBError) {
 	return id, nil
 }
 
+func (dao *dbUserDAO) SaveWithRole(user model.User, role string) (int, DBError) {
+	id := 0
+	err := inTransaction(dao.db, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(saveUser, user.Login, user.Password, user.Age, user.Sex, user.About); err != nil {
+			return err
+		}
+		if err := tx.QueryRow(getIdByLogin, user.Login).Scan(&id); err != nil {
+			return err
+		}
+		_, err := tx.Exec(grantRole, id, role)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
 func (dao *dbUserDAO) GetIdByLogin(login string) (int, DBError) {
 	return dao.getIdByLogin(login)
 }
@@ -86,6 +110,19 @@ func (dao *dbUserDAO) ExistsByLogin(login string) (bool, DBError) {
 	return cnt > 0, nil
 }
 
+func (dao *dbUserDAO) UpdatePassword(id int, hash []byte) DBError {
+	result, err := dao.db.Exec(updatePassword, hash, id)
+	if err != nil {
+		return NewCrashDBErr(err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return NewCrashDBErr(err)
+	} else if affected == 0 {
+		return NewDBErr(http.StatusNotFound, "user not found")
+	}
+	return nil
+}
+
 func (dao *dbUserDAO) getIdByLogin(login string) (int, DBError) {
 	id := 0
 	getErr := dao.db.QueryRow(getIdByLogin, login).Scan(&id)
@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/suite"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
 	"testing"
 )
 
@@ -155,6 +156,44 @@ func (s *UserTestSuite) TestSaveDuplicateLogin() {
 	s.Equal("duplicate id", saveErr.Error())
 }
 
+func (s *UserTestSuite) TestSaveWithRole() {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectExec("INSERT INTO users").
+		WithArgs("login", string(s.passHash), 100, model.FEMALE, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.
+		ExpectQuery("SELECT id FROM users").
+		WithArgs("login").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.
+		ExpectExec("INSERT INTO user_role").
+		WithArgs(1, model.RolePlayer).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	user := model.User{Login: "login", Password: string(s.passHash), Sex: model.FEMALE, Age: 100}
+	id, saveErr := s.userDAO.SaveWithRole(user, model.RolePlayer)
+
+	s.NoError(saveErr)
+	s.Equal(1, id)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *UserTestSuite) TestSaveWithRoleGrantFail() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("INSERT INTO users").WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectQuery("SELECT id FROM users").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectExec("INSERT INTO user_role").WillReturnError(fmt.Errorf("fail"))
+	s.mock.ExpectRollback()
+
+	user := model.User{Login: "login", Password: string(s.passHash), Sex: model.FEMALE, Age: 100}
+	_, saveErr := s.userDAO.SaveWithRole(user, model.RolePlayer)
+
+	s.Error(saveErr)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
 func (s *UserTestSuite) TestGetUserByIdSuccess() {
 	rows := sqlmock.NewRows([]string{"id", "login", "password", "age", "sex", "about"}).
 		AddRow(1, "login", "pass", 100, model.MALE, "about")
@@ -209,6 +248,27 @@ func (s *UserTestSuite) TestGetUserByLoginNotFound() {
 	s.Equal("user not found", err.Error())
 }
 
+func (s *UserTestSuite) TestUpdatePasswordSuccess() {
+	s.mock.
+		ExpectExec("UPDATE users SET password").
+		WithArgs(s.passHash, 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err := s.userDAO.UpdatePassword(1, s.passHash)
+	s.NoError(err)
+}
+
+func (s *UserTestSuite) TestUpdatePasswordNotFound() {
+	s.mock.
+		ExpectExec("UPDATE users SET password").
+		WithArgs(s.passHash, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err := s.userDAO.UpdatePassword(1, s.passHash)
+	s.Require().Error(err)
+	s.Equal(http.StatusNotFound, err.Code())
+}
+
 func TestUserTestSuite(t *testing.T) {
 	suite.Run(t, new(UserTestSuite))
 }
@@ -0,0 +1,29 @@
+package geo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	moscow := Point{Lat: 55.7558, Lon: 37.6173}
+	petersburg := Point{Lat: 59.9343, Lon: 30.3351}
+
+	assert.InDelta(t, 634e3, Distance(moscow, petersburg), 2e3)
+	assert.Equal(t, 0., Distance(moscow, moscow))
+	assert.InDelta(t, 111.2e3, Distance(Point{Lat: 0, Lon: 179.5}, Point{Lat: 0, Lon: -179.5}), 0.1e3)
+}
+
+func TestPointValidate(t *testing.T) {
+	assert.NoError(t, Point{Lat: -90, Lon: 180}.Validate())
+	assert.Error(t, Point{Lat: 91}.Validate())
+	assert.Error(t, Point{Lon: -181}.Validate())
+}
+
+func TestRect(t *testing.T) {
+	r := Rect{MinLat: 55, MinLon: 37, MaxLat: 56, MaxLon: 38}
+	assert.NoError(t, r.Validate())
+	assert.True(t, r.Contains(Point{Lat: 55.5, Lon: 37.5}))
+	assert.False(t, r.Contains(Point{Lat: 54.5, Lon: 37.5}))
+	assert.Error(t, Rect{MinLat: 56, MinLon: 37, MaxLat: 55, MaxLon: 38}.Validate())
+}
@@ -0,0 +1,105 @@
+package geo
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultCellSize is about 11 km along a meridian, close to a typical search radius.
+const DefaultCellSize = 0.1
+
+type Hit struct {
+	ID       int
+	Distance float64
+}
+
+// Index is an in-memory grid of points used where the database has no spatial index.
+// Points are bucketed into cells of cellSize degrees; a search only visits the cells
+// overlapping the bounding box of the search circle. Index is not safe for concurrent
+// modification, but concurrent searches are fine.
+type Index struct {
+	cellSize float64
+	lonCells int
+	cells    map[cell][]entry
+	size     int
+}
+
+type cell struct {
+	lat, lon int
+}
+
+type entry struct {
+	id    int
+	point Point
+}
+
+func NewIndex(cellSize float64) *Index {
+	if cellSize <= 0 {
+		cellSize = DefaultCellSize
+	}
+	return &Index{
+		cellSize: cellSize,
+		lonCells: int(math.Ceil(360 / cellSize)),
+		cells:    make(map[cell][]entry),
+	}
+}
+
+func (idx *Index) Len() int {
+	return idx.size
+}
+
+func (idx *Index) Insert(id int, p Point) {
+	key := cell{lat: idx.latCell(p.Lat), lon: idx.lonCell(p.Lon)}
+	idx.cells[key] = append(idx.cells[key], entry{id: id, point: p})
+	idx.size++
+}
+
+// Nearby returns up to limit points within radius meters of center ordered by distance.
+// Non-positive limit means no limit.
+func (idx *Index) Nearby(center Point, radius float64, limit int) []Hit {
+	dLat := degrees(radius / EarthRadius)
+	minLat, maxLat := math.Max(center.Lat-dLat, -90), math.Min(center.Lat+dLat, 90)
+
+	firstLon, lonCount := 0, idx.lonCells
+	if minLat > -90 && maxLat < 90 {
+		widest := math.Max(math.Abs(minLat), math.Abs(maxLat))
+		if dLon := dLat / math.Cos(radians(widest)); dLon < 180 {
+			firstLon = int(math.Floor((center.Lon - dLon + 180) / idx.cellSize))
+			lonCount = int(math.Floor((center.Lon+dLon+180)/idx.cellSize)) - firstLon + 1
+			if lonCount > idx.lonCells {
+				lonCount = idx.lonCells
+			}
+		}
+	}
+
+	hits := make([]Hit, 0)
+	for lat := idx.latCell(minLat); lat <= idx.latCell(maxLat); lat++ {
+		for i := 0; i < lonCount; i++ {
+			lon := ((firstLon+i)%idx.lonCells + idx.lonCells) % idx.lonCells
+			for _, e := range idx.cells[cell{lat: lat, lon: lon}] {
+				if d := Distance(center, e.point); d <= radius {
+					hits = append(hits, Hit{ID: e.id, Distance: d})
+				}
+			}
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Distance == hits[j].Distance {
+			return hits[i].ID < hits[j].ID
+		}
+		return hits[i].Distance < hits[j].Distance
+	})
+	if limit > 0 && len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits
+}
+
+func (idx *Index) latCell(lat float64) int {
+	return int(math.Floor((lat + 90) / idx.cellSize))
+}
+
+func (idx *Index) lonCell(lon float64) int {
+	return int(math.Floor((lon+180)/idx.cellSize)) % idx.lonCells
+}
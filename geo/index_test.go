@@ -0,0 +1,70 @@
+package geo
+
+import (
+	"github.com/stretchr/testify/suite"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+type IndexTestSuite struct {
+	suite.Suite
+	points []Point
+	index  *Index
+}
+
+func (s *IndexTestSuite) SetupTest() {
+	rnd := rand.New(rand.NewSource(1))
+	s.points = nil
+	s.index = NewIndex(DefaultCellSize)
+	for _, center := range []Point{{55.75, 37.61}, {0, 179.9}, {0, -179.9}, {89.9, 0}} {
+		for i := 0; i < 500; i++ {
+			p := Point{Lat: center.Lat + rnd.Float64() - 0.5, Lon: center.Lon + rnd.Float64() - 0.5}
+			if p.Lat > 90 {
+				p.Lat = 90
+			}
+			if p.Lon > 180 {
+				p.Lon -= 360
+			} else if p.Lon < -180 {
+				p.Lon += 360
+			}
+			s.index.Insert(len(s.points), p)
+			s.points = append(s.points, p)
+		}
+	}
+}
+
+func (s *IndexTestSuite) bruteForce(center Point, radius float64) []Hit {
+	hits := make([]Hit, 0)
+	for id, p := range s.points {
+		if d := Distance(center, p); d <= radius {
+			hits = append(hits, Hit{ID: id, Distance: d})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Distance < hits[j].Distance })
+	return hits
+}
+
+func (s *IndexTestSuite) TestMatchesBruteForce() {
+	for _, center := range []Point{{55.75, 37.61}, {0, 180}, {0, -179.95}, {90, 0}, {10, 10}} {
+		for _, radius := range []float64{100, 5e3, 30e3, 200e3} {
+			expected := s.bruteForce(center, radius)
+			s.Equal(expected, s.index.Nearby(center, radius, 0), "center %v radius %v", center, radius)
+		}
+	}
+}
+
+func (s *IndexTestSuite) TestLimit() {
+	center := Point{55.75, 37.61}
+	hits := s.index.Nearby(center, 50e3, 3)
+	s.Require().Len(hits, 3)
+	s.Equal(s.bruteForce(center, 50e3)[:3], hits)
+}
+
+func (s *IndexTestSuite) TestLen() {
+	s.Equal(len(s.points), s.index.Len())
+}
+
+func TestIndexTestSuite(t *testing.T) {
+	suite.Run(t, new(IndexTestSuite))
+}
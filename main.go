@@ -4,6 +4,9 @@ import (
 	"database/sql"
 	"fmt"
 	"github.com/Sovianum/arquest-server/config"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/migrations"
+	"github.com/Sovianum/arquest-server/model"
 	"github.com/Sovianum/arquest-server/mylog"
 	"github.com/Sovianum/arquest-server/routes"
 	"github.com/Sovianum/arquest-server/server"
@@ -15,11 +18,14 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 )
 
 const (
 	confFile   = "resources/ard.conf.json"
 	defaultLog = "/var/log/ard.log"
+
+	tokenPurgeInterval = time.Hour
 )
 
 func main() {
@@ -53,9 +59,48 @@ func main() {
 		panic(err)
 	}
 
-	env := server.NewEnv(db, conf, logger)
+	migrator := migrations.NewMigrator(db)
+	if flags.Command == utils.MigrateCommand {
+		if err := migrations.RunCommand(migrator, flags.CommandArgs, os.Stdout); err != nil {
+			logger.Error(err)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	} else if flags.Command == utils.RoleCommand {
+		if err := runRoleCommand(db, flags.CommandArgs, os.Stdout); err != nil {
+			logger.Error(err)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	} else if flags.Command == utils.RatingCommand {
+		if err := runRatingCommand(db, conf.Logic.Rating, flags.CommandArgs, os.Stdout); err != nil {
+			logger.Error(err)
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	} else if flags.Command != "" {
+		panic(fmt.Errorf("unknown command %q", flags.Command))
+	}
+
+	if conf.DB.CheckSchemaVersion {
+		if err := migrations.CheckVersion(migrator); err != nil {
+			logger.Error(err)
+			panic(err)
+		}
+	}
+
+	env, err := server.NewEnv(db, conf, logger)
+	if err != nil {
+		logger.Error(err)
+		panic(err)
+	}
 	router := routes.GetEngine(env)
 
+	go env.PurgeExpiredTokens(tokenPurgeInterval)
+
 	portLine := fmt.Sprintf(":%d", getServerPort(conf, logger))
 	if err := http.ListenAndServe(portLine, handlers.LoggingHandler(os.Stdout, router)); err != nil {
 		panic(err)
@@ -83,6 +128,57 @@ func getLogger(conf *config.Conf) (*mylog.Logger, *os.File, error) {
 	return mylog.NewLogger(f), f, nil
 }
 
+// runRoleCommand executes `role grant|revoke <login> <role>`. It is the way to
+// appoint the first admin, who can then manage roles through the API.
+func runRoleCommand(db *sql.DB, args []string, out io.Writer) error {
+	if len(args) != 3 {
+		return fmt.Errorf("usage: role grant|revoke <login> <role>")
+	}
+	action, login, role := args[0], args[1], args[2]
+	if !model.IsValidRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	userID, err := dao.NewDBUserDAO(db).GetIdByLogin(login)
+	if err != nil {
+		return fmt.Errorf("user %s: %v", login, err)
+	}
+
+	roleDAO := dao.NewRoleDAO(db)
+	switch action {
+	case "grant":
+		if err := roleDAO.GrantRole(userID, role); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "granted %s to %s\n", role, login)
+	case "revoke":
+		if err := roleDAO.RevokeRole(userID, role); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "revoked %s from %s\n", role, login)
+	default:
+		return fmt.Errorf("unknown role action %q", action)
+	}
+	return nil
+}
+
+// runRatingCommand executes `rating recompute`, which recomputes the ratings of all quests
+// from their marks, e.g. after the rating settings have changed.
+func runRatingCommand(db *sql.DB, conf config.RatingConfig, args []string, out io.Writer) error {
+	if len(args) != 1 || args[0] != "recompute" {
+		return fmt.Errorf("usage: rating recompute")
+	}
+	count, err := dao.NewMarkDAO(db).RecomputeRatings(model.RatingRules{
+		PriorWeight: conf.GetPriorWeight(),
+		PriorMean:   conf.GetPriorMean(),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "recomputed ratings of %d quests\n", count)
+	return nil
+}
+
 func getServerPort(conf *config.Conf, logger *mylog.Logger) int {
 	portStr := os.Getenv(conf.PortEnvVar)
 
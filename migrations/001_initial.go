@@ -0,0 +1,54 @@
+package migrations
+
+// Migration 1 reproduces the schema that used to live in resources/scheme.sql.
+// It only creates missing objects so that databases created from that script
+// can be adopted without losing data.
+func init() {
+	register(Migration{
+		Version: 1,
+		Name:    "initial",
+		Up: `
+			DO $$
+			BEGIN
+				IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'sex') THEN
+					CREATE TYPE SEX AS ENUM ('M', 'F', '');
+				END IF;
+			END
+			$$;
+
+			CREATE TABLE IF NOT EXISTS users (
+				id       SERIAL PRIMARY KEY,
+				login    VARCHAR(50) UNIQUE,
+				password BYTEA,
+				sex      SEX NOT NULL DEFAULT '',
+				age      INT,
+				about    VARCHAR(1000)
+			);
+
+			CREATE TABLE IF NOT EXISTS quest (
+				id          SERIAL PRIMARY KEY,
+				name        VARCHAR(50),
+				description VARCHAR(1000),
+				rating      FLOAT,
+				mark_count  INT
+			);
+
+			CREATE TABLE IF NOT EXISTS quest_user_link (
+				id        SERIAL PRIMARY KEY,
+				user_id   INT REFERENCES users(id),
+				quest_id  INT REFERENCES quest(id),
+				started   BOOLEAN DEFAULT TRUE,
+				completed BOOLEAN DEFAULT FALSE,
+				marked    BOOLEAN DEFAULT FALSE,
+				mark      FLOAT DEFAULT 0,
+				CONSTRAINT ux_user_id_quest_id UNIQUE (user_id, quest_id)
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS quest_user_link;
+			DROP TABLE IF EXISTS quest;
+			DROP TABLE IF EXISTS users;
+			DROP TYPE IF EXISTS SEX;
+		`,
+	})
+}
@@ -0,0 +1,33 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 2,
+		Name:    "refresh_tokens",
+		Up: `
+			CREATE TABLE refresh_token (
+				id                SERIAL PRIMARY KEY,
+				user_id           INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				family_id         VARCHAR(64) NOT NULL,
+				token_hash        BYTEA NOT NULL UNIQUE,
+				access_jti        VARCHAR(64) NOT NULL,
+				access_expires_at TIMESTAMPTZ NOT NULL,
+				expires_at        TIMESTAMPTZ NOT NULL,
+				created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+				used_at           TIMESTAMPTZ,
+				revoked           BOOLEAN NOT NULL DEFAULT FALSE
+			);
+			CREATE INDEX ix_refresh_token_family_id ON refresh_token (family_id);
+			CREATE INDEX ix_refresh_token_user_id ON refresh_token (user_id);
+
+			CREATE TABLE revoked_token (
+				jti        VARCHAR(64) PRIMARY KEY,
+				expires_at TIMESTAMPTZ NOT NULL
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS revoked_token;
+			DROP TABLE IF EXISTS refresh_token;
+		`,
+	})
+}
@@ -0,0 +1,21 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 3,
+		Name:    "roles",
+		Up: `
+			CREATE TABLE user_role (
+				user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				role       VARCHAR(16) NOT NULL CHECK (role IN ('player', 'author', 'moderator', 'admin')),
+				granted_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				PRIMARY KEY (user_id, role)
+			);
+
+			INSERT INTO user_role (user_id, role) SELECT id, 'player' FROM users;
+		`,
+		Down: `
+			DROP TABLE IF EXISTS user_role;
+		`,
+	})
+}
@@ -0,0 +1,30 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 4,
+		Name:    "quest_authoring",
+		Up: `
+			ALTER TABLE quest
+				ADD COLUMN author_id  INT REFERENCES users(id) ON DELETE SET NULL,
+				ADD COLUMN version    INT NOT NULL DEFAULT 1,
+				ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				ADD COLUMN deleted_at TIMESTAMPTZ,
+				ALTER COLUMN rating SET DEFAULT 0,
+				ALTER COLUMN mark_count SET DEFAULT 0;
+
+			CREATE INDEX ix_quest_author_id ON quest (author_id);
+		`,
+		Down: `
+			ALTER TABLE quest
+				DROP COLUMN IF EXISTS author_id,
+				DROP COLUMN IF EXISTS version,
+				DROP COLUMN IF EXISTS created_at,
+				DROP COLUMN IF EXISTS updated_at,
+				DROP COLUMN IF EXISTS deleted_at,
+				ALTER COLUMN rating DROP DEFAULT,
+				ALTER COLUMN mark_count DROP DEFAULT;
+		`,
+	})
+}
@@ -0,0 +1,20 @@
+package migrations
+
+func init() {
+	register(Migration{
+		Version: 5,
+		Name:    "quest_archive",
+		Up: `
+			ALTER TABLE quest
+				ADD COLUMN archive_size        BIGINT,
+				ADD COLUMN archive_sha256      CHAR(64),
+				ADD COLUMN archive_uploaded_at TIMESTAMPTZ;
+		`,
+		Down: `
+			ALTER TABLE quest
+				DROP COLUMN IF EXISTS archive_size,
+				DROP COLUMN IF EXISTS archive_sha256,
+				DROP COLUMN IF EXISTS archive_uploaded_at;
+		`,
+	})
+}
@@ -0,0 +1,51 @@
+package migrations
+
+// Migration 6 turns the single quest archive into immutable versions. An archive uploaded
+// before versioning becomes version 1 without a file list, stored under the quest id.
+func init() {
+	register(Migration{
+		Version: 6,
+		Name:    "quest_versions",
+		Up: `
+			CREATE TABLE quest_version (
+				quest_id       INT NOT NULL REFERENCES quest(id) ON DELETE CASCADE,
+				version        INT NOT NULL CHECK (version > 0),
+				changelog      TEXT NOT NULL DEFAULT '',
+				archive_key    TEXT NOT NULL,
+				archive_size   BIGINT NOT NULL,
+				archive_sha256 CHAR(64) NOT NULL,
+				author_id      INT REFERENCES users(id) ON DELETE SET NULL,
+				created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+				PRIMARY KEY (quest_id, version)
+			);
+
+			CREATE TABLE quest_version_file (
+				quest_id INT NOT NULL,
+				version  INT NOT NULL,
+				path     TEXT NOT NULL,
+				size     BIGINT NOT NULL,
+				sha256   CHAR(64) NOT NULL,
+				PRIMARY KEY (quest_id, version, path),
+				FOREIGN KEY (quest_id, version) REFERENCES quest_version (quest_id, version) ON DELETE CASCADE
+			);
+
+			CREATE INDEX ix_quest_version_file_sha256 ON quest_version_file (quest_id, sha256);
+
+			ALTER TABLE quest
+				ADD COLUMN published_version INT,
+				ADD CONSTRAINT fk_quest_published_version
+					FOREIGN KEY (id, published_version) REFERENCES quest_version (quest_id, version);
+
+			INSERT INTO quest_version (quest_id, version, archive_key, archive_size, archive_sha256, author_id, created_at)
+				SELECT id, 1, id::TEXT, archive_size, archive_sha256, author_id, COALESCE(archive_uploaded_at, now())
+				FROM quest WHERE archive_sha256 IS NOT NULL;
+
+			UPDATE quest SET published_version = 1 WHERE archive_sha256 IS NOT NULL;
+		`,
+		Down: `
+			ALTER TABLE quest DROP COLUMN IF EXISTS published_version;
+			DROP TABLE IF EXISTS quest_version_file;
+			DROP TABLE IF EXISTS quest_version;
+		`,
+	})
+}
@@ -0,0 +1,56 @@
+package migrations
+
+// Migration 7 adds the start point and the optional area of a quest. The spatial index
+// is created with PostGIS when the extension is available; without it nearby search
+// falls back to the in-memory index and the plain index below only speeds up loading it.
+func init() {
+	register(Migration{
+		Version: 7,
+		Name:    "quest_location",
+		Up: `
+			ALTER TABLE quest
+				ADD COLUMN start_lat    DOUBLE PRECISION CHECK (start_lat BETWEEN -90 AND 90),
+				ADD COLUMN start_lon    DOUBLE PRECISION CHECK (start_lon BETWEEN -180 AND 180),
+				ADD COLUMN area_min_lat DOUBLE PRECISION,
+				ADD COLUMN area_min_lon DOUBLE PRECISION,
+				ADD COLUMN area_max_lat DOUBLE PRECISION,
+				ADD COLUMN area_max_lon DOUBLE PRECISION,
+				ADD CONSTRAINT ck_quest_start CHECK ((start_lat IS NULL) = (start_lon IS NULL)),
+				ADD CONSTRAINT ck_quest_area CHECK (
+					(area_min_lat IS NULL AND area_min_lon IS NULL AND area_max_lat IS NULL AND area_max_lon IS NULL) OR
+					(start_lat IS NOT NULL AND area_min_lat <= area_max_lat AND area_min_lon <= area_max_lon)
+				);
+
+			CREATE INDEX ix_quest_start ON quest (start_lat, start_lon) WHERE start_lat IS NOT NULL;
+
+			DO $$
+			BEGIN
+				IF EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'postgis') THEN
+					BEGIN
+						CREATE EXTENSION IF NOT EXISTS postgis;
+					EXCEPTION WHEN insufficient_privilege THEN
+						RAISE NOTICE 'no privilege to create postgis extension, nearby search will use the in-memory index';
+					END;
+				END IF;
+				IF EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'postgis') THEN
+					EXECUTE 'CREATE INDEX ix_quest_start_geog ON quest USING GIST ' ||
+						'((ST_SetSRID(ST_MakePoint(start_lon, start_lat), 4326)::geography)) ' ||
+						'WHERE start_lat IS NOT NULL';
+				END IF;
+			END
+			$$;
+		`,
+		Down: `
+			DROP INDEX IF EXISTS ix_quest_start_geog;
+			ALTER TABLE quest
+				DROP CONSTRAINT IF EXISTS ck_quest_area,
+				DROP CONSTRAINT IF EXISTS ck_quest_start,
+				DROP COLUMN IF EXISTS start_lat,
+				DROP COLUMN IF EXISTS start_lon,
+				DROP COLUMN IF EXISTS area_min_lat,
+				DROP COLUMN IF EXISTS area_min_lon,
+				DROP COLUMN IF EXISTS area_max_lat,
+				DROP COLUMN IF EXISTS area_max_lon;
+		`,
+	})
+}
@@ -0,0 +1,42 @@
+package migrations
+
+// Migration 8 adds ordered quest checkpoints and the checkpoints reached by each user.
+// Positions are unique per quest only at commit so that authors can reorder checkpoints
+// in a single transaction.
+func init() {
+	register(Migration{
+		Version: 8,
+		Name:    "checkpoints",
+		Up: `
+			CREATE TABLE quest_checkpoint (
+				id          SERIAL PRIMARY KEY,
+				quest_id    INT NOT NULL REFERENCES quest(id) ON DELETE CASCADE,
+				position    INT NOT NULL,
+				name        VARCHAR(100) NOT NULL,
+				description VARCHAR(1000) NOT NULL DEFAULT '',
+				type        VARCHAR(20) NOT NULL CHECK (type IN ('gps', 'ar_marker', 'qr', 'answer')),
+				optional    BOOLEAN NOT NULL DEFAULT FALSE,
+				lat         DOUBLE PRECISION CHECK (lat BETWEEN -90 AND 90),
+				lon         DOUBLE PRECISION CHECK (lon BETWEEN -180 AND 180),
+				radius      DOUBLE PRECISION CHECK (radius > 0),
+				marker_id   VARCHAR(100),
+				CONSTRAINT ux_quest_checkpoint_position UNIQUE (quest_id, position) DEFERRABLE INITIALLY DEFERRED,
+				CONSTRAINT ck_quest_checkpoint_gps CHECK (type <> 'gps' OR (lat IS NOT NULL AND lon IS NOT NULL AND radius IS NOT NULL))
+			);
+
+			CREATE TABLE checkpoint_progress (
+				user_id       INT NOT NULL REFERENCES users(id),
+				checkpoint_id INT NOT NULL REFERENCES quest_checkpoint(id) ON DELETE CASCADE,
+				quest_id      INT NOT NULL REFERENCES quest(id) ON DELETE CASCADE,
+				reached_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+				PRIMARY KEY (user_id, checkpoint_id)
+			);
+
+			CREATE INDEX ix_checkpoint_progress_user_quest ON checkpoint_progress (user_id, quest_id);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS checkpoint_progress;
+			DROP TABLE IF EXISTS quest_checkpoint;
+		`,
+	})
+}
@@ -0,0 +1,49 @@
+package migrations
+
+// Migration 9 keeps what the server needs to verify checkpoint proofs: QR signing
+// secrets, salted answer hashes and the GPS fix accepted at each reached checkpoint.
+// QR checkpoints created before it get their secret when the author saves the
+// checkpoint list again. Rejected proofs are kept in checkpoint_failure for moderators.
+func init() {
+	register(Migration{
+		Version: 9,
+		Name:    "checkpoint_proofs",
+		Up: `
+			ALTER TABLE quest_checkpoint
+				ADD COLUMN qr_secret     BYTEA,
+				ADD COLUMN answer_salt   BYTEA,
+				ADD COLUMN answer_hashes BYTEA[];
+
+			ALTER TABLE checkpoint_progress
+				ADD COLUMN fix_lat      DOUBLE PRECISION,
+				ADD COLUMN fix_lon      DOUBLE PRECISION,
+				ADD COLUMN fix_accuracy DOUBLE PRECISION,
+				ADD COLUMN fix_time     TIMESTAMPTZ;
+
+			CREATE TABLE checkpoint_failure (
+				id            SERIAL PRIMARY KEY,
+				user_id       INT NOT NULL REFERENCES users(id),
+				quest_id      INT NOT NULL REFERENCES quest(id) ON DELETE CASCADE,
+				checkpoint_id INT REFERENCES quest_checkpoint(id) ON DELETE SET NULL,
+				reason        VARCHAR(40) NOT NULL,
+				details       VARCHAR(1000) NOT NULL DEFAULT '',
+				created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+			);
+
+			CREATE INDEX ix_checkpoint_failure_quest ON checkpoint_failure (quest_id, id);
+			CREATE INDEX ix_checkpoint_failure_user ON checkpoint_failure (user_id, id);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS checkpoint_failure;
+			ALTER TABLE checkpoint_progress
+				DROP COLUMN IF EXISTS fix_lat,
+				DROP COLUMN IF EXISTS fix_lon,
+				DROP COLUMN IF EXISTS fix_accuracy,
+				DROP COLUMN IF EXISTS fix_time;
+			ALTER TABLE quest_checkpoint
+				DROP COLUMN IF EXISTS qr_secret,
+				DROP COLUMN IF EXISTS answer_salt,
+				DROP COLUMN IF EXISTS answer_hashes;
+		`,
+	})
+}
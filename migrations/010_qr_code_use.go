@@ -0,0 +1,21 @@
+package migrations
+
+// Migration 10 records the use of one-time QR codes. A code is identified by the nonce
+// signed into it and may only be used by one player.
+func init() {
+	register(Migration{
+		Version: 10,
+		Name:    "qr_code_use",
+		Up: `
+			CREATE TABLE qr_code_use (
+				nonce         VARCHAR(64) PRIMARY KEY,
+				checkpoint_id INT NOT NULL REFERENCES quest_checkpoint(id) ON DELETE CASCADE,
+				user_id       INT NOT NULL REFERENCES users(id),
+				used_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS qr_code_use;
+		`,
+	})
+}
@@ -0,0 +1,34 @@
+package migrations
+
+// Migration 11 stores quest scenarios and the position of every player in them. The state
+// version is used for optimistic locking of concurrent transitions.
+func init() {
+	register(Migration{
+		Version: 11,
+		Name:    "scenarios",
+		Up: `
+			CREATE TABLE quest_scenario (
+				quest_id   INT PRIMARY KEY REFERENCES quest(id) ON DELETE CASCADE,
+				format     VARCHAR(10) NOT NULL CHECK (format IN ('yaml', 'json')),
+				source     TEXT NOT NULL,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			);
+
+			CREATE TABLE scenario_state (
+				user_id    INT NOT NULL REFERENCES users(id),
+				quest_id   INT NOT NULL REFERENCES quest(id) ON DELETE CASCADE,
+				state      VARCHAR(64) NOT NULL,
+				items      TEXT[] NOT NULL DEFAULT '{}',
+				timers     JSONB NOT NULL DEFAULT '{}',
+				final      BOOLEAN NOT NULL DEFAULT FALSE,
+				version    INT NOT NULL,
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				PRIMARY KEY (user_id, quest_id)
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS scenario_state;
+			DROP TABLE IF EXISTS quest_scenario;
+		`,
+	})
+}
@@ -0,0 +1,36 @@
+package migrations
+
+// Migration 12 adds hint tiers of checkpoints and the hints taken by players. Taken hints
+// keep their penalty when the author changes or removes the checkpoint.
+func init() {
+	register(Migration{
+		Version: 12,
+		Name:    "hints",
+		Up: `
+			CREATE TABLE checkpoint_hint (
+				checkpoint_id INT NOT NULL REFERENCES quest_checkpoint(id) ON DELETE CASCADE,
+				tier          INT NOT NULL CHECK (tier > 0),
+				text          VARCHAR(1000) NOT NULL,
+				penalty       INT NOT NULL CHECK (penalty >= 0),
+				PRIMARY KEY (checkpoint_id, tier)
+			);
+
+			CREATE TABLE hint_usage (
+				id            SERIAL PRIMARY KEY,
+				user_id       INT NOT NULL REFERENCES users(id),
+				quest_id      INT NOT NULL REFERENCES quest(id) ON DELETE CASCADE,
+				checkpoint_id INT REFERENCES quest_checkpoint(id) ON DELETE SET NULL,
+				tier          INT NOT NULL,
+				penalty       INT NOT NULL,
+				used_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+				CONSTRAINT ux_hint_usage UNIQUE (user_id, checkpoint_id, tier)
+			);
+
+			CREATE INDEX ix_hint_usage_user_quest ON hint_usage (user_id, quest_id);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS hint_usage;
+			DROP TABLE IF EXISTS checkpoint_hint;
+		`,
+	})
+}
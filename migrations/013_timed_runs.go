@@ -0,0 +1,50 @@
+package migrations
+
+// Migration 13 records when players start and finish quests and how their runs are scored,
+// and adds the friends of users for friends-only leaderboards.
+func init() {
+	register(Migration{
+		Version: 13,
+		Name:    "timed_runs",
+		Up: `
+			ALTER TABLE quest_user_link
+				ADD COLUMN started_at       TIMESTAMPTZ,
+				ADD COLUMN finished_at      TIMESTAMPTZ,
+				ADD COLUMN elapsed_s        INT,
+				ADD COLUMN hints_used       INT NOT NULL DEFAULT 0,
+				ADD COLUMN hint_penalty     INT NOT NULL DEFAULT 0,
+				ADD COLUMN optional_reached INT NOT NULL DEFAULT 0,
+				ADD COLUMN score            INT;
+
+			UPDATE quest_user_link AS l SET hints_used = h.used, hint_penalty = h.penalty
+			FROM (
+				SELECT user_id, quest_id, count(*) AS used, sum(penalty) AS penalty FROM hint_usage
+				GROUP BY user_id, quest_id
+			) AS h
+			WHERE h.user_id = l.user_id AND h.quest_id = l.quest_id AND l.completed;
+
+			CREATE INDEX ix_quest_user_link_leaderboard ON quest_user_link (quest_id, finished_at)
+				WHERE completed AND elapsed_s IS NOT NULL;
+
+			CREATE TABLE user_friend (
+				user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				friend_id  INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				PRIMARY KEY (user_id, friend_id),
+				CHECK (user_id <> friend_id)
+			);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS user_friend;
+			DROP INDEX IF EXISTS ix_quest_user_link_leaderboard;
+			ALTER TABLE quest_user_link
+				DROP COLUMN IF EXISTS started_at,
+				DROP COLUMN IF EXISTS finished_at,
+				DROP COLUMN IF EXISTS elapsed_s,
+				DROP COLUMN IF EXISTS hints_used,
+				DROP COLUMN IF EXISTS hint_penalty,
+				DROP COLUMN IF EXISTS optional_reached,
+				DROP COLUMN IF EXISTS score;
+		`,
+	})
+}
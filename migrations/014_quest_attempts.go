@@ -0,0 +1,120 @@
+package migrations
+
+// Migration 14 lets players replay quests: every pass is an attempt with its own progress,
+// hints, scenario state and score. Existing progress becomes the first attempt of each
+// player; quest_user_link keeps only whether the quest was ever completed and its mark.
+func init() {
+	register(Migration{
+		Version: 14,
+		Name:    "quest_attempts",
+		Up: `
+			CREATE TABLE quest_attempt (
+				id               SERIAL PRIMARY KEY,
+				user_id          INT NOT NULL REFERENCES users(id),
+				quest_id         INT NOT NULL REFERENCES quest(id) ON DELETE CASCADE,
+				outcome          VARCHAR(20) NOT NULL DEFAULT 'active',
+				started_at       TIMESTAMPTZ DEFAULT now(),
+				ended_at         TIMESTAMPTZ,
+				elapsed_s        INT,
+				hints_used       INT NOT NULL DEFAULT 0,
+				hint_penalty     INT NOT NULL DEFAULT 0,
+				optional_reached INT NOT NULL DEFAULT 0,
+				score            INT,
+				CHECK (outcome IN ('active', 'finished', 'abandoned'))
+			);
+
+			CREATE UNIQUE INDEX ux_quest_attempt_active ON quest_attempt (user_id, quest_id) WHERE outcome = 'active';
+			CREATE INDEX ix_quest_attempt_user_quest ON quest_attempt (user_id, quest_id, id);
+			CREATE INDEX ix_quest_attempt_leaderboard ON quest_attempt (quest_id, ended_at)
+				WHERE outcome = 'finished' AND elapsed_s IS NOT NULL;
+
+			INSERT INTO quest_attempt (
+				user_id, quest_id, outcome, started_at, ended_at, elapsed_s, hints_used, hint_penalty, optional_reached, score
+			)
+			SELECT
+				p.user_id, p.quest_id, CASE WHEN l.completed THEN 'finished' ELSE 'active' END,
+				l.started_at, CASE WHEN l.completed THEN COALESCE(l.finished_at, now()) END, l.elapsed_s,
+				COALESCE(l.hints_used, 0), COALESCE(l.hint_penalty, 0), COALESCE(l.optional_reached, 0), l.score
+			FROM (
+				SELECT user_id, quest_id FROM quest_user_link WHERE user_id IS NOT NULL AND quest_id IS NOT NULL
+				UNION SELECT user_id, quest_id FROM checkpoint_progress
+				UNION SELECT user_id, quest_id FROM hint_usage
+				UNION SELECT user_id, quest_id FROM scenario_state
+			) AS p
+			LEFT JOIN quest_user_link AS l ON l.user_id = p.user_id AND l.quest_id = p.quest_id;
+
+			INSERT INTO quest_user_link (user_id, quest_id)
+			SELECT user_id, quest_id FROM quest_attempt
+			ON CONFLICT ON CONSTRAINT ux_user_id_quest_id DO NOTHING;
+
+			ALTER TABLE checkpoint_progress ADD COLUMN attempt_id INT REFERENCES quest_attempt(id) ON DELETE CASCADE;
+			UPDATE checkpoint_progress AS p SET attempt_id = a.id
+			FROM quest_attempt AS a WHERE a.user_id = p.user_id AND a.quest_id = p.quest_id;
+			ALTER TABLE checkpoint_progress
+				ALTER COLUMN attempt_id SET NOT NULL,
+				DROP CONSTRAINT checkpoint_progress_pkey,
+				ADD PRIMARY KEY (attempt_id, checkpoint_id);
+
+			ALTER TABLE hint_usage ADD COLUMN attempt_id INT REFERENCES quest_attempt(id) ON DELETE CASCADE;
+			UPDATE hint_usage AS h SET attempt_id = a.id
+			FROM quest_attempt AS a WHERE a.user_id = h.user_id AND a.quest_id = h.quest_id;
+			ALTER TABLE hint_usage
+				ALTER COLUMN attempt_id SET NOT NULL,
+				DROP CONSTRAINT ux_hint_usage,
+				ADD CONSTRAINT ux_hint_usage UNIQUE (attempt_id, checkpoint_id, tier);
+
+			ALTER TABLE scenario_state ADD COLUMN attempt_id INT REFERENCES quest_attempt(id) ON DELETE CASCADE;
+			UPDATE scenario_state AS s SET attempt_id = a.id
+			FROM quest_attempt AS a WHERE a.user_id = s.user_id AND a.quest_id = s.quest_id;
+			ALTER TABLE scenario_state
+				ALTER COLUMN attempt_id SET NOT NULL,
+				DROP CONSTRAINT scenario_state_pkey,
+				ADD PRIMARY KEY (attempt_id);
+
+			DROP INDEX ix_quest_user_link_leaderboard;
+			ALTER TABLE quest_user_link
+				DROP COLUMN started_at,
+				DROP COLUMN finished_at,
+				DROP COLUMN elapsed_s,
+				DROP COLUMN hints_used,
+				DROP COLUMN hint_penalty,
+				DROP COLUMN optional_reached,
+				DROP COLUMN score;
+		`,
+		// only the latest attempt of every player is kept
+		Down: `
+			ALTER TABLE quest_user_link
+				ADD COLUMN started_at       TIMESTAMPTZ,
+				ADD COLUMN finished_at      TIMESTAMPTZ,
+				ADD COLUMN elapsed_s        INT,
+				ADD COLUMN hints_used       INT NOT NULL DEFAULT 0,
+				ADD COLUMN hint_penalty     INT NOT NULL DEFAULT 0,
+				ADD COLUMN optional_reached INT NOT NULL DEFAULT 0,
+				ADD COLUMN score            INT;
+
+			DELETE FROM quest_attempt AS a WHERE EXISTS (
+				SELECT 1 FROM quest_attempt AS b WHERE b.user_id = a.user_id AND b.quest_id = a.quest_id AND b.id > a.id
+			);
+			UPDATE quest_user_link AS l SET
+				started_at = a.started_at, finished_at = a.ended_at, elapsed_s = a.elapsed_s, hints_used = a.hints_used,
+				hint_penalty = a.hint_penalty, optional_reached = a.optional_reached, score = a.score
+			FROM quest_attempt AS a
+			WHERE a.user_id = l.user_id AND a.quest_id = l.quest_id AND a.outcome = 'finished';
+			CREATE INDEX ix_quest_user_link_leaderboard ON quest_user_link (quest_id, finished_at)
+				WHERE completed AND elapsed_s IS NOT NULL;
+
+			ALTER TABLE scenario_state DROP CONSTRAINT scenario_state_pkey, ADD PRIMARY KEY (user_id, quest_id);
+			ALTER TABLE scenario_state DROP COLUMN attempt_id;
+			ALTER TABLE hint_usage
+				DROP CONSTRAINT ux_hint_usage,
+				ADD CONSTRAINT ux_hint_usage UNIQUE (user_id, checkpoint_id, tier);
+			ALTER TABLE hint_usage DROP COLUMN attempt_id;
+			ALTER TABLE checkpoint_progress
+				DROP CONSTRAINT checkpoint_progress_pkey,
+				ADD PRIMARY KEY (user_id, checkpoint_id);
+			ALTER TABLE checkpoint_progress DROP COLUMN attempt_id;
+
+			DROP TABLE IF EXISTS quest_attempt;
+		`,
+	})
+}
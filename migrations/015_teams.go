@@ -0,0 +1,64 @@
+package migrations
+
+// Migration 15 adds teams of players. Quests allowing team play bound the team size; a team
+// attempt is shared by the members of the team when it was started.
+func init() {
+	register(Migration{
+		Version: 15,
+		Name:    "teams",
+		Up: `
+			CREATE TABLE team (
+				id          SERIAL PRIMARY KEY,
+				name        VARCHAR(50) NOT NULL,
+				invite_code VARCHAR(16) NOT NULL UNIQUE,
+				created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+			);
+
+			CREATE TABLE team_member (
+				team_id   INT NOT NULL REFERENCES team(id),
+				user_id   INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				joined_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				PRIMARY KEY (team_id, user_id)
+			);
+			CREATE INDEX ix_team_member_user ON team_member (user_id);
+
+			ALTER TABLE quest
+				ADD COLUMN min_team_size INT,
+				ADD COLUMN max_team_size INT,
+				ADD CONSTRAINT quest_team_size_check CHECK (
+					(min_team_size IS NULL) = (max_team_size IS NULL) AND 1 <= min_team_size AND min_team_size <= max_team_size
+				);
+
+			ALTER TABLE quest_attempt ADD COLUMN team_id INT REFERENCES team(id);
+			DROP INDEX ux_quest_attempt_active;
+			CREATE UNIQUE INDEX ux_quest_attempt_active ON quest_attempt (user_id, quest_id)
+				WHERE outcome = 'active' AND team_id IS NULL;
+			CREATE UNIQUE INDEX ux_quest_attempt_team_active ON quest_attempt (team_id, quest_id)
+				WHERE outcome = 'active' AND team_id IS NOT NULL;
+
+			CREATE TABLE quest_attempt_member (
+				attempt_id INT NOT NULL REFERENCES quest_attempt(id) ON DELETE CASCADE,
+				user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				PRIMARY KEY (attempt_id, user_id)
+			);
+			CREATE INDEX ix_quest_attempt_member_user ON quest_attempt_member (user_id, attempt_id);
+		`,
+		// team attempts are dropped together with their progress
+		Down: `
+			DROP TABLE IF EXISTS quest_attempt_member;
+			DELETE FROM quest_attempt WHERE team_id IS NOT NULL;
+			DROP INDEX IF EXISTS ux_quest_attempt_team_active;
+			DROP INDEX IF EXISTS ux_quest_attempt_active;
+			CREATE UNIQUE INDEX ux_quest_attempt_active ON quest_attempt (user_id, quest_id) WHERE outcome = 'active';
+			ALTER TABLE quest_attempt DROP COLUMN IF EXISTS team_id;
+
+			ALTER TABLE quest
+				DROP CONSTRAINT IF EXISTS quest_team_size_check,
+				DROP COLUMN IF EXISTS min_team_size,
+				DROP COLUMN IF EXISTS max_team_size;
+
+			DROP TABLE IF EXISTS team_member;
+			DROP TABLE IF EXISTS team;
+		`,
+	})
+}
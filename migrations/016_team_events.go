@@ -0,0 +1,79 @@
+package migrations
+
+// Migration 16 records the events of teams for their live streams. Events are written by
+// triggers in the transaction of the change itself, so every path that reaches a checkpoint,
+// uses a hint, ends an attempt or changes the members is covered. user_id is not a foreign
+// key: member_left is also written while a user is being deleted.
+func init() {
+	register(Migration{
+		Version: 16,
+		Name:    "team_events",
+		Up: `
+			CREATE TABLE team_event (
+				id            BIGSERIAL PRIMARY KEY,
+				team_id       INT NOT NULL REFERENCES team(id),
+				attempt_id    INT REFERENCES quest_attempt(id) ON DELETE CASCADE,
+				type          VARCHAR(30) NOT NULL,
+				user_id       INT,
+				checkpoint_id INT REFERENCES quest_checkpoint(id) ON DELETE SET NULL,
+				created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+			);
+			CREATE INDEX ix_team_event_team ON team_event (team_id, id);
+
+			CREATE FUNCTION team_attempt_event() RETURNS TRIGGER AS $$
+			BEGIN
+				IF NEW.team_id IS NULL THEN
+					RETURN NULL;
+				END IF;
+				IF TG_OP = 'INSERT' THEN
+					INSERT INTO team_event (team_id, attempt_id, type, user_id)
+					VALUES (NEW.team_id, NEW.id, 'attempt_started', NEW.user_id);
+				ELSIF NEW.outcome <> OLD.outcome THEN
+					INSERT INTO team_event (team_id, attempt_id, type)
+					VALUES (NEW.team_id, NEW.id, 'attempt_' || NEW.outcome);
+				END IF;
+				RETURN NULL;
+			END;
+			$$ LANGUAGE plpgsql;
+
+			CREATE FUNCTION team_progress_event() RETURNS TRIGGER AS $$
+			BEGIN
+				INSERT INTO team_event (team_id, attempt_id, type, user_id, checkpoint_id)
+				SELECT team_id, id, TG_ARGV[0], NEW.user_id, NEW.checkpoint_id
+				FROM quest_attempt WHERE id = NEW.attempt_id AND team_id IS NOT NULL;
+				RETURN NULL;
+			END;
+			$$ LANGUAGE plpgsql;
+
+			CREATE FUNCTION team_member_event() RETURNS TRIGGER AS $$
+			BEGIN
+				IF TG_OP = 'INSERT' THEN
+					INSERT INTO team_event (team_id, type, user_id) VALUES (NEW.team_id, 'member_joined', NEW.user_id);
+				ELSE
+					INSERT INTO team_event (team_id, type, user_id) VALUES (OLD.team_id, 'member_left', OLD.user_id);
+				END IF;
+				RETURN NULL;
+			END;
+			$$ LANGUAGE plpgsql;
+
+			CREATE TRIGGER tr_quest_attempt_team_event AFTER INSERT OR UPDATE OF outcome ON quest_attempt
+				FOR EACH ROW EXECUTE PROCEDURE team_attempt_event();
+			CREATE TRIGGER tr_checkpoint_progress_team_event AFTER INSERT ON checkpoint_progress
+				FOR EACH ROW EXECUTE PROCEDURE team_progress_event('checkpoint_reached');
+			CREATE TRIGGER tr_hint_usage_team_event AFTER INSERT ON hint_usage
+				FOR EACH ROW EXECUTE PROCEDURE team_progress_event('hint_used');
+			CREATE TRIGGER tr_team_member_event AFTER INSERT OR DELETE ON team_member
+				FOR EACH ROW EXECUTE PROCEDURE team_member_event();
+		`,
+		Down: `
+			DROP TRIGGER IF EXISTS tr_team_member_event ON team_member;
+			DROP TRIGGER IF EXISTS tr_hint_usage_team_event ON hint_usage;
+			DROP TRIGGER IF EXISTS tr_checkpoint_progress_team_event ON checkpoint_progress;
+			DROP TRIGGER IF EXISTS tr_quest_attempt_team_event ON quest_attempt;
+			DROP FUNCTION IF EXISTS team_member_event();
+			DROP FUNCTION IF EXISTS team_progress_event();
+			DROP FUNCTION IF EXISTS team_attempt_event();
+			DROP TABLE IF EXISTS team_event;
+		`,
+	})
+}
@@ -0,0 +1,37 @@
+package migrations
+
+// Migration 17 keeps the sum of the marks of every quest so that its rating is updated by the
+// change of a single mark. Ratings are recomputed from the marked links only; weighted ratings
+// are applied by `rating recompute`.
+func init() {
+	register(Migration{
+		Version: 17,
+		Name:    "quest_rating",
+		Up: `
+			ALTER TABLE quest ADD COLUMN mark_sum FLOAT NOT NULL DEFAULT 0;
+
+			WITH marks AS (
+				SELECT quest_id, sum(mark) AS mark_sum, count(*) AS mark_count
+				FROM quest_user_link WHERE marked GROUP BY quest_id
+			)
+			UPDATE quest AS q SET
+				mark_sum = COALESCE(m.mark_sum, 0),
+				mark_count = COALESCE(m.mark_count, 0),
+				rating = COALESCE(m.mark_sum / m.mark_count, 0)
+			FROM quest AS a LEFT JOIN marks AS m ON m.quest_id = a.id
+			WHERE q.id = a.id;
+
+			ALTER TABLE quest
+				ALTER COLUMN rating SET NOT NULL,
+				ALTER COLUMN mark_count SET NOT NULL;
+			CREATE INDEX ix_quest_user_link_marked ON quest_user_link (quest_id) WHERE marked;
+		`,
+		Down: `
+			DROP INDEX IF EXISTS ix_quest_user_link_marked;
+			ALTER TABLE quest
+				ALTER COLUMN rating DROP NOT NULL,
+				ALTER COLUMN mark_count DROP NOT NULL,
+				DROP COLUMN IF EXISTS mark_sum;
+		`,
+	})
+}
@@ -0,0 +1,63 @@
+package migrations
+
+// Migration 18 adds written reviews of quests with photos, helpful votes and reply threads.
+// Helpful votes are counted when reviews are read, so deleted users leave no stale counts.
+func init() {
+	register(Migration{
+		Version: 18,
+		Name:    "reviews",
+		Up: `
+			CREATE TABLE photo (
+				id           SERIAL PRIMARY KEY,
+				user_id      INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				sha256       CHAR(64) NOT NULL,
+				content_type VARCHAR(32) NOT NULL,
+				size         BIGINT NOT NULL,
+				created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+			);
+
+			CREATE TABLE review (
+				id         SERIAL PRIMARY KEY,
+				quest_id   INT NOT NULL REFERENCES quest(id) ON DELETE CASCADE,
+				user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				text       TEXT NOT NULL,
+				language   VARCHAR(16),
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				CONSTRAINT ux_review_quest_user UNIQUE (quest_id, user_id)
+			);
+			CREATE INDEX ix_review_quest ON review (quest_id, created_at);
+
+			CREATE TABLE review_photo (
+				review_id INT NOT NULL REFERENCES review(id) ON DELETE CASCADE,
+				photo_id  INT NOT NULL REFERENCES photo(id) ON DELETE CASCADE,
+				position  INT NOT NULL,
+				PRIMARY KEY (review_id, photo_id)
+			);
+
+			CREATE TABLE review_vote (
+				review_id  INT NOT NULL REFERENCES review(id) ON DELETE CASCADE,
+				user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				PRIMARY KEY (review_id, user_id)
+			);
+
+			CREATE TABLE review_reply (
+				id         SERIAL PRIMARY KEY,
+				review_id  INT NOT NULL REFERENCES review(id) ON DELETE CASCADE,
+				user_id    INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				text       TEXT NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			);
+			CREATE INDEX ix_review_reply_review ON review_reply (review_id, id);
+		`,
+		// photo objects stay in the storage
+		Down: `
+			DROP TABLE IF EXISTS review_reply;
+			DROP TABLE IF EXISTS review_vote;
+			DROP TABLE IF EXISTS review_photo;
+			DROP TABLE IF EXISTS review;
+			DROP TABLE IF EXISTS photo;
+		`,
+	})
+}
@@ -0,0 +1,66 @@
+package migrations
+
+// Migration 19 adds the moderation queue, user reports, bans and the audit trail of moderation
+// decisions. Reviews and replies held by the filter or rejected by a moderator are hidden.
+// Queue items and audit records outlive the content and the users they refer to.
+func init() {
+	register(Migration{
+		Version: 19,
+		Name:    "moderation",
+		Up: `
+			ALTER TABLE review ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;
+			ALTER TABLE review_reply ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+			CREATE TABLE moderation_item (
+				id           SERIAL PRIMARY KEY,
+				content_type VARCHAR(16) NOT NULL,
+				content_id   INT NOT NULL,
+				author_id    INT REFERENCES users(id) ON DELETE SET NULL,
+				text         TEXT NOT NULL,
+				flags        TEXT[] NOT NULL DEFAULT '{}',
+				report_count INT NOT NULL DEFAULT 0,
+				status       VARCHAR(16) NOT NULL DEFAULT 'pending',
+				created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+				updated_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+				CONSTRAINT ux_moderation_item_content UNIQUE (content_type, content_id)
+			);
+			CREATE INDEX ix_moderation_item_status ON moderation_item (status, id);
+
+			CREATE TABLE content_report (
+				content_type VARCHAR(16) NOT NULL,
+				content_id   INT NOT NULL,
+				reporter_id  INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				reason       TEXT NOT NULL DEFAULT '',
+				created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+				PRIMARY KEY (content_type, content_id, reporter_id)
+			);
+
+			CREATE TABLE user_ban (
+				user_id      INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+				moderator_id INT REFERENCES users(id) ON DELETE SET NULL,
+				reason       TEXT NOT NULL DEFAULT '',
+				created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+			);
+
+			CREATE TABLE moderation_action (
+				id           SERIAL PRIMARY KEY,
+				item_id      INT REFERENCES moderation_item(id) ON DELETE SET NULL,
+				user_id      INT REFERENCES users(id) ON DELETE SET NULL,
+				moderator_id INT REFERENCES users(id) ON DELETE SET NULL,
+				action       VARCHAR(16) NOT NULL,
+				reason       TEXT NOT NULL DEFAULT '',
+				created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+			);
+			CREATE INDEX ix_moderation_action_item ON moderation_action (item_id);
+			CREATE INDEX ix_moderation_action_user ON moderation_action (user_id);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS moderation_action;
+			DROP TABLE IF EXISTS user_ban;
+			DROP TABLE IF EXISTS content_report;
+			DROP TABLE IF EXISTS moderation_item;
+			ALTER TABLE review_reply DROP COLUMN IF EXISTS hidden;
+			ALTER TABLE review DROP COLUMN IF EXISTS hidden;
+		`,
+	})
+}
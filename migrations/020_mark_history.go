@@ -0,0 +1,27 @@
+package migrations
+
+// Migration 20 keeps every change of the marks of quests. The current marks become the first
+// records of the history; the time they were given is unknown, so they are dated by the migration.
+func init() {
+	register(Migration{
+		Version: 20,
+		Name:    "mark_history",
+		Up: `
+			CREATE TABLE mark_history (
+				id            SERIAL PRIMARY KEY,
+				user_id       INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+				quest_id      INT NOT NULL REFERENCES quest(id) ON DELETE CASCADE,
+				mark          FLOAT NOT NULL,
+				previous_mark FLOAT,
+				created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+			);
+			CREATE INDEX ix_mark_history_user ON mark_history (user_id, quest_id, id);
+
+			INSERT INTO mark_history (user_id, quest_id, mark)
+			SELECT user_id, quest_id, mark FROM quest_user_link WHERE marked ORDER BY id;
+		`,
+		Down: `
+			DROP TABLE IF EXISTS mark_history;
+		`,
+	})
+}
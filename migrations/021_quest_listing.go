@@ -0,0 +1,36 @@
+package migrations
+
+// Migration 21 adds the fields quest listings are filtered by and the indexes of the listing:
+// tags, full-text search over name and description with both Russian and English stemming,
+// and players of a quest counted for sorting by popularity. The search expression must match
+// questSearchVector of the quest DAO for the index to be used.
+func init() {
+	register(Migration{
+		Version: 21,
+		Name:    "quest_listing",
+		Up: `
+			ALTER TABLE quest
+				ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}',
+				ADD COLUMN difficulty VARCHAR(16) CHECK (difficulty IN ('easy', 'medium', 'hard')),
+				ADD COLUMN duration_minutes INT CHECK (duration_minutes > 0);
+
+			CREATE INDEX ix_quest_tags ON quest USING GIN (tags);
+			CREATE INDEX ix_quest_search ON quest USING GIN ((
+				setweight(to_tsvector('russian', COALESCE(name, '')), 'A') ||
+				setweight(to_tsvector('russian', COALESCE(description, '')), 'B') ||
+				setweight(to_tsvector('english', COALESCE(name, '')), 'A') ||
+				setweight(to_tsvector('english', COALESCE(description, '')), 'B')
+			));
+			CREATE INDEX ix_quest_user_link_quest ON quest_user_link (quest_id);
+		`,
+		Down: `
+			DROP INDEX IF EXISTS ix_quest_user_link_quest;
+			DROP INDEX IF EXISTS ix_quest_search;
+			DROP INDEX IF EXISTS ix_quest_tags;
+			ALTER TABLE quest
+				DROP COLUMN IF EXISTS tags,
+				DROP COLUMN IF EXISTS difficulty,
+				DROP COLUMN IF EXISTS duration_minutes;
+		`,
+	})
+}
@@ -0,0 +1,46 @@
+package migrations
+
+// Migration 22 numbers the events of every team with its own sequence. Event ids come from
+// a shared sequence and are taken before the transaction that writes the event commits, so
+// a stream resumed after an id could skip events committed later with smaller ids. The next
+// number of the team is taken by updating the team row, which stays locked until the
+// transaction ends: events of a team become visible in the order of their numbers, and
+// numbers of rolled back events are reused.
+func init() {
+	register(Migration{
+		Version: 22,
+		Name:    "team_event_sequence",
+		Up: `
+			ALTER TABLE team ADD COLUMN last_event_seq BIGINT NOT NULL DEFAULT 0;
+			ALTER TABLE team_event ADD COLUMN seq BIGINT;
+
+			UPDATE team_event AS e SET seq = n.seq
+			FROM (SELECT id, row_number() OVER (PARTITION BY team_id ORDER BY id) AS seq FROM team_event) AS n
+			WHERE n.id = e.id;
+			UPDATE team AS t SET last_event_seq = COALESCE((SELECT MAX(seq) FROM team_event WHERE team_id = t.id), 0);
+
+			ALTER TABLE team_event ALTER COLUMN seq SET NOT NULL;
+			CREATE UNIQUE INDEX ux_team_event_seq ON team_event (team_id, seq);
+			DROP INDEX IF EXISTS ix_team_event_team;
+
+			CREATE FUNCTION team_event_seq() RETURNS TRIGGER AS $$
+			BEGIN
+				UPDATE team SET last_event_seq = last_event_seq + 1 WHERE id = NEW.team_id
+				RETURNING last_event_seq INTO NEW.seq;
+				RETURN NEW;
+			END;
+			$$ LANGUAGE plpgsql;
+
+			CREATE TRIGGER tr_team_event_seq BEFORE INSERT ON team_event
+				FOR EACH ROW EXECUTE PROCEDURE team_event_seq();
+		`,
+		Down: `
+			DROP TRIGGER IF EXISTS tr_team_event_seq ON team_event;
+			DROP FUNCTION IF EXISTS team_event_seq();
+			CREATE INDEX IF NOT EXISTS ix_team_event_team ON team_event (team_id, id);
+			DROP INDEX IF EXISTS ux_team_event_seq;
+			ALTER TABLE team_event DROP COLUMN IF EXISTS seq;
+			ALTER TABLE team DROP COLUMN IF EXISTS last_event_seq;
+		`,
+	})
+}
@@ -0,0 +1,21 @@
+package migrations
+
+// Migration 23 stores the checkpoints the scenario of a quest refers to, so that checkpoints
+// still used by the scenario are not deleted with the checkpoint list. Stored scenarios get
+// the ids of their "checkpoint" conditions, which look the same in YAML and JSON.
+func init() {
+	register(Migration{
+		Version: 23,
+		Name:    "scenario_checkpoints",
+		Up: `
+			ALTER TABLE quest_scenario ADD COLUMN checkpoint_ids INT[] NOT NULL DEFAULT '{}';
+
+			UPDATE quest_scenario SET checkpoint_ids = ARRAY(
+				SELECT DISTINCT m[1]::INT FROM regexp_matches(source, '"?\mcheckpoint"?\s*:\s*(\d+)', 'g') AS m
+			);
+		`,
+		Down: `
+			ALTER TABLE quest_scenario DROP COLUMN IF EXISTS checkpoint_ids;
+		`,
+	})
+}
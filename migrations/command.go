@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	upCmd     = "up"
+	downCmd   = "down"
+	statusCmd = "status"
+)
+
+// RunCommand executes `migrate up|down [steps]|status` and reports progress to out.
+func RunCommand(m Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("migrate requires one of: %s, %s, %s", upCmd, downCmd, statusCmd)
+	}
+
+	switch args[0] {
+	case upCmd:
+		applied, err := m.Up()
+		for _, migration := range applied {
+			fmt.Fprintf(out, "applied %d %s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Fprintln(out, "schema is up to date")
+		}
+		return nil
+
+	case downCmd:
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		reverted, err := m.Down(steps)
+		for _, migration := range reverted {
+			fmt.Fprintf(out, "reverted %d %s\n", migration.Version, migration.Name)
+		}
+		return err
+
+	case statusCmd:
+		statuses, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			state := "pending"
+			if status.Applied {
+				state = "applied at " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(out, "%4d %-40s %s\n", status.Version, status.Name, state)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command %q", args[0])
+	}
+}
+
+// CheckVersion fails if the database schema is older than the one the binary was built for.
+func CheckVersion(m Migrator) error {
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if expected := Latest(); version < expected {
+		return fmt.Errorf("database schema version %d is behind expected version %d, run `migrate up`", version, expected)
+	}
+	return nil
+}
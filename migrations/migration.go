@@ -0,0 +1,46 @@
+package migrations
+
+import (
+	"fmt"
+	"sort"
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+var registry = make([]Migration, 0)
+
+func register(m Migration) {
+	for _, existing := range registry {
+		if existing.Version == m.Version {
+			panic(fmt.Errorf("migration version %d registered twice (%s, %s)", m.Version, existing.Name, m.Name))
+		}
+	}
+	registry = append(registry, m)
+	sort.Slice(registry, func(i, j int) bool {
+		return registry[i].Version < registry[j].Version
+	})
+}
+
+// All returns every migration compiled into the binary ordered by version.
+func All() []Migration {
+	result := make([]Migration, len(registry))
+	copy(result, registry)
+	return result
+}
+
+// Latest returns the schema version the binary expects the database to have.
+func Latest() int {
+	return latestOf(registry)
+}
+
+func latestOf(list []Migration) int {
+	if len(list) == 0 {
+		return 0
+	}
+	return list[len(list)-1].Version
+}
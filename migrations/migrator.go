@@ -0,0 +1,160 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	createVersionTable = `
+		CREATE TABLE IF NOT EXISTS schema_version (
+			version    INT PRIMARY KEY,
+			name       VARCHAR(100) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT now()
+		)
+	`
+	getAppliedVersions = `SELECT version, applied_at FROM schema_version ORDER BY version`
+	getCurrentVersion  = `SELECT coalesce(max(version), 0) FROM schema_version`
+	insertVersion      = `INSERT INTO schema_version (version, name) VALUES ($1, $2)`
+	deleteVersion      = `DELETE FROM schema_version WHERE version = $1`
+)
+
+type MigrationStatus struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+type Migrator interface {
+	Up() ([]Migration, error)
+	Down(steps int) ([]Migration, error)
+	Status() ([]MigrationStatus, error)
+	Version() (int, error)
+}
+
+func NewMigrator(db *sql.DB) Migrator {
+	return newMigrator(db, All())
+}
+
+func newMigrator(db *sql.DB, migrations []Migration) Migrator {
+	return &dbMigrator{db: db, migrations: migrations}
+}
+
+type dbMigrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func (m *dbMigrator) Up() ([]Migration, error) {
+	applied, err := m.getApplied()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Migration, 0)
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := m.apply(migration.Up, func(tx *sql.Tx) error {
+			_, err := tx.Exec(insertVersion, migration.Version, migration.Name)
+			return err
+		}); err != nil {
+			return result, fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+		result = append(result, migration)
+	}
+	return result, nil
+}
+
+func (m *dbMigrator) Down(steps int) ([]Migration, error) {
+	applied, err := m.getApplied()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Migration, 0)
+	for i := len(m.migrations) - 1; i >= 0 && len(result) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+		if err := m.apply(migration.Down, func(tx *sql.Tx) error {
+			_, err := tx.Exec(deleteVersion, migration.Version)
+			return err
+		}); err != nil {
+			return result, fmt.Errorf("rollback of migration %d (%s) failed: %v", migration.Version, migration.Name, err)
+		}
+		result = append(result, migration)
+	}
+	return result, nil
+}
+
+func (m *dbMigrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.getApplied()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		result[i] = MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			result[i].Applied = true
+			result[i].AppliedAt = &appliedAt
+		}
+	}
+	return result, nil
+}
+
+func (m *dbMigrator) Version() (int, error) {
+	if _, err := m.db.Exec(createVersionTable); err != nil {
+		return 0, err
+	}
+	version := 0
+	if err := m.db.QueryRow(getCurrentVersion).Scan(&version); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+func (m *dbMigrator) apply(script string, updateVersion func(tx *sql.Tx) error) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(script); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := updateVersion(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (m *dbMigrator) getApplied() (map[int]time.Time, error) {
+	if _, err := m.db.Exec(createVersionTable); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query(getAppliedVersions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make(map[int]time.Time)
+	for rows.Next() {
+		version := 0
+		appliedAt := time.Time{}
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		result[version] = appliedAt
+	}
+	return result, rows.Err()
+}
@@ -0,0 +1,149 @@
+package migrations
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"testing"
+	"time"
+)
+
+type MigratorTestSuite struct {
+	suite.Suite
+	db       *sql.DB
+	mock     sqlmock.Sqlmock
+	migrator Migrator
+}
+
+func (s *MigratorTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+	s.migrator = newMigrator(s.db, []Migration{
+		{Version: 1, Name: "first", Up: "CREATE TABLE a", Down: "DROP TABLE a"},
+		{Version: 2, Name: "second", Up: "CREATE TABLE b", Down: "DROP TABLE b"},
+	})
+}
+
+func (s *MigratorTestSuite) expectApplied(versions ...int) {
+	s.mock.
+		ExpectExec("CREATE TABLE IF NOT EXISTS schema_version").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rows := sqlmock.NewRows([]string{"version", "applied_at"})
+	for _, v := range versions {
+		rows.AddRow(v, time.Now())
+	}
+	s.mock.
+		ExpectQuery("SELECT version, applied_at FROM schema_version").
+		WillReturnRows(rows)
+}
+
+func (s *MigratorTestSuite) TestUpAppliesPending() {
+	s.expectApplied(1)
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("CREATE TABLE b").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.
+		ExpectExec("INSERT INTO schema_version").
+		WithArgs(2, "second").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	applied, err := s.migrator.Up()
+	s.Require().NoError(err)
+	s.Require().Len(applied, 1)
+	s.Equal(2, applied[0].Version)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *MigratorTestSuite) TestUpUpToDate() {
+	s.expectApplied(1, 2)
+
+	applied, err := s.migrator.Up()
+	s.Require().NoError(err)
+	s.Empty(applied)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *MigratorTestSuite) TestUpRollsBackFailed() {
+	s.expectApplied()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("CREATE TABLE a").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.
+		ExpectExec("INSERT INTO schema_version").
+		WithArgs(1, "first").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("CREATE TABLE b").WillReturnError(fmt.Errorf("fail"))
+	s.mock.ExpectRollback()
+
+	applied, err := s.migrator.Up()
+	s.Require().Error(err)
+	s.Len(applied, 1)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *MigratorTestSuite) TestDownRevertsLatest() {
+	s.expectApplied(1, 2)
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("DROP TABLE b").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.
+		ExpectExec("DELETE FROM schema_version").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	reverted, err := s.migrator.Down(1)
+	s.Require().NoError(err)
+	s.Require().Len(reverted, 1)
+	s.Equal(2, reverted[0].Version)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *MigratorTestSuite) TestStatus() {
+	s.expectApplied(1)
+
+	statuses, err := s.migrator.Status()
+	s.Require().NoError(err)
+	s.Require().Len(statuses, 2)
+	s.True(statuses[0].Applied)
+	s.NotNil(statuses[0].AppliedAt)
+	s.False(statuses[1].Applied)
+	s.Nil(statuses[1].AppliedAt)
+}
+
+func (s *MigratorTestSuite) TestVersion() {
+	s.mock.
+		ExpectExec("CREATE TABLE IF NOT EXISTS schema_version").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.
+		ExpectQuery("SELECT coalesce").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(2))
+
+	version, err := s.migrator.Version()
+	s.Require().NoError(err)
+	s.Equal(2, version)
+}
+
+func (s *MigratorTestSuite) TestRunCommandUnknown() {
+	out := bytes.Buffer{}
+	s.Error(RunCommand(s.migrator, []string{"sideways"}, &out))
+	s.Error(RunCommand(s.migrator, nil, &out))
+	s.Error(RunCommand(s.migrator, []string{downCmd, "zero"}, &out))
+}
+
+func (s *MigratorTestSuite) TestRegistryOrdered() {
+	all := All()
+	s.Require().NotEmpty(all)
+	for i := 1; i < len(all); i++ {
+		s.True(all[i-1].Version < all[i].Version)
+	}
+	s.Equal(all[len(all)-1].Version, Latest())
+}
+
+func TestMigratorTestSuite(t *testing.T) {
+	suite.Run(t, new(MigratorTestSuite))
+}
@@ -0,0 +1,213 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Sovianum/arquest-server/geo"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	CheckpointGPS      = "gps"
+	CheckpointARMarker = "ar_marker"
+	CheckpointQR       = "qr"
+	CheckpointAnswer   = "answer"
+
+	CheckpointMaxNameLength        = 100
+	CheckpointMaxDescriptionLength = 1000
+	CheckpointMaxMarkerLength      = 100
+	CheckpointMaxRadius            = 10000
+	CheckpointMaxAnswers           = 20
+	CheckpointMaxAnswerLength      = 200
+	CheckpointMaxHints             = 5
+	HintMaxTextLength              = 1000
+	QuestMaxCheckpoints            = 100
+)
+
+// Checkpoint is a step of a quest. Checkpoints are passed in the order of Position;
+// optional checkpoints may be skipped.
+type Checkpoint struct {
+	ID          int    `json:"id,omitempty"`
+	QuestID     int    `json:"quest_id"`
+	Position    int    `json:"position"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Optional    bool   `json:"optional"`
+
+	// Location and Radius (meters) define the geofence of a GPS checkpoint
+	Location *geo.Point `json:"location,omitempty"`
+	Radius   float64    `json:"radius,omitempty"`
+	// MarkerID identifies the image recognized by the client at an AR marker checkpoint
+	MarkerID string `json:"marker_id,omitempty"`
+	// Answers are the accepted solutions of an answer checkpoint. They are only written:
+	// the server keeps their salted hashes and never returns them.
+	Answers []string `json:"answers,omitempty"`
+	// Hints are the hint tiers of the checkpoint in the order players get them. They are
+	// only written, players get them one by one; checkpoints saved without hints keep theirs.
+	Hints []Hint `json:"hints,omitempty"`
+
+	QRSecret     []byte   `json:"-"`
+	AnswerSalt   []byte   `json:"-"`
+	AnswerHashes [][]byte `json:"-"`
+}
+
+func (checkpoint *Checkpoint) Validate() error {
+	var msgList []string
+	if strings.TrimSpace(checkpoint.Name) == "" {
+		msgList = append(msgList, "\"name\" field required")
+	}
+	if utf8.RuneCountInString(checkpoint.Name) > CheckpointMaxNameLength {
+		msgList = append(msgList, fmt.Sprintf("\"name\" must not be longer than %d characters", CheckpointMaxNameLength))
+	}
+	if utf8.RuneCountInString(checkpoint.Description) > CheckpointMaxDescriptionLength {
+		msgList = append(msgList, fmt.Sprintf("\"description\" must not be longer than %d characters", CheckpointMaxDescriptionLength))
+	}
+
+	switch checkpoint.Type {
+	case CheckpointGPS:
+		if checkpoint.Location == nil {
+			msgList = append(msgList, "gps checkpoint requires \"location\"")
+		} else if err := checkpoint.Location.Validate(); err != nil {
+			msgList = append(msgList, fmt.Sprintf("\"location\": %v", err))
+		}
+		if checkpoint.Radius <= 0 || checkpoint.Radius > CheckpointMaxRadius {
+			msgList = append(msgList, fmt.Sprintf("\"radius\" must be in (0, %d] meters", CheckpointMaxRadius))
+		}
+	case CheckpointARMarker:
+		if strings.TrimSpace(checkpoint.MarkerID) == "" {
+			msgList = append(msgList, "ar_marker checkpoint requires \"marker_id\"")
+		}
+		if utf8.RuneCountInString(checkpoint.MarkerID) > CheckpointMaxMarkerLength {
+			msgList = append(msgList, fmt.Sprintf("\"marker_id\" must not be longer than %d characters", CheckpointMaxMarkerLength))
+		}
+	case CheckpointAnswer:
+		if len(checkpoint.Answers) > CheckpointMaxAnswers {
+			msgList = append(msgList, fmt.Sprintf("answer checkpoint can not have more than %d \"answers\"", CheckpointMaxAnswers))
+		}
+		for _, answer := range checkpoint.Answers {
+			if strings.TrimSpace(answer) == "" || utf8.RuneCountInString(answer) > CheckpointMaxAnswerLength {
+				msgList = append(msgList, fmt.Sprintf("\"answers\" must be non-empty and not longer than %d characters", CheckpointMaxAnswerLength))
+				break
+			}
+		}
+	case CheckpointQR:
+	default:
+		msgList = append(msgList, fmt.Sprintf(
+			"\"type\" must be one of %s, %s, %s, %s", CheckpointGPS, CheckpointARMarker, CheckpointQR, CheckpointAnswer,
+		))
+	}
+
+	if len(checkpoint.Hints) > CheckpointMaxHints {
+		msgList = append(msgList, fmt.Sprintf("checkpoint can not have more than %d \"hints\"", CheckpointMaxHints))
+	}
+	for _, hint := range checkpoint.Hints {
+		if strings.TrimSpace(hint.Text) == "" || utf8.RuneCountInString(hint.Text) > HintMaxTextLength {
+			msgList = append(msgList, fmt.Sprintf("hint \"text\" must be non-empty and not longer than %d characters", HintMaxTextLength))
+			break
+		}
+		if hint.Penalty != nil && *hint.Penalty < 0 {
+			msgList = append(msgList, "hint \"penalty\" must not be negative")
+			break
+		}
+	}
+
+	if len(msgList) != 0 {
+		return errors.New(strings.Join(msgList, ";\n"))
+	}
+	return nil
+}
+
+// ValidateCheckpoints checks the whole ordered list of quest checkpoints.
+func ValidateCheckpoints(checkpoints []Checkpoint) error {
+	if len(checkpoints) > QuestMaxCheckpoints {
+		return fmt.Errorf("quest can not have more than %d checkpoints", QuestMaxCheckpoints)
+	}
+	ids := make(map[int]bool, len(checkpoints))
+	for i := range checkpoints {
+		if err := checkpoints[i].Validate(); err != nil {
+			return fmt.Errorf("checkpoint %d: %v", i+1, err)
+		}
+		if id := checkpoints[i].ID; id != 0 {
+			if ids[id] {
+				return fmt.Errorf("checkpoint %d: duplicate id %d", i+1, id)
+			}
+			ids[id] = true
+		}
+	}
+	return nil
+}
+
+type CheckpointProgress struct {
+	Checkpoint
+	Reached     bool        `json:"reached"`
+	ReachedAt   *QuotedTime `json:"reached_at,omitempty"`
+	HintsUsed   int         `json:"hints_used"`
+	HintPenalty int         `json:"hint_penalty"`
+}
+
+// QuestProgress shows how far the user got in the latest attempt of the quest. The quest can
+// be finished once every required (not optional) checkpoint is reached.
+type QuestProgress struct {
+	QuestID     int                  `json:"quest_id"`
+	AttemptID   int                  `json:"attempt_id,omitempty"`
+	TeamID      int                  `json:"team_id,omitempty"`
+	Started     bool                 `json:"started"`
+	Completed   bool                 `json:"completed"`
+	Reached     int                  `json:"reached"`
+	Required    int                  `json:"required"`
+	CanFinish   bool                 `json:"can_finish"`
+	HintsUsed   int                  `json:"hints_used"`
+	HintPenalty int                  `json:"hint_penalty"`
+	Checkpoints []CheckpointProgress `json:"checkpoints"`
+}
+
+// Count fills the counters and CanFinish from Checkpoints.
+func (progress *QuestProgress) Count() {
+	progress.Reached, progress.Required = 0, 0
+	progress.HintsUsed, progress.HintPenalty = 0, 0
+	requiredLeft := 0
+	for _, checkpoint := range progress.Checkpoints {
+		progress.HintsUsed += checkpoint.HintsUsed
+		progress.HintPenalty += checkpoint.HintPenalty
+		if checkpoint.Reached {
+			progress.Reached++
+		}
+		if !checkpoint.Optional {
+			progress.Required++
+			if !checkpoint.Reached {
+				requiredLeft++
+			}
+		}
+	}
+	progress.CanFinish = requiredLeft == 0
+}
+
+// GPSFix is a position reported by the player device; Accuracy is the radius of
+// its uncertainty in meters.
+type GPSFix struct {
+	geo.Point
+	Accuracy float64    `json:"accuracy"`
+	Time     QuotedTime `json:"time"`
+}
+
+// CheckpointProof is what the player sends to pass a checkpoint; the field matching
+// the checkpoint type is required.
+type CheckpointProof struct {
+	Fix      *GPSFix `json:"fix,omitempty"`
+	MarkerID string  `json:"marker_id,omitempty"`
+	QR       string  `json:"qr,omitempty"`
+	Answer   string  `json:"answer,omitempty"`
+}
+
+// CheckpointFailure is a rejected checkpoint proof kept for moderators.
+type CheckpointFailure struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"user_id"`
+	QuestID      int        `json:"quest_id"`
+	CheckpointID int        `json:"checkpoint_id"`
+	Reason       string     `json:"reason"`
+	Details      string     `json:"details"`
+	CreatedAt    QuotedTime `json:"created_at"`
+}
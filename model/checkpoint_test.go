@@ -0,0 +1,76 @@
+package model
+
+import (
+	"github.com/Sovianum/arquest-server/geo"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestCheckpoint_Validate(t *testing.T) {
+	gps := Checkpoint{Name: "start", Type: CheckpointGPS, Location: &geo.Point{Lat: 55.75, Lon: 37.61}, Radius: 50}
+	assert.Nil(t, gps.Validate())
+
+	gps.Radius = 0
+	assert.Contains(t, gps.Validate().Error(), "\"radius\"")
+
+	gps.Location = nil
+	assert.Contains(t, gps.Validate().Error(), "\"location\"")
+
+	marker := Checkpoint{Name: "statue", Type: CheckpointARMarker}
+	assert.Contains(t, marker.Validate().Error(), "\"marker_id\"")
+	marker.MarkerID = "statue-1"
+	assert.Nil(t, marker.Validate())
+
+	marker.Hints = []Hint{{Text: "look up"}, {Text: " "}}
+	assert.Contains(t, marker.Validate().Error(), "hint \"text\"")
+	penalty := -1
+	marker.Hints = []Hint{{Text: "look up", Penalty: &penalty}}
+	assert.Contains(t, marker.Validate().Error(), "hint \"penalty\"")
+	penalty = 0
+	assert.Nil(t, marker.Validate())
+	marker.Hints = make([]Hint, CheckpointMaxHints+1)
+	assert.Contains(t, marker.Validate().Error(), "\"hints\"")
+
+	unknown := Checkpoint{Name: strings.Repeat("n", CheckpointMaxNameLength+1), Type: "photo"}
+	err := unknown.Validate()
+	assert.Contains(t, err.Error(), "\"name\"")
+	assert.Contains(t, err.Error(), "\"type\"")
+}
+
+func TestValidateCheckpoints(t *testing.T) {
+	assert.Nil(t, ValidateCheckpoints([]Checkpoint{
+		{ID: 1, Name: "a", Type: CheckpointQR},
+		{Name: "b", Type: CheckpointAnswer},
+		{Name: "c", Type: CheckpointAnswer},
+	}))
+
+	err := ValidateCheckpoints([]Checkpoint{
+		{ID: 1, Name: "a", Type: CheckpointQR},
+		{ID: 1, Name: "b", Type: CheckpointQR},
+	})
+	assert.Contains(t, err.Error(), "duplicate id")
+
+	err = ValidateCheckpoints([]Checkpoint{{Name: "a", Type: CheckpointQR}, {Type: CheckpointQR}})
+	assert.Contains(t, err.Error(), "checkpoint 2")
+}
+
+func TestQuestProgress_Count(t *testing.T) {
+	progress := QuestProgress{Checkpoints: []CheckpointProgress{
+		{Checkpoint: Checkpoint{ID: 1}, Reached: true},
+		{Checkpoint: Checkpoint{ID: 2, Optional: true}},
+		{Checkpoint: Checkpoint{ID: 3}},
+	}}
+	progress.Count()
+	assert.Equal(t, 1, progress.Reached)
+	assert.Equal(t, 2, progress.Required)
+	assert.False(t, progress.CanFinish)
+
+	progress.Checkpoints[2].Reached = true
+	progress.Count()
+	assert.True(t, progress.CanFinish)
+
+	empty := QuestProgress{}
+	empty.Count()
+	assert.True(t, empty.CanFinish)
+}
@@ -0,0 +1,25 @@
+package model
+
+const (
+	EventAttemptStarted    = "attempt_started"
+	EventAttemptFinished   = "attempt_finished"
+	EventAttemptAbandoned  = "attempt_abandoned"
+	EventCheckpointReached = "checkpoint_reached"
+	EventHintUsed          = "hint_used"
+	EventMemberJoined      = "member_joined"
+	EventMemberLeft        = "member_left"
+)
+
+// TeamEvent is a change of a team or of its attempt streamed to the members. ID is the number
+// of the event within the team; events become visible in the order of their IDs, so a client
+// resumes the stream from the last event it has seen.
+type TeamEvent struct {
+	ID           int64      `json:"id"`
+	Type         string     `json:"type"`
+	TeamID       int        `json:"team_id"`
+	AttemptID    int        `json:"attempt_id,omitempty"`
+	UserID       int        `json:"user_id,omitempty"`
+	Login        string     `json:"login,omitempty"`
+	CheckpointID int        `json:"checkpoint_id,omitempty"`
+	CreatedAt    QuotedTime `json:"created_at"`
+}
@@ -0,0 +1,23 @@
+package model
+
+// Hint is a tier of help for a checkpoint. Every hint taken by a player costs Penalty
+// points of the quest score; nil penalty means the default one, zero makes the hint free.
+type Hint struct {
+	Text    string `json:"text"`
+	Penalty *int   `json:"penalty,omitempty"`
+}
+
+// UsedHint is a hint taken by the player with the penalty charged at that time.
+type UsedHint struct {
+	Tier    int        `json:"tier"`
+	Text    string     `json:"text"`
+	Penalty int        `json:"penalty"`
+	UsedAt  QuotedTime `json:"used_at"`
+}
+
+// CheckpointHints shows the hints of the checkpoint taken by the player out of Total.
+type CheckpointHints struct {
+	CheckpointID int        `json:"checkpoint_id"`
+	Total        int        `json:"total"`
+	Used         []UsedHint `json:"used"`
+}
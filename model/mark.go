@@ -1,7 +1,64 @@
 package model
 
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	MarkHistoryDefaultLimit = 50
+	MarkHistoryMaxLimit     = 200
+
+	// markStepTolerance absorbs the rounding of marks sent as float32
+	markStepTolerance = 1e-4
+)
+
 type Mark struct {
 	UserID  int     `json:"user_id"`
 	QuestID int     `json:"quest_id"`
 	Mark    float32 `json:"mark"`
 }
+
+// RatingRules turn the marks of a quest into its rating: the mean of the marks pulled towards
+// PriorMean as if the quest had PriorWeight more marks equal to it, so that quests with few
+// marks do not outrank well-known ones. Zero PriorWeight gives the plain mean.
+type RatingRules struct {
+	PriorWeight float64
+	PriorMean   float64
+}
+
+// MarkRules allow marks from Min to Max that differ from Min by a multiple of Step.
+type MarkRules struct {
+	Min  float64
+	Max  float64
+	Step float64
+}
+
+func (rules MarkRules) Validate(mark float32) error {
+	value := float64(mark)
+	if value < rules.Min || value > rules.Max {
+		return fmt.Errorf("\"mark\" must be in [%g, %g]", rules.Min, rules.Max)
+	}
+	steps := (value - rules.Min) / rules.Step
+	if math.Abs(steps-math.Round(steps)) > markStepTolerance {
+		return fmt.Errorf("\"mark\" must be %g plus a multiple of %g", rules.Min, rules.Step)
+	}
+	return nil
+}
+
+// MarkChange is a record of the mark history: the first mark of a quest has no PreviousMark.
+type MarkChange struct {
+	ID           int        `json:"id"`
+	QuestID      int        `json:"quest_id"`
+	Mark         float32    `json:"mark"`
+	PreviousMark *float32   `json:"previous_mark,omitempty"`
+	CreatedAt    QuotedTime `json:"created_at"`
+}
+
+// MarkHistoryFilter selects the changes of the marks of a user with ids less than BeforeID,
+// newest first. Zero QuestID selects the changes of all quests.
+type MarkHistoryFilter struct {
+	QuestID  int
+	BeforeID int
+	Limit    int
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMarkRules_Validate(t *testing.T) {
+	rules := MarkRules{Min: 1, Max: 5, Step: 0.5}
+	for _, mark := range []float32{1, 2.5, 5} {
+		assert.NoError(t, rules.Validate(mark), mark)
+	}
+	for _, mark := range []float32{0, 0.5, 5.5, -1, 2.3} {
+		assert.Error(t, rules.Validate(mark), mark)
+	}
+
+	rules = MarkRules{Min: 0, Max: 10, Step: 0.1}
+	assert.NoError(t, rules.Validate(4.7))
+	assert.NoError(t, rules.Validate(9.9))
+	assert.Error(t, rules.Validate(4.75))
+}
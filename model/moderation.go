@@ -0,0 +1,145 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kinds of user content under moderation. The content id is the id of the review, the reply,
+// the user whose about text it is or the team.
+const (
+	ContentReview   = "review"
+	ContentReply    = "review_reply"
+	ContentAbout    = "user_about"
+	ContentTeamName = "team_name"
+)
+
+const (
+	ModerationPending  = "pending"
+	ModerationApproved = "approved"
+	ModerationRejected = "rejected"
+)
+
+// Moderation actions kept in the audit trail. Flags are raised by the automatic filter,
+// the other actions are taken by moderators.
+const (
+	ActionFlag    = "flag"
+	ActionApprove = "approve"
+	ActionReject  = "reject"
+	ActionBan     = "ban"
+	ActionUnban   = "unban"
+)
+
+const (
+	ModerationReasonMaxLength = 500
+	ModerationDefaultLimit    = 50
+	ModerationMaxLimit        = 200
+)
+
+// IsModeratedContent tells whether the content type is one of the moderated kinds.
+func IsModeratedContent(contentType string) bool {
+	switch contentType {
+	case ContentReview, ContentReply, ContentAbout, ContentTeamName:
+		return true
+	}
+	return false
+}
+
+// ModerationItem is the state of a piece of content in the moderation queue. It enters the
+// queue when the automatic filter flags it or a user reports it; Text is the content at that time.
+type ModerationItem struct {
+	ID          int        `json:"id"`
+	ContentType string     `json:"content_type"`
+	ContentID   int        `json:"content_id"`
+	AuthorID    int        `json:"author_id,omitempty"`
+	Text        string     `json:"text"`
+	Flags       []string   `json:"flags"`
+	ReportCount int        `json:"report_count"`
+	Status      string     `json:"status"`
+	CreatedAt   QuotedTime `json:"created_at"`
+	UpdatedAt   QuotedTime `json:"updated_at"`
+}
+
+// ModerationFilter selects items of the queue with ids greater than AfterID, oldest first.
+type ModerationFilter struct {
+	Status      string
+	ContentType string
+	AfterID     int
+	Limit       int
+}
+
+// ContentReport is a complaint of a user about content written by somebody else.
+type ContentReport struct {
+	ContentType string `json:"content_type"`
+	ContentID   int    `json:"content_id"`
+	Reason      string `json:"reason"`
+
+	ReporterID int `json:"-"`
+}
+
+func (report *ContentReport) Validate() error {
+	var msgList []string
+	if !IsModeratedContent(report.ContentType) {
+		msgList = append(msgList, fmt.Sprintf(
+			"\"content_type\" must be one of: %s, %s, %s, %s", ContentReview, ContentReply, ContentAbout, ContentTeamName,
+		))
+	}
+	if report.ContentID <= 0 {
+		msgList = append(msgList, "\"content_id\" field required")
+	}
+	if utf8.RuneCountInString(report.Reason) > ModerationReasonMaxLength {
+		msgList = append(msgList, fmt.Sprintf("\"reason\" must not be longer than %d characters", ModerationReasonMaxLength))
+	}
+
+	if len(msgList) > 0 {
+		return errors.New(strings.Join(msgList, ";\n"))
+	}
+	return nil
+}
+
+// ModerationDecision is a decision of a moderator about a queue item.
+type ModerationDecision struct {
+	Reason string `json:"reason"`
+
+	ItemID      int    `json:"-"`
+	ModeratorID int    `json:"-"`
+	Action      string `json:"-"`
+}
+
+func (decision *ModerationDecision) Validate() error {
+	if utf8.RuneCountInString(decision.Reason) > ModerationReasonMaxLength {
+		return fmt.Errorf("\"reason\" must not be longer than %d characters", ModerationReasonMaxLength)
+	}
+	return nil
+}
+
+// ModerationAction is a record of the audit trail. ModeratorID is zero for the automatic
+// filter; ItemID is zero for unbans, which are not related to content.
+type ModerationAction struct {
+	ID          int        `json:"id"`
+	ItemID      int        `json:"item_id,omitempty"`
+	UserID      int        `json:"user_id,omitempty"`
+	ModeratorID int        `json:"moderator_id,omitempty"`
+	Action      string     `json:"action"`
+	Reason      string     `json:"reason"`
+	CreatedAt   QuotedTime `json:"created_at"`
+}
+
+// ModerationActionFilter selects the records with ids less than BeforeID, newest first.
+// Zero fields do not filter.
+type ModerationActionFilter struct {
+	ItemID   int
+	UserID   int
+	BeforeID int
+	Limit    int
+}
+
+// Ban keeps a user from signing in.
+type Ban struct {
+	UserID      int        `json:"user_id"`
+	ModeratorID int        `json:"moderator_id,omitempty"`
+	Reason      string     `json:"reason"`
+	CreatedAt   QuotedTime `json:"created_at"`
+}
@@ -1,9 +1,145 @@
 package model
 
+import (
+	"errors"
+	"fmt"
+	"github.com/Sovianum/arquest-server/geo"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	QuestMaxNameLength        = 50
+	QuestMaxDescriptionLength = 1000
+	QuestMaxTags              = 10
+	QuestMaxTagLength         = 30
+	QuestMaxDurationMinutes   = 7 * 24 * 60
+
+	QuestRequiredName = "\"name\" field required"
+
+	DifficultyEasy   = "easy"
+	DifficultyMedium = "medium"
+	DifficultyHard   = "hard"
+)
+
+// questTag is a lowercase word, possibly of several parts joined by hyphens
+var questTag = regexp.MustCompile(`^[\p{Ll}\p{Nd}]+(-[\p{Ll}\p{Nd}]+)*$`)
+
 type Quest struct {
 	ID          int     `json:"id"`
 	Name        string  `json:"name"`
 	Description string  `json:"description"`
 	Rating      float32 `json:"rating"`
 	DataPath    string  `json:"data_path,omitempty"`
+	AuthorID    int     `json:"author_id,omitempty"`
+	Version     int     `json:"version,omitempty"`
+
+	// Location is where the quest starts, Area optionally bounds the whole quest
+	Location *geo.Point `json:"location,omitempty"`
+	Area     *geo.Rect  `json:"area,omitempty"`
+
+	// Teams allows team play; nil for quests played solo only
+	Teams *TeamLimits `json:"teams,omitempty"`
+
+	// Tags, Difficulty and DurationMinutes, the expected time to play the quest, are used to
+	// filter quest listings
+	Tags            []string `json:"tags,omitempty"`
+	Difficulty      string   `json:"difficulty,omitempty"`
+	DurationMinutes int      `json:"duration_minutes,omitempty"`
+
+	// archive of the published content version
+	PublishedVersion int    `json:"published_version,omitempty"`
+	ArchiveSize      int64  `json:"archive_size,omitempty"`
+	ArchiveSHA256    string `json:"archive_sha256,omitempty"`
+}
+
+// Validate checks the fields editable by authors. Lengths are counted in characters
+// to match the VARCHAR limits of the quest table.
+func (quest *Quest) Validate() error {
+	var msgList []string
+	if strings.TrimSpace(quest.Name) == "" {
+		msgList = append(msgList, QuestRequiredName)
+	}
+	if utf8.RuneCountInString(quest.Name) > QuestMaxNameLength {
+		msgList = append(msgList, fmt.Sprintf("\"name\" must not be longer than %d characters", QuestMaxNameLength))
+	}
+	if utf8.RuneCountInString(quest.Description) > QuestMaxDescriptionLength {
+		msgList = append(msgList, fmt.Sprintf("\"description\" must not be longer than %d characters", QuestMaxDescriptionLength))
+	}
+
+	if quest.Location != nil {
+		if err := quest.Location.Validate(); err != nil {
+			msgList = append(msgList, fmt.Sprintf("\"location\": %v", err))
+		}
+	}
+	if quest.Area != nil {
+		if quest.Location == nil {
+			msgList = append(msgList, "\"area\" requires \"location\"")
+		} else if err := quest.Area.Validate(); err != nil {
+			msgList = append(msgList, fmt.Sprintf("\"area\": %v", err))
+		} else if !quest.Area.Contains(*quest.Location) {
+			msgList = append(msgList, "\"location\" must be inside \"area\"")
+		}
+	}
+
+	if quest.Teams != nil {
+		if err := quest.Teams.Validate(); err != nil {
+			msgList = append(msgList, fmt.Sprintf("\"teams\": %v", err))
+		}
+	}
+
+	msgList = append(msgList, quest.validateListingFields()...)
+
+	if len(msgList) != 0 {
+		return errors.New(strings.Join(msgList, ";\n"))
+	}
+	return nil
+}
+
+func (quest *Quest) validateListingFields() []string {
+	var msgList []string
+	if len(quest.Tags) > QuestMaxTags {
+		msgList = append(msgList, fmt.Sprintf("a quest can not have more than %d tags", QuestMaxTags))
+	}
+	seen := make(map[string]bool, len(quest.Tags))
+	for _, tag := range quest.Tags {
+		if utf8.RuneCountInString(tag) > QuestMaxTagLength || !questTag.MatchString(tag) {
+			msgList = append(msgList, fmt.Sprintf(
+				"tag %q must be lowercase letters and digits, possibly joined by hyphens, at most %d characters",
+				tag, QuestMaxTagLength,
+			))
+		} else if seen[tag] {
+			msgList = append(msgList, fmt.Sprintf("tag %q is given twice", tag))
+		}
+		seen[tag] = true
+	}
+	if quest.Difficulty != "" && !IsDifficulty(quest.Difficulty) {
+		msgList = append(msgList, fmt.Sprintf(
+			"\"difficulty\" must be one of: %s, %s, %s", DifficultyEasy, DifficultyMedium, DifficultyHard,
+		))
+	}
+	if quest.DurationMinutes < 0 || quest.DurationMinutes > QuestMaxDurationMinutes {
+		msgList = append(msgList, fmt.Sprintf("\"duration_minutes\" must be in [0, %d]", QuestMaxDurationMinutes))
+	}
+	return msgList
+}
+
+func IsDifficulty(difficulty string) bool {
+	switch difficulty {
+	case DifficultyEasy, DifficultyMedium, DifficultyHard:
+		return true
+	}
+	return false
+}
+
+// AllowsSolo checks whether the quest may be played without a team.
+func (quest *Quest) AllowsSolo() bool {
+	return quest.Teams == nil || quest.Teams.MinSize <= 1
+}
+
+// NearbyQuest is a quest found around a point; Distance to its start is in meters.
+type NearbyQuest struct {
+	Quest
+	Distance float64 `json:"distance"`
 }
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/Sovianum/arquest-server/geo"
+)
+
+const (
+	QuestsDefaultLimit = 20
+	QuestsMaxLimit     = 100
+
+	QuestSortNewest     = "newest"
+	QuestSortRating     = "rating"
+	QuestSortPopularity = "popularity"
+	QuestSortDistance   = "distance"
+	// QuestSortRelevance orders the quests found by a search query by how well they match it
+	QuestSortRelevance = "relevance"
+)
+
+var invalidCursorErr = errors.New("invalid cursor")
+
+func IsQuestSort(sort string) bool {
+	switch sort {
+	case QuestSortNewest, QuestSortRating, QuestSortPopularity, QuestSortDistance, QuestSortRelevance:
+		return true
+	}
+	return false
+}
+
+// QuestFilter selects a page of the quest listing. Zero values turn the filters off: quests
+// must have all of Tags, one of Difficulties, a duration within the given bounds, a rating
+// of at least MinRating and match the full-text Query. Center is required to sort by
+// distance and adds the distance to every quest with a location.
+type QuestFilter struct {
+	Tags         []string
+	Difficulties []string
+	MinDuration  int
+	MaxDuration  int
+	MinRating    float64
+	Query        string
+	Center       *geo.Point
+
+	Sort   string
+	Cursor *QuestCursor
+	Limit  int
+}
+
+// QuestCursor points at the last quest of a page: the next page starts after the quest with
+// sort key Key and id ID in the listing sorted by Sort.
+type QuestCursor struct {
+	Sort string  `json:"s"`
+	Key  float64 `json:"k"`
+	ID   int     `json:"i"`
+}
+
+// Encode turns the cursor into an opaque string safe to pass in URLs.
+func (cursor QuestCursor) Encode() string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func ParseQuestCursor(value string) (*QuestCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, invalidCursorErr
+	}
+	cursor := new(QuestCursor)
+	if err := json.Unmarshal(data, cursor); err != nil || !IsQuestSort(cursor.Sort) || cursor.ID <= 0 {
+		return nil, invalidCursorErr
+	}
+	return cursor, nil
+}
+
+// ListedQuest is a quest in the listing; Distance to its start in meters is given only when
+// the listing is made around a point and the quest has a location.
+type ListedQuest struct {
+	Quest
+	Distance *float64 `json:"distance,omitempty"`
+}
+
+// QuestPage is a page of the quest listing; NextCursor is empty on the last page.
+type QuestPage struct {
+	Quests     []ListedQuest `json:"quests"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestQuestCursor_RoundTrip(t *testing.T) {
+	cursor := QuestCursor{Sort: QuestSortDistance, Key: -1250.5, ID: 42}
+	parsed, err := ParseQuestCursor(cursor.Encode())
+	assert.NoError(t, err)
+	assert.Equal(t, &cursor, parsed)
+}
+
+func TestParseQuestCursor_Invalid(t *testing.T) {
+	for _, value := range []string{
+		"not base64!",
+		base64.RawURLEncoding.EncodeToString([]byte("not json")),
+		QuestCursor{Sort: "oldest", Key: 1, ID: 1}.Encode(),
+		QuestCursor{Sort: QuestSortRating, Key: 1}.Encode(),
+	} {
+		_, err := ParseQuestCursor(value)
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,87 @@
+package model
+
+import (
+	"github.com/Sovianum/arquest-server/geo"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestQuest_Validate_Success(t *testing.T) {
+	q := Quest{Name: strings.Repeat("я", QuestMaxNameLength), Description: strings.Repeat("д", QuestMaxDescriptionLength)}
+	assert.Nil(t, q.Validate())
+}
+
+func TestQuest_Validate_NoName(t *testing.T) {
+	q := Quest{Name: "  ", Description: "description"}
+	err := q.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, QuestRequiredName, err.Error())
+}
+
+func TestQuest_Validate_TooLong(t *testing.T) {
+	q := Quest{Name: strings.Repeat("n", QuestMaxNameLength+1), Description: strings.Repeat("d", QuestMaxDescriptionLength+1)}
+	err := q.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "\"name\"")
+	assert.Contains(t, err.Error(), "\"description\"")
+}
+
+func TestQuest_Validate_Location(t *testing.T) {
+	q := Quest{
+		Name:     "name",
+		Location: &geo.Point{Lat: 55.75, Lon: 37.61},
+		Area:     &geo.Rect{MinLat: 55.7, MinLon: 37.5, MaxLat: 55.8, MaxLon: 37.7},
+	}
+	assert.Nil(t, q.Validate())
+
+	q.Location = &geo.Point{Lat: 91, Lon: 37.61}
+	assert.Contains(t, q.Validate().Error(), "\"location\"")
+
+	q.Location = &geo.Point{Lat: 55.9, Lon: 37.61}
+	assert.Contains(t, q.Validate().Error(), "inside \"area\"")
+
+	q.Location = nil
+	assert.Contains(t, q.Validate().Error(), "requires \"location\"")
+}
+
+func TestQuest_Validate_Teams(t *testing.T) {
+	q := Quest{Name: "name", Teams: &TeamLimits{MinSize: 2, MaxSize: 4}}
+	assert.Nil(t, q.Validate())
+	assert.False(t, q.AllowsSolo())
+	assert.True(t, q.Teams.Allows(4))
+	assert.False(t, q.Teams.Allows(5))
+
+	q.Teams = &TeamLimits{MinSize: 1, MaxSize: QuestMaxTeamSize + 1}
+	assert.Contains(t, q.Validate().Error(), "\"teams\"")
+
+	q.Teams = &TeamLimits{MinSize: 1, MaxSize: 3}
+	assert.True(t, q.AllowsSolo())
+}
+
+func TestQuest_Validate_ListingFields(t *testing.T) {
+	q := Quest{Name: "name", Tags: []string{"прогулка", "history", "18-century"}, Difficulty: DifficultyHard, DurationMinutes: 90}
+	assert.Nil(t, q.Validate())
+
+	q.Tags = []string{"Walk", "two words", "-walk", strings.Repeat("t", QuestMaxTagLength+1)}
+	err := q.Validate()
+	assert.NotNil(t, err)
+	assert.Equal(t, 4, strings.Count(err.Error(), "must be lowercase"))
+
+	q.Tags = []string{"walk", "walk"}
+	assert.Contains(t, q.Validate().Error(), "given twice")
+
+	q.Tags = make([]string, QuestMaxTags+1)
+	for i := range q.Tags {
+		q.Tags[i] = strings.Repeat("t", i+1)
+	}
+	assert.Contains(t, q.Validate().Error(), "more than")
+
+	q.Tags = nil
+	q.Difficulty = "extreme"
+	assert.Contains(t, q.Validate().Error(), "\"difficulty\"")
+
+	q.Difficulty = ""
+	q.DurationMinutes = QuestMaxDurationMinutes + 1
+	assert.Contains(t, q.Validate().Error(), "\"duration_minutes\"")
+}
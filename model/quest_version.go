@@ -0,0 +1,69 @@
+package model
+
+import "sort"
+
+const (
+	QuestVersionMaxChangelogLength = 2000
+)
+
+// QuestVersion is an immutable snapshot of the quest content. Files is empty for
+// versions uploaded before versioning was introduced.
+type QuestVersion struct {
+	QuestID       int         `json:"quest_id"`
+	Version       int         `json:"version"`
+	Changelog     string      `json:"changelog"`
+	ArchiveSize   int64       `json:"archive_size"`
+	ArchiveSHA256 string      `json:"archive_sha256"`
+	AuthorID      int         `json:"author_id,omitempty"`
+	CreatedAt     QuotedTime  `json:"created_at"`
+	Published     bool        `json:"published"`
+	Files         []QuestFile `json:"files,omitempty"`
+
+	ArchiveKey string `json:"-"`
+}
+
+type QuestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	URL    string `json:"url,omitempty"`
+}
+
+// QuestDiff lists the files a client with version From has to download (Added, Changed)
+// and delete (Removed) to get version To. Full is set when the file lists of the versions
+// are unknown and the whole archive has to be downloaded instead.
+type QuestDiff struct {
+	From    int         `json:"from"`
+	To      int         `json:"to"`
+	Full    bool        `json:"full"`
+	Added   []QuestFile `json:"added"`
+	Changed []QuestFile `json:"changed"`
+	Removed []QuestFile `json:"removed"`
+}
+
+// DiffFiles compares two file lists by path and content hash. The result is sorted by path.
+func DiffFiles(from, to []QuestFile) (added, changed, removed []QuestFile) {
+	added, changed, removed = make([]QuestFile, 0), make([]QuestFile, 0), make([]QuestFile, 0)
+
+	old := make(map[string]QuestFile, len(from))
+	for _, file := range from {
+		old[file.Path] = file
+	}
+	for _, file := range to {
+		prev, ok := old[file.Path]
+		if !ok {
+			added = append(added, file)
+		} else if prev.SHA256 != file.SHA256 {
+			changed = append(changed, file)
+		}
+		delete(old, file.Path)
+	}
+	for _, file := range old {
+		removed = append(removed, file)
+	}
+
+	for _, list := range [][]QuestFile{added, changed, removed} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	}
+	return added, changed, removed
+}
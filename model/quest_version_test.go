@@ -0,0 +1,23 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDiffFiles(t *testing.T) {
+	from := []QuestFile{{Path: "a", SHA256: "1"}, {Path: "b", SHA256: "2"}, {Path: "c", SHA256: "3"}}
+	to := []QuestFile{{Path: "d", SHA256: "4"}, {Path: "b", SHA256: "5"}, {Path: "a", SHA256: "1"}}
+
+	added, changed, removed := DiffFiles(from, to)
+	assert.Equal(t, []QuestFile{{Path: "d", SHA256: "4"}}, added)
+	assert.Equal(t, []QuestFile{{Path: "b", SHA256: "5"}}, changed)
+	assert.Equal(t, []QuestFile{{Path: "c", SHA256: "3"}}, removed)
+}
+
+func TestDiffFiles_Install(t *testing.T) {
+	added, changed, removed := DiffFiles(nil, []QuestFile{{Path: "b"}, {Path: "a"}})
+	assert.Equal(t, []QuestFile{{Path: "a"}, {Path: "b"}}, added)
+	assert.Empty(t, changed)
+	assert.NotNil(t, removed)
+}
@@ -0,0 +1,129 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	ReviewMaxTextLength  = 4000
+	ReviewMaxPhotos      = 5
+	ReplyMaxTextLength   = 2000
+	ReviewsDefaultLimit  = 20
+	ReviewsMaxLimit      = 100
+	ReviewSortRecent     = "recent"
+	ReviewSortHelpful    = "helpful"
+	languageTagMaxLength = 16
+)
+
+// languageTag accepts BCP 47 tags like "ru", "en-US" or "zh-Hant"
+var languageTag = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// Review is a written opinion of a player attached to the mark the player gave the quest.
+// A player has at most one review of a quest; it is edited in place.
+type Review struct {
+	ID           int           `json:"id"`
+	QuestID      int           `json:"quest_id"`
+	UserID       int           `json:"user_id"`
+	Login        string        `json:"login"`
+	Mark         float32       `json:"mark"`
+	Text         string        `json:"text"`
+	Language     string        `json:"language,omitempty"`
+	Photos       []Photo       `json:"photos"`
+	HelpfulCount int           `json:"helpful_count"`
+	Replies      []ReviewReply `json:"replies"`
+	CreatedAt    QuotedTime    `json:"created_at"`
+	UpdatedAt    QuotedTime    `json:"updated_at"`
+	// Hidden reviews are held for moderation or rejected; only their authors see them
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+// Validate checks the fields written by the player; photos are referenced by id only.
+func (review *Review) Validate() error {
+	var msgList []string
+	if strings.TrimSpace(review.Text) == "" {
+		msgList = append(msgList, "\"text\" field required")
+	}
+	if utf8.RuneCountInString(review.Text) > ReviewMaxTextLength {
+		msgList = append(msgList, fmt.Sprintf("\"text\" must not be longer than %d characters", ReviewMaxTextLength))
+	}
+	if review.Language != "" && (len(review.Language) > languageTagMaxLength || !languageTag.MatchString(review.Language)) {
+		msgList = append(msgList, "\"language\" must be a language tag like \"en\" or \"pt-BR\"")
+	}
+	if len(review.Photos) > ReviewMaxPhotos {
+		msgList = append(msgList, fmt.Sprintf("a review can not have more than %d photos", ReviewMaxPhotos))
+	}
+	seen := make(map[int]bool, len(review.Photos))
+	for _, photo := range review.Photos {
+		if seen[photo.ID] {
+			msgList = append(msgList, fmt.Sprintf("photo %d is attached twice", photo.ID))
+		}
+		seen[photo.ID] = true
+	}
+
+	if len(msgList) > 0 {
+		return errors.New(strings.Join(msgList, ";\n"))
+	}
+	return nil
+}
+
+// PhotoIDs returns the ids of the photos in their order.
+func (review *Review) PhotoIDs() []int {
+	ids := make([]int, len(review.Photos))
+	for i, photo := range review.Photos {
+		ids[i] = photo.ID
+	}
+	return ids
+}
+
+// ReviewReply is a message in the thread under a review, written by the quest author or by
+// the author of the review.
+type ReviewReply struct {
+	ID        int        `json:"id"`
+	ReviewID  int        `json:"review_id"`
+	UserID    int        `json:"user_id"`
+	Login     string     `json:"login"`
+	Text      string     `json:"text"`
+	CreatedAt QuotedTime `json:"created_at"`
+	Hidden    bool       `json:"hidden,omitempty"`
+}
+
+func (reply *ReviewReply) Validate() error {
+	if strings.TrimSpace(reply.Text) == "" {
+		return errors.New("\"text\" field required")
+	}
+	if utf8.RuneCountInString(reply.Text) > ReplyMaxTextLength {
+		return fmt.Errorf("\"text\" must not be longer than %d characters", ReplyMaxTextLength)
+	}
+	return nil
+}
+
+// Photo is an image uploaded by a player to be attached to reviews.
+type Photo struct {
+	ID          int    `json:"id"`
+	URL         string `json:"url,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+
+	UserID int `json:"-"`
+}
+
+// ReviewFilter selects a page of the reviews of a quest.
+type ReviewFilter struct {
+	Language string
+	Sort     string
+	Limit    int
+	Offset   int
+}
+
+type ReviewPage struct {
+	QuestID int      `json:"quest_id"`
+	Total   int      `json:"total"`
+	Limit   int      `json:"limit"`
+	Offset  int      `json:"offset"`
+	Reviews []Review `json:"reviews"`
+}
@@ -0,0 +1,29 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestReview_Validate(t *testing.T) {
+	review := Review{Text: "nice", Language: "pt-BR", Photos: []Photo{{ID: 1}, {ID: 2}}}
+	assert.NoError(t, review.Validate())
+	assert.Equal(t, []int{1, 2}, review.PhotoIDs())
+
+	for _, invalid := range []Review{
+		{Text: " "},
+		{Text: strings.Repeat("ы", ReviewMaxTextLength+1)},
+		{Text: "nice", Language: "english"},
+		{Text: "nice", Photos: make([]Photo, ReviewMaxPhotos+1)},
+		{Text: "nice", Photos: []Photo{{ID: 1}, {ID: 1}}},
+	} {
+		assert.Error(t, invalid.Validate(), invalid.Text)
+	}
+}
+
+func TestReviewReply_Validate(t *testing.T) {
+	assert.NoError(t, (&ReviewReply{Text: "thanks"}).Validate())
+	assert.Error(t, (&ReviewReply{Text: ""}).Validate())
+	assert.Error(t, (&ReviewReply{Text: strings.Repeat("a", ReplyMaxTextLength+1)}).Validate())
+}
@@ -0,0 +1,23 @@
+package model
+
+const (
+	RolePlayer    = "player"
+	RoleAuthor    = "author"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roles = []string{RolePlayer, RoleAuthor, RoleModerator, RoleAdmin}
+
+type RoleRequest struct {
+	Role string `json:"role"`
+}
+
+func IsValidRole(role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
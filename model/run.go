@@ -0,0 +1,110 @@
+package model
+
+import "time"
+
+const (
+	LeaderboardDay  = "day"
+	LeaderboardWeek = "week"
+	LeaderboardAll  = "all"
+
+	AttemptActive    = "active"
+	AttemptFinished  = "finished"
+	AttemptAbandoned = "abandoned"
+)
+
+// Run is the timing and the score of a quest attempt; it is embedded in Attempt. Attempts
+// started before the start time was recorded have no StartedAt and ElapsedSeconds.
+type Run struct {
+	StartedAt       *QuotedTime `json:"started_at,omitempty"`
+	FinishedAt      *QuotedTime `json:"finished_at,omitempty"`
+	ElapsedSeconds  int         `json:"elapsed_seconds"`
+	HintsUsed       int         `json:"hints_used"`
+	HintPenalty     int         `json:"hint_penalty"`
+	OptionalReached int         `json:"optional_reached"`
+	Score           int         `json:"score"`
+}
+
+// ScoreRules score a run: MaxScore less the hint penalty and TimePenaltyPerMinute for every
+// full minute of the run plus OptionalBonus for every optional checkpoint reached.
+type ScoreRules struct {
+	MaxScore             int
+	TimePenaltyPerMinute int
+	OptionalBonus        int
+}
+
+// Score returns the score of the run, never below zero.
+func (rules ScoreRules) Score(run Run) int {
+	score := rules.MaxScore - run.HintPenalty - rules.TimePenaltyPerMinute*(run.ElapsedSeconds/60) +
+		rules.OptionalBonus*run.OptionalReached
+	if score < 0 {
+		return 0
+	}
+	return score
+}
+
+// Attempt is a pass of the quest by the player. A player has at most one active attempt of
+// a quest; it ends when the quest is finished or the attempt is abandoned. Only finished
+// attempts have a score.
+type Attempt struct {
+	ID      int `json:"id"`
+	QuestID int `json:"quest_id"`
+	// TeamID is set for team attempts shared by the members of the team
+	TeamID  int         `json:"team_id,omitempty"`
+	Outcome string      `json:"outcome"`
+	EndedAt *QuotedTime `json:"ended_at,omitempty"`
+	Run
+}
+
+// FinishedQuest is a quest completed by the player together with the best run of the player.
+type FinishedQuest struct {
+	Quest
+	Run
+}
+
+// LeaderboardEntry is the best run of a player or of a team in the leaderboard; runs with
+// equal score and time share the rank. Own marks the runs of the user asking for it.
+type LeaderboardEntry struct {
+	Rank   int    `json:"rank"`
+	UserID int    `json:"user_id,omitempty"`
+	Login  string `json:"login,omitempty"`
+	TeamID int    `json:"team_id,omitempty"`
+	Team   string `json:"team,omitempty"`
+	Own    bool   `json:"-"`
+	Run
+}
+
+// Leaderboard lists the best solo or team runs of the quest finished in the window. Own is
+// the best run of the user who asked for the leaderboard, also when it is not among the Entries.
+type Leaderboard struct {
+	QuestID int                `json:"quest_id"`
+	Window  string             `json:"window"`
+	Friends bool               `json:"friends"`
+	Teams   bool               `json:"teams"`
+	Entries []LeaderboardEntry `json:"entries"`
+	Own     *LeaderboardEntry  `json:"own,omitempty"`
+}
+
+// IsValidLeaderboardWindow checks the leaderboard window name.
+func IsValidLeaderboardWindow(window string) bool {
+	return window == LeaderboardDay || window == LeaderboardWeek || window == LeaderboardAll
+}
+
+// LeaderboardSince returns the start of the window containing now: the UTC day, the UTC week
+// starting on Monday or the zero time for all time.
+func LeaderboardSince(window string, now time.Time) time.Time {
+	day := now.UTC().Truncate(24 * time.Hour)
+	switch window {
+	case LeaderboardDay:
+		return day
+	case LeaderboardWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	}
+	return time.Time{}
+}
+
+// Friend is a user whose runs are shown in friends-only leaderboards.
+type Friend struct {
+	ID        int        `json:"id"`
+	Login     string     `json:"login"`
+	CreatedAt QuotedTime `json:"created_at"`
+}
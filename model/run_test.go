@@ -0,0 +1,30 @@
+package model
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestScoreRules_Score(t *testing.T) {
+	rules := ScoreRules{MaxScore: 1000, TimePenaltyPerMinute: 5, OptionalBonus: 50}
+	assert.Equal(t, 1000, rules.Score(Run{ElapsedSeconds: 59}))
+	assert.Equal(t, 700, rules.Score(Run{ElapsedSeconds: 630, HintPenalty: 300, OptionalReached: 1}))
+	assert.Equal(t, 0, rules.Score(Run{HintPenalty: 1200}))
+}
+
+func TestScoreRules_ScoreHintPenalty(t *testing.T) {
+	rules := ScoreRules{MaxScore: 1000}
+	assert.Equal(t, 700, rules.Score(Run{HintPenalty: 300}))
+	assert.Equal(t, 0, rules.Score(Run{HintPenalty: 1200}))
+}
+
+func TestLeaderboardSince(t *testing.T) {
+	now := time.Date(2018, 5, 3, 15, 30, 0, 0, time.UTC) // Thursday
+	assert.Equal(t, time.Date(2018, 5, 3, 0, 0, 0, 0, time.UTC), LeaderboardSince(LeaderboardDay, now))
+	assert.Equal(t, time.Date(2018, 4, 30, 0, 0, 0, 0, time.UTC), LeaderboardSince(LeaderboardWeek, now))
+	assert.True(t, LeaderboardSince(LeaderboardAll, now).IsZero())
+
+	sunday := time.Date(2018, 5, 6, 23, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2018, 4, 30, 0, 0, 0, 0, time.UTC), LeaderboardSince(LeaderboardWeek, sunday))
+}
@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+// QuestScenario is the scenario source uploaded by the author of the quest.
+type QuestScenario struct {
+	QuestID   int        `json:"quest_id"`
+	Format    string     `json:"format"`
+	Source    string     `json:"source"`
+	UpdatedAt QuotedTime `json:"updated_at"`
+}
+
+// ScenarioState is the position of a player in the quest scenario. Version is 0 until the
+// state is stored and grows with every transition.
+type ScenarioState struct {
+	State   string
+	Items   []string
+	Timers  map[string]time.Time
+	Final   bool
+	Version int
+}
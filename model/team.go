@@ -0,0 +1,65 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	TeamMaxNameLength = 50
+	// QuestMaxTeamSize is the largest team size an author may allow
+	QuestMaxTeamSize = 20
+)
+
+// Team is a group of players who play quests together. Players join a team with its invite
+// code; members can not join or leave while the team is playing a quest.
+type Team struct {
+	ID         int          `json:"id"`
+	Name       string       `json:"name"`
+	InviteCode string       `json:"invite_code"`
+	CreatedAt  QuotedTime   `json:"created_at"`
+	Members    []TeamMember `json:"members,omitempty"`
+}
+
+// Validate checks the fields chosen by the player creating the team.
+func (team *Team) Validate() error {
+	if strings.TrimSpace(team.Name) == "" {
+		return errors.New("\"name\" field required")
+	}
+	if utf8.RuneCountInString(team.Name) > TeamMaxNameLength {
+		return fmt.Errorf("\"name\" must not be longer than %d characters", TeamMaxNameLength)
+	}
+	return nil
+}
+
+type TeamMember struct {
+	UserID   int        `json:"user_id"`
+	Login    string     `json:"login"`
+	JoinedAt QuotedTime `json:"joined_at"`
+}
+
+// TeamInvite is what a player sends to join a team.
+type TeamInvite struct {
+	InviteCode string `json:"invite_code"`
+}
+
+// TeamLimits bound the number of members of the teams playing a quest. Quests without limits
+// are played solo only; quests with MinSize above one are played by teams only.
+type TeamLimits struct {
+	MinSize int `json:"min_size"`
+	MaxSize int `json:"max_size"`
+}
+
+func (limits TeamLimits) Validate() error {
+	if limits.MinSize < 1 || limits.MinSize > limits.MaxSize || limits.MaxSize > QuestMaxTeamSize {
+		return fmt.Errorf("team sizes must satisfy 1 <= min_size <= max_size <= %d", QuestMaxTeamSize)
+	}
+	return nil
+}
+
+// Allows checks whether a team of size members may play the quest.
+func (limits TeamLimits) Allows(size int) bool {
+	return limits.MinSize <= size && size <= limits.MaxSize
+}
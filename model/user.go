@@ -17,12 +17,13 @@ const (
 )
 
 type User struct {
-	Id       int    `json:"id"`
-	Login    string `json:"login"`
-	Password string `json:"password,omitempty"`
-	Age      int    `json:"age"`
-	Sex      string `json:"sex"`
-	About    string `json:"about"`
+	Id       int      `json:"id"`
+	Login    string   `json:"login"`
+	Password string   `json:"password,omitempty"`
+	Age      int      `json:"age"`
+	Sex      string   `json:"sex"`
+	About    string   `json:"about"`
+	Roles    []string `json:"roles,omitempty"`
 }
 
 func (user *User) UnmarshalJSON(data []byte) error {
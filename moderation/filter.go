@@ -0,0 +1,150 @@
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/Sovianum/arquest-server/config"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Prefixes of the flags raised by the filters. A flag is the prefix followed by what was found.
+const (
+	FlagBannedWord = "banned_word"
+	FlagLink       = "link"
+
+	// AnyLanguage is the key of the word list applied to texts in every language
+	AnyLanguage = "*"
+)
+
+// linkPattern finds URLs with a scheme, www. addresses and bare domains in popular zones.
+// Bare domains are limited to known zones so that a missing space after a full stop is not a link.
+var linkPattern = regexp.MustCompile(
+	`(?i)\b(?:https?://|www\.)[^\s]+|\b(?:[a-z0-9-]+\.)+(?:com|net|org|info|biz|io|me|co|ru|su|xyz|top|site|online|link|app)\b(?:/[^\s]*)?`,
+)
+
+// Filter checks a text written by a user before others see it. It returns the flags explaining
+// why the text needs a moderator or nothing if the text may be shown at once. Language is the
+// tag of the text language; it is empty if unknown.
+type Filter interface {
+	Check(text, language string) []string
+}
+
+// Chain runs every filter and joins their flags.
+type Chain []Filter
+
+func (chain Chain) Check(text, language string) []string {
+	var flags []string
+	for _, filter := range chain {
+		flags = append(flags, filter.Check(text, language)...)
+	}
+	return flags
+}
+
+// NewFilter builds the filter set up by the config.
+func NewFilter(conf config.ModerationConfig) (Filter, error) {
+	chain := Chain{}
+	if len(conf.WordLists) > 0 {
+		lists := make(map[string][]string, len(conf.WordLists))
+		for language, path := range conf.WordLists {
+			words, err := readWordList(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read word list of language %q: %v", language, err)
+			}
+			lists[language] = words
+		}
+		chain = append(chain, NewWordFilter(lists))
+	}
+	if !conf.AllowLinks {
+		chain = append(chain, LinkFilter{})
+	}
+	return chain, nil
+}
+
+// WordFilter flags texts containing banned words. Words are compared case-insensitively and
+// as whole words, so banned words inside longer words are not flagged.
+type WordFilter struct {
+	lists map[string]map[string]bool
+}
+
+// NewWordFilter takes banned words by language tag; AnyLanguage words apply to every text.
+func NewWordFilter(lists map[string][]string) *WordFilter {
+	filter := &WordFilter{lists: make(map[string]map[string]bool, len(lists))}
+	for language, words := range lists {
+		set := make(map[string]bool, len(words))
+		for _, word := range words {
+			set[strings.ToLower(word)] = true
+		}
+		filter.lists[strings.ToLower(language)] = set
+	}
+	return filter
+}
+
+// Check uses the list of the base language of the text ("pt" for "pt-BR") and the AnyLanguage
+// list. Texts of unknown language are checked against all the lists.
+func (filter *WordFilter) Check(text, language string) []string {
+	lists := filter.listsFor(language)
+	var flags []string
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), isWordSeparator) {
+		if seen[word] {
+			continue
+		}
+		seen[word] = true
+		for _, list := range lists {
+			if list[word] {
+				flags = append(flags, FlagBannedWord+": "+word)
+				break
+			}
+		}
+	}
+	return flags
+}
+
+func (filter *WordFilter) listsFor(language string) []map[string]bool {
+	if language == "" {
+		lists := make([]map[string]bool, 0, len(filter.lists))
+		for _, list := range filter.lists {
+			lists = append(lists, list)
+		}
+		return lists
+	}
+	base := strings.ToLower(strings.SplitN(language, "-", 2)[0])
+	return []map[string]bool{filter.lists[base], filter.lists[AnyLanguage]}
+}
+
+// LinkFilter flags texts with links, which are mostly spam in reviews and names.
+type LinkFilter struct{}
+
+func (LinkFilter) Check(text, language string) []string {
+	var flags []string
+	for _, link := range linkPattern.FindAllString(text, -1) {
+		flags = append(flags, FlagLink+": "+link)
+	}
+	return flags
+}
+
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// readWordList reads one word per line skipping empty lines and lines starting with #.
+func readWordList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	lines := bufio.NewScanner(f)
+	for lines.Scan() {
+		line := strings.TrimSpace(lines.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words = append(words, line)
+		}
+	}
+	return words, lines.Err()
+}
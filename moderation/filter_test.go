@@ -0,0 +1,54 @@
+package moderation
+
+import (
+	"github.com/Sovianum/arquest-server/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWordFilter(t *testing.T) {
+	filter := NewWordFilter(map[string][]string{
+		"ru":        {"Дурак"},
+		"en":        {"idiot"},
+		AnyLanguage: {"casino"},
+	})
+
+	assert.Equal(t, []string{"banned_word: дурак"}, filter.Check("Автор - ДУРАК, дурак!", "ru"))
+	assert.Equal(t, []string{"banned_word: idiot"}, filter.Check("What an idiot", "en-GB"))
+	assert.Empty(t, filter.Check("What an idiot", "ru"))
+	assert.Empty(t, filter.Check("idiotic puzzles", "en"))
+	assert.Equal(t, []string{"banned_word: casino"}, filter.Check("best casino", "de"))
+	assert.Len(t, filter.Check("дурак idiot", ""), 2)
+}
+
+func TestLinkFilter(t *testing.T) {
+	filter := LinkFilter{}
+
+	assert.Equal(t, []string{"link: https://spam.example/x?y=1"}, filter.Check("see https://spam.example/x?y=1 now", ""))
+	assert.Equal(t, []string{"link: www.example.de"}, filter.Check("www.example.de", ""))
+	assert.Equal(t, []string{"link: t.me/channel"}, filter.Check("join t.me/channel", ""))
+	assert.Empty(t, filter.Check("Great quest.It was fun. See you at 5.30", ""))
+}
+
+func TestNewFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "words")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "en.txt")
+	require.NoError(t, ioutil.WriteFile(path, []byte("# banned\nidiot\n\n"), 0600))
+
+	filter, err := NewFilter(config.ModerationConfig{WordLists: map[string]string{"en": path}})
+	require.NoError(t, err)
+	assert.Len(t, filter.Check("idiot at example.com", "en"), 2)
+
+	filter, err = NewFilter(config.ModerationConfig{AllowLinks: true})
+	require.NoError(t, err)
+	assert.Empty(t, filter.Check("example.com", ""))
+
+	_, err = NewFilter(config.ModerationConfig{WordLists: map[string]string{"en": filepath.Join(dir, "missing.txt")}})
+	assert.Error(t, err)
+}
@@ -68,7 +68,7 @@ func TestLogger_LogRequestError(t *testing.T) {
 	)
 
 	errorMsg := "msg"
-	err := fmt.Errorf(errorMsg)
+	err := fmt.Errorf("%s", errorMsg)
 
 	logger.LogRequestError(req, err)
 	msg := string(writer.Bytes())
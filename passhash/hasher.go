@@ -0,0 +1,83 @@
+package passhash
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Sovianum/arquest-server/config"
+)
+
+const (
+	BcryptAlgorithm   = "bcrypt"
+	Argon2idAlgorithm = "argon2id"
+)
+
+var (
+	ErrMismatch      = errors.New("password does not match")
+	ErrUnknownFormat = errors.New("unknown password hash format")
+)
+
+type Hasher interface {
+	Hash(password []byte) ([]byte, error)
+	Verify(password, hash []byte) error
+	// NeedsRehash reports whether hash was produced by an outdated algorithm or with outdated parameters.
+	NeedsRehash(hash []byte) bool
+}
+
+// Scheme is a single hashing algorithm which can recognize its own hashes.
+type Scheme interface {
+	Hasher
+	Matches(hash []byte) bool
+}
+
+// NewHasher returns a Hasher which produces hashes with preferred and
+// verifies hashes of preferred and of every legacy scheme.
+func NewHasher(preferred Scheme, legacy ...Scheme) Hasher {
+	return &multiHasher{
+		preferred: preferred,
+		schemes:   append([]Scheme{preferred}, legacy...),
+	}
+}
+
+func NewHasherFromConfig(conf config.PasswordHashConfig) (Hasher, error) {
+	var preferred Scheme
+	switch conf.Algorithm {
+	case BcryptAlgorithm:
+		preferred = NewBcrypt(conf.BcryptCost)
+	case Argon2idAlgorithm, "":
+		preferred = NewArgon2id(Argon2Params{
+			Time:    conf.Argon2Time,
+			Memory:  conf.Argon2Memory,
+			Threads: conf.Argon2Threads,
+		})
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", conf.Algorithm)
+	}
+
+	legacy := []Scheme{NewBcrypt(conf.BcryptCost), NewArgon2id(Argon2Params{}), NewLegacySHA256()}
+	return NewHasher(preferred, legacy...), nil
+}
+
+type multiHasher struct {
+	preferred Scheme
+	schemes   []Scheme
+}
+
+func (h *multiHasher) Hash(password []byte) ([]byte, error) {
+	return h.preferred.Hash(password)
+}
+
+func (h *multiHasher) Verify(password, hash []byte) error {
+	for _, scheme := range h.schemes {
+		if scheme.Matches(hash) {
+			return scheme.Verify(password, hash)
+		}
+	}
+	return ErrUnknownFormat
+}
+
+func (h *multiHasher) NeedsRehash(hash []byte) bool {
+	if !h.preferred.Matches(hash) {
+		return true
+	}
+	return h.preferred.NeedsRehash(hash)
+}
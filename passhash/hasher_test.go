@@ -0,0 +1,95 @@
+package passhash
+
+import (
+	"crypto/sha256"
+	"github.com/Sovianum/arquest-server/config"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+const (
+	password = "password"
+)
+
+var fastArgon2 = Argon2Params{Time: 1, Memory: 64, Threads: 1}
+
+type HasherTestSuite struct {
+	suite.Suite
+	hasher Hasher
+}
+
+func (s *HasherTestSuite) SetupTest() {
+	s.hasher = NewHasher(NewArgon2id(fastArgon2), NewBcrypt(bcrypt.MinCost), NewLegacySHA256())
+}
+
+func (s *HasherTestSuite) TestArgon2idRoundTrip() {
+	hash, err := s.hasher.Hash([]byte(password))
+	s.Require().NoError(err)
+	s.True(strings.HasPrefix(string(hash), "$argon2id$v=19$m=64,t=1,p=1$"))
+
+	s.NoError(s.hasher.Verify([]byte(password), hash))
+	s.Equal(ErrMismatch, s.hasher.Verify([]byte("wrong"), hash))
+	s.False(s.hasher.NeedsRehash(hash))
+}
+
+func (s *HasherTestSuite) TestArgon2idSalted() {
+	first, err := s.hasher.Hash([]byte(password))
+	s.Require().NoError(err)
+	second, err := s.hasher.Hash([]byte(password))
+	s.Require().NoError(err)
+	s.NotEqual(first, second)
+}
+
+func (s *HasherTestSuite) TestArgon2idParamsChanged() {
+	hash, err := NewArgon2id(Argon2Params{Time: 2, Memory: 64, Threads: 1}).Hash([]byte(password))
+	s.Require().NoError(err)
+
+	s.NoError(s.hasher.Verify([]byte(password), hash))
+	s.True(s.hasher.NeedsRehash(hash))
+}
+
+func (s *HasherTestSuite) TestBcryptVerified() {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	s.Require().NoError(err)
+
+	s.NoError(s.hasher.Verify([]byte(password), hash))
+	s.Equal(ErrMismatch, s.hasher.Verify([]byte("wrong"), hash))
+	s.True(s.hasher.NeedsRehash(hash))
+}
+
+func (s *HasherTestSuite) TestLegacyVerified() {
+	hash := sha256.Sum256([]byte(password))
+
+	s.NoError(s.hasher.Verify([]byte(password), hash[:]))
+	s.Equal(ErrMismatch, s.hasher.Verify([]byte("wrong"), hash[:]))
+	s.True(s.hasher.NeedsRehash(hash[:]))
+}
+
+func (s *HasherTestSuite) TestUnknownFormat() {
+	s.Equal(ErrUnknownFormat, s.hasher.Verify([]byte(password), []byte("plain")))
+	s.True(s.hasher.NeedsRehash([]byte("plain")))
+}
+
+func (s *HasherTestSuite) TestMalformedArgon2id() {
+	err := s.hasher.Verify([]byte(password), []byte("$argon2id$v=19$m=64,t=1,p=1$salt"))
+	s.Equal(ErrUnknownFormat, err)
+}
+
+func (s *HasherTestSuite) TestFromConfig() {
+	hasher, err := NewHasherFromConfig(config.PasswordHashConfig{Algorithm: BcryptAlgorithm, BcryptCost: bcrypt.MinCost})
+	s.Require().NoError(err)
+
+	hash, err := hasher.Hash([]byte(password))
+	s.Require().NoError(err)
+	s.True(strings.HasPrefix(string(hash), "$2a$"))
+	s.False(hasher.NeedsRehash(hash))
+
+	_, err = NewHasherFromConfig(config.PasswordHashConfig{Algorithm: "md5"})
+	s.Error(err)
+}
+
+func TestHasherTestSuite(t *testing.T) {
+	suite.Run(t, new(HasherTestSuite))
+}
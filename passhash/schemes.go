@@ -0,0 +1,185 @@
+package passhash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	argon2idPrefix = "$argon2id$"
+	argon2idFormat = "$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s"
+
+	defaultArgon2Time    = 3
+	defaultArgon2Memory  = 64 * 1024
+	defaultArgon2Threads = 2
+	argon2SaltLen        = 16
+	argon2KeyLen         = 32
+)
+
+var bcryptPrefixes = [][]byte{[]byte("$2a$"), []byte("$2b$"), []byte("$2y$")}
+
+type Argon2Params struct {
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+}
+
+func (p Argon2Params) withDefaults() Argon2Params {
+	if p.Time == 0 {
+		p.Time = defaultArgon2Time
+	}
+	if p.Memory == 0 {
+		p.Memory = defaultArgon2Memory
+	}
+	if p.Threads == 0 {
+		p.Threads = defaultArgon2Threads
+	}
+	return p
+}
+
+// NewArgon2id returns a scheme storing hashes in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+func NewArgon2id(params Argon2Params) Scheme {
+	return &argon2idScheme{params: params.withDefaults()}
+}
+
+type argon2idScheme struct {
+	params Argon2Params
+}
+
+func (s *argon2idScheme) Hash(password []byte) ([]byte, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey(password, salt, s.params.Time, s.params.Memory, s.params.Threads, argon2KeyLen)
+	return []byte(fmt.Sprintf(
+		argon2idFormat,
+		argon2.Version,
+		s.params.Memory, s.params.Time, s.params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)), nil
+}
+
+func (s *argon2idScheme) Verify(password, hash []byte) error {
+	params, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	actual := argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (s *argon2idScheme) NeedsRehash(hash []byte) bool {
+	params, _, _, err := decodeArgon2id(hash)
+	return err != nil || params != s.params
+}
+
+func (s *argon2idScheme) Matches(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte(argon2idPrefix))
+}
+
+func decodeArgon2id(hash []byte) (Argon2Params, []byte, []byte, error) {
+	params := Argon2Params{}
+	parts := bytes.Split(hash, []byte("$"))
+	if len(parts) != 6 {
+		return params, nil, nil, ErrUnknownFormat
+	}
+
+	version := 0
+	if _, err := fmt.Sscanf(string(parts[2]), "v=%d", &version); err != nil {
+		return params, nil, nil, ErrUnknownFormat
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err := fmt.Sscanf(string(parts[3]), "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads); err != nil {
+		return params, nil, nil, ErrUnknownFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(string(parts[4]))
+	if err != nil {
+		return params, nil, nil, ErrUnknownFormat
+	}
+	key, err := base64.RawStdEncoding.DecodeString(string(parts[5]))
+	if err != nil {
+		return params, nil, nil, ErrUnknownFormat
+	}
+	return params, salt, key, nil
+}
+
+func NewBcrypt(cost int) Scheme {
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+	return &bcryptScheme{cost: cost}
+}
+
+type bcryptScheme struct {
+	cost int
+}
+
+func (s *bcryptScheme) Hash(password []byte) ([]byte, error) {
+	return bcrypt.GenerateFromPassword(password, s.cost)
+}
+
+func (s *bcryptScheme) Verify(password, hash []byte) error {
+	err := bcrypt.CompareHashAndPassword(hash, password)
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrMismatch
+	}
+	return err
+}
+
+func (s *bcryptScheme) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != s.cost
+}
+
+func (s *bcryptScheme) Matches(hash []byte) bool {
+	for _, prefix := range bcryptPrefixes {
+		if bytes.HasPrefix(hash, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// NewLegacySHA256 returns the unsalted scheme used before adaptive hashing was introduced.
+// It is kept only to verify old hashes, which are always reported as needing a rehash.
+func NewLegacySHA256() Scheme {
+	return legacySHA256Scheme{}
+}
+
+type legacySHA256Scheme struct{}
+
+func (legacySHA256Scheme) Hash(password []byte) ([]byte, error) {
+	sum := sha256.Sum256(password)
+	return sum[:], nil
+}
+
+func (s legacySHA256Scheme) Verify(password, hash []byte) error {
+	expected, _ := s.Hash(password)
+	if subtle.ConstantTimeCompare(expected, hash) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (legacySHA256Scheme) NeedsRehash(hash []byte) bool {
+	return true
+}
+
+func (legacySHA256Scheme) Matches(hash []byte) bool {
+	return len(hash) == sha256.Size
+}
@@ -0,0 +1,146 @@
+package proof
+
+import (
+	"fmt"
+	"github.com/Sovianum/arquest-server/config"
+	"github.com/Sovianum/arquest-server/geo"
+	"github.com/Sovianum/arquest-server/model"
+	"strings"
+	"time"
+)
+
+// Reasons of rejected proofs. They are stored with failed attempts and returned to the client.
+const (
+	ReasonMissingProof     = "missing_proof"
+	ReasonNotConfigured    = "not_configured"
+	ReasonOutsideGeofence  = "outside_geofence"
+	ReasonLowAccuracy      = "low_accuracy"
+	ReasonStaleFix         = "stale_fix"
+	ReasonFutureFix        = "future_fix"
+	ReasonImplausibleSpeed = "implausible_speed"
+	ReasonWrongMarker      = "wrong_marker"
+	ReasonMalformedQR      = "malformed_qr"
+	ReasonForeignQR        = "foreign_qr"
+	ReasonInvalidSignature = "invalid_signature"
+	ReasonExpiredQR        = "expired_qr"
+	ReasonUsedQR           = "used_qr"
+	ReasonWrongAnswer      = "wrong_answer"
+)
+
+// Rejection explains why a proof does not pass a checkpoint.
+type Rejection struct {
+	Reason  string
+	Details string
+}
+
+func (r *Rejection) Error() string {
+	if r.Details == "" {
+		return r.Reason
+	}
+	return r.Reason + ": " + r.Details
+}
+
+func reject(reason string, format string, args ...interface{}) *Rejection {
+	return &Rejection{Reason: reason, Details: fmt.Sprintf(format, args...)}
+}
+
+type Verifier struct {
+	maxAccuracy  float64
+	maxSpeed     float64
+	maxFixAge    time.Duration
+	maxClockSkew time.Duration
+	now          func() time.Time
+}
+
+func NewVerifier(conf config.ProofConfig) *Verifier {
+	return &Verifier{
+		maxAccuracy:  conf.GetMaxAccuracy(),
+		maxSpeed:     conf.GetMaxSpeed(),
+		maxFixAge:    conf.GetMaxFixAge(),
+		maxClockSkew: conf.GetMaxClockSkew(),
+		now:          time.Now,
+	}
+}
+
+// Verify checks proof against the checkpoint. lastFix is the last accepted GPS fix of the
+// player in the quest, it is used to detect teleportation; nil means there is none.
+func (v *Verifier) Verify(checkpoint model.Checkpoint, proof model.CheckpointProof, lastFix *model.GPSFix) *Rejection {
+	switch checkpoint.Type {
+	case model.CheckpointGPS:
+		return v.verifyFix(checkpoint, proof.Fix, lastFix)
+	case model.CheckpointARMarker:
+		if proof.MarkerID == "" {
+			return reject(ReasonMissingProof, "marker_id required")
+		}
+		if proof.MarkerID != checkpoint.MarkerID {
+			return reject(ReasonWrongMarker, "marker %q does not belong to the checkpoint", proof.MarkerID)
+		}
+		return nil
+	case model.CheckpointQR:
+		if proof.QR == "" {
+			return reject(ReasonMissingProof, "qr required")
+		}
+		if len(checkpoint.QRSecret) == 0 {
+			return reject(ReasonNotConfigured, "checkpoint has no QR secret, the author has to save the checkpoints again")
+		}
+		_, rejection := VerifyQR(checkpoint.QRSecret, checkpoint.QuestID, checkpoint.ID, proof.QR, v.now())
+		return rejection
+	case model.CheckpointAnswer:
+		if strings.TrimSpace(proof.Answer) == "" {
+			return reject(ReasonMissingProof, "answer required")
+		}
+		if len(checkpoint.AnswerHashes) == 0 {
+			return reject(ReasonNotConfigured, "checkpoint has no answers")
+		}
+		if !MatchAnswer(checkpoint.AnswerSalt, checkpoint.AnswerHashes, proof.Answer) {
+			return &Rejection{Reason: ReasonWrongAnswer}
+		}
+		return nil
+	default:
+		return reject(ReasonNotConfigured, "unknown checkpoint type %q", checkpoint.Type)
+	}
+}
+
+func (v *Verifier) verifyFix(checkpoint model.Checkpoint, fix *model.GPSFix, lastFix *model.GPSFix) *Rejection {
+	if fix == nil {
+		return reject(ReasonMissingProof, "fix required")
+	}
+	if err := fix.Validate(); err != nil {
+		return reject(ReasonMissingProof, "%v", err)
+	}
+	if checkpoint.Location == nil {
+		return reject(ReasonNotConfigured, "checkpoint has no location")
+	}
+	if fix.Accuracy <= 0 || fix.Accuracy > v.maxAccuracy {
+		return reject(ReasonLowAccuracy, "accuracy %.0f m, at most %.0f m allowed", fix.Accuracy, v.maxAccuracy)
+	}
+
+	now := v.now()
+	fixTime := time.Time(fix.Time)
+	if fixTime.After(now.Add(v.maxClockSkew)) {
+		return reject(ReasonFutureFix, "fix time %v is ahead of server time %v", fixTime.UTC(), now.UTC())
+	}
+	if fixTime.Before(now.Add(-v.maxFixAge)) {
+		return reject(ReasonStaleFix, "fix is older than %v", v.maxFixAge)
+	}
+
+	if distance := geo.Distance(fix.Point, *checkpoint.Location); distance > checkpoint.Radius {
+		return reject(ReasonOutsideGeofence, "%.0f m from the checkpoint, radius %.0f m", distance, checkpoint.Radius)
+	}
+
+	if lastFix != nil {
+		lastTime := time.Time(lastFix.Time)
+		if fixTime.Before(lastTime) {
+			return reject(ReasonStaleFix, "fix is older than the previous accepted fix")
+		}
+		distance := geo.Distance(lastFix.Point, fix.Point)
+		// a second of slack keeps fixes taken almost at once from dividing by zero
+		seconds := fixTime.Sub(lastTime).Seconds() + 1
+		if speed := distance / seconds; speed > v.maxSpeed {
+			return reject(
+				ReasonImplausibleSpeed, "%.0f m in %.0f s since the previous checkpoint (%.1f m/s)", distance, seconds, speed,
+			)
+		}
+	}
+	return nil
+}
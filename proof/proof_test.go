@@ -0,0 +1,131 @@
+package proof
+
+import (
+	"github.com/Sovianum/arquest-server/config"
+	"github.com/Sovianum/arquest-server/geo"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ProofTestSuite struct {
+	suite.Suite
+	now      time.Time
+	verifier *Verifier
+	gps      model.Checkpoint
+}
+
+func (s *ProofTestSuite) SetupTest() {
+	s.now = time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.verifier = NewVerifier(config.ProofConfig{})
+	s.verifier.now = func() time.Time { return s.now }
+	s.gps = model.Checkpoint{
+		ID: 2, QuestID: 1, Type: model.CheckpointGPS, Location: &geo.Point{Lat: 55.75, Lon: 37.61}, Radius: 50,
+	}
+}
+
+func (s *ProofTestSuite) fix(lat, lon, accuracy float64, at time.Time) *model.GPSFix {
+	return &model.GPSFix{Point: geo.Point{Lat: lat, Lon: lon}, Accuracy: accuracy, Time: model.QuotedTime(at)}
+}
+
+func (s *ProofTestSuite) reason(rejection *Rejection) string {
+	if rejection == nil {
+		return ""
+	}
+	return rejection.Reason
+}
+
+func (s *ProofTestSuite) TestGPS() {
+	check := func(fix, last *model.GPSFix) string {
+		return s.reason(s.verifier.Verify(s.gps, model.CheckpointProof{Fix: fix}, last))
+	}
+
+	s.Equal("", check(s.fix(55.7502, 37.61, 10, s.now), nil))
+	s.Equal(ReasonMissingProof, check(nil, nil))
+	s.Equal(ReasonOutsideGeofence, check(s.fix(55.76, 37.61, 10, s.now), nil))
+	s.Equal(ReasonLowAccuracy, check(s.fix(55.75, 37.61, 500, s.now), nil))
+	s.Equal(ReasonLowAccuracy, check(s.fix(55.75, 37.61, 0, s.now), nil))
+	s.Equal(ReasonStaleFix, check(s.fix(55.75, 37.61, 10, s.now.Add(-time.Hour)), nil))
+	s.Equal(ReasonFutureFix, check(s.fix(55.75, 37.61, 10, s.now.Add(time.Hour)), nil))
+
+	// 11 km in two minutes is about 90 m/s
+	far := s.fix(55.65, 37.61, 10, s.now.Add(-2*time.Minute))
+	s.Equal(ReasonImplausibleSpeed, check(s.fix(55.75, 37.61, 10, s.now), far))
+	// 1.1 km in two minutes is a run
+	near := s.fix(55.74, 37.61, 10, s.now.Add(-2*time.Minute))
+	s.Equal("", check(s.fix(55.75, 37.61, 10, s.now), near))
+	s.Equal(ReasonStaleFix, check(s.fix(55.75, 37.61, 10, s.now.Add(-3*time.Minute)), near))
+}
+
+func (s *ProofTestSuite) TestMarker() {
+	checkpoint := model.Checkpoint{Type: model.CheckpointARMarker, MarkerID: "statue"}
+	s.Nil(s.verifier.Verify(checkpoint, model.CheckpointProof{MarkerID: "statue"}, nil))
+	s.Equal(ReasonWrongMarker, s.reason(s.verifier.Verify(checkpoint, model.CheckpointProof{MarkerID: "fountain"}, nil)))
+}
+
+func (s *ProofTestSuite) TestQR() {
+	secret, err := NewSecret()
+	s.Require().NoError(err)
+	checkpoint := model.Checkpoint{ID: 2, QuestID: 1, Type: model.CheckpointQR, QRSecret: secret}
+	check := func(payload string) string {
+		return s.reason(s.verifier.Verify(checkpoint, model.CheckpointProof{QR: payload}, nil))
+	}
+
+	s.Equal("", check(SignQR(secret, QRCode{QuestID: 1, CheckpointID: 2})))
+	s.Equal(ReasonForeignQR, check(SignQR(secret, QRCode{QuestID: 1, CheckpointID: 3})))
+	s.Equal(ReasonInvalidSignature, check(SignQR([]byte("other"), QRCode{QuestID: 1, CheckpointID: 2})))
+	s.Equal(ReasonMalformedQR, check("https://example.com"))
+	s.Equal(ReasonMalformedQR, check("ARQ1:1:2:x1:c2lnbmF0dXJl"))
+	s.Equal(ReasonMissingProof, check(""))
+
+	s.Equal("", check(SignQR(secret, QRCode{QuestID: 1, CheckpointID: 2, ExpiresAt: s.now.Add(time.Minute)})))
+	s.Equal(ReasonExpiredQR, check(SignQR(secret, QRCode{QuestID: 1, CheckpointID: 2, ExpiresAt: s.now})))
+
+	// options are covered by the signature
+	signed := SignQR(secret, QRCode{QuestID: 1, CheckpointID: 2, ExpiresAt: s.now.Add(-time.Hour)})
+	forged := strings.Replace(signed, ":e", ":e9", 1)
+	s.Equal(ReasonInvalidSignature, check(forged))
+
+	checkpoint.QRSecret = nil
+	s.Equal(ReasonNotConfigured, check(SignQR(secret, QRCode{QuestID: 1, CheckpointID: 2})))
+}
+
+func (s *ProofTestSuite) TestParseQR() {
+	nonce, err := NewNonce()
+	s.Require().NoError(err)
+	expiresAt := s.now.Add(time.Hour)
+	code := QRCode{QuestID: 1, CheckpointID: 2, ExpiresAt: expiresAt, Nonce: nonce}
+
+	parsed, rejection := ParseQR(SignQR([]byte("secret"), code))
+	s.Require().Nil(rejection)
+	s.Equal(code, parsed)
+
+	parsed, rejection = ParseQR(SignQR([]byte("secret"), QRCode{QuestID: 1, CheckpointID: 2, Nonce: nonce}))
+	s.Require().Nil(rejection)
+	s.Equal(nonce, parsed.Nonce)
+	s.True(parsed.ExpiresAt.IsZero())
+}
+
+func (s *ProofTestSuite) TestAnswer() {
+	salt, err := NewSecret()
+	s.Require().NoError(err)
+	checkpoint := model.Checkpoint{
+		Type:         model.CheckpointAnswer,
+		AnswerSalt:   salt,
+		AnswerHashes: [][]byte{HashAnswer(salt, "Пушкин"), HashAnswer(salt, "A. S. Pushkin")},
+	}
+	check := func(answer string) string {
+		return s.reason(s.verifier.Verify(checkpoint, model.CheckpointProof{Answer: answer}, nil))
+	}
+
+	s.Equal("", check("  пушкин "))
+	s.Equal("", check("a.  s. PUSHKIN"))
+	s.Equal(ReasonWrongAnswer, check("Лермонтов"))
+	s.Equal(ReasonMissingProof, check(" "))
+}
+
+func TestProofTestSuite(t *testing.T) {
+	suite.Run(t, new(ProofTestSuite))
+}
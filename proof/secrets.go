@@ -0,0 +1,153 @@
+package proof
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// qrPrefix starts every QR payload and versions its format
+	qrPrefix = "ARQ1"
+	// qrExpiry and qrNonce start optional parts of the payload
+	qrExpiry = "e"
+	qrNonce  = "n"
+
+	secretBytes = 32
+	nonceBytes  = 16
+)
+
+// NewSecret returns a random key for QR signatures or a salt for answer hashes.
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, secretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// QRCode is what a checkpoint QR code stands for.
+type QRCode struct {
+	QuestID      int
+	CheckpointID int
+	// ExpiresAt is zero for codes without expiry.
+	ExpiresAt time.Time
+	// Nonce is set for one-time codes and identifies the code among all others.
+	Nonce string
+}
+
+// NewNonce returns a random nonce for a one-time code.
+func NewNonce() (string, error) {
+	nonce := make([]byte, nonceBytes)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(nonce), nil
+}
+
+// SignQR returns the QR payload of code: ARQ1:<quest id>:<checkpoint id>[:e<expiry>][:n<nonce>]:<signature>,
+// where expiry is a unix time and signature is the base64url HMAC-SHA256 of the preceding
+// part keyed by the checkpoint secret.
+func SignQR(secret []byte, code QRCode) string {
+	parts := []string{qrPrefix, strconv.Itoa(code.QuestID), strconv.Itoa(code.CheckpointID)}
+	if !code.ExpiresAt.IsZero() {
+		parts = append(parts, qrExpiry+strconv.FormatInt(code.ExpiresAt.Unix(), 10))
+	}
+	if code.Nonce != "" {
+		parts = append(parts, qrNonce+code.Nonce)
+	}
+	message := strings.Join(parts, ":")
+	return message + ":" + base64.RawURLEncoding.EncodeToString(qrSignature(secret, message))
+}
+
+// ParseQR reads the code from payload without checking the signature.
+func ParseQR(payload string) (QRCode, *Rejection) {
+	code, _, _, rejection := parseQR(payload)
+	return code, rejection
+}
+
+func parseQR(payload string) (code QRCode, message string, signature []byte, rejection *Rejection) {
+	malformed := reject(ReasonMalformedQR, "not a quest QR code")
+	parts := strings.Split(strings.TrimSpace(payload), ":")
+	if len(parts) < 4 || len(parts) > 6 || parts[0] != qrPrefix {
+		return code, "", nil, malformed
+	}
+	var questErr, checkpointErr, signatureErr error
+	code.QuestID, questErr = strconv.Atoi(parts[1])
+	code.CheckpointID, checkpointErr = strconv.Atoi(parts[2])
+	signature, signatureErr = base64.RawURLEncoding.DecodeString(parts[len(parts)-1])
+	if questErr != nil || checkpointErr != nil || signatureErr != nil {
+		return code, "", nil, malformed
+	}
+
+	options := parts[3 : len(parts)-1]
+	if len(options) > 0 && strings.HasPrefix(options[0], qrExpiry) {
+		expiry, err := strconv.ParseInt(strings.TrimPrefix(options[0], qrExpiry), 10, 64)
+		if err != nil {
+			return code, "", nil, malformed
+		}
+		code.ExpiresAt = time.Unix(expiry, 0).UTC()
+		options = options[1:]
+	}
+	if len(options) > 0 && strings.HasPrefix(options[0], qrNonce) && len(options[0]) > len(qrNonce) {
+		code.Nonce = strings.TrimPrefix(options[0], qrNonce)
+		options = options[1:]
+	}
+	if len(options) > 0 {
+		return code, "", nil, malformed
+	}
+	return code, strings.Join(parts[:len(parts)-1], ":"), signature, nil
+}
+
+// VerifyQR checks that payload was produced by SignQR for this very checkpoint and has not expired by now.
+func VerifyQR(secret []byte, questID, checkpointID int, payload string, now time.Time) (QRCode, *Rejection) {
+	code, message, signature, rejection := parseQR(payload)
+	if rejection != nil {
+		return code, rejection
+	}
+	if code.QuestID != questID || code.CheckpointID != checkpointID {
+		return code, reject(ReasonForeignQR, "code of checkpoint %d of quest %d", code.CheckpointID, code.QuestID)
+	}
+	if !hmac.Equal(signature, qrSignature(secret, message)) {
+		return code, &Rejection{Reason: ReasonInvalidSignature}
+	}
+	if !code.ExpiresAt.IsZero() && !now.Before(code.ExpiresAt) {
+		return code, reject(ReasonExpiredQR, "code expired at %v", code.ExpiresAt)
+	}
+	return code, nil
+}
+
+func qrSignature(secret []byte, message string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+// HashAnswer returns the salted hash of the normalized answer.
+func HashAnswer(salt []byte, answer string) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(NormalizeAnswer(answer)))
+	return mac.Sum(nil)
+}
+
+// MatchAnswer reports whether answer matches any of the stored hashes.
+func MatchAnswer(salt []byte, hashes [][]byte, answer string) bool {
+	hash := HashAnswer(salt, answer)
+	matched := false
+	for _, stored := range hashes {
+		// no early return so that the time does not depend on which answer matched
+		if hmac.Equal(hash, stored) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// NormalizeAnswer makes answers differing only in case and spacing equal.
+func NormalizeAnswer(answer string) string {
+	return strings.Join(strings.Fields(strings.ToLower(answer)), " ")
+}
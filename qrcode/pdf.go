@@ -0,0 +1,120 @@
+package qrcode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// A4 in points
+	pageWidth  = 595
+	pageHeight = 842
+
+	pdfCodeSide    = 400
+	pdfFontSize    = 14
+	pdfLineSpacing = 20
+)
+
+// Page is a page of a printable sheet: a code with caption lines under it.
+type Page struct {
+	Code    *Code
+	Caption []string
+}
+
+// WritePDF writes the pages as an A4 PDF document, one code per page. Captions are set in
+// the standard Helvetica font, so characters outside ASCII are replaced with '?'.
+func WritePDF(w io.Writer, pages []Page) error {
+	doc := &pdfWriter{}
+	doc.buf.WriteString("%PDF-1.4\n")
+
+	// objects 1-3 are the catalog, the page tree and the font; pages follow in pairs of
+	// the page and its content
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	doc.object("<< /Type /Catalog /Pages 2 0 R >>")
+	doc.object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	doc.object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>")
+	for i, page := range pages {
+		doc.object(fmt.Sprintf(
+			"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 5+2*i,
+		))
+		content := pageContent(page)
+		doc.object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content))
+	}
+	doc.finish()
+
+	_, err := w.Write(doc.buf.Bytes())
+	return err
+}
+
+func pageContent(page Page) string {
+	var content bytes.Buffer
+	side := page.Code.Size + 2*QuietZone
+	module := float64(pdfCodeSide) / float64(side)
+	left := float64(pageWidth-pdfCodeSide) / 2
+	bottom := float64(pageHeight-pdfCodeSide)/2 + 60
+
+	content.WriteString("0 g\n")
+	for y := 0; y < page.Code.Size; y++ {
+		for x := 0; x < page.Code.Size; x++ {
+			if page.Code.Dark(x, y) {
+				// PDF y axis points up
+				fmt.Fprintf(
+					&content, "%.3f %.3f %.3f %.3f re\n",
+					left+float64(x+QuietZone)*module, bottom+float64(side-QuietZone-y-1)*module, module, module,
+				)
+			}
+		}
+	}
+	content.WriteString("f\n")
+
+	for i, line := range page.Caption {
+		fmt.Fprintf(
+			&content, "BT /F1 %d Tf %.3f %.3f Td (%s) Tj ET\n",
+			pdfFontSize, left+float64(QuietZone)*module, bottom-float64(pdfLineSpacing*(i+1)), pdfString(line),
+		)
+	}
+	return content.String()
+}
+
+// pdfString escapes s for a PDF literal string.
+func pdfString(s string) string {
+	var result bytes.Buffer
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			result.WriteByte('\\')
+			result.WriteRune(r)
+		case r < 32 || r > 126:
+			result.WriteByte('?')
+		default:
+			result.WriteRune(r)
+		}
+	}
+	return result.String()
+}
+
+type pdfWriter struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+func (p *pdfWriter) object(body string) {
+	p.offsets = append(p.offsets, p.buf.Len())
+	fmt.Fprintf(&p.buf, "%d 0 obj\n%s\nendobj\n", len(p.offsets), body)
+}
+
+// finish writes the cross-reference table and the trailer.
+func (p *pdfWriter) finish() {
+	start := p.buf.Len()
+	fmt.Fprintf(&p.buf, "xref\n0 %d\n0000000000 65535 f \n", len(p.offsets)+1)
+	for _, offset := range p.offsets {
+		fmt.Fprintf(&p.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&p.buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(p.offsets)+1, start)
+}
@@ -0,0 +1,427 @@
+// Package qrcode encodes short byte strings as QR codes and renders them as PNG, SVG or PDF.
+// Only what checkpoint codes need is supported: byte mode, error correction level M and
+// versions 1-10, that is up to 213 bytes of data.
+package qrcode
+
+import (
+	"errors"
+)
+
+// QuietZone is the width of the light border around the symbol in modules.
+const QuietZone = 4
+
+var ErrTooLong = errors.New("data does not fit into a QR code")
+
+type blockGroup struct {
+	blocks        int
+	dataCodewords int
+}
+
+type blockLayout struct {
+	ecCodewords int
+	groups      []blockGroup
+}
+
+func (l blockLayout) dataCodewords() int {
+	result := 0
+	for _, group := range l.groups {
+		result += group.blocks * group.dataCodewords
+	}
+	return result
+}
+
+// layouts are the error correction blocks of level M by version
+var layouts = []blockLayout{
+	{10, []blockGroup{{1, 16}}},
+	{16, []blockGroup{{1, 28}}},
+	{26, []blockGroup{{1, 44}}},
+	{18, []blockGroup{{2, 32}}},
+	{24, []blockGroup{{2, 43}}},
+	{16, []blockGroup{{4, 27}}},
+	{18, []blockGroup{{4, 31}}},
+	{22, []blockGroup{{2, 38}, {2, 39}}},
+	{22, []blockGroup{{3, 36}, {2, 37}}},
+	{26, []blockGroup{{4, 43}, {1, 44}}},
+}
+
+// alignmentPositions are the coordinates of alignment pattern centers by version
+var alignmentPositions = [][]int{
+	{},
+	{6, 18},
+	{6, 22},
+	{6, 26},
+	{6, 30},
+	{6, 34},
+	{6, 22, 38},
+	{6, 24, 42},
+	{6, 26, 46},
+	{6, 28, 50},
+}
+
+// Code is an encoded QR symbol without the quiet zone.
+type Code struct {
+	Version  int
+	Size     int
+	modules  []bool
+	function []bool
+}
+
+// Encode returns the smallest QR code holding data.
+func Encode(data []byte) (*Code, error) {
+	version := 0
+	for v := 1; v <= len(layouts); v++ {
+		if 4+countBits(v)+len(data)*8 <= layouts[v-1].dataCodewords()*8 {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+
+	size := 4*version + 17
+	code := &Code{
+		Version:  version,
+		Size:     size,
+		modules:  make([]bool, size*size),
+		function: make([]bool, size*size),
+	}
+	code.drawFunctionPatterns()
+	code.drawCodewords(addErrorCorrection(version, encodeData(version, data)))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		code.applyMask(mask)
+		code.drawFormat(mask)
+		if penalty := code.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		// masks are XORs, so applying the mask again removes it
+		code.applyMask(mask)
+	}
+	code.applyMask(best)
+	code.drawFormat(best)
+	return code, nil
+}
+
+// Dark reports whether the module in column x and row y is dark.
+func (c *Code) Dark(x, y int) bool {
+	if x < 0 || y < 0 || x >= c.Size || y >= c.Size {
+		return false
+	}
+	return c.modules[y*c.Size+x]
+}
+
+func countBits(version int) int {
+	if version <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// encodeData returns the data codewords of a byte mode segment with terminator and padding.
+func encodeData(version int, data []byte) []byte {
+	capacity := layouts[version-1].dataCodewords() * 8
+	bits := make([]bool, 0, capacity)
+	appendBits := func(value, count int) {
+		for i := count - 1; i >= 0; i-- {
+			bits = append(bits, (value>>uint(i))&1 == 1)
+		}
+	}
+
+	appendBits(0x4, 4)
+	appendBits(len(data), countBits(version))
+	for _, b := range data {
+		appendBits(int(b), 8)
+	}
+	terminator := capacity - len(bits)
+	if terminator > 4 {
+		terminator = 4
+	}
+	appendBits(0, terminator)
+	appendBits(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacity; pad ^= 0xEC ^ 0x11 {
+		appendBits(pad, 8)
+	}
+
+	result := make([]byte, len(bits)/8)
+	for i, bit := range bits {
+		if bit {
+			result[i/8] |= 1 << uint(7-i%8)
+		}
+	}
+	return result
+}
+
+// addErrorCorrection splits data into blocks and interleaves them with their error correction codewords.
+func addErrorCorrection(version int, data []byte) []byte {
+	layout := layouts[version-1]
+	divisor := rsDivisor(layout.ecCodewords)
+
+	var blocks, ecBlocks [][]byte
+	offset, longest := 0, 0
+	for _, group := range layout.groups {
+		for i := 0; i < group.blocks; i++ {
+			block := data[offset : offset+group.dataCodewords]
+			offset += group.dataCodewords
+			blocks = append(blocks, block)
+			ecBlocks = append(ecBlocks, rsRemainder(block, divisor))
+			if len(block) > longest {
+				longest = len(block)
+			}
+		}
+	}
+
+	result := make([]byte, 0, len(data)+len(blocks)*layout.ecCodewords)
+	for i := 0; i < longest; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < layout.ecCodewords; i++ {
+		for _, block := range ecBlocks {
+			result = append(result, block[i])
+		}
+	}
+	return result
+}
+
+func (c *Code) setFunction(x, y int, dark bool) {
+	c.modules[y*c.Size+x] = dark
+	c.function[y*c.Size+x] = true
+}
+
+func (c *Code) drawFunctionPatterns() {
+	for i := 0; i < c.Size; i++ {
+		c.setFunction(6, i, i%2 == 0)
+		c.setFunction(i, 6, i%2 == 0)
+	}
+
+	c.drawFinder(3, 3)
+	c.drawFinder(c.Size-4, 3)
+	c.drawFinder(3, c.Size-4)
+
+	positions := alignmentPositions[c.Version-1]
+	last := len(positions) - 1
+	for i, x := range positions {
+		for j, y := range positions {
+			// the corners taken by finder patterns
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			c.drawAlignment(x, y)
+		}
+	}
+
+	// reserves the format areas, the real mask is drawn later
+	c.drawFormat(0)
+	c.drawVersion()
+}
+
+// drawFinder draws the finder pattern centered at (x, y) together with its separator.
+func (c *Code) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || yy < 0 || xx >= c.Size || yy >= c.Size {
+				continue
+			}
+			distance := maxInt(absInt(dx), absInt(dy))
+			c.setFunction(xx, yy, distance != 2 && distance != 4)
+		}
+	}
+}
+
+func (c *Code) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			c.setFunction(x+dx, y+dy, maxInt(absInt(dx), absInt(dy)) != 1)
+		}
+	}
+}
+
+// drawFormat draws both copies of the format information for level M and mask.
+func (c *Code) drawFormat(mask int) {
+	// level M is encoded as 00
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool {
+		return (bits>>uint(i))&1 == 1
+	}
+
+	for i := 0; i <= 5; i++ {
+		c.setFunction(8, i, bit(i))
+	}
+	c.setFunction(8, 7, bit(6))
+	c.setFunction(8, 8, bit(7))
+	c.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		c.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		c.setFunction(c.Size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		c.setFunction(8, c.Size-15+i, bit(i))
+	}
+	c.setFunction(8, c.Size-8, true)
+}
+
+func (c *Code) drawVersion() {
+	if c.Version < 7 {
+		return
+	}
+	rem := c.Version
+	for i := 0; i < 12; i++ {
+		rem = (rem << 1) ^ ((rem >> 11) * 0x1F25)
+	}
+	bits := c.Version<<12 | rem
+	for i := 0; i < 18; i++ {
+		dark := (bits>>uint(i))&1 == 1
+		a, b := c.Size-11+i%3, i/3
+		c.setFunction(a, b, dark)
+		c.setFunction(b, a, dark)
+	}
+}
+
+// drawCodewords places codewords in the zigzag order, from the bottom right corner up and
+// down in two-module columns; the remainder modules stay light.
+func (c *Code) drawCodewords(codewords []byte) {
+	i := 0
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// the vertical timing pattern
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < c.Size; vert++ {
+			y := vert
+			if upward {
+				y = c.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if c.function[y*c.Size+x] || i >= len(codewords)*8 {
+					continue
+				}
+				c.modules[y*c.Size+x] = (codewords[i/8]>>uint(7-i%8))&1 == 1
+				i++
+			}
+		}
+	}
+}
+
+func (c *Code) applyMask(mask int) {
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.function[y*c.Size+x] && maskBit(mask, x, y) {
+				c.modules[y*c.Size+x] = !c.modules[y*c.Size+x]
+			}
+		}
+	}
+}
+
+func maskBit(mask, x, y int) bool {
+	switch mask {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+// penalty scores the symbol by the four rules of the standard; the mask with the lowest
+// score is the easiest to scan.
+func (c *Code) penalty() int {
+	result := 0
+	finderLike := [][]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+	for _, vertical := range []bool{false, true} {
+		at := func(line, i int) bool {
+			if vertical {
+				return c.modules[i*c.Size+line]
+			}
+			return c.modules[line*c.Size+i]
+		}
+		for line := 0; line < c.Size; line++ {
+			run := 1
+			for i := 1; i <= c.Size; i++ {
+				if i < c.Size && at(line, i) == at(line, i-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					result += run - 2
+				}
+				run = 1
+			}
+			for i := 0; i+11 <= c.Size; i++ {
+				for _, pattern := range finderLike {
+					matches := true
+					for k, dark := range pattern {
+						if at(line, i+k) != dark {
+							matches = false
+							break
+						}
+					}
+					if matches {
+						result += 40
+					}
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			module := c.modules[y*c.Size+x]
+			if module {
+				dark++
+			}
+			if x+1 < c.Size && y+1 < c.Size &&
+				module == c.modules[y*c.Size+x+1] &&
+				module == c.modules[(y+1)*c.Size+x] &&
+				module == c.modules[(y+1)*c.Size+x+1] {
+				result += 3
+			}
+		}
+	}
+	result += absInt(dark*100/(c.Size*c.Size)-50) / 5 * 10
+	return result
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,157 @@
+package qrcode
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRSRemainder(t *testing.T) {
+	// the data codewords of "HELLO WORLD" in a 1-M symbol and their error correction codewords
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	ec := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	assert.Equal(t, ec, rsRemainder(data, rsDivisor(len(ec))))
+}
+
+func TestEncodeData(t *testing.T) {
+	codewords := encodeData(1, []byte("ab"))
+	assert.Len(t, codewords, 16)
+	// mode 0100, length 00000010, 'a', 'b', terminator, then pad codewords
+	assert.Equal(t, []byte{0x40, 0x26, 0x16, 0x20, 0xEC, 0x11}, codewords[:6])
+}
+
+func TestFormatAndVersion(t *testing.T) {
+	code, err := Encode(bytes.Repeat([]byte("x"), 150))
+	require.NoError(t, err)
+	require.Equal(t, 8, code.Version)
+
+	code.drawFormat(0)
+	// M with mask 0 is 101010000010010, read from the bit 14 down along column 8 of the second copy
+	var format []byte
+	for y := code.Size - 1; y > code.Size-8; y-- {
+		format = append(format, bit(code.Dark(8, y)))
+	}
+	for x := code.Size - 8; x < code.Size; x++ {
+		format = append(format, bit(code.Dark(x, 8)))
+	}
+	assert.Equal(t, "101010000010010", string(format))
+
+	code.Version = 7
+	code.drawVersion()
+	// version 7 is 000111110010010100, the bit 0 is at the top left of the block
+	var version []byte
+	for i := 17; i >= 0; i-- {
+		version = append(version, bit(code.Dark(code.Size-11+i%3, i/3)))
+	}
+	assert.Equal(t, "000111110010010100", string(version))
+}
+
+func TestEncode(t *testing.T) {
+	for _, tc := range []struct {
+		length  int
+		version int
+	}{
+		{1, 1}, {14, 1}, {15, 2}, {106, 6}, {107, 7}, {213, 10},
+	} {
+		code, err := Encode(bytes.Repeat([]byte("a"), tc.length))
+		require.NoError(t, err)
+		assert.Equal(t, tc.version, code.Version, "length %d", tc.length)
+		assert.Equal(t, 4*tc.version+17, code.Size)
+
+		// finder patterns in three corners and the dark module
+		for _, corner := range [][2]int{{0, 0}, {code.Size - 7, 0}, {0, code.Size - 7}} {
+			assert.True(t, code.Dark(corner[0], corner[1]))
+			assert.True(t, code.Dark(corner[0]+3, corner[1]+3))
+			assert.False(t, code.Dark(corner[0]+1, corner[1]+1))
+		}
+		assert.True(t, code.Dark(8, code.Size-8))
+	}
+
+	_, err := Encode(bytes.Repeat([]byte("a"), 214))
+	assert.Equal(t, ErrTooLong, err)
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	data := []byte("ARQ1:100:7:n0123456789abcdef:signature")
+	code, err := Encode(data)
+	require.NoError(t, err)
+
+	// the mask comes from the first copy of the format information
+	formatBits := 0
+	for i := 0; i <= 5; i++ {
+		formatBits |= int(bit(code.Dark(8, i))-'0') << uint(i)
+	}
+	formatBits |= int(bit(code.Dark(8, 7))-'0') << 6
+	formatBits |= int(bit(code.Dark(8, 8))-'0') << 7
+	formatBits |= int(bit(code.Dark(7, 8))-'0') << 8
+	for i := 9; i < 15; i++ {
+		formatBits |= int(bit(code.Dark(14-i, 8))-'0') << uint(i)
+	}
+	mask := (formatBits ^ 0x5412) >> 10 & 7
+
+	read := make([]byte, 0, len(code.modules)/8)
+	var current byte
+	count := 0
+	for right := code.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < code.Size; vert++ {
+			y := vert
+			if (right+1)&2 == 0 {
+				y = code.Size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if code.function[y*code.Size+x] {
+					continue
+				}
+				current = current<<1 | (bit(code.Dark(x, y) != maskBit(mask, x, y)) - '0')
+				if count++; count%8 == 0 {
+					read = append(read, current)
+				}
+			}
+		}
+	}
+
+	expected := addErrorCorrection(code.Version, encodeData(code.Version, data))
+	assert.Equal(t, expected, read[:len(expected)])
+}
+
+func TestRender(t *testing.T) {
+	code, err := Encode([]byte("ARQ1:1:2:sig"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, code.WritePNG(&buf, 4))
+	img, err := png.Decode(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, (code.Size+2*QuietZone)*4, img.Bounds().Dx())
+
+	buf.Reset()
+	require.NoError(t, code.WriteSVG(&buf, 4))
+	assert.True(t, strings.HasPrefix(buf.String(), "<svg"))
+	assert.Contains(t, buf.String(), "M4,4h1v1h-1z")
+
+	buf.Reset()
+	require.NoError(t, WritePDF(&buf, []Page{
+		{Code: code, Caption: []string{"Checkpoint (1)", "Точка"}},
+		{Code: code},
+	}))
+	pdf := buf.String()
+	assert.True(t, strings.HasPrefix(pdf, "%PDF-1.4"))
+	assert.Contains(t, pdf, "/Count 2")
+	assert.Contains(t, pdf, `(Checkpoint \(1\)) Tj`)
+	assert.Contains(t, pdf, "(?????) Tj")
+	assert.True(t, strings.HasSuffix(pdf, "%%EOF\n"))
+}
+
+func bit(dark bool) byte {
+	if dark {
+		return '1'
+	}
+	return '0'
+}
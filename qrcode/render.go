@@ -0,0 +1,55 @@
+package qrcode
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// Image returns the code with the quiet zone, scale pixels per module.
+func (c *Code) Image(scale int) image.Image {
+	side := (c.Size + 2*QuietZone) * scale
+	img := image.NewPaletted(image.Rect(0, 0, side, side), color.Palette{color.White, color.Black})
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if !c.Dark(x, y) {
+				continue
+			}
+			for py := 0; py < scale; py++ {
+				row := (y+QuietZone)*scale + py
+				for px := 0; px < scale; px++ {
+					img.SetColorIndex((x+QuietZone)*scale+px, row, 1)
+				}
+			}
+		}
+	}
+	return img
+}
+
+func (c *Code) WritePNG(w io.Writer, scale int) error {
+	return png.Encode(w, c.Image(scale))
+}
+
+// WriteSVG writes the code as a single path in a viewBox measured in modules.
+func (c *Code) WriteSVG(w io.Writer, scale int) error {
+	side := c.Size + 2*QuietZone
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(
+		out,
+		`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" shape-rendering="crispEdges">`,
+		side, side, side*scale, side*scale,
+	)
+	fmt.Fprintf(out, `<rect width="%d" height="%d" fill="#ffffff"/><path fill="#000000" d="`, side, side)
+	for y := 0; y < c.Size; y++ {
+		for x := 0; x < c.Size; x++ {
+			if c.Dark(x, y) {
+				fmt.Fprintf(out, "M%d,%dh1v1h-1z", x+QuietZone, y+QuietZone)
+			}
+		}
+	}
+	fmt.Fprint(out, `"/></svg>`)
+	return out.Flush()
+}
@@ -1,31 +1,108 @@
 package routes
 
 import (
+	"github.com/Sovianum/arquest-server/model"
 	"github.com/Sovianum/arquest-server/server"
 	"github.com/gin-gonic/gin"
 )
 
 func GetEngine(env *server.Env) *gin.Engine {
 	router := gin.Default()
+	router.GET("/.well-known/jwks.json", env.GetJWKS)
 
 	root := router.Group("/api/v1/")
-	root.GET("quests", env.GetAllQuests)
+	root.GET("quests", env.ListQuests)
+	root.GET("quests/:id", env.GetQuest)
+	root.GET("nearby/quests", env.GetNearbyQuests)
+	root.GET("quests/:id/archive", env.CheckAuthorization, env.DownloadQuestArchive)
+	root.HEAD("quests/:id/archive", env.CheckAuthorization, env.DownloadQuestArchive)
+	root.GET("quests/:id/files/:sha256", env.CheckAuthorization, env.DownloadQuestFile)
+	root.GET("quests/:id/diff", env.CheckAuthorization, env.GetQuestDiff)
+	root.GET("quests/:id/versions", env.CheckAuthorization, env.GetQuestVersions)
+	root.GET("quests/:id/versions/:version", env.CheckAuthorization, env.GetQuestVersion)
+	root.GET("quests/:id/checkpoints", env.AllowAuthorization, env.GetQuestCheckpoints)
+	root.GET("quests/:id/reviews", env.GetQuestReviews)
+	root.PUT("quests/:id/review", env.CheckAuthorization, env.SaveQuestReview)
+	root.DELETE("quests/:id/review", env.CheckAuthorization, env.DeleteQuestReview)
+	root.POST("quests/:id/checkpoints/:checkpoint/reach", env.CheckAuthorization, env.ReachCheckpoint)
+	root.GET("quests/:id/checkpoints/:checkpoint/hints", env.CheckAuthorization, env.GetCheckpointHints)
+	root.POST("quests/:id/checkpoints/:checkpoint/hints", env.CheckAuthorization, env.UseCheckpointHint)
+	root.GET("quests/:id/progress", env.CheckAuthorization, env.GetQuestProgress)
+	root.GET("quests/:id/attempts", env.CheckAuthorization, env.GetAttempts)
+	root.POST("quests/:id/attempts", env.CheckAuthorization, env.StartAttempt)
+	root.POST("quests/:id/attempts/:attempt/abandon", env.CheckAuthorization, env.AbandonAttempt)
+	root.GET("quests/:id/leaderboard", env.CheckAuthorization, env.GetQuestLeaderboard)
+	root.GET("quests/:id/scenario/state", env.CheckAuthorization, env.GetScenarioState)
+	root.POST("quests/:id/scenario/transitions", env.CheckAuthorization, env.MakeScenarioTransition)
+
+	root.PUT("reviews/:id/helpful", env.CheckAuthorization, env.VoteReviewHelpful)
+	root.DELETE("reviews/:id/helpful", env.CheckAuthorization, env.UnvoteReviewHelpful)
+	root.POST("reviews/:id/replies", env.CheckAuthorization, env.ReplyToReview)
+	root.GET("photos/:id", env.GetPhoto)
+
+	teamGroup := root.Group("teams")
+	teamGroup.Use(env.CheckAuthorization)
+	teamGroup.POST("", env.CreateTeam)
+	teamGroup.GET(":id", env.GetTeam)
+
+	authorGroup := root.Group("quests")
+	authorGroup.Use(env.CheckAuthorization, env.RequireRoles(model.RoleAuthor))
+	authorGroup.POST("", env.CreateQuest)
+	authorGroup.PUT(":id", env.UpdateQuest)
+	authorGroup.DELETE(":id", env.DeleteQuest)
+	authorGroup.PUT(":id/archive", env.UploadQuestArchive)
+	authorGroup.DELETE(":id/archive", env.DeleteQuestArchive)
+	authorGroup.POST(":id/versions/:version/publish", env.PublishQuestVersion)
+	authorGroup.PUT(":id/checkpoints", env.SetQuestCheckpoints)
+	authorGroup.GET(":id/qr-codes", env.GetQuestQRCodes)
+	authorGroup.GET(":id/qr-codes/:checkpoint", env.GetCheckpointQR)
+	authorGroup.GET(":id/scenario", env.GetQuestScenario)
+	authorGroup.PUT(":id/scenario", env.SetQuestScenario)
+	authorGroup.DELETE(":id/scenario", env.DeleteQuestScenario)
 
 	authGroup := root.Group("auth")
 	authGroup.POST("register", env.UserRegisterPost)
 	authGroup.POST("login", env.UserSignInPost)
+	authGroup.POST("refresh", env.RefreshTokenPost)
+	authGroup.POST("logout", env.CheckAuthorization, env.UserLogoutPost)
 
 	userGroup := root.Group("user")
 	userGroup.Use(env.CheckAuthorization)
 	userGroup.GET("self", env.UserGetSelfInfo)
+	userGroup.GET("friends", env.GetFriends)
+	userGroup.PUT("friends/:id", env.AddFriend)
+	userGroup.DELETE("friends/:id", env.RemoveFriend)
+	userGroup.GET("teams", env.GetTeams)
+	userGroup.POST("teams", env.JoinTeam)
+	userGroup.DELETE("teams/:id", env.LeaveTeam)
+	userGroup.GET("teams/:id/events", env.StreamTeamEvents)
+	userGroup.POST("photos", env.UploadPhoto)
+	userGroup.POST("reports", env.ReportContent)
 
 	questGroup := userGroup.Group("quest")
 	questGroup.GET("finished", env.GetFinishedQuests)
 
 	voteGroup := userGroup.Group("mark")
 	voteGroup.GET("all", env.GetUserMarks)
+	voteGroup.GET("history", env.GetMarkHistory)
 	voteGroup.POST("mark", env.MarkQuest)
 	voteGroup.POST("finish", env.FinishQuest)
 
+	moderationGroup := root.Group("moderation")
+	moderationGroup.Use(env.CheckAuthorization, env.RequireRoles(model.RoleModerator))
+	moderationGroup.GET("checkpoint-failures", env.GetCheckpointFailures)
+	moderationGroup.GET("queue", env.GetModerationQueue)
+	moderationGroup.POST("queue/:id/approve", env.ApproveContent)
+	moderationGroup.POST("queue/:id/reject", env.RejectContent)
+	moderationGroup.POST("queue/:id/ban", env.BanContentAuthor)
+	moderationGroup.GET("actions", env.GetModerationActions)
+	moderationGroup.DELETE("bans/:id", env.UnbanUser)
+
+	adminGroup := root.Group("admin")
+	adminGroup.Use(env.CheckAuthorization, env.RequireRoles(model.RoleAdmin))
+	adminGroup.GET("users/:id/roles", env.GetUserRoles)
+	adminGroup.POST("users/:id/roles", env.GrantUserRole)
+	adminGroup.DELETE("users/:id/roles/:role", env.RevokeUserRole)
+
 	return router
 }
@@ -0,0 +1,211 @@
+package scenario
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Sovianum/arquest-server/model"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUnknownTransition is returned for transitions missing from the scenario.
+var ErrUnknownTransition = errors.New("unknown transition")
+
+// TransitionError explains why a transition of the scenario can not be made now.
+type TransitionError struct {
+	Transition string
+	State      string
+	Unmet      []string
+}
+
+func (e *TransitionError) Error() string {
+	if len(e.Unmet) == 0 {
+		return fmt.Sprintf("transition %q does not start in state %q", e.Transition, e.State)
+	}
+	return fmt.Sprintf("transition %q is not allowed: %s", e.Transition, strings.Join(e.Unmet, ", "))
+}
+
+// Facts are what conditions are checked against besides the player state.
+type Facts struct {
+	Reached map[int]bool
+	Now     time.Time
+}
+
+// View is the player state together with the transitions out of it.
+type View struct {
+	State       string   `json:"state"`
+	Name        string   `json:"name,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Final       bool     `json:"final"`
+	Items       []string `json:"items"`
+	// Timers are the seconds since each started timer was started.
+	Timers  map[string]int `json:"timers"`
+	Actions []Action       `json:"actions"`
+}
+
+// Action is a transition out of the current state; Unmet lists the conditions that do not hold.
+type Action struct {
+	Transition string   `json:"transition"`
+	Name       string   `json:"name,omitempty"`
+	To         string   `json:"to"`
+	Allowed    bool     `json:"allowed"`
+	Unmet      []string `json:"unmet,omitempty"`
+}
+
+// Current returns the state of the player. Players who have not started the scenario or
+// whose state was removed from it by the author are at the start state.
+func (s *Scenario) Current(player model.ScenarioState) model.ScenarioState {
+	if state := s.state(player.State); state != nil {
+		return player
+	}
+	start := s.state(s.Start)
+	return model.ScenarioState{
+		State:   start.ID,
+		Items:   []string{},
+		Timers:  map[string]time.Time{},
+		Final:   start.Final,
+		Version: player.Version,
+	}
+}
+
+func (s *Scenario) View(player model.ScenarioState, facts Facts) View {
+	player = s.Current(player)
+	state := s.state(player.State)
+	view := View{
+		State:       state.ID,
+		Name:        state.Name,
+		Description: state.Description,
+		Final:       state.Final,
+		Items:       append([]string{}, player.Items...),
+		Timers:      make(map[string]int, len(player.Timers)),
+		Actions:     make([]Action, 0, len(state.Transitions)),
+	}
+	for name, started := range player.Timers {
+		view.Timers[name] = int(facts.Now.Sub(started).Seconds())
+	}
+	for _, transition := range state.Transitions {
+		unmet := transition.unmet(player, facts)
+		view.Actions = append(view.Actions, Action{
+			Transition: transition.ID,
+			Name:       transition.Name,
+			To:         transition.To,
+			Allowed:    len(unmet) == 0,
+			Unmet:      unmet,
+		})
+	}
+	return view
+}
+
+// Apply makes the transition for the player and returns the new player state.
+func (s *Scenario) Apply(player model.ScenarioState, transitionID string, facts Facts) (model.ScenarioState, error) {
+	player = s.Current(player)
+	var transition *Transition
+	for _, state := range s.States {
+		for i := range state.Transitions {
+			if state.Transitions[i].ID == transitionID {
+				if state.ID != player.State {
+					return player, &TransitionError{Transition: transitionID, State: player.State}
+				}
+				transition = &state.Transitions[i]
+			}
+		}
+	}
+	if transition == nil {
+		return player, ErrUnknownTransition
+	}
+	if unmet := transition.unmet(player, facts); len(unmet) > 0 {
+		return player, &TransitionError{Transition: transitionID, State: player.State, Unmet: unmet}
+	}
+
+	items := make(map[string]bool, len(player.Items))
+	for _, item := range player.Items {
+		items[item] = true
+	}
+	for _, item := range transition.Take {
+		delete(items, item)
+	}
+	for _, item := range transition.Give {
+		items[item] = true
+	}
+	timers := make(map[string]time.Time, len(player.Timers)+len(transition.StartTimers))
+	for name, started := range player.Timers {
+		timers[name] = started
+	}
+	for _, name := range transition.StartTimers {
+		timers[name] = facts.Now
+	}
+
+	next := model.ScenarioState{
+		State:   transition.To,
+		Items:   make([]string, 0, len(items)),
+		Timers:  timers,
+		Final:   s.state(transition.To).Final,
+		Version: player.Version,
+	}
+	for item := range items {
+		next.Items = append(next.Items, item)
+	}
+	sort.Strings(next.Items)
+	return next, nil
+}
+
+func (s *Scenario) state(id string) *State {
+	for i := range s.States {
+		if s.States[i].ID == id {
+			return &s.States[i]
+		}
+	}
+	return nil
+}
+
+func (t Transition) unmet(player model.ScenarioState, facts Facts) []string {
+	var result []string
+	for _, condition := range t.When {
+		if reason := condition.unmet(player, facts); reason != "" {
+			result = append(result, reason)
+		}
+	}
+	return result
+}
+
+// unmet describes why the condition does not hold or returns "" if it holds.
+func (c Condition) unmet(player model.ScenarioState, facts Facts) string {
+	switch {
+	case c.Checkpoint != 0:
+		if !facts.Reached[c.Checkpoint] {
+			return fmt.Sprintf("checkpoint %d is not reached", c.Checkpoint)
+		}
+	case c.Item != "":
+		if !hasItem(player, c.Item) {
+			return fmt.Sprintf("item %q is required", c.Item)
+		}
+	case c.NoItem != "":
+		if hasItem(player, c.NoItem) {
+			return fmt.Sprintf("item %q must not be held", c.NoItem)
+		}
+	case c.Timer != "":
+		started, ok := player.Timers[c.Timer]
+		if !ok {
+			return fmt.Sprintf("timer %q is not started", c.Timer)
+		}
+		elapsed := facts.Now.Sub(started)
+		if after := time.Duration(c.After) * time.Second; elapsed < after {
+			return fmt.Sprintf("timer %q: %d s left", c.Timer, int(math.Ceil((after - elapsed).Seconds())))
+		}
+		if c.Before != 0 && elapsed >= time.Duration(c.Before)*time.Second {
+			return fmt.Sprintf("timer %q has run out", c.Timer)
+		}
+	}
+	return ""
+}
+
+func hasItem(player model.ScenarioState, item string) bool {
+	for _, held := range player.Items {
+		if held == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,267 @@
+// Package scenario runs quests described as state machines. A scenario is written in YAML
+// or JSON; players move between its states by transitions whose conditions on reached
+// checkpoints, held items and timers are checked on the server.
+package scenario
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"regexp"
+	"sort"
+)
+
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+
+	// Version is the only supported version of the scenario format.
+	Version = 1
+
+	MaxSourceSize  = 256 << 10
+	MaxStates      = 200
+	MaxTransitions = 1000
+)
+
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Scenario is a quest state machine. Items and timers must be declared before transitions use them.
+type Scenario struct {
+	Version int      `yaml:"version" json:"version"`
+	Start   string   `yaml:"start" json:"start"`
+	Items   []string `yaml:"items,omitempty" json:"items,omitempty"`
+	Timers  []string `yaml:"timers,omitempty" json:"timers,omitempty"`
+	States  []State  `yaml:"states" json:"states"`
+}
+
+// State is a step of the quest. A final state finishes the scenario and has no transitions.
+type State struct {
+	ID          string       `yaml:"id" json:"id"`
+	Name        string       `yaml:"name,omitempty" json:"name,omitempty"`
+	Description string       `yaml:"description,omitempty" json:"description,omitempty"`
+	Final       bool         `yaml:"final,omitempty" json:"final,omitempty"`
+	Transitions []Transition `yaml:"transitions,omitempty" json:"transitions,omitempty"`
+}
+
+// Transition moves the player to state To when all conditions hold. It may give and take
+// items and (re)start timers.
+type Transition struct {
+	ID          string      `yaml:"id" json:"id"`
+	Name        string      `yaml:"name,omitempty" json:"name,omitempty"`
+	To          string      `yaml:"to" json:"to"`
+	When        []Condition `yaml:"when,omitempty" json:"when,omitempty"`
+	Give        []string    `yaml:"give,omitempty" json:"give,omitempty"`
+	Take        []string    `yaml:"take,omitempty" json:"take,omitempty"`
+	StartTimers []string    `yaml:"start_timers,omitempty" json:"start_timers,omitempty"`
+}
+
+// Condition checks exactly one thing: a reached checkpoint, a held or missing item or the
+// time since a timer started. After and Before are in seconds and only apply to timers.
+type Condition struct {
+	Checkpoint int    `yaml:"checkpoint,omitempty" json:"checkpoint,omitempty"`
+	Item       string `yaml:"item,omitempty" json:"item,omitempty"`
+	NoItem     string `yaml:"no_item,omitempty" json:"no_item,omitempty"`
+	Timer      string `yaml:"timer,omitempty" json:"timer,omitempty"`
+	After      int    `yaml:"after,omitempty" json:"after,omitempty"`
+	Before     int    `yaml:"before,omitempty" json:"before,omitempty"`
+}
+
+// Parse reads a scenario in format without validating it; unknown fields are errors.
+func Parse(source []byte, format string) (*Scenario, error) {
+	if len(source) > MaxSourceSize {
+		return nil, fmt.Errorf("scenario is larger than %d bytes", MaxSourceSize)
+	}
+	scenario := &Scenario{}
+	var err error
+	switch format {
+	case FormatYAML:
+		err = yaml.UnmarshalStrict(source, scenario)
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(source))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(scenario)
+	default:
+		return nil, fmt.Errorf("unknown scenario format %q", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s scenario: %v", format, err)
+	}
+	return scenario, nil
+}
+
+// Validate checks that the scenario is a well-formed state machine: ids are unique, every
+// reference is defined, every state is reachable from the start and there is a final state.
+func (s *Scenario) Validate() error {
+	if s.Version != Version {
+		return fmt.Errorf("unsupported scenario version %d, expected %d", s.Version, Version)
+	}
+	if len(s.States) == 0 || len(s.States) > MaxStates {
+		return fmt.Errorf("scenario must have from 1 to %d states", MaxStates)
+	}
+	items, err := declare("item", s.Items)
+	if err != nil {
+		return err
+	}
+	timers, err := declare("timer", s.Timers)
+	if err != nil {
+		return err
+	}
+
+	states := make(map[string]*State, len(s.States))
+	hasFinal := false
+	for i := range s.States {
+		state := &s.States[i]
+		if !idPattern.MatchString(state.ID) {
+			return fmt.Errorf("state %d: invalid id %q", i+1, state.ID)
+		}
+		if states[state.ID] != nil {
+			return fmt.Errorf("duplicate state %q", state.ID)
+		}
+		states[state.ID] = state
+		hasFinal = hasFinal || state.Final
+	}
+	if states[s.Start] == nil {
+		return fmt.Errorf("start state %q is not defined", s.Start)
+	}
+	if !hasFinal {
+		return fmt.Errorf("scenario has no final state")
+	}
+
+	transitions := make(map[string]bool)
+	for _, state := range s.States {
+		if state.Final && len(state.Transitions) > 0 {
+			return fmt.Errorf("final state %q has transitions", state.ID)
+		}
+		if !state.Final && len(state.Transitions) == 0 {
+			return fmt.Errorf("state %q has no transitions and is not final", state.ID)
+		}
+		for _, transition := range state.Transitions {
+			if !idPattern.MatchString(transition.ID) {
+				return fmt.Errorf("state %q: invalid transition id %q", state.ID, transition.ID)
+			}
+			if transitions[transition.ID] {
+				return fmt.Errorf("duplicate transition %q", transition.ID)
+			}
+			transitions[transition.ID] = true
+			if err := transition.validate(states, items, timers); err != nil {
+				return fmt.Errorf("transition %q: %v", transition.ID, err)
+			}
+		}
+	}
+	if len(transitions) > MaxTransitions {
+		return fmt.Errorf("scenario has more than %d transitions", MaxTransitions)
+	}
+
+	reached := map[string]bool{s.Start: true}
+	queue := []string{s.Start}
+	for len(queue) > 0 {
+		state := states[queue[0]]
+		queue = queue[1:]
+		for _, transition := range state.Transitions {
+			if !reached[transition.To] {
+				reached[transition.To] = true
+				queue = append(queue, transition.To)
+			}
+		}
+	}
+	for _, state := range s.States {
+		if !reached[state.ID] {
+			return fmt.Errorf("state %q is not reachable from the start state", state.ID)
+		}
+	}
+	return nil
+}
+
+// CheckpointIDs returns the sorted ids of checkpoints the conditions refer to.
+func (s *Scenario) CheckpointIDs() []int {
+	unique := make(map[int]bool)
+	for _, state := range s.States {
+		for _, transition := range state.Transitions {
+			for _, condition := range transition.When {
+				if condition.Checkpoint != 0 {
+					unique[condition.Checkpoint] = true
+				}
+			}
+		}
+	}
+	result := make([]int, 0, len(unique))
+	for id := range unique {
+		result = append(result, id)
+	}
+	sort.Ints(result)
+	return result
+}
+
+func (t Transition) validate(states map[string]*State, items, timers map[string]bool) error {
+	if states[t.To] == nil {
+		return fmt.Errorf("state %q is not defined", t.To)
+	}
+	for i, condition := range t.When {
+		if err := condition.validate(items, timers); err != nil {
+			return fmt.Errorf("condition %d: %v", i+1, err)
+		}
+	}
+	for _, names := range [][]string{t.Give, t.Take} {
+		if err := checkDeclared("item", names, items); err != nil {
+			return err
+		}
+	}
+	return checkDeclared("timer", t.StartTimers, timers)
+}
+
+func (c Condition) validate(items, timers map[string]bool) error {
+	kinds := 0
+	for _, set := range []bool{c.Checkpoint != 0, c.Item != "", c.NoItem != "", c.Timer != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("exactly one of checkpoint, item, no_item and timer is required")
+	}
+	if c.Timer == "" && (c.After != 0 || c.Before != 0) {
+		return fmt.Errorf("after and before only apply to timers")
+	}
+
+	switch {
+	case c.Checkpoint < 0:
+		return fmt.Errorf("invalid checkpoint %d", c.Checkpoint)
+	case c.Item != "":
+		return checkDeclared("item", []string{c.Item}, items)
+	case c.NoItem != "":
+		return checkDeclared("item", []string{c.NoItem}, items)
+	case c.Timer != "":
+		if c.After < 0 || c.Before < 0 || c.After == 0 && c.Before == 0 {
+			return fmt.Errorf("timer %q requires positive after or before", c.Timer)
+		}
+		if c.Before != 0 && c.Before <= c.After {
+			return fmt.Errorf("timer %q: before must be greater than after", c.Timer)
+		}
+		return checkDeclared("timer", []string{c.Timer}, timers)
+	}
+	return nil
+}
+
+func declare(kind string, names []string) (map[string]bool, error) {
+	result := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !idPattern.MatchString(name) {
+			return nil, fmt.Errorf("invalid %s name %q", kind, name)
+		}
+		if result[name] {
+			return nil, fmt.Errorf("duplicate %s %q", kind, name)
+		}
+		result[name] = true
+	}
+	return result, nil
+}
+
+func checkDeclared(kind string, names []string, declared map[string]bool) error {
+	for _, name := range names {
+		if !declared[name] {
+			return fmt.Errorf("%s %q is not declared", kind, name)
+		}
+	}
+	return nil
+}
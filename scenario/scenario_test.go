@@ -0,0 +1,184 @@
+package scenario
+
+import (
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/stretchr/testify/suite"
+	"strings"
+	"testing"
+	"time"
+)
+
+const sampleYAML = `
+version: 1
+start: gate
+items: [key]
+timers: [escape]
+states:
+  - id: gate
+    name: Ворота
+    transitions:
+      - id: open
+        to: hall
+        when:
+          - checkpoint: 3
+        give: [key]
+        start_timers: [escape]
+  - id: hall
+    transitions:
+      - id: leave
+        to: out
+        when:
+          - item: key
+          - timer: escape
+            after: 60
+            before: 600
+        take: [key]
+      - id: lock
+        to: gate
+        when:
+          - no_item: key
+  - id: out
+    final: true
+`
+
+type ScenarioTestSuite struct {
+	suite.Suite
+	scenario *Scenario
+	now      time.Time
+}
+
+func (s *ScenarioTestSuite) SetupTest() {
+	var err error
+	s.scenario, err = Parse([]byte(sampleYAML), FormatYAML)
+	s.Require().NoError(err)
+	s.Require().NoError(s.scenario.Validate())
+	s.now = time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC)
+}
+
+func (s *ScenarioTestSuite) TestParseJSON() {
+	scenario, err := Parse([]byte(`{
+		"version": 1, "start": "a",
+		"states": [{"id": "a", "transitions": [{"id": "go", "to": "b"}]}, {"id": "b", "final": true}]
+	}`), FormatJSON)
+	s.Require().NoError(err)
+	s.NoError(scenario.Validate())
+}
+
+func (s *ScenarioTestSuite) TestParseErrors() {
+	_, err := Parse([]byte("version: 1\nstart: a\nunknown: 1\n"), FormatYAML)
+	s.Error(err)
+	_, err = Parse([]byte(`{"version": 1, "unknown": 1}`), FormatJSON)
+	s.Error(err)
+	_, err = Parse([]byte("states: [\n"), FormatYAML)
+	s.Error(err)
+	_, err = Parse([]byte("{}"), "xml")
+	s.Error(err)
+	_, err = Parse(make([]byte, MaxSourceSize+1), FormatYAML)
+	s.Error(err)
+}
+
+func (s *ScenarioTestSuite) TestValidate() {
+	for _, tc := range []struct {
+		change string
+		with   string
+		err    string
+	}{
+		{"version: 1", "version: 2", "unsupported scenario version"},
+		{"start: gate", "start: nowhere", "start state"},
+		{"- id: hall", "- id: gate", "duplicate state"},
+		{"- id: leave", "- id: open", "duplicate transition"},
+		{"to: out", "to: nowhere", `transition "leave": state "nowhere"`},
+		{"final: true", "final: false", "no final state"},
+		{"- item: key", "- item: map", `item "map" is not declared`},
+		{"start_timers: [escape]", "start_timers: [other]", `timer "other" is not declared`},
+		{"- checkpoint: 3", "- checkpoint: 3\n            item: key", "exactly one of"},
+		{"- checkpoint: 3", "- checkpoint: 3\n            after: 5", "only apply to timers"},
+		{"after: 60", "after: 700", "before must be greater than after"},
+		{"before: 600", "before: -1", "requires positive after or before"},
+		{"        to: out", "        to: gate", `state "out" is not reachable`},
+		{"items: [key]", "items: [key, key]", "duplicate item"},
+		{"- id: open", "- id: open door", "invalid transition id"},
+	} {
+		source := strings.Replace(sampleYAML, tc.change, tc.with, 1)
+		s.Require().NotEqual(sampleYAML, source, tc.change)
+		scenario, err := Parse([]byte(source), FormatYAML)
+		s.Require().NoError(err, tc.with)
+		err = scenario.Validate()
+		if s.Error(err, tc.with) {
+			s.Contains(err.Error(), tc.err)
+		}
+	}
+}
+
+func (s *ScenarioTestSuite) TestValidateDeadEnd() {
+	scenario, err := Parse([]byte(`
+version: 1
+start: a
+states:
+  - id: a
+    transitions: [{id: go, to: b}]
+  - id: b
+  - id: c
+    final: true
+`), FormatYAML)
+	s.Require().NoError(err)
+	s.EqualError(scenario.Validate(), `state "b" has no transitions and is not final`)
+}
+
+func (s *ScenarioTestSuite) TestCheckpointIDs() {
+	s.Equal([]int{3}, s.scenario.CheckpointIDs())
+}
+
+func (s *ScenarioTestSuite) TestPlay() {
+	facts := Facts{Reached: map[int]bool{}, Now: s.now}
+	player := s.scenario.Current(model.ScenarioState{})
+	s.Equal("gate", player.State)
+
+	view := s.scenario.View(player, facts)
+	s.Equal("Ворота", view.Name)
+	s.Equal([]Action{{Transition: "open", To: "hall", Unmet: []string{"checkpoint 3 is not reached"}}}, view.Actions)
+
+	_, err := s.scenario.Apply(player, "open", facts)
+	s.IsType(&TransitionError{}, err)
+	_, err = s.scenario.Apply(player, "leave", facts)
+	s.EqualError(err, `transition "leave" does not start in state "gate"`)
+	_, err = s.scenario.Apply(player, "fly", facts)
+	s.Equal(ErrUnknownTransition, err)
+
+	facts.Reached[3] = true
+	player, err = s.scenario.Apply(player, "open", facts)
+	s.Require().NoError(err)
+	s.Equal("hall", player.State)
+	s.Equal([]string{"key"}, player.Items)
+	s.Equal(s.now, player.Timers["escape"])
+
+	facts.Now = s.now.Add(30 * time.Second)
+	view = s.scenario.View(player, facts)
+	s.Equal(30, view.Timers["escape"])
+	s.Require().Len(view.Actions, 2)
+	s.Equal([]string{`timer "escape": 30 s left`}, view.Actions[0].Unmet)
+	s.Equal([]string{`item "key" must not be held`}, view.Actions[1].Unmet)
+
+	facts.Now = s.now.Add(time.Hour)
+	_, err = s.scenario.Apply(player, "leave", facts)
+	s.EqualError(err, `transition "leave" is not allowed: timer "escape" has run out`)
+
+	facts.Now = s.now.Add(2 * time.Minute)
+	player, err = s.scenario.Apply(player, "leave", facts)
+	s.Require().NoError(err)
+	s.Equal("out", player.State)
+	s.True(player.Final)
+	s.Empty(player.Items)
+	s.Empty(s.scenario.View(player, facts).Actions)
+}
+
+func (s *ScenarioTestSuite) TestRemovedState() {
+	player := s.scenario.Current(model.ScenarioState{State: "removed", Items: []string{"key"}, Version: 3})
+	s.Equal("gate", player.State)
+	s.Empty(player.Items)
+	s.Equal(3, player.Version)
+}
+
+func TestScenarioTestSuite(t *testing.T) {
+	suite.Run(t, new(ScenarioTestSuite))
+}
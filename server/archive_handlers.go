@@ -0,0 +1,226 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Sovianum/arquest-server/archive"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/Sovianum/arquest-server/storage"
+	"github.com/gin-gonic/gin"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"unicode/utf8"
+)
+
+const (
+	archiveField   = "archive"
+	changelogField = "changelog"
+	publishQuery   = "publish"
+
+	// multipartOverhead is allowed on top of the archive size for part headers and boundaries.
+	multipartOverhead = 64 << 10
+
+	archiveKeyPrefix = "archives/"
+	fileKeyPrefix    = "files/"
+)
+
+var (
+	noArchiveFieldErr  = fmt.Errorf("multipart field %q with the quest archive required", archiveField)
+	twoArchivesErr     = fmt.Errorf("multipart field %q must be given once", archiveField)
+	longChangelogErr   = fmt.Errorf("%q must not be longer than %d characters", changelogField, model.QuestVersionMaxChangelogLength)
+	binaryChangelogErr = fmt.Errorf("%q must be UTF-8 text", changelogField)
+)
+
+// UploadQuestArchive streams the "archive" part of a multipart request into a temporary
+// file, validates it and saves it as a new immutable version of the quest content.
+// Archives and the files inside them are stored under their hashes, so files unchanged
+// between versions are stored once. The new version is published unless publish=false.
+func (env *Env) UploadQuestArchive(c *gin.Context) {
+	quest, ok := env.getManagedQuest(c)
+	if !ok {
+		return
+	}
+	publish, err := strconv.ParseBool(c.DefaultQuery(publishQuery, "true"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("invalid %s %q", publishQuery, c.Query(publishQuery))))
+		return
+	}
+
+	limits := env.conf.Logic.Archive
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limits.GetMaxSize()+multipartOverhead)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+
+	tmp, err := ioutil.TempFile("", "quest-upload-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	version := model.QuestVersion{QuestID: quest.ID, AuthorID: c.GetInt(UserID)}
+	var size int64 = -1
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+			return
+		}
+
+		switch part.FormName() {
+		case changelogField:
+			if version.Changelog, err = readChangelog(part); err != nil {
+				c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(err))
+				return
+			}
+		case archiveField:
+			if size >= 0 {
+				c.JSON(http.StatusBadRequest, common.GetErrResponse(twoArchivesErr))
+				return
+			}
+			hash := sha256.New()
+			size, err = io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(part, limits.GetMaxSize()+1))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+				return
+			}
+			if size > limits.GetMaxSize() {
+				c.JSON(
+					http.StatusRequestEntityTooLarge,
+					common.GetErrResponse(fmt.Errorf("archive is larger than %d bytes", limits.GetMaxSize())),
+				)
+				return
+			}
+			version.ArchiveSHA256 = hex.EncodeToString(hash.Sum(nil))
+		}
+	}
+	if size < 0 {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(noArchiveFieldErr))
+		return
+	}
+
+	_, files, err := archive.Validate(tmp, size, archive.Limits{
+		MaxEntrySize:    limits.GetMaxEntrySize(),
+		MaxUnpackedSize: limits.GetMaxUnpackedSize(),
+		MaxEntries:      limits.GetMaxEntries(),
+	})
+	if err != nil {
+		c.JSON(archiveErrCode(err), common.GetErrResponse(err))
+		return
+	}
+
+	version.ArchiveSize = size
+	version.ArchiveKey = archiveKey(version.ArchiveSHA256)
+	if err := env.storeArchive(tmp, size, version.ArchiveKey, files); err != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+		return
+	}
+	for _, file := range files {
+		version.Files = append(version.Files, model.QuestFile{Path: file.Path, Size: file.Size, SHA256: file.SHA256})
+	}
+
+	created, dbErr := env.versionDAO.CreateVersion(version, publish)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	setFileURLs(quest.ID, created.Files)
+	c.JSON(http.StatusCreated, common.GetDataResponse(created))
+}
+
+// DeleteQuestArchive unpublishes the quest content. Versions stay available for rollback.
+func (env *Env) DeleteQuestArchive(c *gin.Context) {
+	quest, ok := env.getManagedQuest(c)
+	if !ok {
+		return
+	}
+	if err := env.versionDAO.Unpublish(quest.ID); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
+
+// storeArchive puts the archive and every file of it missing in the storage.
+func (env *Env) storeArchive(tmp *os.File, size int64, key string, files []archive.File) error {
+	if err := env.putIfMissing(key, tmp, size); err != nil {
+		return err
+	}
+
+	hashes := make(map[string]string, len(files))
+	for _, file := range files {
+		hashes[file.Path] = file.SHA256
+	}
+	return archive.Walk(tmp, size, func(name string, content io.Reader) error {
+		entry, err := ioutil.TempFile("", "quest-file-")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(entry.Name())
+		defer entry.Close()
+
+		n, err := io.Copy(entry, content)
+		if err != nil {
+			return err
+		}
+		return env.putIfMissing(fileKey(hashes[name]), entry, n)
+	})
+}
+
+// putIfMissing relies on keys being content hashes: an existing object already has the content.
+func (env *Env) putIfMissing(key string, f *os.File, size int64) error {
+	obj, _, err := env.storage.Open(key)
+	if err == nil {
+		return obj.Close()
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return env.storage.Put(key, f, size)
+}
+
+func readChangelog(part *multipart.Part) (string, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(part, 4*model.QuestVersionMaxChangelogLength+1))
+	if err != nil {
+		return "", err
+	}
+	if utf8.RuneCount(data) > model.QuestVersionMaxChangelogLength {
+		return "", longChangelogErr
+	}
+	if !utf8.Valid(data) {
+		return "", binaryChangelogErr
+	}
+	return string(data), nil
+}
+
+func archiveErrCode(err error) int {
+	if validationErr, ok := err.(*archive.ValidationError); ok {
+		if validationErr.TooLarge {
+			return http.StatusRequestEntityTooLarge
+		}
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+func archiveKey(sha256 string) string {
+	return archiveKeyPrefix + sha256
+}
+
+func fileKey(sha256 string) string {
+	return fileKeyPrefix + sha256
+}
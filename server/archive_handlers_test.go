@@ -0,0 +1,292 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/Sovianum/arquest-server/archive"
+	"github.com/Sovianum/arquest-server/config"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/Sovianum/arquest-server/storage"
+	"github.com/Sovianum/arquest-server/storage/s3test"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	manifestSHA256 = "4443663f7c60c0221d137957836a4955e312d2432a0fadc2564f270767a0059e"
+	sceneSHA256    = "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"
+)
+
+type ArchiveTestSuite struct {
+	suite.Suite
+	db        *sql.DB
+	env       *Env
+	mock      sqlmock.Sqlmock
+	c         *gin.Context
+	rw        *httptest.ResponseRecorder
+	dataDir   string
+	changelog string
+	query     string
+}
+
+func (s *ArchiveTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.dataDir, err = ioutil.TempDir("", "quests")
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	s.env.questDAO = dao.NewQuestDAO(s.db)
+	s.env.reviewDAO = dao.NewReviewDAO(s.db)
+	s.env.storage, err = storage.NewFS(s.dataDir, questsURL)
+	s.Require().NoError(err)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.changelog, s.query = "", ""
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+	s.c.Set(UserID, 20)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+}
+
+func (s *ArchiveTestSuite) TearDownTest() {
+	os.RemoveAll(s.dataDir)
+}
+
+func (s *ArchiveTestSuite) expectStoredQuest(authorID int) {
+	s.mock.
+		ExpectQuery("SELECT id, name, description, rating, author_id, version").
+		WithArgs(3).
+		WillReturnRows(
+			sqlmock.NewRows(questColumnNames).
+				AddRow(questRow(3, "n1", "d1", 4., authorID, 2, 0, 0, "")...),
+		)
+}
+
+func (s *ArchiveTestSuite) upload(field string, data []byte) {
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile(field, "quest.zip")
+	s.Require().NoError(err)
+	part.Write(data)
+	if s.changelog != "" {
+		s.Require().NoError(w.WriteField(changelogField, s.changelog))
+	}
+	s.Require().NoError(w.Close())
+
+	s.c.Request, err = http.NewRequest(http.MethodPut, urlSample+s.query, body)
+	s.Require().NoError(err)
+	s.c.Request.Header.Set("Content-Type", w.FormDataContentType())
+	s.env.UploadQuestArchive(s.c)
+}
+
+func (s *ArchiveTestSuite) archive(withManifest bool) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	if withManifest {
+		f, _ := w.Create(archive.ManifestName)
+		f.Write([]byte(`{"format_version": 1}`))
+	}
+	f, _ := w.Create("scene.json")
+	f.Write([]byte(`{}`))
+	s.Require().NoError(w.Close())
+	return buf.Bytes()
+}
+
+func (s *ArchiveTestSuite) expectCreateVersion(changelog string, publish bool) {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.
+		ExpectQuery("INSERT INTO quest_version").
+		WithArgs(3, changelog, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), 20).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "created_at"}).AddRow(4, time.Now()))
+	prepared := s.mock.ExpectPrepare("INSERT INTO quest_version_file")
+	prepared.ExpectExec().WithArgs(3, 4, archive.ManifestName, 21, manifestSHA256).WillReturnResult(sqlmock.NewResult(0, 1))
+	prepared.ExpectExec().WithArgs(3, 4, "scene.json", 2, sceneSHA256).WillReturnResult(sqlmock.NewResult(0, 1))
+	if publish {
+		s.mock.ExpectExec("UPDATE quest AS q SET").WithArgs(3, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	s.mock.ExpectCommit()
+}
+
+func (s *ArchiveTestSuite) uploadedVersion() model.QuestVersion {
+	resp := struct {
+		Data model.QuestVersion `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	return resp.Data
+}
+
+func (s *ArchiveTestSuite) TestUploadSuccess() {
+	data := s.archive(true)
+	sum := sha256.Sum256(data)
+	s.expectStoredQuest(20)
+	s.expectCreateVersion("", true)
+
+	s.upload(archiveField, data)
+
+	s.Require().Equal(http.StatusCreated, s.rw.Code, s.rw.Body.String())
+	s.NoError(s.mock.ExpectationsWereMet())
+	version := s.uploadedVersion()
+	s.Equal(4, version.Version)
+	s.True(version.Published)
+	s.Equal(hex.EncodeToString(sum[:]), version.ArchiveSHA256)
+	s.Equal(int64(len(data)), version.ArchiveSize)
+	s.Require().Len(version.Files, 2)
+	s.Equal("/api/v1/quests/3/files/"+sceneSHA256, version.Files[1].URL)
+
+	stored, err := ioutil.ReadFile(filepath.Join(s.dataDir, "archives", version.ArchiveSHA256))
+	s.Require().NoError(err)
+	s.Equal(data, stored)
+	scene, err := ioutil.ReadFile(filepath.Join(s.dataDir, "files", sceneSHA256))
+	s.Require().NoError(err)
+	s.Equal("{}", string(scene))
+
+	leftovers, _ := filepath.Glob(filepath.Join(s.dataDir, ".*"))
+	s.Empty(leftovers)
+}
+
+func (s *ArchiveTestSuite) TestUploadDraftKeepsStoredFiles() {
+	s.Require().NoError(os.MkdirAll(filepath.Join(s.dataDir, "files"), 0755))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.dataDir, "files", sceneSHA256), []byte("stored"), 0644))
+	s.changelog = "fixed texture"
+	s.query = "?publish=false"
+	s.expectStoredQuest(20)
+	s.expectCreateVersion("fixed texture", false)
+
+	s.upload(archiveField, s.archive(true))
+
+	s.Require().Equal(http.StatusCreated, s.rw.Code, s.rw.Body.String())
+	s.NoError(s.mock.ExpectationsWereMet())
+	version := s.uploadedVersion()
+	s.False(version.Published)
+	s.Equal("fixed texture", version.Changelog)
+	scene, err := ioutil.ReadFile(filepath.Join(s.dataDir, "files", sceneSHA256))
+	s.Require().NoError(err)
+	s.Equal("stored", string(scene))
+}
+
+func (s *ArchiveTestSuite) TestUploadLongChangelog() {
+	s.changelog = strings.Repeat("ж", model.QuestVersionMaxChangelogLength+1)
+	s.expectStoredQuest(20)
+
+	s.upload(archiveField, s.archive(true))
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+}
+
+func (s *ArchiveTestSuite) TestUploadToS3() {
+	server := s3test.NewServer("quests", "access")
+	defer server.Close()
+	var err error
+	s.env.storage, err = storage.NewS3(config.S3StorageConfig{
+		Endpoint: server.URL, Region: "us-east-1", Bucket: "quests", AccessKey: "access", SecretKey: "secret", PathStyle: true,
+	})
+	s.Require().NoError(err)
+
+	data := s.archive(true)
+	s.expectStoredQuest(20)
+	s.expectCreateVersion("", true)
+
+	s.upload(archiveField, data)
+
+	s.Require().Equal(http.StatusCreated, s.rw.Code, s.rw.Body.String())
+	stored, ok := server.Object(archiveKey(s.uploadedVersion().ArchiveSHA256))
+	s.Require().True(ok)
+	s.Equal(data, stored)
+	_, ok = server.Object(fileKey(manifestSHA256))
+	s.True(ok)
+}
+
+func (s *ArchiveTestSuite) TestDeleteArchive() {
+	s.expectStoredQuest(20)
+	s.mock.
+		ExpectExec("UPDATE quest SET").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	s.c.Request, _ = http.NewRequest(http.MethodDelete, urlSample, nil)
+	s.env.DeleteQuestArchive(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ArchiveTestSuite) TestUploadInvalidArchive() {
+	s.expectStoredQuest(20)
+
+	s.upload(archiveField, s.archive(false))
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+	_, err := os.Stat(filepath.Join(s.dataDir, "archives"))
+	s.True(os.IsNotExist(err))
+}
+
+func (s *ArchiveTestSuite) TestUploadTooLarge() {
+	s.env.conf.Logic.Archive.MaxSizeMB = 1
+	s.expectStoredQuest(20)
+
+	s.upload(archiveField, make([]byte, 1<<20+1))
+
+	s.Equal(http.StatusRequestEntityTooLarge, s.rw.Code)
+}
+
+func (s *ArchiveTestSuite) TestUploadNoArchiveField() {
+	s.expectStoredQuest(20)
+
+	s.upload("file", s.archive(true))
+
+	s.Equal(http.StatusBadRequest, s.rw.Code)
+}
+
+func (s *ArchiveTestSuite) TestUploadTwoArchives() {
+	s.expectStoredQuest(20)
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	for _, data := range [][]byte{s.archive(true), s.archive(false)} {
+		part, err := w.CreateFormFile(archiveField, "quest.zip")
+		s.Require().NoError(err)
+		part.Write(data)
+	}
+	s.Require().NoError(w.Close())
+
+	var err error
+	s.c.Request, err = http.NewRequest(http.MethodPut, urlSample, body)
+	s.Require().NoError(err)
+	s.c.Request.Header.Set("Content-Type", w.FormDataContentType())
+	s.env.UploadQuestArchive(s.c)
+
+	s.Equal(http.StatusBadRequest, s.rw.Code)
+	s.Contains(s.rw.Body.String(), "must be given once")
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ArchiveTestSuite) TestUploadForeignQuest() {
+	s.c.Set(UserRoles, []string{model.RoleAuthor})
+	s.expectStoredQuest(21)
+
+	s.upload(archiveField, s.archive(true))
+
+	s.Equal(http.StatusForbidden, s.rw.Code)
+}
+
+func TestArchiveTestSuite(t *testing.T) {
+	suite.Run(t, new(ArchiveTestSuite))
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	attemptParam = "attempt"
+
+	teamQuery    = "team"
+	windowQuery  = "window"
+	friendsQuery = "friends"
+	teamsQuery   = "teams"
+
+	defaultLeaderboardLimit = 10
+	maxLeaderboardLimit     = 100
+)
+
+// StartAttempt starts a new attempt of the quest for the current user or returns the active
+// one. With the team query parameter the attempt is started for the team and shared by its
+// members. Reaching the first checkpoint of a quest never attempted also starts a solo attempt.
+func (env *Env) StartAttempt(c *gin.Context) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	teamID, err := getIntQuery(c, teamQuery, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	quest, dbErr := env.questDAO.GetQuest(questID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+
+	var attempt model.Attempt
+	switch {
+	case teamID != 0 && quest.Teams == nil:
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(fmt.Errorf("the quest is not played by teams")))
+		return
+	case teamID != 0:
+		attempt, dbErr = env.attemptDAO.StartTeamAttempt(c.GetInt(UserID), questID, teamID, *quest.Teams)
+	case !quest.AllowsSolo():
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(fmt.Errorf(
+			"the quest is played by teams of at least %d members", quest.Teams.MinSize,
+		)))
+		return
+	default:
+		attempt, dbErr = env.attemptDAO.StartAttempt(c.GetInt(UserID), questID)
+	}
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(attempt))
+}
+
+// AbandonAttempt ends the active attempt of the current user without a score so that the
+// quest can be replayed from the start.
+func (env *Env) AbandonAttempt(c *gin.Context) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	attemptID, err := getIntParam(c, attemptParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	attempt, dbErr := env.attemptDAO.AbandonAttempt(c.GetInt(UserID), questID, attemptID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(attempt))
+}
+
+// GetAttempts lists the attempts of the quest by the current user from the latest one.
+func (env *Env) GetAttempts(c *gin.Context) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	attempts, dbErr := env.attemptDAO.GetAttempts(c.GetInt(UserID), questID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(attempts))
+}
+
+// GetQuestLeaderboard ranks the runs of the quest finished in the current day, week or all
+// time, optionally only of the current user and their friends. The run of the current user
+// is returned in own also when it is not in the top limit. Every player is ranked by their
+// best solo attempt or, with teams=true, every team by its best attempt.
+func (env *Env) GetQuestLeaderboard(c *gin.Context) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	board := model.Leaderboard{QuestID: questID, Window: c.DefaultQuery(windowQuery, model.LeaderboardAll)}
+	if !model.IsValidLeaderboardWindow(board.Window) {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf(
+			"%s must be %s, %s or %s", windowQuery, model.LeaderboardDay, model.LeaderboardWeek, model.LeaderboardAll,
+		)))
+		return
+	}
+	if board.Friends, err = getBoolQuery(c, friendsQuery); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if board.Teams, err = getBoolQuery(c, teamsQuery); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	limit, err := getIntQuery(c, limitQuery, defaultLeaderboardLimit)
+	if err == nil && (limit <= 0 || limit > maxLeaderboardLimit) {
+		err = fmt.Errorf("%s must be in [1, %d]", limitQuery, maxLeaderboardLimit)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if _, err := env.questDAO.GetQuest(questID); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+
+	userID := c.GetInt(UserID)
+	since := model.LeaderboardSince(board.Window, time.Now())
+	getLeaderboard := env.attemptDAO.GetLeaderboard
+	if board.Teams {
+		getLeaderboard = env.attemptDAO.GetTeamLeaderboard
+	}
+	entries, dbErr := getLeaderboard(questID, userID, since, board.Friends, limit)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	board.Entries = make([]model.LeaderboardEntry, 0, len(entries))
+	for i, entry := range entries {
+		if entry.Own && board.Own == nil {
+			board.Own = &entries[i]
+		}
+		if i < limit {
+			board.Entries = append(board.Entries, entry)
+		}
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(board))
+}
+
+func (env *Env) GetFriends(c *gin.Context) {
+	friends, err := env.friendDAO.GetFriends(c.GetInt(UserID))
+	if err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(friends))
+}
+
+func (env *Env) AddFriend(c *gin.Context) {
+	friendID, ok := env.getExistingUserParam(c)
+	if !ok {
+		return
+	}
+	if friendID == c.GetInt(UserID) {
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(fmt.Errorf("you can not be your own friend")))
+		return
+	}
+	if err := env.friendDAO.AddFriend(c.GetInt(UserID), friendID); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
+
+func (env *Env) RemoveFriend(c *gin.Context) {
+	friendID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := env.friendDAO.RemoveFriend(c.GetInt(UserID), friendID); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
+
+// getBoolQuery parses an optional query parameter that is false by default.
+func getBoolQuery(c *gin.Context, name string) (bool, error) {
+	value := c.Query(name)
+	if value == "" {
+		return false, nil
+	}
+	result, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return result, nil
+}
+
+func (env *Env) scoreRules() model.ScoreRules {
+	score := env.conf.Logic.Score
+	return model.ScoreRules{
+		MaxScore:             score.GetMaxScore(),
+		TimePenaltyPerMinute: score.GetTimePenaltyPerMinute(),
+		OptionalBonus:        score.GetOptionalCheckpointBonus(),
+	}
+}
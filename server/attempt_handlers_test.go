@@ -0,0 +1,164 @@
+package server
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var leaderboardColumns = []string{
+	"rank", "user_id", "login",
+	"started_at", "finished_at", "elapsed_s", "hints_used", "hint_penalty", "optional_reached", "score", "own",
+}
+
+var attemptColumns = []string{
+	"id", "quest_id", "team_id", "outcome", "ended_at",
+	"started_at", "finished_at", "elapsed_s", "hints_used", "hint_penalty", "optional_reached", "score",
+}
+
+type AttemptTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *AttemptTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	s.env.questDAO = dao.NewQuestDAO(s.db)
+	s.env.attemptDAO = dao.NewAttemptDAO(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+	s.c.Set(UserID, 20)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+}
+
+// expectActiveAttempt expects the active attempt 7 of the current user to be locked.
+func expectActiveAttempt(mock sqlmock.Sqlmock) {
+	mock.
+		ExpectQuery("SELECT id FROM quest_attempt .+ FOR UPDATE").
+		WithArgs(20, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+}
+
+// expectLatestAttempt expects the latest attempt of the current user to be read.
+func expectLatestAttempt(mock sqlmock.Sqlmock, outcome string) {
+	rows := sqlmock.NewRows([]string{"id", "team_id", "outcome"})
+	if outcome != "" {
+		rows.AddRow(7, 0, outcome)
+	}
+	mock.ExpectQuery("SELECT id, COALESCE\\(team_id, 0\\), outcome FROM quest_attempt").WithArgs(20, 3).WillReturnRows(rows)
+}
+
+func (s *AttemptTestSuite) TestStartAttempt() {
+	expectStoredQuest(s.mock, 1)
+	s.mock.
+		ExpectQuery("INSERT INTO quest_attempt").
+		WithArgs(20, 3).
+		WillReturnRows(sqlmock.NewRows(attemptColumns).AddRow(7, 3, 0, "active", nil, time.Now(), nil, 0, 0, 0, 0, 0))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, http.NoBody)
+	s.env.StartAttempt(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"id":7,"quest_id":3,"outcome":"active","started_at"`)
+}
+
+func (s *AttemptTestSuite) TestAbandonAttempt() {
+	s.c.Params = append(s.c.Params, gin.Param{Key: attemptParam, Value: "7"})
+	s.mock.
+		ExpectQuery("UPDATE quest_attempt SET outcome = 'abandoned'").
+		WithArgs(20, 3, 7).
+		WillReturnRows(sqlmock.NewRows(attemptColumns).AddRow(7, 3, 0, "abandoned", time.Now(), time.Now(), nil, 0, 0, 0, 0, 0))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, http.NoBody)
+	s.env.AbandonAttempt(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"outcome":"abandoned","ended_at"`)
+}
+
+func (s *AttemptTestSuite) TestAbandonFinishedAttempt() {
+	s.c.Params = append(s.c.Params, gin.Param{Key: attemptParam, Value: "7"})
+	s.mock.ExpectQuery("UPDATE quest_attempt").WillReturnRows(sqlmock.NewRows(attemptColumns))
+	s.mock.ExpectQuery("SELECT outcome FROM quest_attempt").WillReturnRows(sqlmock.NewRows([]string{"outcome"}).AddRow("finished"))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, http.NoBody)
+	s.env.AbandonAttempt(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
+}
+
+func (s *AttemptTestSuite) TestGetAttempts() {
+	s.mock.
+		ExpectQuery("SELECT id, quest_id, COALESCE\\(team_id, 0\\), outcome").
+		WithArgs(20, 3).
+		WillReturnRows(sqlmock.NewRows(attemptColumns))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetAttempts(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"data":[]`)
+}
+
+func (s *AttemptTestSuite) TestGetQuestLeaderboard() {
+	finishedAt := time.Now()
+	expectStoredQuest(s.mock, 1)
+	s.mock.
+		ExpectQuery("WITH best AS").
+		WithArgs(3, sqlmock.AnyArg(), true, 20, 2).
+		WillReturnRows(sqlmock.NewRows(leaderboardColumns).
+			AddRow(1, 21, "first", nil, finishedAt, 600, 0, 0, 0, 950, false).
+			AddRow(2, 22, "second", nil, finishedAt, 660, 0, 0, 0, 945, false).
+			AddRow(7, 20, "own", nil, finishedAt, 900, 1, 100, 0, 825, true))
+
+	s.c.Request, _ = getRequest(urlSample+"?window=week&friends=true&limit=2", http.MethodGet, nil)
+	s.env.GetQuestLeaderboard(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	body := s.rw.Body.String()
+	s.Contains(body, `"window":"week","friends":true`)
+	s.Contains(body, `"own":{"rank":7,"user_id":20,"login":"own"`)
+	s.Equal(3, strings.Count(body, `"rank":`), "two entries and the own run")
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *AttemptTestSuite) TestGetQuestLeaderboardBadQuery() {
+	for _, query := range []string{"?window=month", "?friends=maybe", "?limit=0", "?limit=101"} {
+		s.SetupTest()
+		s.c.Request, _ = getRequest(urlSample+query, http.MethodGet, nil)
+		s.env.GetQuestLeaderboard(s.c)
+
+		s.Equal(http.StatusBadRequest, s.rw.Code, query)
+	}
+}
+
+func (s *AttemptTestSuite) TestAddSelfAsFriend() {
+	s.c.Params = gin.Params{{Key: idParam, Value: "20"}}
+	s.mock.ExpectQuery("SELECT count").WithArgs(20).WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPut, http.NoBody)
+	s.env.AddFriend(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+}
+
+func TestAttemptTestSuite(t *testing.T) {
+	suite.Run(t, new(AttemptTestSuite))
+}
@@ -14,10 +14,19 @@ const (
 	idStr    = "id"
 	loginStr = "login"
 	expStr   = "exp"
+	jtiStr   = "jti"
+	rolesStr = "roles"
 )
 
 var notFoundErr = fmt.Errorf("not found")
 
+func bannedErr(ban *model.Ban) error {
+	if ban.Reason == "" {
+		return fmt.Errorf("your account is banned")
+	}
+	return fmt.Errorf("your account is banned: %s", ban.Reason)
+}
+
 func (env *Env) UserRegisterPost(c *gin.Context) {
 	var user model.User
 	if err := c.BindJSON(&user); err != nil {
@@ -39,26 +48,27 @@ func (env *Env) UserRegisterPost(c *gin.Context) {
 		return
 	}
 
-	hash, err := env.hashFunc([]byte(user.Password))
+	hash, err := env.hasher.Hash([]byte(user.Password))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
 		return
 	}
 	user.Password = string(hash)
 
-	userId, saveErr := env.userDAO.Save(user)
+	userId, saveErr := env.userDAO.SaveWithRole(user, model.RolePlayer)
 	if saveErr != nil {
 		c.JSON(http.StatusInternalServerError, common.GetErrResponse(saveErr))
 		return
 	}
+	env.queueFlagged(model.ContentAbout, userId, userId, user.About, env.filter.Check(user.About, ""))
 
-	tokenString, tokenErr := env.generateTokenString(userId, user.Login)
+	tokens, tokenErr := env.issueTokenPair(userId, user.Login, "")
 	if tokenErr != nil {
 		c.JSON(http.StatusInternalServerError, common.GetErrResponse(tokenErr))
 		// TODO add info that u has been successfully saved
 		return
 	}
-	c.JSON(http.StatusOK, common.GetDataResponse(tokenString))
+	c.JSON(http.StatusOK, common.GetDataResponse(tokens))
 }
 
 func (env *Env) UserSignInPost(c *gin.Context) {
@@ -87,30 +97,71 @@ func (env *Env) UserSignInPost(c *gin.Context) {
 		return
 	}
 
-	if err := env.hashValidator([]byte(user.Password), []byte(dbUser.Password)); err != nil {
+	if err := env.hasher.Verify([]byte(user.Password), []byte(dbUser.Password)); err != nil {
 		c.JSON(http.StatusNotFound, common.GetErrResponse(err))
 		return
 	}
+	ban, banErr := env.moderationDAO.GetBan(dbUser.Id)
+	if banErr != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(banErr))
+		return
+	}
+	if ban != nil {
+		c.JSON(http.StatusForbidden, common.GetErrResponse(bannedErr(ban)))
+		return
+	}
+	env.rehashPassword(dbUser, []byte(user.Password))
 
-	tokenString, tokenErr := env.generateTokenString(dbUser.Id, dbUser.Login)
+	tokens, tokenErr := env.issueTokenPair(dbUser.Id, dbUser.Login, "")
 	if tokenErr != nil {
 		c.JSON(http.StatusInternalServerError, common.GetErrResponse(tokenErr))
 		return
 		// TODO add info that u has been successfully saved
 	}
-	c.JSON(http.StatusOK, common.GetDataResponse(tokenString))
+	c.JSON(http.StatusOK, common.GetDataResponse(tokens))
 }
 
-func (env *Env) generateTokenString(id int, login string) (string, error) {
-	t := jwt.New(jwt.SigningMethodHS256)
-	claims := t.Claims.(jwt.MapClaims)
+type accessToken struct {
+	token     string
+	jti       string
+	expiresAt time.Time
+}
 
-	claims[idStr] = id
-	claims[loginStr] = login
-	claims[expStr] = time.Now().Add(time.Hour * 24 * time.Duration(env.conf.Auth.ExpireDays)).Unix()
+func (env *Env) generateAccessToken(id int, login string, roles []string) (accessToken, error) {
+	jti, err := newRandomString(tokenIdBytes)
+	if err != nil {
+		return accessToken{}, err
+	}
+	expiresAt := time.Now().Add(env.conf.Auth.GetAccessTTL())
 
-	var tokenKey = env.conf.Auth.GetTokenKey()
-	return t.SignedString(tokenKey)
+	claims := jwt.MapClaims{
+		idStr:    id,
+		loginStr: login,
+		jtiStr:   jti,
+		rolesStr: roles,
+		expStr:   expiresAt.Unix(),
+	}
+	tokenString, err := env.keys.Sign(claims)
+	if err != nil {
+		return accessToken{}, err
+	}
+	return accessToken{token: tokenString, jti: jti, expiresAt: expiresAt}, nil
+}
+
+// rehashPassword upgrades the stored hash of a user who has just proved the password.
+// Failures are only logged because the user is already authenticated.
+func (env *Env) rehashPassword(user *model.User, password []byte) {
+	if !env.hasher.NeedsRehash([]byte(user.Password)) {
+		return
+	}
+	hash, err := env.hasher.Hash(password)
+	if err != nil {
+		env.logger.Errorf("failed to rehash password of user %d: %v", user.Id, err)
+		return
+	}
+	if err := env.userDAO.UpdatePassword(user.Id, hash); err != nil {
+		env.logger.Errorf("failed to update password hash of user %d: %v", user.Id, err)
+	}
 }
 
 func validateUser(user *model.User) (int, error) {
@@ -8,22 +8,32 @@ import (
 	"github.com/Sovianum/arquest-server/config"
 	"github.com/Sovianum/arquest-server/dao"
 	"github.com/Sovianum/arquest-server/model"
+	"github.com/Sovianum/arquest-server/moderation"
 	"github.com/Sovianum/arquest-server/mylog"
+	"github.com/Sovianum/arquest-server/passhash"
+	"github.com/Sovianum/arquest-server/proof"
+	"github.com/Sovianum/arquest-server/signing"
+	"github.com/Sovianum/arquest-server/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/suite"
+	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 const (
 	urlSample  = "/URL"
 	expireDays = 100
 	tokenKey   = "token90"
+	questsURL  = "/data/quests/"
 )
 
 type headerPair struct {
@@ -32,33 +42,32 @@ type headerPair struct {
 }
 
 func getEnv(db *sql.DB) *Env {
+	conf := getAuthConf()
+	keys, _ := signing.NewKeySet(conf.Auth)
+	assets, _ := storage.NewFS(filepath.Join(os.TempDir(), "ard-test-quests"), questsURL)
 	return &Env{
-		userDAO: dao.NewDBUserDAO(db),
-		conf:    getAuthConf(),
-		hashFunc: func(password []byte) ([]byte, error) {
-			var h = sha256.New()
-			h.Write(password)
-			return h.Sum(nil), nil
-		},
-		hashValidator: func(password []byte, hash []byte) error {
-			var h = sha256.New()
-			h.Write(password)
-			var passHash = h.Sum(nil)
-
-			if string(passHash) != string(hash) {
-				return fmt.Errorf("hashes %s, %s do not match", string(passHash), string(hash))
-			}
-			return nil
-		},
-		logger: mylog.NewLogger(ioutil.Discard),
+		userDAO:       dao.NewDBUserDAO(db),
+		tokenDAO:      dao.NewTokenDAO(db),
+		roleDAO:       dao.NewRoleDAO(db),
+		versionDAO:    dao.NewQuestVersionDAO(db),
+		nearbyDAO:     dao.NewIndexNearbyQuestDAO(db),
+		friendDAO:     dao.NewFriendDAO(db),
+		moderationDAO: dao.NewModerationDAO(db),
+		verifier:      proof.NewVerifier(conf.Logic.Proof),
+		filter:        moderation.Chain{},
+		conf:          conf,
+		keys:          keys,
+		storage:       assets,
+		hasher:        passhash.NewHasher(passhash.NewBcrypt(bcrypt.MinCost), passhash.NewLegacySHA256()),
+		logger:        mylog.NewLogger(ioutil.Discard),
 	}
 }
 
 func getAuthConf() *config.Conf {
 	return &config.Conf{
 		Auth: config.AuthConfig{
-			ExpireDays: expireDays,
-			TokenKey:   tokenKey,
+			RefreshExpireDays: expireDays,
+			TokenKey:          tokenKey,
 		},
 	}
 }
@@ -115,7 +124,7 @@ func (s *AuthHandlersTestSuite) SetupTest() {
 	s.Require().NoError(err)
 
 	s.env = getEnv(s.db)
-	s.hash, _ = s.env.hashFunc([]byte(s.user.Password))
+	s.hash, _ = s.env.hasher.Hash([]byte(s.user.Password))
 	s.env.logger = mylog.NewLogger(ioutil.Discard)
 	gin.SetMode(gin.ReleaseMode)
 }
@@ -128,9 +137,10 @@ func (s *AuthHandlersTestSuite) TestRegisterSuccess() {
 		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(0))
 
 	// mock user insertion
+	s.mock.ExpectBegin()
 	s.mock.
 		ExpectExec("INSERT INTO users").
-		WithArgs(s.user.Login, string(s.hash), s.user.Age, s.user.Sex, s.user.About).
+		WithArgs(s.user.Login, sqlmock.AnyArg(), s.user.Age, s.user.Sex, s.user.About).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// mock id selection
@@ -139,6 +149,24 @@ func (s *AuthHandlersTestSuite) TestRegisterSuccess() {
 		WithArgs(s.user.Login).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 
+	// mock default role grant
+	s.mock.
+		ExpectExec("INSERT INTO user_role").
+		WithArgs(1, model.RolePlayer).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	// mock roles selection
+	s.mock.
+		ExpectQuery("SELECT role FROM user_role").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(model.RolePlayer))
+
+	// mock refresh token insertion
+	s.mock.
+		ExpectExec("INSERT INTO refresh_token").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
 	requestMsg, jsonErr := json.Marshal(s.user)
 	s.Require().NoError(jsonErr)
 
@@ -215,16 +243,47 @@ func (s *AuthHandlersTestSuite) TestRegisterSaveErr() {
 		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(0))
 
 	// mock user insertion
+	s.mock.ExpectBegin()
 	s.mock.
-		ExpectExec("INSERT INTO Users").
-		WithArgs(s.user.Login, s.hash, s.user.Age, s.user.Sex, s.user.About).
+		ExpectExec("INSERT INTO users").
+		WithArgs(s.user.Login, sqlmock.AnyArg(), s.user.Age, s.user.Sex, s.user.About).
 		WillReturnError(fmt.Errorf("db fail"))
+	s.mock.ExpectRollback()
+
+	requestMsg, jsonErr := json.Marshal(s.user)
+	s.Require().NoError(jsonErr)
+
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodPost,
+		s.env.UserRegisterPost,
+		strings.NewReader(string(requestMsg)),
+		headerPair{"Content-Type", "application/json"},
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusInternalServerError, rec.Code)
+}
+
+func (s *AuthHandlersTestSuite) TestRegisterIdExtractionErr() {
+	// mock exists
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs(s.user.Login).
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(0))
+
+	// mock user insertion
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectExec("INSERT INTO users").
+		WithArgs(s.user.Login, sqlmock.AnyArg(), s.user.Age, s.user.Sex, s.user.About).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// mock id selection
 	s.mock.
 		ExpectQuery("SELECT id FROM").
 		WithArgs(s.user.Login).
-		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		WillReturnError(fmt.Errorf("db fail"))
+	s.mock.ExpectRollback()
 
 	requestMsg, jsonErr := json.Marshal(s.user)
 	s.Require().NoError(jsonErr)
@@ -240,24 +299,28 @@ func (s *AuthHandlersTestSuite) TestRegisterSaveErr() {
 	s.Equal(http.StatusInternalServerError, rec.Code)
 }
 
-func (s *AuthHandlersTestSuite) TestRegisterIdExtractionErr() {
+func (s *AuthHandlersTestSuite) TestRegisterRoleErr() {
 	// mock exists
 	s.mock.
 		ExpectQuery("SELECT count").
 		WithArgs(s.user.Login).
 		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(0))
 
-	// mock user insertion
+	// the user is not kept without the default role
+	s.mock.ExpectBegin()
 	s.mock.
-		ExpectExec("INSERT INTO Users").
-		WithArgs(s.user.Login, s.hash, s.user.Age, s.user.Sex, s.user.About).
+		ExpectExec("INSERT INTO users").
+		WithArgs(s.user.Login, sqlmock.AnyArg(), s.user.Age, s.user.Sex, s.user.About).
 		WillReturnResult(sqlmock.NewResult(1, 1))
-
-	// mock id selection
 	s.mock.
 		ExpectQuery("SELECT id FROM").
 		WithArgs(s.user.Login).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.
+		ExpectExec("INSERT INTO user_role").
+		WithArgs(1, model.RolePlayer).
 		WillReturnError(fmt.Errorf("db fail"))
+	s.mock.ExpectRollback()
 
 	requestMsg, jsonErr := json.Marshal(s.user)
 	s.Require().NoError(jsonErr)
@@ -271,6 +334,7 @@ func (s *AuthHandlersTestSuite) TestRegisterIdExtractionErr() {
 	)
 	s.Require().NoError(recErr)
 	s.Equal(http.StatusInternalServerError, rec.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
 }
 
 func (s *AuthHandlersTestSuite) TestRegisterNoLogin() {
@@ -335,6 +399,162 @@ func (s *AuthHandlersTestSuite) TestSignInSuccess() {
 				AddRow(1, "login", s.hash, 100, model.MALE, "about"),
 		)
 
+	// mock ban check
+	s.mock.
+		ExpectQuery("SELECT user_id, .+ FROM user_ban").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "moderator_id", "reason", "created_at"}))
+
+	// mock roles selection
+	s.mock.
+		ExpectQuery("SELECT role FROM user_role").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(model.RolePlayer))
+
+	// mock refresh token insertion
+	s.mock.
+		ExpectExec("INSERT INTO refresh_token").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	requestMsg, jsonErr := json.Marshal(s.user)
+	s.NoError(jsonErr)
+
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodPost,
+		s.env.UserSignInPost,
+		strings.NewReader(string(requestMsg)),
+		headerPair{"Content-Type", "application/json"},
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusOK, rec.Code)
+}
+
+func (s *AuthHandlersTestSuite) TestSignInBanned() {
+	s.mock.ExpectQuery("SELECT count").WithArgs(s.user.Login).WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+	s.mock.
+		ExpectQuery("SELECT id").
+		WithArgs(s.user.Login).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "login", "password", "age", "sex", "about"}).
+			AddRow(1, "login", s.hash, 100, model.MALE, "about"))
+	s.mock.
+		ExpectQuery("SELECT user_id, .+ FROM user_ban").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "moderator_id", "reason", "created_at"}).AddRow(1, 2, "spam", time.Now()))
+
+	requestMsg, _ := json.Marshal(s.user)
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodPost,
+		s.env.UserSignInPost,
+		strings.NewReader(string(requestMsg)),
+		headerPair{"Content-Type", "application/json"},
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusForbidden, rec.Code)
+	s.Contains(rec.Body.String(), "your account is banned: spam")
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *AuthHandlersTestSuite) TestSignInRehashesLegacyPassword() {
+	legacyHash := sha256.Sum256([]byte(s.user.Password))
+
+	// mock exists
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs(s.user.Login).
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+
+	// mock user extraction
+	s.mock.
+		ExpectQuery("SELECT id").
+		WithArgs(s.user.Login).
+		WillReturnRows(
+			sqlmock.NewRows(
+				[]string{"id", "login", "password", "age", "sex", "about"}).
+				AddRow(1, "login", legacyHash[:], 100, model.MALE, "about"),
+		)
+
+	// mock ban check
+	s.mock.
+		ExpectQuery("SELECT user_id, .+ FROM user_ban").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "moderator_id", "reason", "created_at"}))
+
+	// mock hash upgrade
+	s.mock.
+		ExpectExec("UPDATE users SET password").
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	// mock roles selection
+	s.mock.
+		ExpectQuery("SELECT role FROM user_role").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(model.RolePlayer))
+
+	// mock refresh token insertion
+	s.mock.
+		ExpectExec("INSERT INTO refresh_token").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	requestMsg, jsonErr := json.Marshal(s.user)
+	s.NoError(jsonErr)
+
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodPost,
+		s.env.UserSignInPost,
+		strings.NewReader(string(requestMsg)),
+		headerPair{"Content-Type", "application/json"},
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusOK, rec.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *AuthHandlersTestSuite) TestSignInRehashFailureIgnored() {
+	legacyHash := sha256.Sum256([]byte(s.user.Password))
+
+	// mock exists
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs(s.user.Login).
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+
+	// mock user extraction
+	s.mock.
+		ExpectQuery("SELECT id").
+		WithArgs(s.user.Login).
+		WillReturnRows(
+			sqlmock.NewRows(
+				[]string{"id", "login", "password", "age", "sex", "about"}).
+				AddRow(1, "login", legacyHash[:], 100, model.MALE, "about"),
+		)
+
+	// mock ban check
+	s.mock.
+		ExpectQuery("SELECT user_id, .+ FROM user_ban").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "moderator_id", "reason", "created_at"}))
+
+	// mock hash upgrade
+	s.mock.
+		ExpectExec("UPDATE users SET password").
+		WithArgs(sqlmock.AnyArg(), 1).
+		WillReturnError(fmt.Errorf("db fail"))
+
+	// mock roles selection
+	s.mock.
+		ExpectQuery("SELECT role FROM user_role").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(model.RolePlayer))
+
+	// mock refresh token insertion
+	s.mock.
+		ExpectExec("INSERT INTO refresh_token").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
 	requestMsg, jsonErr := json.Marshal(s.user)
 	s.NoError(jsonErr)
 
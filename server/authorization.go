@@ -1,19 +1,62 @@
 package server
 
 import (
+	"fmt"
 	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
 
 const (
-	UserID = "userID"
+	UserID      = "userID"
+	TokenID     = "tokenID"
+	TokenExpiry = "tokenExpiry"
+	UserRoles   = "userRoles"
 )
 
 func (env *Env) CheckAuthorization(c *gin.Context) {
-	userId, idCode, idErr := env.getIdFromRequest(c.Request)
+	token, tokenCode, tokenErr := env.getTokenFromRequest(c.Request)
+	if tokenErr != nil {
+		c.JSON(tokenCode, common.GetErrResponse(tokenErr))
+		c.Abort()
+		return
+	}
+
+	userId, idErr := env.getIdFromTokenString(token)
 	if idErr != nil {
-		c.JSON(idCode, common.GetErrResponse(idErr))
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("your token does not contain your id")))
+		c.Abort()
+		return
+	}
+	jti, jtiErr := env.getJtiFromToken(token)
+	if jtiErr != nil {
+		c.JSON(http.StatusUnauthorized, common.GetErrResponse(fmt.Errorf("your token is outdated, sign in again")))
+		c.Abort()
+		return
+	}
+	expiresAt, expErr := env.getExpFromToken(token)
+	if expErr != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("your token does not contain expiration time")))
+		c.Abort()
+		return
+	}
+
+	roles, rolesErr := env.getRolesFromToken(token)
+	if rolesErr != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("your token contains malformed roles")))
+		c.Abort()
+		return
+	}
+
+	revoked, revokedErr := env.tokenDAO.IsRevoked(jti)
+	if revokedErr != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(revokedErr))
+		c.Abort()
+		return
+	}
+	if revoked {
+		c.JSON(http.StatusUnauthorized, common.GetErrResponse(fmt.Errorf("your token has been revoked")))
 		c.Abort()
 		return
 	}
@@ -29,6 +72,70 @@ func (env *Env) CheckAuthorization(c *gin.Context) {
 		c.Abort()
 		return
 	}
+
+	// a ban revokes the tokens of the user, the check only covers tokens issued while it was decided
+	if !isReadMethod(c.Request.Method) {
+		ban, banErr := env.moderationDAO.GetBan(userId)
+		if banErr != nil {
+			c.JSON(http.StatusInternalServerError, common.GetErrResponse(banErr))
+			c.Abort()
+			return
+		}
+		if ban != nil {
+			c.JSON(http.StatusForbidden, common.GetErrResponse(bannedErr(ban)))
+			c.Abort()
+			return
+		}
+	}
 	c.Set(UserID, userId)
+	c.Set(TokenID, jti)
+	c.Set(TokenExpiry, expiresAt)
+	c.Set(UserRoles, roles)
 	c.Next()
 }
+
+// AllowAuthorization authorizes requests carrying a token like CheckAuthorization and lets
+// anonymous requests through, so that handlers can show more to the signed in users.
+func (env *Env) AllowAuthorization(c *gin.Context) {
+	if _, ok := c.Request.Header[authorizationStr]; !ok {
+		c.Next()
+		return
+	}
+	env.CheckAuthorization(c)
+}
+
+// RequireRoles must follow CheckAuthorization. It lets the request through if the token
+// carries any of the given roles; admins pass every check. Roles come from the token,
+// so a revoked role stays effective until the access token expires.
+func (env *Env) RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasAnyRole(c.GetStringSlice(UserRoles), roles) {
+			c.JSON(http.StatusForbidden, common.GetErrResponse(fmt.Errorf("you do not have permission for this action")))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func isReadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+func isAdmin(c *gin.Context) bool {
+	return hasAnyRole(c.GetStringSlice(UserRoles), nil)
+}
+
+func hasAnyRole(userRoles []string, required []string) bool {
+	for _, userRole := range userRoles {
+		if userRole == model.RoleAdmin {
+			return true
+		}
+		for _, role := range required {
+			if userRole == role {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"github.com/Sovianum/arquest-server/model"
 	"github.com/Sovianum/arquest-server/mylog"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/suite"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 type AuthTestSuite struct {
@@ -36,19 +39,28 @@ func (s *AuthTestSuite) SetupTest() {
 	s.Require().NoError(err)
 
 	s.env = getEnv(s.db)
-	s.hash, _ = s.env.hashFunc([]byte(s.user.Password))
+	s.hash, _ = s.env.hasher.Hash([]byte(s.user.Password))
 	s.env.logger = mylog.NewLogger(ioutil.Discard)
 	gin.SetMode(gin.ReleaseMode)
 }
 
+func (s *AuthTestSuite) expectNotRevoked() {
+	s.mock.
+		ExpectQuery("SELECT count\\(\\*\\) cnt FROM revoked_token").
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(0))
+}
+
 func (s *AuthTestSuite) TestAuthOk() {
+	s.expectNotRevoked()
+
 	// mock exists
 	s.mock.
 		ExpectQuery("SELECT count").
 		WithArgs(s.user.Id).
 		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+	expectBan(s.mock, s.user.Id, false)
 
-	token, tokenErr := s.env.generateTokenString(s.user.Id, s.user.Login)
+	token, tokenErr := s.env.generateAccessToken(s.user.Id, s.user.Login, []string{model.RolePlayer})
 	s.Require().NoError(tokenErr)
 
 	rec, recErr := getRecorder(
@@ -57,20 +69,90 @@ func (s *AuthTestSuite) TestAuthOk() {
 		s.env.CheckAuthorization,
 		strings.NewReader(""),
 		headerPair{"Content-Type", "application/json"},
-		headerPair{authorizationStr, token},
+		headerPair{authorizationStr, token.token},
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusOK, rec.Code)
+}
+
+func (s *AuthTestSuite) TestAuthBannedWrite() {
+	s.expectNotRevoked()
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs(s.user.Id).
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+	expectBan(s.mock, s.user.Id, true)
+
+	token, tokenErr := s.env.generateAccessToken(s.user.Id, s.user.Login, []string{model.RolePlayer})
+	s.Require().NoError(tokenErr)
+
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodPut,
+		s.env.CheckAuthorization,
+		strings.NewReader(""),
+		headerPair{"Content-Type", "application/json"},
+		headerPair{authorizationStr, token.token},
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusForbidden, rec.Code)
+	s.Contains(rec.Body.String(), "your account is banned")
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *AuthTestSuite) TestAuthRevokedAfterBan() {
+	token, tokenErr := s.env.generateAccessToken(s.user.Id, s.user.Login, []string{model.RolePlayer})
+	s.Require().NoError(tokenErr)
+	// the ban revoked the access token, so even reads are refused
+	s.mock.
+		ExpectQuery("SELECT count\\(\\*\\) cnt FROM revoked_token").
+		WithArgs(token.jti).
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodGet,
+		s.env.CheckAuthorization,
+		strings.NewReader(""),
+		headerPair{authorizationStr, token.token},
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusUnauthorized, rec.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *AuthTestSuite) TestAuthReadSkipsBan() {
+	s.expectNotRevoked()
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs(s.user.Id).
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+
+	token, tokenErr := s.env.generateAccessToken(s.user.Id, s.user.Login, []string{model.RolePlayer})
+	s.Require().NoError(tokenErr)
+
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodGet,
+		s.env.CheckAuthorization,
+		strings.NewReader(""),
+		headerPair{authorizationStr, token.token},
 	)
 	s.Require().NoError(recErr)
 	s.Equal(http.StatusOK, rec.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
 }
 
 func (s *AuthTestSuite) TestAuthUserNotFound() {
+	s.expectNotRevoked()
+
 	// mock exists
 	s.mock.
 		ExpectQuery("SELECT count").
 		WithArgs(s.user.Id).
 		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(0))
 
-	token, tokenErr := s.env.generateTokenString(s.user.Id, s.user.Login)
+	token, tokenErr := s.env.generateAccessToken(s.user.Id, s.user.Login, []string{model.RolePlayer})
 	s.Require().NoError(tokenErr)
 
 	rec, recErr := getRecorder(
@@ -79,7 +161,7 @@ func (s *AuthTestSuite) TestAuthUserNotFound() {
 		s.env.CheckAuthorization,
 		strings.NewReader(""),
 		headerPair{"Content-Type", "application/json"},
-		headerPair{authorizationStr, token},
+		headerPair{authorizationStr, token.token},
 	)
 	s.Require().NoError(recErr)
 	s.Equal(http.StatusNotFound, rec.Code)
@@ -97,14 +179,39 @@ func (s *AuthTestSuite) TestAuthUserNoToken() {
 	s.Equal(http.StatusUnauthorized, rec.Code)
 }
 
+func (s *AuthTestSuite) TestAllowAuthorizationNoToken() {
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodGet,
+		s.env.AllowAuthorization,
+		strings.NewReader(""),
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusOK, rec.Code)
+}
+
+func (s *AuthTestSuite) TestAllowAuthorizationBadToken() {
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodGet,
+		s.env.AllowAuthorization,
+		strings.NewReader(""),
+		headerPair{authorizationStr, "Bearer garbage"},
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusBadRequest, rec.Code)
+}
+
 func (s *AuthTestSuite) TestAuthUserDBErr() {
+	s.expectNotRevoked()
+
 	// mock exists
 	s.mock.
 		ExpectQuery("SELECT count").
 		WithArgs(s.user.Id).
 		WillReturnError(fmt.Errorf("fail"))
 
-	token, tokenErr := s.env.generateTokenString(s.user.Id, s.user.Login)
+	token, tokenErr := s.env.generateAccessToken(s.user.Id, s.user.Login, []string{model.RolePlayer})
 	s.Require().NoError(tokenErr)
 
 	rec, recErr := getRecorder(
@@ -113,12 +220,108 @@ func (s *AuthTestSuite) TestAuthUserDBErr() {
 		s.env.CheckAuthorization,
 		strings.NewReader(""),
 		headerPair{"Content-Type", "application/json"},
-		headerPair{authorizationStr, token},
+		headerPair{authorizationStr, token.token},
 	)
 	s.Require().NoError(recErr)
 	s.Equal(http.StatusInternalServerError, rec.Code)
 }
 
+func (s *AuthTestSuite) TestAuthRevoked() {
+	token, tokenErr := s.env.generateAccessToken(s.user.Id, s.user.Login, []string{model.RolePlayer})
+	s.Require().NoError(tokenErr)
+
+	s.mock.
+		ExpectQuery("SELECT count\\(\\*\\) cnt FROM revoked_token").
+		WithArgs(token.jti).
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodPost,
+		s.env.CheckAuthorization,
+		strings.NewReader(""),
+		headerPair{"Content-Type", "application/json"},
+		headerPair{authorizationStr, token.token},
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusUnauthorized, rec.Code)
+}
+
+func (s *AuthTestSuite) TestAuthExpired() {
+	token := s.signClaims(jwt.MapClaims{idStr: s.user.Id, jtiStr: "jti", expStr: time.Now().Add(-time.Minute).Unix()})
+
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodPost,
+		s.env.CheckAuthorization,
+		strings.NewReader(""),
+		headerPair{authorizationStr, "Bearer " + token},
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusUnauthorized, rec.Code)
+}
+
+func (s *AuthTestSuite) TestAuthNoJti() {
+	token := s.signClaims(jwt.MapClaims{idStr: s.user.Id, expStr: time.Now().Add(time.Minute).Unix()})
+
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodPost,
+		s.env.CheckAuthorization,
+		strings.NewReader(""),
+		headerPair{authorizationStr, "Bearer " + token},
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusUnauthorized, rec.Code)
+}
+
+func (s *AuthTestSuite) TestAuthMalformedRoles() {
+	token := s.signClaims(jwt.MapClaims{
+		idStr: s.user.Id, jtiStr: "jti", rolesStr: "admin", expStr: time.Now().Add(time.Minute).Unix(),
+	})
+
+	rec, recErr := getRecorder(
+		urlSample,
+		http.MethodPost,
+		s.env.CheckAuthorization,
+		strings.NewReader(""),
+		headerPair{authorizationStr, "Bearer " + token},
+	)
+	s.Require().NoError(recErr)
+	s.Equal(http.StatusBadRequest, rec.Code)
+}
+
+func (s *AuthTestSuite) TestRequireRoles() {
+	for _, testCase := range []struct {
+		userRoles []string
+		required  []string
+		code      int
+	}{
+		{[]string{model.RolePlayer}, []string{model.RoleAuthor}, http.StatusForbidden},
+		{[]string{}, []string{model.RolePlayer}, http.StatusForbidden},
+		{[]string{model.RolePlayer, model.RoleAuthor}, []string{model.RoleAuthor}, http.StatusOK},
+		{[]string{model.RoleModerator}, []string{model.RoleAuthor, model.RoleModerator}, http.StatusOK},
+		{[]string{model.RoleAdmin}, []string{model.RoleModerator}, http.StatusOK},
+	} {
+		rw := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rw)
+		c.Set(UserRoles, testCase.userRoles)
+
+		s.env.RequireRoles(testCase.required...)(c)
+
+		s.Equal(testCase.code != http.StatusOK, c.IsAborted(), "%v %v", testCase.userRoles, testCase.required)
+		if testCase.code != http.StatusOK {
+			s.Equal(testCase.code, rw.Code)
+		}
+	}
+}
+
+func (s *AuthTestSuite) signClaims(claims jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(tokenKey))
+	s.Require().NoError(err)
+	return token
+}
+
 func TestAuthTestSuite(t *testing.T) {
 	suite.Run(t, new(AuthTestSuite))
 }
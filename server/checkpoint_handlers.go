@@ -0,0 +1,258 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/Sovianum/arquest-server/proof"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"io"
+	"net/http"
+)
+
+const (
+	checkpointParam = "checkpoint"
+
+	questIDQuery  = "quest_id"
+	userIDQuery   = "user_id"
+	beforeIDQuery = "before_id"
+
+	defaultFailuresLimit = 50
+	maxFailuresLimit     = 500
+)
+
+// GetQuestCheckpoints lists the quest checkpoints. AR marker ids prove that the player is at
+// the marker, so they are only returned to users who can manage the quest.
+func (env *Env) GetQuestCheckpoints(c *gin.Context) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	quest, dbErr := env.questDAO.GetQuest(questID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	checkpoints, dbErr := env.checkpointDAO.GetCheckpoints(questID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	if !canSeeMarkers(c, quest) {
+		for i := range checkpoints {
+			checkpoints[i].MarkerID = ""
+		}
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(checkpoints))
+}
+
+// SetQuestCheckpoints replaces the ordered checkpoint list of the quest. Checkpoints are
+// identified by id, so that players keep the progress on checkpoints which are only changed or moved.
+func (env *Env) SetQuestCheckpoints(c *gin.Context) {
+	stored, ok := env.getManagedQuest(c)
+	if !ok {
+		return
+	}
+
+	var checkpoints []model.Checkpoint
+	if err := c.BindJSON(&checkpoints); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := model.ValidateCheckpoints(checkpoints); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(err))
+		return
+	}
+	if err := setCheckpointSecrets(checkpoints); err != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+		return
+	}
+	setHintPenalties(checkpoints, env.conf.Logic.Hints.GetDefaultPenalty())
+
+	saved, err := env.checkpointDAO.SetCheckpoints(stored.ID, checkpoints)
+	if err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(saved))
+}
+
+// ReachCheckpoint verifies the proof sent by the current user and, if it holds, records that
+// the user has reached the checkpoint and returns the updated progress. Rejected proofs
+// are stored for moderators and answered with 422 and the reason code.
+func (env *Env) ReachCheckpoint(c *gin.Context) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	checkpointID, err := getIntParam(c, checkpointParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	var checkpointProof model.CheckpointProof
+	if err := c.ShouldBindWith(&checkpointProof, binding.JSON); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	quest, dbErr := env.questDAO.GetQuest(questID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	checkpoint, dbErr := env.checkpointDAO.GetCheckpoint(questID, checkpointID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+
+	userID := c.GetInt(UserID)
+	// proofs of checkpoints reached out of order are not failures of the proof
+	if err := env.checkpointDAO.CheckOrder(userID, questID, checkpointID); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	var lastFix *model.GPSFix
+	if checkpoint.Type == model.CheckpointGPS {
+		if lastFix, dbErr = env.checkpointDAO.GetLastFix(userID, questID); dbErr != nil {
+			c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+			return
+		}
+	}
+	if rejection := env.verifier.Verify(checkpoint, checkpointProof, lastFix); rejection != nil {
+		env.rejectProof(c, userID, checkpoint, rejection)
+		return
+	}
+
+	var fix *model.GPSFix
+	var qrNonce string
+	switch checkpoint.Type {
+	case model.CheckpointGPS:
+		fix = checkpointProof.Fix
+	case model.CheckpointQR:
+		code, _ := proof.ParseQR(checkpointProof.QR)
+		qrNonce = code.Nonce
+	}
+	if qrNonce != "" {
+		usedBy, err := env.checkpointDAO.GetQRCodeUser(qrNonce)
+		if err != nil {
+			c.JSON(err.Code(), common.GetErrResponse(err))
+			return
+		}
+		if usedBy != 0 && usedBy != userID {
+			env.rejectProof(c, userID, checkpoint, &proof.Rejection{
+				Reason: proof.ReasonUsedQR, Details: "the one-time code is already used by another player",
+			})
+			return
+		}
+	}
+	if err := env.checkpointDAO.ReachCheckpoint(userID, questID, checkpointID, fix, qrNonce); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	env.writeProgress(c, userID, quest)
+}
+
+func (env *Env) GetQuestProgress(c *gin.Context) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	quest, dbErr := env.questDAO.GetQuest(questID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	env.writeProgress(c, c.GetInt(UserID), quest)
+}
+
+func (env *Env) writeProgress(c *gin.Context, userID int, quest model.Quest) {
+	progress, err := env.checkpointDAO.GetProgress(userID, quest.ID)
+	if err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	if !canSeeMarkers(c, quest) {
+		for i := range progress.Checkpoints {
+			progress.Checkpoints[i].MarkerID = ""
+		}
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(progress))
+}
+
+// canSeeMarkers tells whether the current user may read the AR marker ids of the quest.
+// Anonymous users can not, even for quests without an author.
+func canSeeMarkers(c *gin.Context, quest model.Quest) bool {
+	return c.GetInt(UserID) != 0 && canManageQuest(c, quest)
+}
+
+// GetCheckpointFailures lists rejected checkpoint proofs from the newest, optionally
+// filtered by quest_id and user_id. The next page starts before the id of the last failure.
+func (env *Env) GetCheckpointFailures(c *gin.Context) {
+	var filter [3]int
+	for i, name := range []string{questIDQuery, userIDQuery, beforeIDQuery} {
+		var err error
+		if filter[i], err = getIntQuery(c, name, 0); err != nil || filter[i] < 0 {
+			c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("invalid %s %q", name, c.Query(name))))
+			return
+		}
+	}
+	limit, err := getIntQuery(c, limitQuery, defaultFailuresLimit)
+	if err != nil || limit <= 0 || limit > maxFailuresLimit {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("limit must be in [1, %d]", maxFailuresLimit)))
+		return
+	}
+
+	failures, dbErr := env.checkpointDAO.GetFailures(filter[0], filter[1], filter[2], limit)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(failures))
+}
+
+func (env *Env) rejectProof(c *gin.Context, userID int, checkpoint model.Checkpoint, rejection *proof.Rejection) {
+	failure := model.CheckpointFailure{
+		UserID:       userID,
+		QuestID:      checkpoint.QuestID,
+		CheckpointID: checkpoint.ID,
+		Reason:       rejection.Reason,
+		Details:      rejection.Details,
+	}
+	if err := env.checkpointDAO.AddFailure(failure); err != nil {
+		env.logger.Errorf("failed to record rejected proof of user %d: %v", userID, err)
+	}
+	c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(rejection))
+}
+
+// setCheckpointSecrets gives QR checkpoints a signing secret (the stored one is kept if any)
+// and replaces answers with their salted hashes, so that answers are never stored or returned.
+func setCheckpointSecrets(checkpoints []model.Checkpoint) error {
+	for i := range checkpoints {
+		checkpoint := &checkpoints[i]
+		var err error
+		switch checkpoint.Type {
+		case model.CheckpointQR:
+			checkpoint.QRSecret, err = proof.NewSecret()
+		case model.CheckpointAnswer:
+			if len(checkpoint.Answers) == 0 {
+				break
+			}
+			if checkpoint.AnswerSalt, err = proof.NewSecret(); err == nil {
+				checkpoint.AnswerHashes = make([][]byte, len(checkpoint.Answers))
+				for j, answer := range checkpoint.Answers {
+					checkpoint.AnswerHashes[j] = proof.HashAnswer(checkpoint.AnswerSalt, answer)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		checkpoint.Answers = nil
+	}
+	return nil
+}
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/geo"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/Sovianum/arquest-server/proof"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var checkpointProgressColumns = []string{
+	"id", "quest_id", "position", "name", "description", "type", "optional", "lat", "lon", "radius", "marker_id",
+	"reached_at", "hints_used", "hint_penalty",
+}
+
+var checkpointSecretColumns = []string{
+	"id", "quest_id", "position", "name", "description", "type", "optional", "lat", "lon", "radius", "marker_id",
+	"qr_secret", "answer_salt", "answer_hashes",
+}
+
+type CheckpointTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *CheckpointTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	s.env.questDAO = dao.NewQuestDAO(s.db)
+	s.env.checkpointDAO = dao.NewCheckpointDAO(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+	s.c.Set(UserID, 20)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}, {Key: checkpointParam, Value: "2"}}
+}
+
+// expectStoredQuest expects the quest 3 of the author to be read; it is shared by the quest feature suites.
+func expectStoredQuest(mock sqlmock.Sqlmock, authorID int) {
+	mock.
+		ExpectQuery("SELECT id, name, description, rating, author_id, version").
+		WithArgs(3).
+		WillReturnRows(
+			sqlmock.NewRows(questColumnNames).
+				AddRow(questRow(3, "n1", "d1", 4., authorID, 2, 0, 0, "")...),
+		)
+}
+
+func (s *CheckpointTestSuite) TestSetCheckpoints() {
+	expectStoredQuest(s.mock, 20)
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest WHERE").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.ExpectQuery("SELECT id, type FROM quest_checkpoint").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id", "type"}))
+	s.mock.ExpectQuery("SELECT id FROM quest_scenario").WithArgs(3, pq.Array([]int64{})).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectExec("DELETE FROM quest_checkpoint").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.
+		ExpectQuery("INSERT INTO quest_checkpoint").
+		WithArgs(3, 1, "start", "", model.CheckpointGPS, false, 55.75, 37.61, 30., nil, nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.
+		ExpectQuery("INSERT INTO quest_checkpoint").
+		WithArgs(3, 2, "gate", "", model.CheckpointQR, false, nil, nil, nil, nil, sqlmock.AnyArg(), nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	s.mock.
+		ExpectQuery("INSERT INTO quest_checkpoint").
+		WithArgs(3, 3, "riddle", "", model.CheckpointAnswer, false, nil, nil, nil, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.ExpectCommit()
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPut, strings.NewReader(`[
+		{"name": "start", "type": "gps", "location": {"lat": 55.75, "lon": 37.61}, "radius": 30},
+		{"name": "gate", "type": "qr"},
+		{"name": "riddle", "type": "answer", "answers": ["Пушкин"]}
+	]`))
+	s.env.SetQuestCheckpoints(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.NotContains(s.rw.Body.String(), "Пушкин")
+	resp := struct {
+		Data []model.Checkpoint `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Require().Len(resp.Data, 3)
+	s.Equal(1, resp.Data[0].ID)
+	s.Equal(1, resp.Data[0].Position)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestSetInvalidCheckpoints() {
+	expectStoredQuest(s.mock, 20)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPut, strings.NewReader(`[{"name": "start", "type": "gps"}]`))
+	s.env.SetQuestCheckpoints(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+}
+
+func (s *CheckpointTestSuite) TestSetForeignQuestCheckpoints() {
+	expectStoredQuest(s.mock, 21)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPut, strings.NewReader(`[]`))
+	s.env.SetQuestCheckpoints(s.c)
+
+	s.Equal(http.StatusForbidden, s.rw.Code)
+}
+
+// expectCheckpoint expects the checkpoint 2 of the quest 3 to be read with its secrets.
+func expectCheckpoint(mock sqlmock.Sqlmock, checkpoint model.Checkpoint) {
+	var lat, lon, radius interface{}
+	if checkpoint.Location != nil {
+		lat, lon, radius = checkpoint.Location.Lat, checkpoint.Location.Lon, checkpoint.Radius
+	}
+	var hashes interface{}
+	if checkpoint.AnswerHashes != nil {
+		hashes, _ = pq.ByteaArray(checkpoint.AnswerHashes).Value()
+	}
+	mock.
+		ExpectQuery("SELECT .+ qr_secret").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows(checkpointSecretColumns).AddRow(
+			2, 3, 1, "a", "", checkpoint.Type, false, lat, lon, radius, checkpoint.MarkerID,
+			checkpoint.QRSecret, checkpoint.AnswerSalt, hashes,
+		))
+}
+
+// expectMissed expects the order of the checkpoint 2 of the quest 3 to be checked.
+func expectMissed(mock sqlmock.Sqlmock, missed int) {
+	mock.
+		ExpectQuery("SELECT count\\(\\*\\) FROM quest_checkpoint AS c\\s+JOIN quest_checkpoint AS t").
+		WithArgs(20, 3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(missed))
+}
+
+// expectFailure expects the rejected proof of the checkpoint 2 to be recorded.
+func expectFailure(mock sqlmock.Sqlmock, reason string) {
+	mock.
+		ExpectExec("INSERT INTO checkpoint_failure").
+		WithArgs(20, 3, 2, reason, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func (s *CheckpointTestSuite) TestReachCheckpoint() {
+	secret, _ := proof.NewSecret()
+	expectStoredQuest(s.mock, 1)
+	expectCheckpoint(s.mock, model.Checkpoint{Type: model.CheckpointQR, QRSecret: secret})
+	expectMissed(s.mock, 0)
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT position FROM quest_checkpoint").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow(1))
+	expectActiveAttempt(s.mock)
+	s.mock.ExpectQuery("SELECT count").WithArgs(3, 1, 7).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.
+		ExpectExec("INSERT INTO checkpoint_progress").
+		WithArgs(7, 20, 2, 3, nil, nil, nil, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	expectLatestAttempt(s.mock, model.AttemptActive)
+	s.mock.
+		ExpectQuery("SELECT .+ reached_at").
+		WithArgs(3, 7).
+		WillReturnRows(sqlmock.NewRows(checkpointProgressColumns).
+			AddRow(2, 3, 1, "a", "", model.CheckpointQR, false, nil, nil, nil, "", time.Now(), 0, 0).
+			AddRow(4, 3, 2, "b", "", model.CheckpointQR, false, nil, nil, nil, "", nil, 0, 0))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"qr": "`+proof.SignQR(secret, proof.QRCode{QuestID: 3, CheckpointID: 2})+`"}`))
+	s.env.ReachCheckpoint(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	resp := struct {
+		Data model.QuestProgress `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Equal(1, resp.Data.Reached)
+	s.Equal(2, resp.Data.Required)
+	s.False(resp.Data.CanFinish)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestReachCheckpointOutOfOrder() {
+	expectStoredQuest(s.mock, 1)
+	expectCheckpoint(s.mock, model.Checkpoint{Type: model.CheckpointARMarker, MarkerID: "statue"})
+	expectMissed(s.mock, 0)
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT position FROM quest_checkpoint").WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow(2))
+	expectActiveAttempt(s.mock)
+	s.mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.ExpectRollback()
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"marker_id": "statue"}`))
+	s.env.ReachCheckpoint(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
+}
+
+// A wrong proof of a checkpoint reached out of order is refused for the order and is not
+// recorded as a failure.
+func (s *CheckpointTestSuite) TestReachCheckpointOutOfOrderBeforeProof() {
+	salt, _ := proof.NewSecret()
+	expectStoredQuest(s.mock, 1)
+	expectCheckpoint(s.mock, model.Checkpoint{
+		Type: model.CheckpointAnswer, AnswerSalt: salt, AnswerHashes: [][]byte{proof.HashAnswer(salt, "Пушкин")},
+	})
+	expectMissed(s.mock, 1)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"answer": "Лермонтов"}`))
+	s.env.ReachCheckpoint(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
+	s.Contains(s.rw.Body.String(), "previous required checkpoints are not reached")
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestReachGPSCheckpoint() {
+	fixTime := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	expectStoredQuest(s.mock, 1)
+	expectCheckpoint(s.mock, model.Checkpoint{Type: model.CheckpointGPS, Location: &geo.Point{Lat: 55.75, Lon: 37.61}, Radius: 30})
+	expectMissed(s.mock, 0)
+	s.mock.ExpectQuery("SELECT fix_lat").WithArgs(20, 3).WillReturnError(sql.ErrNoRows)
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT position FROM quest_checkpoint").WillReturnRows(sqlmock.NewRows([]string{"position"}).AddRow(1))
+	expectActiveAttempt(s.mock)
+	s.mock.ExpectQuery("SELECT count").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.
+		ExpectExec("INSERT INTO checkpoint_progress").
+		WithArgs(7, 20, 2, 3, 55.7501, 37.61, 8., fixTime).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	expectLatestAttempt(s.mock, model.AttemptActive)
+	s.mock.ExpectQuery("SELECT .+ reached_at").WillReturnRows(sqlmock.NewRows(checkpointProgressColumns))
+
+	body, _ := json.Marshal(model.CheckpointProof{Fix: &model.GPSFix{
+		Point: geo.Point{Lat: 55.7501, Lon: 37.61}, Accuracy: 8, Time: model.QuotedTime(fixTime),
+	}})
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(string(body)))
+	s.env.ReachCheckpoint(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestReachCheckpointOutsideGeofence() {
+	expectStoredQuest(s.mock, 1)
+	expectCheckpoint(s.mock, model.Checkpoint{Type: model.CheckpointGPS, Location: &geo.Point{Lat: 55.75, Lon: 37.61}, Radius: 30})
+	expectMissed(s.mock, 0)
+	s.mock.ExpectQuery("SELECT fix_lat").WithArgs(20, 3).WillReturnError(sql.ErrNoRows)
+	expectFailure(s.mock, proof.ReasonOutsideGeofence)
+
+	body, _ := json.Marshal(model.CheckpointProof{Fix: &model.GPSFix{
+		Point: geo.Point{Lat: 55.76, Lon: 37.61}, Accuracy: 8, Time: model.QuotedTime(time.Now()),
+	}})
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(string(body)))
+	s.env.ReachCheckpoint(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+	s.Contains(s.rw.Body.String(), proof.ReasonOutsideGeofence)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestReachCheckpointWrongAnswer() {
+	salt, _ := proof.NewSecret()
+	expectStoredQuest(s.mock, 1)
+	expectCheckpoint(s.mock, model.Checkpoint{
+		Type: model.CheckpointAnswer, AnswerSalt: salt, AnswerHashes: [][]byte{proof.HashAnswer(salt, "Пушкин")},
+	})
+	expectMissed(s.mock, 0)
+	expectFailure(s.mock, proof.ReasonWrongAnswer)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"answer": "Лермонтов"}`))
+	s.env.ReachCheckpoint(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+	s.Contains(s.rw.Body.String(), proof.ReasonWrongAnswer)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestReachCheckpointWithoutProof() {
+	expectStoredQuest(s.mock, 1)
+	expectCheckpoint(s.mock, model.Checkpoint{Type: model.CheckpointQR, QRSecret: []byte("secret")})
+	expectMissed(s.mock, 0)
+	expectFailure(s.mock, proof.ReasonMissingProof)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, http.NoBody)
+	s.env.ReachCheckpoint(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *CheckpointTestSuite) TestGetCheckpointFailures() {
+	s.mock.
+		ExpectQuery("SELECT .+ FROM checkpoint_failure").
+		WithArgs(3, 0, 0, defaultFailuresLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "quest_id", "checkpoint_id", "reason", "details", "created_at"}).
+			AddRow(1, 20, 3, 2, proof.ReasonWrongAnswer, "", time.Now()))
+
+	s.c.Request, _ = getRequest(urlSample+"?quest_id=3", http.MethodGet, nil)
+	s.env.GetCheckpointFailures(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	resp := struct {
+		Data []model.CheckpointFailure `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Require().Len(resp.Data, 1)
+	s.Equal(proof.ReasonWrongAnswer, resp.Data[0].Reason)
+}
+
+func (s *CheckpointTestSuite) TestGetCheckpointFailuresBadLimit() {
+	s.c.Request, _ = getRequest(urlSample+"?limit=0", http.MethodGet, nil)
+	s.env.GetCheckpointFailures(s.c)
+
+	s.Equal(http.StatusBadRequest, s.rw.Code)
+}
+
+func (s *CheckpointTestSuite) expectMarkerCheckpoints() {
+	s.mock.
+		ExpectQuery("SELECT .+ FROM quest_checkpoint WHERE quest_id = \\$1 ORDER BY position").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(checkpointSecretColumns[:11]).
+			AddRow(2, 3, 1, "a", "", model.CheckpointARMarker, false, nil, nil, nil, "statue"))
+}
+
+func (s *CheckpointTestSuite) TestGetCheckpointsHidesMarker() {
+	expectStoredQuest(s.mock, 1)
+	s.expectMarkerCheckpoints()
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetQuestCheckpoints(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"type":"ar_marker"`)
+	s.NotContains(s.rw.Body.String(), "statue")
+}
+
+func (s *CheckpointTestSuite) TestGetCheckpointsAnonymousHidesMarker() {
+	s.c.Set(UserID, 0)
+	expectStoredQuest(s.mock, 0)
+	s.expectMarkerCheckpoints()
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetQuestCheckpoints(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.NotContains(s.rw.Body.String(), "statue")
+}
+
+func (s *CheckpointTestSuite) TestGetCheckpointsShowsMarkerToAuthor() {
+	expectStoredQuest(s.mock, 20)
+	s.expectMarkerCheckpoints()
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetQuestCheckpoints(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"marker_id":"statue"`)
+}
+
+func (s *CheckpointTestSuite) TestProgressHidesMarker() {
+	expectStoredQuest(s.mock, 1)
+	expectLatestAttempt(s.mock, model.AttemptActive)
+	s.mock.
+		ExpectQuery("SELECT .+ reached_at").
+		WithArgs(3, 7).
+		WillReturnRows(sqlmock.NewRows(checkpointProgressColumns).
+			AddRow(2, 3, 1, "a", "", model.CheckpointARMarker, false, nil, nil, nil, "statue", nil, 0, 0))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetQuestProgress(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.NotContains(s.rw.Body.String(), "statue")
+}
+
+func (s *CheckpointTestSuite) TestProgressOfDeletedQuest() {
+	s.mock.ExpectQuery("SELECT id, name").WithArgs(3).WillReturnError(sql.ErrNoRows)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetQuestProgress(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+}
+
+func TestCheckpointTestSuite(t *testing.T) {
+	suite.Run(t, new(CheckpointTestSuite))
+}
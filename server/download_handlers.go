@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/Sovianum/arquest-server/storage"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const (
+	archiveContentType = "application/zip"
+	fileContentType    = "application/octet-stream"
+
+	sha256Param = "sha256"
+)
+
+var (
+	noArchiveErr      = fmt.Errorf("quest has no archive")
+	noFileErr         = fmt.Errorf("quest has no such file")
+	unpublishedErr    = fmt.Errorf("quest not found")
+	cannotDownloadErr = fmt.Errorf("you can not download this quest")
+)
+
+// DownloadQuestArchive serves the archive of the published quest version to an authorized
+// user. Range and If-Range requests let clients resume interrupted downloads; the archive
+// SHA-256 is the ETag, so unchanged archives are answered with 304.
+func (env *Env) DownloadQuestArchive(c *gin.Context) {
+	quest, ok := env.getDownloadableQuest(c)
+	if !ok {
+		return
+	}
+	version, err := env.versionDAO.GetPublishedVersion(quest.ID)
+	if err != nil {
+		if err.Code() == http.StatusNotFound {
+			c.JSON(http.StatusNotFound, common.GetErrResponse(noArchiveErr))
+		} else {
+			c.JSON(err.Code(), common.GetErrResponse(err))
+		}
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="quest-%d-v%d.zip"`, quest.ID, version.Version))
+	env.serveObject(c, version.ArchiveKey, version.ArchiveSHA256, archiveContentType)
+}
+
+// DownloadQuestFile serves a single file of the quest by its SHA-256, so that clients update
+// only the files listed in the version diff. Players get the files of the published version,
+// the author and admins the files of any version.
+func (env *Env) DownloadQuestFile(c *gin.Context) {
+	quest, ok := env.getDownloadableQuest(c)
+	if !ok {
+		return
+	}
+	sha256 := c.Param(sha256Param)
+	if decoded, err := hex.DecodeString(sha256); err != nil || len(decoded) != 32 || sha256 != hex.EncodeToString(decoded) {
+		c.JSON(http.StatusNotFound, common.GetErrResponse(noFileErr))
+		return
+	}
+	exists, err := env.versionDAO.HasFile(quest.ID, sha256, !canManageQuest(c, quest))
+	if err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	} else if !exists {
+		c.JSON(http.StatusNotFound, common.GetErrResponse(noFileErr))
+		return
+	}
+	env.serveObject(c, fileKey(sha256), sha256, fileContentType)
+}
+
+// serveObject redirects to the storage URL of the object if downloads are redirected
+// and streams the object with conditional and range requests support otherwise.
+func (env *Env) serveObject(c *gin.Context, key string, sha256 string, contentType string) {
+	if env.conf.Storage.RedirectDownloads {
+		location, err := env.storage.URL(key)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+			return
+		}
+		c.Redirect(http.StatusFound, location)
+		return
+	}
+
+	obj, info, err := env.storage.Open(key)
+	if err == storage.ErrNotFound {
+		env.logger.Errorf("object %s is recorded but missing in the storage", key)
+		c.JSON(http.StatusNotFound, common.GetErrResponse(fmt.Errorf("object is missing in the storage")))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+		return
+	}
+	defer obj.Close()
+
+	header := c.Writer.Header()
+	header.Set("ETag", `"`+sha256+`"`)
+	header.Set("Content-Type", contentType)
+	header.Set("Cache-Control", "private, no-cache")
+	http.ServeContent(c.Writer, c.Request, "", info.ModTime, obj)
+}
+
+// getDownloadableQuest loads the quest from the id path parameter and checks that the
+// current user may download its content. On failure it writes the response itself and returns false.
+func (env *Env) getDownloadableQuest(c *gin.Context) (model.Quest, bool) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return model.Quest{}, false
+	}
+	quest, dbErr := env.questDAO.GetQuest(questID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return model.Quest{}, false
+	}
+	if code, err := checkQuestDownload(c, quest); err != nil {
+		c.JSON(code, common.GetErrResponse(err))
+		return model.Quest{}, false
+	}
+	return quest, true
+}
+
+// checkQuestDownload decides whether the current user may fetch the quest assets. The author
+// and admins may download drafts; other signed in users only quests with a published version,
+// and drafts are reported as missing to them.
+func checkQuestDownload(c *gin.Context, quest model.Quest) (int, error) {
+	switch {
+	case c.GetInt(UserID) == 0:
+		return http.StatusForbidden, cannotDownloadErr
+	case canManageQuest(c, quest):
+		return http.StatusOK, nil
+	case quest.PublishedVersion == 0:
+		return http.StatusNotFound, unpublishedErr
+	}
+	return http.StatusOK, nil
+}
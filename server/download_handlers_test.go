@@ -0,0 +1,260 @@
+package server
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/config"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/storage"
+	"github.com/Sovianum/arquest-server/storage/s3test"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var versionColumns = []string{
+	"quest_id", "version", "changelog", "archive_key", "archive_size", "archive_sha256", "author_id", "created_at", "published",
+}
+
+const (
+	archiveContent = "0123456789"
+	archiveSHA256  = "84d89877f0d4041efb6bf91a16f0248f2fd573e6af05c19f96bedb9f882f7882"
+)
+
+type DownloadTestSuite struct {
+	suite.Suite
+	db      *sql.DB
+	env     *Env
+	mock    sqlmock.Sqlmock
+	dataDir string
+}
+
+func (s *DownloadTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.dataDir, err = ioutil.TempDir("", "quests")
+	s.Require().NoError(err)
+	s.Require().NoError(os.MkdirAll(filepath.Join(s.dataDir, "archives"), 0755))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.dataDir, "archives", archiveSHA256), []byte(archiveContent), 0644))
+
+	s.env = getEnv(s.db)
+	s.env.questDAO = dao.NewQuestDAO(s.db)
+	s.env.storage, err = storage.NewFS(s.dataDir, questsURL)
+	s.Require().NoError(err)
+	gin.SetMode(gin.ReleaseMode)
+}
+
+func (s *DownloadTestSuite) TearDownTest() {
+	os.RemoveAll(s.dataDir)
+}
+
+// expectQuest expects the quest 3 of the author 1 with the version 5 published to be read.
+func (s *DownloadTestSuite) expectQuest() {
+	s.expectQuestVersion(5)
+}
+
+func (s *DownloadTestSuite) expectQuestVersion(publishedVersion int) {
+	row := questRow(3, "n1", "d1", 4., 1, 2, 0, 0, "")
+	if publishedVersion != 0 {
+		row = questRow(3, "n1", "d1", 4., 1, 2, publishedVersion, len(archiveContent), archiveSHA256)
+	}
+	s.mock.
+		ExpectQuery("SELECT id, name, description, rating, author_id, version").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(questColumnNames).AddRow(row...))
+}
+
+func (s *DownloadTestSuite) expectPublished(published bool) {
+	rows := sqlmock.NewRows(versionColumns)
+	if published {
+		rows.AddRow(3, 5, "", archiveKey(archiveSHA256), len(archiveContent), archiveSHA256, 1, time.Now(), true)
+	}
+	s.mock.ExpectQuery("SELECT v.quest_id").WithArgs(3).WillReturnRows(rows)
+	if published {
+		s.mock.ExpectQuery("SELECT path, size, sha256").WithArgs(3, 5).WillReturnRows(sqlmock.NewRows([]string{"path", "size", "sha256"}))
+	}
+}
+
+func (s *DownloadTestSuite) download(userID int, headers ...headerPair) *httptest.ResponseRecorder {
+	return s.serve(s.env.DownloadQuestArchive, userID, gin.Params{{Key: idParam, Value: "3"}}, headers...)
+}
+
+func (s *DownloadTestSuite) serve(handler gin.HandlerFunc, userID int, params gin.Params, headers ...headerPair) *httptest.ResponseRecorder {
+	rw := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rw)
+	if userID != 0 {
+		c.Set(UserID, userID)
+	}
+	c.Params = params
+	var err error
+	c.Request, err = getRequest(urlSample, http.MethodGet, strings.NewReader(""), headers...)
+	s.Require().NoError(err)
+	handler(c)
+	c.Writer.WriteHeaderNow() // the engine does it after the handler chain
+	return rw
+}
+
+func (s *DownloadTestSuite) TestFullDownload() {
+	s.expectQuest()
+	s.expectPublished(true)
+
+	rw := s.download(20)
+
+	s.Require().Equal(http.StatusOK, rw.Code)
+	s.Equal(archiveContent, rw.Body.String())
+	s.Equal(`"`+archiveSHA256+`"`, rw.Header().Get("ETag"))
+	s.Equal("bytes", rw.Header().Get("Accept-Ranges"))
+	s.Equal(archiveContentType, rw.Header().Get("Content-Type"))
+	s.NotEmpty(rw.Header().Get("Last-Modified"))
+}
+
+func (s *DownloadTestSuite) TestRange() {
+	s.expectQuest()
+	s.expectPublished(true)
+
+	rw := s.download(20, headerPair{"Range", "bytes=4-"})
+
+	s.Require().Equal(http.StatusPartialContent, rw.Code)
+	s.Equal("456789", rw.Body.String())
+	s.Equal("bytes 4-9/10", rw.Header().Get("Content-Range"))
+}
+
+func (s *DownloadTestSuite) TestStaleIfRange() {
+	s.expectQuest()
+	s.expectPublished(true)
+
+	rw := s.download(20, headerPair{"Range", "bytes=4-"}, headerPair{"If-Range", `"outdated"`})
+
+	s.Equal(http.StatusOK, rw.Code)
+	s.Equal(archiveContent, rw.Body.String())
+}
+
+func (s *DownloadTestSuite) TestNotModified() {
+	s.expectQuest()
+	s.expectPublished(true)
+
+	rw := s.download(20, headerPair{"If-None-Match", `"` + archiveSHA256 + `"`})
+
+	s.Equal(http.StatusNotModified, rw.Code)
+	s.Empty(rw.Body.String())
+}
+
+func (s *DownloadTestSuite) TestUnauthorized() {
+	s.expectQuest()
+
+	rw := s.download(0)
+
+	s.Equal(http.StatusForbidden, rw.Code)
+}
+
+func (s *DownloadTestSuite) TestNoArchive() {
+	s.expectQuest()
+	s.expectPublished(false)
+
+	rw := s.download(20)
+
+	s.Equal(http.StatusNotFound, rw.Code)
+}
+
+func (s *DownloadTestSuite) TestRangeFromS3() {
+	server := s3test.NewServer("quests", "access")
+	defer server.Close()
+	var err error
+	s.env.storage, err = storage.NewS3(config.S3StorageConfig{
+		Endpoint: server.URL, Region: "us-east-1", Bucket: "quests", AccessKey: "access", SecretKey: "secret", PathStyle: true,
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(s.env.storage.Put(archiveKey(archiveSHA256), strings.NewReader(archiveContent), int64(len(archiveContent))))
+	s.expectQuest()
+	s.expectPublished(true)
+
+	rw := s.download(20, headerPair{"Range", "bytes=2-4"})
+
+	s.Require().Equal(http.StatusPartialContent, rw.Code)
+	s.Equal("234", rw.Body.String())
+}
+
+func (s *DownloadTestSuite) TestRedirect() {
+	s.env.conf.Storage.RedirectDownloads = true
+	s.expectQuest()
+	s.expectPublished(true)
+
+	rw := s.download(20)
+
+	s.Equal(http.StatusFound, rw.Code)
+	s.Equal(questsURL+archiveKey(archiveSHA256), rw.Header().Get("Location"))
+}
+
+func (s *DownloadTestSuite) TestFile() {
+	s.Require().NoError(os.MkdirAll(filepath.Join(s.dataDir, "files"), 0755))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.dataDir, "files", archiveSHA256), []byte(archiveContent), 0644))
+	s.expectQuest()
+	s.mock.ExpectQuery("SELECT EXISTS").WithArgs(3, archiveSHA256, true).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	rw := s.serve(s.env.DownloadQuestFile, 20, gin.Params{{Key: idParam, Value: "3"}, {Key: sha256Param, Value: archiveSHA256}})
+
+	s.Require().Equal(http.StatusOK, rw.Code)
+	s.Equal(archiveContent, rw.Body.String())
+	s.Equal(fileContentType, rw.Header().Get("Content-Type"))
+}
+
+func (s *DownloadTestSuite) TestForeignFile() {
+	s.expectQuest()
+	s.mock.ExpectQuery("SELECT EXISTS").WithArgs(3, archiveSHA256, true).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	rw := s.serve(s.env.DownloadQuestFile, 20, gin.Params{{Key: idParam, Value: "3"}, {Key: sha256Param, Value: archiveSHA256}})
+
+	s.Equal(http.StatusNotFound, rw.Code)
+}
+
+func (s *DownloadTestSuite) TestMalformedFileHash() {
+	s.expectQuest()
+
+	rw := s.serve(s.env.DownloadQuestFile, 20, gin.Params{{Key: idParam, Value: "3"}, {Key: sha256Param, Value: "../../secret"}})
+
+	s.Equal(http.StatusNotFound, rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *DownloadTestSuite) TestDraftHiddenFromPlayer() {
+	s.expectQuestVersion(0)
+
+	rw := s.download(20)
+
+	s.Equal(http.StatusNotFound, rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *DownloadTestSuite) TestDraftFileHiddenFromPlayer() {
+	s.expectQuestVersion(0)
+
+	rw := s.serve(s.env.DownloadQuestFile, 20, gin.Params{{Key: idParam, Value: "3"}, {Key: sha256Param, Value: archiveSHA256}})
+
+	s.Equal(http.StatusNotFound, rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *DownloadTestSuite) TestDraftFileByAuthor() {
+	s.Require().NoError(os.MkdirAll(filepath.Join(s.dataDir, "files"), 0755))
+	s.Require().NoError(ioutil.WriteFile(filepath.Join(s.dataDir, "files", archiveSHA256), []byte(archiveContent), 0644))
+	s.expectQuestVersion(0)
+	s.mock.ExpectQuery("SELECT EXISTS").WithArgs(3, archiveSHA256, false).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	rw := s.serve(s.env.DownloadQuestFile, 1, gin.Params{{Key: idParam, Value: "3"}, {Key: sha256Param, Value: archiveSHA256}})
+
+	s.Require().Equal(http.StatusOK, rw.Code)
+	s.Equal(archiveContent, rw.Body.String())
+}
+
+func TestDownloadTestSuite(t *testing.T) {
+	suite.Run(t, new(DownloadTestSuite))
+}
@@ -1,16 +1,21 @@
 package server
 
 import (
-	"crypto/sha256"
 	"database/sql"
 	"fmt"
 	"github.com/Sovianum/arquest-server/common"
 	"github.com/Sovianum/arquest-server/config"
 	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/moderation"
 	"github.com/Sovianum/arquest-server/mylog"
+	"github.com/Sovianum/arquest-server/passhash"
+	"github.com/Sovianum/arquest-server/proof"
+	"github.com/Sovianum/arquest-server/signing"
+	"github.com/Sovianum/arquest-server/storage"
 	"github.com/dgrijalva/jwt-go"
 	"net/http"
 	"strings"
+	"time"
 )
 
 const (
@@ -19,77 +24,111 @@ const (
 
 type tokenKeyGetterType func() string
 
-func NewEnv(db *sql.DB, conf *config.Conf, logger *mylog.Logger) *Env {
+func NewEnv(db *sql.DB, conf *config.Conf, logger *mylog.Logger) (*Env, error) {
+	hasher, err := passhash.NewHasherFromConfig(conf.Auth.PasswordHash)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := signing.NewKeySet(conf.Auth)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := storage.New(conf.Storage)
+	if err != nil {
+		return nil, err
+	}
+	nearbyDAO, err := dao.NewNearbyQuestDAO(db, conf.DB.Spatial)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := moderation.NewFilter(conf.Logic.Moderation)
+	if err != nil {
+		return nil, err
+	}
+
 	env := &Env{
-		userDAO:  dao.NewDBUserDAO(db),
-		questDAO: dao.NewQuestDAO(db),
-		markDAO:  dao.NewMarkDAO(db),
-		conf:     conf,
-		hashFunc: func(password []byte) ([]byte, error) {
-			var h = sha256.New()
-			h.Write(password)
-			return h.Sum(nil), nil
-		},
-		hashValidator: func(password []byte, hash []byte) error {
-			var h = sha256.New()
-			h.Write(password)
-			var passHash = h.Sum(nil)
-
-			if string(passHash) != string(hash) {
-				return fmt.Errorf("hashes %s, %s do not match", string(passHash), string(hash))
-			}
-			return nil
-		},
-		logger: logger,
-	}
-	return env
+		userDAO:       dao.NewDBUserDAO(db),
+		questDAO:      dao.NewQuestDAO(db),
+		markDAO:       dao.NewMarkDAO(db),
+		tokenDAO:      dao.NewTokenDAO(db),
+		roleDAO:       dao.NewRoleDAO(db),
+		versionDAO:    dao.NewQuestVersionDAO(db),
+		nearbyDAO:     nearbyDAO,
+		checkpointDAO: dao.NewCheckpointDAO(db),
+		scenarioDAO:   dao.NewScenarioDAO(db),
+		hintDAO:       dao.NewHintDAO(db),
+		attemptDAO:    dao.NewAttemptDAO(db),
+		friendDAO:     dao.NewFriendDAO(db),
+		teamDAO:       dao.NewTeamDAO(db),
+		eventDAO:      dao.NewEventDAO(db),
+		reviewDAO:     dao.NewReviewDAO(db),
+		moderationDAO: dao.NewModerationDAO(db),
+		verifier:      proof.NewVerifier(conf.Logic.Proof),
+		filter:        filter,
+		conf:          conf,
+		hasher:        hasher,
+		keys:          keys,
+		storage:       assets,
+		logger:        logger,
+	}
+	return env, nil
 }
 
 type Env struct {
 	userDAO       dao.UserDAO
 	questDAO      dao.QuestDAO
 	markDAO       dao.MarkDAO
+	tokenDAO      dao.TokenDAO
+	roleDAO       dao.RoleDAO
+	versionDAO    dao.QuestVersionDAO
+	nearbyDAO     dao.NearbyQuestDAO
+	checkpointDAO dao.CheckpointDAO
+	scenarioDAO   dao.ScenarioDAO
+	hintDAO       dao.HintDAO
+	attemptDAO    dao.AttemptDAO
+	friendDAO     dao.FriendDAO
+	teamDAO       dao.TeamDAO
+	eventDAO      dao.EventDAO
+	reviewDAO     dao.ReviewDAO
+	moderationDAO dao.ModerationDAO
+	verifier      *proof.Verifier
+	filter        moderation.Filter
 	conf          *config.Conf
-	hashFunc      func(password []byte) ([]byte, error)
-	hashValidator func(password []byte, hash []byte) error
+	hasher        passhash.Hasher
+	keys          *signing.KeySet
+	storage       storage.Storage
 	logger        *mylog.Logger
 }
 
-// TODO use some standard mechanisms instead of bicycles
 func (env *Env) parseTokenString(tokenString string) (*jwt.Token, error) {
-	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return env.conf.Auth.GetTokenKey(), nil
-	})
+	return jwt.Parse(tokenString, env.keys.Keyfunc)
 }
 
-func (env *Env) getIdFromRequest(r *http.Request) (id int, code int, err error) {
+func (env *Env) getTokenFromRequest(r *http.Request) (token *jwt.Token, code int, err error) {
 	headers := r.Header
 	authHeaderList, ok := headers[authorizationStr]
 	if !ok {
-		return 0, http.StatusUnauthorized, fmt.Errorf("header \"Authorization\" not set in request")
+		return nil, http.StatusUnauthorized, fmt.Errorf("header \"Authorization\" not set in request")
 	}
 	if len(authHeaderList) != 1 {
-		return 0, http.StatusBadRequest, fmt.Errorf("you set too many (%d) \"Authorization\" headers", len(authHeaderList))
+		return nil, http.StatusBadRequest, fmt.Errorf("you set too many (%d) \"Authorization\" headers", len(authHeaderList))
 	}
 	authHeader := authHeaderList[0]
 
 	fields := strings.Fields(authHeader) // getting last word to remove Bearer word from header
+	if len(fields) == 0 {
+		return nil, http.StatusUnauthorized, fmt.Errorf("header \"Authorization\" is empty")
+	}
 	tokenString := fields[len(fields)-1]
 
 	token, tokenErr := env.parseTokenString(tokenString)
-	if tokenErr != nil {
-		return 0, http.StatusBadRequest, fmt.Errorf("you sent unparseable token")
+	if validationErr, ok := tokenErr.(*jwt.ValidationError); ok && validationErr.Errors&jwt.ValidationErrorExpired != 0 {
+		return nil, http.StatusUnauthorized, fmt.Errorf("your token has expired")
 	}
-
-	userId, idErr := env.getIdFromTokenString(token)
-	if idErr != nil {
-		return 0, http.StatusBadRequest, fmt.Errorf("your token does not contain your id")
+	if tokenErr != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("you sent unparseable token")
 	}
-
-	return userId, http.StatusOK, nil
+	return token, http.StatusOK, nil
 }
 
 func (env *Env) getIdFromTokenString(token *jwt.Token) (int, error) {
@@ -116,3 +155,53 @@ func (env *Env) getIdFromTokenString(token *jwt.Token) (int, error) {
 
 	return id, nil
 }
+
+func (env *Env) getJtiFromToken(token *jwt.Token) (string, error) {
+	claims, okClaims := token.Claims.(jwt.MapClaims)
+	if !okClaims {
+		return "", fmt.Errorf("failed to extract claims from token")
+	}
+	jti, ok := claims[jtiStr].(string)
+	if !ok || jti == "" {
+		return "", fmt.Errorf("failed to extract jti from claims")
+	}
+	return jti, nil
+}
+
+// getRolesFromToken returns no roles for tokens issued before roles were introduced.
+func (env *Env) getRolesFromToken(token *jwt.Token) ([]string, error) {
+	claims, okClaims := token.Claims.(jwt.MapClaims)
+	if !okClaims {
+		return nil, fmt.Errorf("failed to extract claims from token")
+	}
+	rolesData, ok := claims[rolesStr]
+	if !ok {
+		return []string{}, nil
+	}
+	list, ok := rolesData.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("failed to cast claims[roles] to list")
+	}
+
+	roles := make([]string, 0, len(list))
+	for _, item := range list {
+		role, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("failed to cast role to string")
+		}
+		roles = append(roles, role)
+	}
+	return roles, nil
+}
+
+func (env *Env) getExpFromToken(token *jwt.Token) (time.Time, error) {
+	claims, okClaims := token.Claims.(jwt.MapClaims)
+	if !okClaims {
+		return time.Time{}, fmt.Errorf("failed to extract claims from token")
+	}
+	exp, ok := claims[expStr].(float64)
+	if !ok {
+		return time.Time{}, fmt.Errorf("failed to extract exp from claims")
+	}
+	return time.Unix(int64(exp), 0), nil
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	lastEventIDHeader = "Last-Event-ID"
+	lastEventIDQuery  = "last_event_id"
+	// teamEventBatch is the number of events read from the database at once
+	teamEventBatch = 100
+	// eventRetryMS is how long clients wait before reconnecting to a closed stream
+	eventRetryMS = 3000
+)
+
+// StreamTeamEvents streams the events of the team to its member as server-sent events. The
+// stream starts after the event given in the Last-Event-ID header or the last_event_id query
+// (sent again by clients on reconnect) or, if there is none or it is ahead of the team, with
+// the events following the request. It ends when the client disconnects, the access token
+// expires or the member leaves.
+func (env *Env) StreamTeamEvents(c *gin.Context) {
+	teamID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	afterID, resume, err := getLastEventID(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	userID := c.GetInt(UserID)
+	if _, dbErr := env.teamDAO.GetTeam(userID, teamID); dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	lastID, dbErr := env.eventDAO.GetLastTeamEventID(teamID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	// ids given before migration 22 were global and may be ahead of the team numbers
+	if !resume || afterID > lastID {
+		afterID = lastID
+	}
+
+	expired := time.NewTimer(time.Until(c.GetTime(TokenExpiry)))
+	defer expired.Stop()
+	poll := time.NewTicker(env.conf.Logic.Events.GetPollInterval())
+	defer poll.Stop()
+	heartbeat := env.conf.Logic.Events.GetHeartbeat()
+
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry:%d\n\n", eventRetryMS)
+	c.Writer.Flush()
+	written := time.Now()
+
+	for {
+		events, dbErr := env.eventDAO.GetTeamEvents(teamID, afterID, teamEventBatch)
+		if dbErr != nil {
+			env.logger.Errorf("failed to read events of team %d: %v", teamID, dbErr)
+			return
+		}
+		for _, event := range events {
+			if err := sse.Encode(c.Writer, sse.Event{Id: strconv.FormatInt(event.ID, 10), Event: event.Type, Data: event}); err != nil {
+				return
+			}
+			afterID = event.ID
+			written = time.Now()
+			if event.Type == model.EventMemberLeft && event.UserID == userID {
+				c.Writer.Flush()
+				return
+			}
+		}
+		if time.Since(written) >= heartbeat {
+			// comments keep proxies from closing an idle stream and are ignored by clients
+			fmt.Fprint(c.Writer, ":\n\n")
+			written = time.Now()
+		}
+		c.Writer.Flush()
+
+		if len(events) == teamEventBatch {
+			continue
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-expired.C:
+			return
+		case <-poll.C:
+		}
+	}
+}
+
+// getLastEventID reads the id of the last event seen by the client; resume is false if the
+// client has not seen any.
+func getLastEventID(c *gin.Context) (id int64, resume bool, err error) {
+	value := c.Request.Header.Get(lastEventIDHeader)
+	if value == "" {
+		value = c.Query(lastEventIDQuery)
+	}
+	if value == "" {
+		return 0, false, nil
+	}
+	if id, err = strconv.ParseInt(value, 10, 64); err != nil || id < 0 {
+		return 0, false, fmt.Errorf("%s must be a non-negative integer", lastEventIDQuery)
+	}
+	return id, true, nil
+}
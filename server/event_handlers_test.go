@@ -0,0 +1,167 @@
+package server
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var teamEventColumns = []string{"id", "type", "team_id", "attempt_id", "user_id", "login", "checkpoint_id", "created_at"}
+
+type EventTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *EventTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	s.env.teamDAO = dao.NewTeamDAO(s.db)
+	s.env.eventDAO = dao.NewEventDAO(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+	s.c.Set(UserID, 20)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+}
+
+// expectTeamMember expects the team 4 to be read for its member, the current user.
+func (s *EventTestSuite) expectTeamMember() {
+	s.c.Params = gin.Params{{Key: idParam, Value: "4"}}
+	s.mock.
+		ExpectQuery("SELECT t.id, t.name").
+		WithArgs(4, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "invite_code", "created_at"}).AddRow(4, "owls", "abcdefgh", time.Now()))
+	s.mock.
+		ExpectQuery("SELECT u.id, u.login").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "login", "joined_at"}).AddRow(20, "login", time.Now()))
+}
+
+// expectLastTeamEvent expects the number of the latest event of the team 4 to be read.
+func (s *EventTestSuite) expectLastTeamEvent(seq int64) {
+	s.mock.
+		ExpectQuery("SELECT COALESCE\\(\\(SELECT last_event_seq FROM team").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"last_event_seq"}).AddRow(seq))
+}
+
+func (s *EventTestSuite) TestStreamTeamEventsResume() {
+	s.expectTeamMember()
+	s.expectLastTeamEvent(7)
+	s.mock.
+		ExpectQuery("FROM team_event").
+		WithArgs(4, int64(5), teamEventBatch).
+		WillReturnRows(sqlmock.NewRows(teamEventColumns).
+			AddRow(6, model.EventCheckpointReached, 4, 7, 21, "other", 2, time.Now()).
+			AddRow(7, model.EventHintUsed, 4, 7, 20, "login", 2, time.Now()))
+	s.c.Set(TokenExpiry, time.Now().Add(50*time.Millisecond))
+
+	s.c.Request, _ = getRequest("/api/v1/user/teams/4/events", http.MethodGet, nil, headerPair{lastEventIDHeader, "5"})
+	s.env.StreamTeamEvents(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.Equal("text/event-stream", s.rw.Header().Get("Content-Type"))
+	body := s.rw.Body.String()
+	s.Contains(body, "retry:3000\n\n")
+	s.Contains(body, "id:6\nevent:checkpoint_reached\ndata:{\"id\":6,\"type\":\"checkpoint_reached\",\"team_id\":4,\"attempt_id\":7,\"user_id\":21")
+	s.Contains(body, "id:7\nevent:hint_used\n")
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *EventTestSuite) TestStreamTeamEventsEndsWhenMemberLeaves() {
+	s.expectTeamMember()
+	s.expectLastTeamEvent(9)
+	s.mock.
+		ExpectQuery("FROM team_event").
+		WithArgs(4, int64(9), teamEventBatch).
+		WillReturnRows(sqlmock.NewRows(teamEventColumns).AddRow(10, model.EventMemberLeft, 4, 0, 20, "login", 0, time.Now()))
+	s.c.Set(TokenExpiry, time.Now().Add(time.Hour))
+
+	s.c.Request, _ = getRequest("/api/v1/user/teams/4/events", http.MethodGet, nil)
+	s.env.StreamTeamEvents(s.c)
+
+	s.Contains(s.rw.Body.String(), "event:member_left")
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+// An event committed after a later one is numbered after it, so the stream resumed after
+// the later one still delivers it.
+func (s *EventTestSuite) TestStreamTeamEventsLateCommit() {
+	s.env.conf.Logic.Events.PollIntervalMS = 10
+	s.expectTeamMember()
+	s.expectLastTeamEvent(5)
+	s.mock.
+		ExpectQuery("FROM team_event").
+		WithArgs(4, int64(5), teamEventBatch).
+		WillReturnRows(sqlmock.NewRows(teamEventColumns).AddRow(6, model.EventHintUsed, 4, 7, 21, "other", 2, time.Now()))
+	s.mock.
+		ExpectQuery("FROM team_event").
+		WithArgs(4, int64(6), teamEventBatch).
+		WillReturnRows(sqlmock.NewRows(teamEventColumns).AddRow(7, model.EventCheckpointReached, 4, 7, 20, "login", 3, time.Now()))
+	s.mock.
+		ExpectQuery("FROM team_event").
+		WithArgs(4, int64(7), teamEventBatch).
+		WillReturnRows(sqlmock.NewRows(teamEventColumns))
+	s.c.Set(TokenExpiry, time.Now().Add(25*time.Millisecond))
+
+	s.c.Request, _ = getRequest("/api/v1/user/teams/4/events", http.MethodGet, nil, headerPair{lastEventIDHeader, "5"})
+	s.env.StreamTeamEvents(s.c)
+
+	body := s.rw.Body.String()
+	s.Contains(body, "id:6\nevent:hint_used\n")
+	s.Contains(body, "id:7\nevent:checkpoint_reached\n")
+}
+
+func (s *EventTestSuite) TestStreamTeamEventsLastEventIDAhead() {
+	s.expectTeamMember()
+	s.expectLastTeamEvent(3)
+	s.mock.
+		ExpectQuery("FROM team_event").
+		WithArgs(4, int64(3), teamEventBatch).
+		WillReturnRows(sqlmock.NewRows(teamEventColumns))
+	s.c.Set(TokenExpiry, time.Now().Add(10*time.Millisecond))
+
+	s.c.Request, _ = getRequest("/api/v1/user/teams/4/events", http.MethodGet, nil, headerPair{lastEventIDHeader, "1500"})
+	s.env.StreamTeamEvents(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *EventTestSuite) TestStreamTeamEventsBadLastEventID() {
+	s.c.Params = gin.Params{{Key: idParam, Value: "4"}}
+	s.c.Request, _ = getRequest("/api/v1/user/teams/4/events?last_event_id=x", http.MethodGet, nil)
+	s.env.StreamTeamEvents(s.c)
+
+	s.Equal(http.StatusBadRequest, s.rw.Code)
+}
+
+func (s *EventTestSuite) TestStreamTeamEventsNotMember() {
+	s.c.Params = gin.Params{{Key: idParam, Value: "4"}}
+	s.mock.ExpectQuery("SELECT t.id, t.name").WithArgs(4, 20).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "invite_code", "created_at"}))
+
+	s.c.Request, _ = getRequest("/api/v1/user/teams/4/events", http.MethodGet, nil)
+	s.env.StreamTeamEvents(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+}
+
+func TestEventTestSuite(t *testing.T) {
+	suite.Run(t, new(EventTestSuite))
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// GetCheckpointHints returns the hints of the checkpoint already taken by the current user
+// and the number of hints the checkpoint has.
+func (env *Env) GetCheckpointHints(c *gin.Context) {
+	env.writeHints(c, env.hintDAO.GetHints)
+}
+
+// UseCheckpointHint gives the current user the next hint of the checkpoint. Every hint
+// lowers the score of the quest by its penalty; 409 means all hints are taken.
+func (env *Env) UseCheckpointHint(c *gin.Context) {
+	env.writeHints(c, env.hintDAO.UseHint)
+}
+
+func (env *Env) writeHints(c *gin.Context, getHints func(userID, questID, checkpointID int) (model.CheckpointHints, dao.DBError)) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	checkpointID, err := getIntParam(c, checkpointParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if _, err := env.questDAO.GetQuest(questID); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+
+	hints, dbErr := getHints(c.GetInt(UserID), questID, checkpointID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(hints))
+}
+
+// setHintPenalties gives hints without a penalty the default one; an explicit zero is kept.
+func setHintPenalties(checkpoints []model.Checkpoint, defaultPenalty int) {
+	for i := range checkpoints {
+		for j := range checkpoints[i].Hints {
+			if checkpoints[i].Hints[j].Penalty == nil {
+				penalty := defaultPenalty
+				checkpoints[i].Hints[j].Penalty = &penalty
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type HintTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *HintTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	s.env.questDAO = dao.NewQuestDAO(s.db)
+	s.env.hintDAO = dao.NewHintDAO(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+	s.c.Set(UserID, 20)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}, {Key: checkpointParam, Value: "2"}}
+}
+
+func (s *HintTestSuite) TestUseCheckpointHint() {
+	expectStoredQuest(s.mock, 1)
+	s.mock.ExpectBegin()
+	expectActiveAttempt(s.mock)
+	s.mock.ExpectQuery("SELECT COALESCE\\(MAX\\(tier\\), 0\\) \\+ 1").WithArgs(7, 2).WillReturnRows(sqlmock.NewRows([]string{"tier"}).AddRow(1))
+	s.mock.ExpectExec("INSERT INTO hint_usage").WithArgs(7, 20, 2, 3, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	s.mock.
+		ExpectQuery("SELECT .+ FROM checkpoint_hint").
+		WithArgs(2, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	s.mock.
+		ExpectQuery("SELECT u.tier").
+		WithArgs(20, 3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"tier", "text", "penalty", "used_at"}).AddRow(1, "north", 50, time.Now()))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, http.NoBody)
+	s.env.UseCheckpointHint(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"total":2`)
+	s.Contains(s.rw.Body.String(), `"text":"north","penalty":50`)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *HintTestSuite) TestGetCheckpointHintsOfMissingQuest() {
+	s.mock.ExpectQuery("SELECT id, name, description").WithArgs(3).WillReturnRows(sqlmock.NewRows(questColumnNames))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetCheckpointHints(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+}
+
+func (s *HintTestSuite) TestSetQuestCheckpointsDefaultHintPenalty() {
+	free, penalty := 0, 5
+	checkpoints := []model.Checkpoint{{Name: "a", Type: model.CheckpointQR, Hints: []model.Hint{
+		{Text: "north"}, {Text: "up", Penalty: &penalty}, {Text: "left", Penalty: &free},
+	}}}
+	setHintPenalties(checkpoints, s.env.conf.Logic.Hints.GetDefaultPenalty())
+	s.Equal(100, *checkpoints[0].Hints[0].Penalty)
+	s.Equal(5, *checkpoints[0].Hints[1].Penalty)
+	s.Equal(0, *checkpoints[0].Hints[2].Penalty)
+}
+
+func (s *HintTestSuite) TestUseCheckpointHintAllUsed() {
+	expectStoredQuest(s.mock, 1)
+	s.mock.ExpectBegin()
+	expectActiveAttempt(s.mock)
+	s.mock.ExpectQuery("SELECT COALESCE\\(MAX\\(tier\\), 0\\) \\+ 1").WillReturnRows(sqlmock.NewRows([]string{"tier"}).AddRow(3))
+	s.mock.ExpectExec("INSERT INTO hint_usage").WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+	s.mock.ExpectQuery("SELECT .+ FROM checkpoint_hint").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.
+		ExpectQuery("SELECT u.tier").
+		WillReturnRows(sqlmock.NewRows([]string{"tier", "text", "penalty", "used_at"}).AddRow(1, "north", 50, time.Now()))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(""))
+	s.env.UseCheckpointHint(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
+}
+
+func TestHintTestSuite(t *testing.T) {
+	suite.Run(t, new(HintTestSuite))
+}
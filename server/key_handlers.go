@@ -0,0 +1,18 @@
+package server
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const (
+	jwksMaxAge = 3600
+)
+
+// GetJWKS publishes public keys in the standard JWKS format rather than in common.ResponseMsg
+// so that third party JWT libraries can consume it directly.
+func (env *Env) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksMaxAge))
+	c.JSON(http.StatusOK, env.keys.JWKS())
+}
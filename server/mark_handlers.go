@@ -9,18 +9,52 @@ import (
 	"net/http"
 )
 
+// FinishQuest completes the quest and responds with the scored run.
 func (env *Env) FinishQuest(c *gin.Context) {
-	env.updateLinkTable(c, func(vote model.Mark) dao.DBError {
-		return env.markDAO.FinishQuest(vote.UserID, vote.QuestID)
+	env.updateLinkTable(c, nil, func(vote model.Mark) (interface{}, dao.DBError) {
+		return env.markDAO.FinishQuest(vote.UserID, vote.QuestID, env.scoreRules())
 	})
 }
 
+// MarkQuest saves the mark of a finished quest. Marks out of the configured bounds or off the
+// configured step are rejected with http.StatusUnprocessableEntity.
 func (env *Env) MarkQuest(c *gin.Context) {
-	env.updateLinkTable(c, func(mark model.Mark) dao.DBError {
-		return env.markDAO.MarkQuest(mark.UserID, mark.QuestID, mark.Mark)
+	rules := env.markRules()
+	validate := func(mark model.Mark) error {
+		return rules.Validate(mark.Mark)
+	}
+	env.updateLinkTable(c, validate, func(mark model.Mark) (interface{}, dao.DBError) {
+		return nil, env.markDAO.MarkQuest(mark.UserID, mark.QuestID, mark.Mark, env.ratingRules())
 	})
 }
 
+// GetMarkHistory returns the changes of the marks of the current user, newest first, optionally
+// only for one quest. The next page starts before the id of the last change of the previous one.
+func (env *Env) GetMarkHistory(c *gin.Context) {
+	var filter model.MarkHistoryFilter
+	var err error
+	if filter.QuestID, err = getIntQuery(c, questIDQuery, 0); err != nil || filter.QuestID < 0 {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("invalid %s %q", questIDQuery, c.Query(questIDQuery))))
+		return
+	}
+	if filter.BeforeID, err = getIntQuery(c, beforeIDQuery, 0); err != nil || filter.BeforeID < 0 {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("invalid %s %q", beforeIDQuery, c.Query(beforeIDQuery))))
+		return
+	}
+	filter.Limit, err = getIntQuery(c, limitQuery, model.MarkHistoryDefaultLimit)
+	if err != nil || filter.Limit <= 0 || filter.Limit > model.MarkHistoryMaxLimit {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("%s must be in [1, %d]", limitQuery, model.MarkHistoryMaxLimit)))
+		return
+	}
+
+	history, dbErr := env.markDAO.GetMarkHistory(c.GetInt(UserID), filter)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(history))
+}
+
 func (env *Env) GetUserMarks(c *gin.Context) {
 	id := c.GetInt(UserID)
 	votes, err := env.markDAO.GetUserMarks(id)
@@ -31,13 +65,23 @@ func (env *Env) GetUserMarks(c *gin.Context) {
 	c.JSON(http.StatusOK, votes)
 }
 
-func (env *Env) updateLinkTable(c *gin.Context, updateFunc func(vote model.Mark) dao.DBError) {
+// updateLinkTable responds with the data returned by updateFunc or with an empty response for nil data.
+// The request is checked by validate unless it is nil.
+func (env *Env) updateLinkTable(
+	c *gin.Context, validate func(vote model.Mark) error, updateFunc func(vote model.Mark) (interface{}, dao.DBError),
+) {
 	id := c.GetInt(UserID)
 	var vote model.Mark
 	if err := c.BindJSON(&vote); err != nil {
 		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
 		return
 	}
+	if validate != nil {
+		if err := validate(vote); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(err))
+			return
+		}
+	}
 	if vote.UserID == 0 { // default value
 		vote.UserID = id
 	}
@@ -55,9 +99,28 @@ func (env *Env) updateLinkTable(c *gin.Context, updateFunc func(vote model.Mark)
 		return
 	}
 
-	if err := updateFunc(vote); err != nil {
+	data, err := updateFunc(vote)
+	if err != nil {
 		c.JSON(err.Code(), common.GetErrResponse(err))
 		return
 	}
-	c.JSON(http.StatusOK, common.GetEmptyResponse())
+	if data == nil {
+		c.JSON(http.StatusOK, common.GetEmptyResponse())
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(data))
+}
+
+func (env *Env) markRules() model.MarkRules {
+	rating := env.conf.Logic.Rating
+	min, max := rating.GetMarkBounds()
+	return model.MarkRules{Min: min, Max: max, Step: rating.GetMarkStep()}
+}
+
+func (env *Env) ratingRules() model.RatingRules {
+	rating := env.conf.Logic.Rating
+	return model.RatingRules{
+		PriorWeight: rating.GetPriorWeight(),
+		PriorMean:   rating.GetPriorMean(),
+	}
 }
@@ -16,6 +16,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 type MarkTestSuite struct {
@@ -58,10 +59,28 @@ func (s *MarkTestSuite) TestFinishQuestSuccess() {
 		ExpectQuery("SELECT count").
 		WithArgs(mark.QuestID).
 		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+	startedAt := time.Now().Add(-5 * time.Minute)
+	s.mock.ExpectBegin()
+	endedAt := startedAt.Add(5 * time.Minute)
 	s.mock.
-		ExpectExec("INSERT INTO quest_user_link").
+		ExpectQuery("SELECT id FROM quest_attempt").
 		WithArgs(mark.UserID, mark.QuestID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	s.mock.
+		ExpectQuery("UPDATE quest_attempt AS a SET outcome = 'finished'").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "quest_id", "team_id", "outcome", "ended_at",
+			"started_at", "finished_at", "elapsed_s", "hints_used", "hint_penalty", "optional_reached", "score",
+		}).AddRow(7, mark.QuestID, 0, "finished", endedAt, startedAt, endedAt, 0, 0, 0, 0, 0))
+	s.mock.
+		ExpectQuery("SELECT .+ FROM hint_usage").
+		WillReturnRows(sqlmock.NewRows([]string{"hints_used", "hint_penalty", "optional_reached"}).AddRow(1, 100, 2))
+	s.mock.
+		ExpectExec("UPDATE quest_attempt SET elapsed_s").
+		WithArgs(7, mark.UserID, mark.QuestID, 300, 1, 100, 2, 975).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
 
 	msg, err := json.Marshal(mark)
 	s.Require().NoError(err)
@@ -75,6 +94,25 @@ func (s *MarkTestSuite) TestFinishQuestSuccess() {
 
 	s.Require().Nil(resp.ErrMsg)
 	s.Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"id":7,"quest_id":2,"outcome":"finished"`)
+	s.Contains(s.rw.Body.String(), `"elapsed_seconds":300,"hints_used":1,"hint_penalty":100,"optional_reached":2,"score":975`)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *MarkTestSuite) TestFinishQuestCheckpointsLeft() {
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest_attempt").WithArgs(20, 2).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	s.mock.ExpectQuery("UPDATE quest_attempt").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectRollback()
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"quest_id": 2}`))
+	s.env.FinishQuest(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
 }
 
 func (s *MarkTestSuite) TestFinishQuestForbidden() {
@@ -108,10 +146,12 @@ func (s *MarkTestSuite) TestFinishQuestError() {
 		ExpectQuery("SELECT count").
 		WithArgs(mark.QuestID).
 		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+	s.mock.ExpectBegin()
 	s.mock.
-		ExpectExec("UPDATE quest_user_link SET finished = TRUE").
+		ExpectQuery("INSERT INTO quest_user_link").
 		WithArgs(mark.UserID, mark.QuestID).
 		WillReturnError(fmt.Errorf("fail"))
+	s.mock.ExpectRollback()
 
 	msg, err := json.Marshal(mark)
 	s.Require().NoError(err)
@@ -121,21 +161,36 @@ func (s *MarkTestSuite) TestFinishQuestError() {
 	s.Equal(http.StatusInternalServerError, s.rw.Code)
 }
 
+// expectMarkLocked expects the link of the user to the quest to be locked before the mark is saved.
+func (s *MarkTestSuite) expectMarkLocked(mark model.Mark) {
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT COALESCE\\(completed, FALSE\\), COALESCE\\(marked, FALSE\\), COALESCE\\(mark, 0\\) FROM quest_user_link").
+		WithArgs(mark.UserID, mark.QuestID).
+		WillReturnRows(sqlmock.NewRows([]string{"completed", "marked", "mark"}).AddRow(true, false, 0))
+}
+
 func (s *MarkTestSuite) TestMarkQuestSuccess() {
 	mark := model.Mark{UserID: 20, QuestID: 2, Mark: 4}
 	s.mock.
 		ExpectQuery("SELECT count").
 		WithArgs(mark.QuestID).
 		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+	s.expectMarkLocked(mark)
 	s.mock.
 		ExpectExec("UPDATE quest_user_link SET mark").
 		WithArgs(mark.Mark, mark.UserID, mark.QuestID).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.
+		ExpectExec("INSERT INTO mark_history").
+		WithArgs(mark.UserID, mark.QuestID, mark.Mark, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	s.mock.
 		ExpectExec("UPDATE quest SET").
-		WithArgs(mark.QuestID).
+		WithArgs(mark.QuestID, 4., 1, 0., 3.).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
 
 	msg, err := json.Marshal(mark)
 	s.Require().NoError(err)
@@ -157,6 +212,7 @@ func (s *MarkTestSuite) TestMarkQuestForbidden() {
 		ExpectQuery("SELECT count").
 		WithArgs(mark.QuestID).
 		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+	s.expectMarkLocked(mark)
 	s.mock.
 		ExpectExec("UPDATE quest_user_link SET mark").
 		WithArgs(mark.Mark, mark.UserID, mark.QuestID).
@@ -181,6 +237,7 @@ func (s *MarkTestSuite) TestMarkQuestMarkErr() {
 		ExpectQuery("SELECT count").
 		WithArgs(mark.QuestID).
 		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+	s.expectMarkLocked(mark)
 	s.mock.
 		ExpectExec("UPDATE quest_user_link SET mark").
 		WithArgs(mark.Mark, mark.UserID, mark.QuestID).
@@ -212,15 +269,21 @@ func (s *MarkTestSuite) TestMarkQuestRatingErr() {
 		ExpectQuery("SELECT count").
 		WithArgs(mark.QuestID).
 		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+	s.expectMarkLocked(mark)
 	s.mock.
 		ExpectExec("UPDATE quest_user_link SET mark").
 		WithArgs(mark.Mark, mark.UserID, mark.QuestID).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.
+		ExpectExec("INSERT INTO mark_history").
+		WithArgs(mark.UserID, mark.QuestID, mark.Mark, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	s.mock.
 		ExpectExec("UPDATE quest SET").
-		WithArgs(mark.QuestID).
+		WithArgs(mark.QuestID, 4., 1, 0., 3.).
 		WillReturnError(fmt.Errorf("rating err"))
+	s.mock.ExpectRollback()
 
 	msg, err := json.Marshal(mark)
 	s.Require().NoError(err)
@@ -237,6 +300,65 @@ func (s *MarkTestSuite) TestMarkQuestRatingErr() {
 	s.Equal(http.StatusInternalServerError, s.rw.Code)
 }
 
+func (s *MarkTestSuite) TestMarkQuestInvalidMark() {
+	for _, body := range []string{`{"quest_id": 2, "mark": 0}`, `{"quest_id": 2, "mark": 5.5}`, `{"quest_id": 2, "mark": 4.3}`} {
+		s.SetupTest()
+		s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(body))
+		s.env.MarkQuest(s.c)
+
+		s.Equal(http.StatusUnprocessableEntity, s.rw.Code, body)
+		s.NoError(s.mock.ExpectationsWereMet())
+	}
+}
+
+func (s *MarkTestSuite) TestMarkQuestNotFinished() {
+	s.mock.ExpectQuery("SELECT count").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT COALESCE\\(completed, FALSE\\)").
+		WithArgs(20, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"completed", "marked", "mark"}).AddRow(false, false, 0))
+	s.mock.ExpectRollback()
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"quest_id": 2, "mark": 4.5}`))
+	s.env.MarkQuest(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
+	s.Contains(s.rw.Body.String(), "finish it before marking")
+}
+
+func (s *MarkTestSuite) TestMarkMissingQuest() {
+	s.mock.ExpectQuery("SELECT count").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(0))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"quest_id": 2, "mark": 4}`))
+	s.env.MarkQuest(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+}
+
+func (s *MarkTestSuite) TestGetMarkHistory() {
+	s.mock.
+		ExpectQuery("SELECT .+ FROM mark_history").
+		WithArgs(20, 2, 9, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "quest_id", "mark", "previous_mark", "created_at"}).AddRow(8, 2, 2., 5., time.Now()))
+
+	s.c.Request, _ = getRequest(urlSample+"?quest_id=2&before_id=9&limit=10", http.MethodGet, nil)
+	s.env.GetMarkHistory(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"id":8,"quest_id":2,"mark":2,"previous_mark":5`)
+}
+
+func (s *MarkTestSuite) TestGetMarkHistoryBadQuery() {
+	for _, query := range []string{"?quest_id=x", "?before_id=-1", "?limit=0", "?limit=201"} {
+		s.SetupTest()
+		s.c.Request, _ = getRequest(urlSample+query, http.MethodGet, nil)
+		s.env.GetMarkHistory(s.c)
+
+		s.Equal(http.StatusBadRequest, s.rw.Code, query)
+	}
+}
+
 func TestMarkTestSuite(t *testing.T) {
 	suite.Run(t, new(MarkTestSuite))
 }
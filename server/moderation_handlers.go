@@ -0,0 +1,194 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"io"
+	"net/http"
+)
+
+const (
+	statusQuery  = "status"
+	typeQuery    = "type"
+	afterIDQuery = "after_id"
+	itemIDQuery  = "item_id"
+)
+
+var invalidStatusErr = fmt.Errorf("%s must be one of: %s, %s, %s",
+	statusQuery, model.ModerationPending, model.ModerationApproved, model.ModerationRejected,
+)
+
+// ReportContent records a complaint of the current user about content of another user and
+// puts the content in the moderation queue.
+func (env *Env) ReportContent(c *gin.Context) {
+	var report model.ContentReport
+	if err := c.BindJSON(&report); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := report.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(err))
+		return
+	}
+	report.ReporterID = c.GetInt(UserID)
+	if err := env.moderationDAO.Report(report); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
+
+// GetModerationQueue lists the items with the status (pending by default), oldest first.
+// The next page starts after the id of the last item of the previous one.
+func (env *Env) GetModerationQueue(c *gin.Context) {
+	filter := model.ModerationFilter{
+		Status:      c.DefaultQuery(statusQuery, model.ModerationPending),
+		ContentType: c.Query(typeQuery),
+	}
+	switch filter.Status {
+	case model.ModerationPending, model.ModerationApproved, model.ModerationRejected:
+	default:
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(invalidStatusErr))
+		return
+	}
+	if filter.ContentType != "" && !model.IsModeratedContent(filter.ContentType) {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("invalid %s %q", typeQuery, filter.ContentType)))
+		return
+	}
+	var err error
+	if filter.AfterID, err = getIntQuery(c, afterIDQuery, 0); err != nil || filter.AfterID < 0 {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("invalid %s %q", afterIDQuery, c.Query(afterIDQuery))))
+		return
+	}
+	if filter.Limit, err = getModerationLimit(c); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+
+	items, dbErr := env.moderationDAO.GetQueue(filter)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(items))
+}
+
+// ApproveContent shows the content held by the filter again and closes the item.
+func (env *Env) ApproveContent(c *gin.Context) {
+	env.decide(c, model.ActionApprove)
+}
+
+func (env *Env) RejectContent(c *gin.Context) {
+	env.decide(c, model.ActionReject)
+}
+
+// BanContentAuthor rejects the content and bans its author, closing all sessions of the author.
+func (env *Env) BanContentAuthor(c *gin.Context) {
+	env.decide(c, model.ActionBan)
+}
+
+func (env *Env) decide(c *gin.Context, action string) {
+	itemID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	decision, ok := bindDecision(c)
+	if !ok {
+		return
+	}
+	decision.ItemID = itemID
+	decision.ModeratorID = c.GetInt(UserID)
+	decision.Action = action
+
+	item, dbErr := env.moderationDAO.Decide(decision)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	env.logger.Infof("moderator %d took action %s on moderation item %d", decision.ModeratorID, action, itemID)
+	c.JSON(http.StatusOK, common.GetDataResponse(item))
+}
+
+func (env *Env) UnbanUser(c *gin.Context) {
+	userID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	decision, ok := bindDecision(c)
+	if !ok {
+		return
+	}
+	if err := env.moderationDAO.Unban(userID, c.GetInt(UserID), decision.Reason); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	env.logger.Infof("moderator %d unbanned user %d", c.GetInt(UserID), userID)
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
+
+// GetModerationActions returns the audit trail of moderation, newest first, optionally only
+// for a queue item or for a user whose content or account was affected.
+func (env *Env) GetModerationActions(c *gin.Context) {
+	var filter [3]int
+	for i, name := range []string{itemIDQuery, userIDQuery, beforeIDQuery} {
+		var err error
+		if filter[i], err = getIntQuery(c, name, 0); err != nil || filter[i] < 0 {
+			c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("invalid %s %q", name, c.Query(name))))
+			return
+		}
+	}
+	limit, err := getModerationLimit(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+
+	actions, dbErr := env.moderationDAO.GetActions(model.ModerationActionFilter{
+		ItemID: filter[0], UserID: filter[1], BeforeID: filter[2], Limit: limit,
+	})
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(actions))
+}
+
+// queueFlagged puts content flagged by the automatic filter in the moderation queue. The
+// content is saved by then, so a failure is only logged.
+func (env *Env) queueFlagged(contentType string, contentID, authorID int, text string, flags []string) {
+	if len(flags) == 0 {
+		return
+	}
+	item := model.ModerationItem{ContentType: contentType, ContentID: contentID, AuthorID: authorID, Text: text, Flags: flags}
+	if err := env.moderationDAO.Flag(item); err != nil {
+		env.logger.Errorf("failed to queue flagged %s %d: %v", contentType, contentID, err)
+	}
+}
+
+// bindDecision reads the optional body with the reason of a decision. On failure it writes
+// the response itself and returns false.
+func bindDecision(c *gin.Context) (model.ModerationDecision, bool) {
+	var decision model.ModerationDecision
+	if err := c.ShouldBindWith(&decision, binding.JSON); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return decision, false
+	}
+	if err := decision.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(err))
+		return decision, false
+	}
+	return decision, true
+}
+
+func getModerationLimit(c *gin.Context) (int, error) {
+	limit, err := getIntQuery(c, limitQuery, model.ModerationDefaultLimit)
+	if err != nil || limit <= 0 || limit > model.ModerationMaxLimit {
+		return 0, fmt.Errorf("%s must be in [1, %d]", limitQuery, model.ModerationMaxLimit)
+	}
+	return limit, nil
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/Sovianum/arquest-server/moderation"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var moderationItemColumnNames = []string{
+	"id", "content_type", "content_id", "author_id", "text", "flags", "report_count", "status", "created_at", "updated_at",
+}
+
+type ModerationTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *ModerationTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	s.env.moderationDAO = dao.NewModerationDAO(s.db)
+	s.env.reviewDAO = dao.NewReviewDAO(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+	s.c.Set(UserID, 20)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+}
+
+func (s *ModerationTestSuite) TestSaveFlaggedReview() {
+	s.env.filter = moderation.NewWordFilter(map[string][]string{moderation.AnyLanguage: {"fool"}})
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT COALESCE\\(marked, FALSE\\)").WithArgs(20, 3).WillReturnRows(sqlmock.NewRows([]string{"marked"}).AddRow(true))
+	s.mock.ExpectQuery("INSERT INTO review").WithArgs(3, 20, "what a Fool", "", true).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	s.mock.ExpectExec("DELETE FROM review_photo").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectCommit()
+	s.mock.
+		ExpectQuery("SELECT .+ WHERE r.id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(reviewColumnNames).AddRow(5, 3, 20, "player", 4., "what a Fool", "", 0, time.Now(), time.Now(), true))
+	s.mock.ExpectQuery("SELECT rp.review_id").WillReturnRows(sqlmock.NewRows([]string{"review_id", "id", "content_type", "size", "sha256"}))
+	s.mock.ExpectQuery("SELECT rr.id").WillReturnRows(sqlmock.NewRows([]string{"id", "review_id", "user_id", "login", "text", "created_at", "hidden"}))
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("INSERT INTO moderation_item").
+		WithArgs(model.ContentReview, 5, 20, "what a Fool", pq.Array([]string{"banned_word: fool"})).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	s.mock.ExpectExec("INSERT INTO moderation_action").WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	s.c.Request, _ = getRequest("/api/v1/quests/3/review", http.MethodPut, strings.NewReader(`{"text":"what a Fool"}`))
+	s.env.SaveQuestReview(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"hidden":true`)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ModerationTestSuite) TestReportOwnContent() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT user_id, text FROM review").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"user_id", "text"}).AddRow(20, "nice"))
+	s.mock.ExpectRollback()
+
+	s.c.Request, _ = getRequest("/api/v1/user/reports", http.MethodPost, strings.NewReader(`{"content_type":"review","content_id":5}`))
+	s.env.ReportContent(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+	s.Contains(s.rw.Body.String(), "you can not report your own content")
+}
+
+func (s *ModerationTestSuite) TestReportUnknownContentType() {
+	s.c.Request, _ = getRequest("/api/v1/user/reports", http.MethodPost, strings.NewReader(`{"content_type":"quest","content_id":5}`))
+	s.env.ReportContent(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+}
+
+func (s *ModerationTestSuite) TestGetModerationQueueBadQuery() {
+	for _, query := range []string{"status=open", "type=quest", "after_id=-1", "limit=0", "limit=201"} {
+		rw := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rw)
+		c.Request, _ = getRequest("/api/v1/moderation/queue?"+query, http.MethodGet, nil)
+		s.env.GetModerationQueue(c)
+
+		s.Equal(http.StatusBadRequest, rw.Code, query)
+	}
+}
+
+func (s *ModerationTestSuite) TestBanContentAuthor() {
+	s.c.Params = gin.Params{{Key: idParam, Value: "7"}}
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectQuery("SELECT .+ FROM moderation_item WHERE id = \\$1 FOR UPDATE").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(moderationItemColumnNames).
+			AddRow(7, model.ContentReview, 5, 21, "spam", "{}", 3, model.ModerationPending, time.Now(), time.Now()))
+	s.mock.ExpectExec("INSERT INTO user_ban").WithArgs(21, 20, "spam").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("INSERT INTO revoked_token").WithArgs(21).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("UPDATE refresh_token SET revoked = TRUE").WithArgs(21).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("UPDATE review SET hidden = TRUE").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectQuery("UPDATE moderation_item").WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+	s.mock.ExpectExec("INSERT INTO moderation_action").WithArgs(7, 21, 20, model.ActionBan, "spam").WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+
+	s.c.Request, _ = getRequest("/api/v1/moderation/queue/7/ban", http.MethodPost, strings.NewReader(`{"reason":"spam"}`))
+	s.env.BanContentAuthor(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"status":"rejected"`)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ModerationTestSuite) TestApproveWithoutBody() {
+	s.c.Params = gin.Params{{Key: idParam, Value: "7"}}
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT .+ FROM moderation_item").WithArgs(7).WillReturnRows(sqlmock.NewRows(moderationItemColumnNames))
+	s.mock.ExpectRollback()
+
+	s.c.Request, _ = getRequest("/api/v1/moderation/queue/7/approve", http.MethodPost, strings.NewReader(""))
+	s.env.ApproveContent(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestModerationTestSuite(t *testing.T) {
+	suite.Run(t, new(ModerationTestSuite))
+}
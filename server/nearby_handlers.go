@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/geo"
+	"github.com/gin-gonic/gin"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+const (
+	latQuery    = "lat"
+	lonQuery    = "lon"
+	radiusQuery = "radius"
+	limitQuery  = "limit"
+)
+
+// GetNearbyQuests returns quests starting within radius meters of (lat, lon), nearest first.
+func (env *Env) GetNearbyQuests(c *gin.Context) {
+	var center geo.Point
+	var err error
+	if center.Lat, err = getFloatQuery(c, latQuery, nil); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if center.Lon, err = getFloatQuery(c, lonQuery, nil); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := center.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+
+	conf := env.conf.Logic.Nearby
+	defaultRadius := conf.GetDefaultRadius()
+	radius, err := getFloatQuery(c, radiusQuery, &defaultRadius)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if radius <= 0 || radius > conf.GetMaxRadius() {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(
+			fmt.Errorf("radius must be in (0, %v] meters", conf.GetMaxRadius()),
+		))
+		return
+	}
+
+	limit := conf.GetMaxCount()
+	if value := c.Query(limitQuery); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit <= 0 || limit > conf.GetMaxCount() {
+			c.JSON(http.StatusBadRequest, common.GetErrResponse(
+				fmt.Errorf("limit must be in [1, %d]", conf.GetMaxCount()),
+			))
+			return
+		}
+	}
+
+	quests, dbErr := env.nearbyDAO.GetNearbyQuests(center, radius, limit)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	for i := range quests {
+		quests[i].DataPath = getQuestDataURL(quests[i].ID)
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(quests))
+}
+
+// getIntQuery parses an optional integer query parameter.
+func getIntQuery(c *gin.Context, name string, defaultValue int) (int, error) {
+	value := c.Query(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return result, nil
+}
+
+// getFloatQuery parses a finite query parameter; it is required unless defaultValue is given.
+func getFloatQuery(c *gin.Context, name string, defaultValue *float64) (float64, error) {
+	value := c.Query(name)
+	if value == "" {
+		if defaultValue == nil {
+			return 0, fmt.Errorf("%s required", name)
+		}
+		return *defaultValue, nil
+	}
+	result, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return result, nil
+}
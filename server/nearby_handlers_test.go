@@ -0,0 +1,114 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type NearbyTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *NearbyTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	s.env.nearbyDAO = dao.NewPostGISNearbyQuestDAO(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+}
+
+func (s *NearbyTestSuite) getNearby(query string) {
+	var err error
+	s.c.Request, err = http.NewRequest(http.MethodGet, "/api/v1/nearby/quests?"+query, nil)
+	s.Require().NoError(err)
+	s.env.GetNearbyQuests(s.c)
+}
+
+func (s *NearbyTestSuite) TestNearbySuccess() {
+	columns := append(append([]string{}, questColumnNames...), "distance")
+	s.mock.
+		ExpectQuery("ST_DWithin").
+		WithArgs(55.75, 37.61, 5000., 100).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(3, "n1", "d1", 4., 20, 1, 1, 10, "sha", 55.751, 37.61, nil, nil, nil, nil, nil, nil, nil, "", 0, 111.))
+
+	s.getNearby("lat=55.75&lon=37.61")
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	resp := struct {
+		Data []model.NearbyQuest `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Require().Len(resp.Data, 1)
+	s.Equal(3, resp.Data[0].ID)
+	s.Equal(111., resp.Data[0].Distance)
+	s.Equal(getQuestDataURL(3), resp.Data[0].DataPath)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *NearbyTestSuite) TestNearbyCustomRadius() {
+	s.mock.
+		ExpectQuery("ST_DWithin").
+		WithArgs(0., 0., 250., 5).
+		WillReturnRows(sqlmock.NewRows(append(append([]string{}, questColumnNames...), "distance")))
+
+	s.getNearby("lat=0&lon=0&radius=250&limit=5")
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *NearbyTestSuite) TestNearbyBadRequest() {
+	for _, query := range []string{
+		"lon=37.61",
+		"lat=north&lon=37.61",
+		"lat=91&lon=37.61",
+		"lat=55.75&lon=37.61&radius=0",
+		"lat=55.75&lon=37.61&radius=1000000",
+		"lat=55.75&lon=37.61&limit=0",
+		"lat=55.75&lon=37.61&limit=1000",
+	} {
+		s.SetupTest()
+		s.getNearby(query)
+		s.Equal(http.StatusBadRequest, s.rw.Code, query)
+	}
+}
+
+func (s *NearbyTestSuite) TestNearbyNotFinite() {
+	for _, query := range []string{
+		"lat=NaN&lon=37.61",
+		"lat=55.75&lon=nan",
+		"lat=Inf&lon=37.61",
+		"lat=55.75&lon=-Infinity",
+		"lat=55.75&lon=37.61&radius=NaN",
+		"lat=55.75&lon=37.61&radius=%2BInf",
+	} {
+		s.SetupTest()
+		s.getNearby(query)
+		s.Equal(http.StatusBadRequest, s.rw.Code, query)
+		s.Contains(s.rw.Body.String(), "invalid", query)
+	}
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestNearbyTestSuite(t *testing.T) {
+	suite.Run(t, new(NearbyTestSuite))
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+)
+
+const (
+	photoField       = "photo"
+	photoKeyPrefix   = "photos/"
+	photoURLTemplate = "/api/v1/photos/%d"
+	// contentSniffLength is the most http.DetectContentType looks at
+	contentSniffLength = 512
+)
+
+var (
+	noPhotoFieldErr = fmt.Errorf("multipart field %q with the photo required", photoField)
+	photoTypeErr    = fmt.Errorf("photo must be a JPEG, PNG or WebP image")
+
+	photoContentTypes = map[string]bool{"image/jpeg": true, "image/png": true, "image/webp": true}
+)
+
+// UploadPhoto stores the "photo" part of a multipart request so that the current user can
+// attach it to reviews. The content type is detected from the content, not taken from the client.
+func (env *Env) UploadPhoto(c *gin.Context) {
+	maxSize := env.conf.Logic.Reviews.GetMaxPhotoSize()
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+
+	tmp, err := ioutil.TempFile("", "review-photo-")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	photo := model.Photo{Size: -1, UserID: c.GetInt(UserID)}
+	for photo.Size < 0 {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+			return
+		}
+		if part.FormName() != photoField {
+			continue
+		}
+
+		hash := sha256.New()
+		photo.Size, err = io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(part, maxSize+1))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+			return
+		}
+		if photo.Size > maxSize {
+			c.JSON(http.StatusRequestEntityTooLarge, common.GetErrResponse(fmt.Errorf("photo is larger than %d bytes", maxSize)))
+			return
+		}
+		photo.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	}
+	if photo.Size < 0 {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(noPhotoFieldErr))
+		return
+	}
+
+	head := make([]byte, contentSniffLength)
+	n, err := tmp.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+		return
+	}
+	photo.ContentType = http.DetectContentType(head[:n])
+	if !photoContentTypes[photo.ContentType] {
+		c.JSON(http.StatusUnsupportedMediaType, common.GetErrResponse(photoTypeErr))
+		return
+	}
+
+	if err := env.putIfMissing(photoKey(photo.SHA256), tmp, photo.Size); err != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+		return
+	}
+	created, dbErr := env.reviewDAO.CreatePhoto(photo)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	setPhotoURL(&created)
+	c.JSON(http.StatusCreated, common.GetDataResponse(created))
+}
+
+// GetPhoto serves a review photo to anybody, as reviews are public.
+func (env *Env) GetPhoto(c *gin.Context) {
+	photoID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	photo, dbErr := env.reviewDAO.GetPhoto(photoID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	env.serveObject(c, photoKey(photo.SHA256), photo.SHA256, photo.ContentType)
+}
+
+func setPhotoURL(photo *model.Photo) {
+	photo.URL = fmt.Sprintf(photoURLTemplate, photo.ID)
+}
+
+func photoKey(sha256 string) string {
+	return photoKeyPrefix + sha256
+}
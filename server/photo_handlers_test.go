@@ -0,0 +1,73 @@
+package server
+
+import (
+	"bytes"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func (s *ArchiveTestSuite) uploadPhoto(data []byte) {
+	body := new(bytes.Buffer)
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile(photoField, "photo.png")
+	s.Require().NoError(err)
+	part.Write(data)
+	s.Require().NoError(w.Close())
+
+	s.c.Request, err = http.NewRequest(http.MethodPost, "/api/v1/user/photos", body)
+	s.Require().NoError(err)
+	s.c.Request.Header.Set("Content-Type", w.FormDataContentType())
+	s.env.UploadPhoto(s.c)
+}
+
+func (s *ArchiveTestSuite) TestUploadPhoto() {
+	s.mock.
+		ExpectQuery("INSERT INTO photo \\(user_id, sha256, content_type, size\\)").
+		WithArgs(20, sqlmock.AnyArg(), "image/png", int64(len(pngHeader))).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(8))
+
+	s.uploadPhoto(pngHeader)
+
+	s.Require().Equal(http.StatusCreated, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"id":8,"url":"/api/v1/photos/8","content_type":"image/png"`)
+	stored, _ := filepath.Glob(filepath.Join(s.dataDir, photoKeyPrefix+"*"))
+	s.Len(stored, 1)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ArchiveTestSuite) TestUploadPhotoNotImage() {
+	s.uploadPhoto([]byte("#!/bin/sh\necho hello\n"))
+
+	s.Equal(http.StatusUnsupportedMediaType, s.rw.Code)
+	_, err := os.Stat(filepath.Join(s.dataDir, photoKeyPrefix))
+	s.True(os.IsNotExist(err))
+}
+
+func (s *ArchiveTestSuite) TestUploadPhotoTooLarge() {
+	s.env.conf.Logic.Reviews.MaxPhotoMB = 1
+
+	s.uploadPhoto(append(pngHeader, make([]byte, 1<<20)...))
+
+	s.Equal(http.StatusRequestEntityTooLarge, s.rw.Code)
+}
+
+func (s *ArchiveTestSuite) TestGetPhoto() {
+	s.mock.
+		ExpectQuery("SELECT id, user_id, sha256, content_type, size FROM photo").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "sha256", "content_type", "size"}).
+			AddRow(3, 20, "abc", "image/png", len(pngHeader)))
+	s.Require().NoError(s.env.storage.Put(photoKey("abc"), bytes.NewReader(pngHeader), int64(len(pngHeader))))
+
+	s.c.Request, _ = getRequest("/api/v1/photos/3", http.MethodGet, nil)
+	s.env.GetPhoto(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Equal("image/png", s.rw.Header().Get("Content-Type"))
+	s.Equal(pngHeader, s.rw.Body.Bytes())
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/Sovianum/arquest-server/proof"
+	"github.com/Sovianum/arquest-server/qrcode"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	formatQuery    = "format"
+	imageQuery     = "image"
+	scaleQuery     = "scale"
+	expiresAtQuery = "expires_at"
+	oneTimeQuery   = "one_time"
+
+	formatPNG = "png"
+	formatSVG = "svg"
+	formatPDF = "pdf"
+	formatZip = "zip"
+
+	defaultQRScale = 8
+	maxQRScale     = 40
+)
+
+var qrContentTypes = map[string]string{
+	formatPNG: "image/png",
+	formatSVG: "image/svg+xml",
+	formatPDF: "application/pdf",
+	formatZip: "application/zip",
+}
+
+type qrOptions struct {
+	scale     int
+	expiresAt time.Time
+	oneTime   bool
+}
+
+// GetCheckpointQR renders the signed code of a QR checkpoint as PNG or SVG. The code may
+// expire at expires_at and may be made one-time, then only one player can pass the checkpoint with it.
+func (env *Env) GetCheckpointQR(c *gin.Context) {
+	stored, ok := env.getManagedQuest(c)
+	if !ok {
+		return
+	}
+	checkpointID, err := getIntParam(c, checkpointParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	format := c.DefaultQuery(formatQuery, formatPNG)
+	if format != formatPNG && format != formatSVG {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("format must be %s or %s", formatPNG, formatSVG)))
+		return
+	}
+	options, err := getQROptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+
+	checkpoint, dbErr := env.checkpointDAO.GetCheckpoint(stored.ID, checkpointID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	if checkpoint.Type != model.CheckpointQR {
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(
+			fmt.Errorf("checkpoint %d is not a %s checkpoint", checkpoint.ID, model.CheckpointQR),
+		))
+		return
+	}
+	code, status, err := encodeCheckpointQR(checkpoint, options)
+	if err != nil {
+		c.JSON(status, common.GetErrResponse(err))
+		return
+	}
+
+	var buf bytes.Buffer
+	if format == formatPNG {
+		err = code.WritePNG(&buf, options.scale)
+	} else {
+		err = code.WriteSVG(&buf, options.scale)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="checkpoint-%d.%s"`, checkpoint.ID, format))
+	c.Data(http.StatusOK, qrContentTypes[format], buf.Bytes())
+}
+
+// GetQuestQRCodes exports the codes of all QR checkpoints of the quest as a printable PDF
+// with a code per page or as a zip of PNG or SVG images.
+func (env *Env) GetQuestQRCodes(c *gin.Context) {
+	stored, ok := env.getManagedQuest(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery(formatQuery, formatPDF)
+	image := c.DefaultQuery(imageQuery, formatPNG)
+	if format != formatPDF && format != formatZip || image != formatPNG && image != formatSVG {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf(
+			"format must be %s or %s and image must be %s or %s", formatPDF, formatZip, formatPNG, formatSVG,
+		)))
+		return
+	}
+	options, err := getQROptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+
+	checkpoints, dbErr := env.checkpointDAO.GetQRCheckpoints(stored.ID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	if len(checkpoints) == 0 {
+		c.JSON(http.StatusNotFound, common.GetErrResponse(fmt.Errorf("quest has no %s checkpoints", model.CheckpointQR)))
+		return
+	}
+
+	var buf bytes.Buffer
+	var pages []qrcode.Page
+	var archive *zip.Writer
+	if format == formatZip {
+		archive = zip.NewWriter(&buf)
+	}
+	for _, checkpoint := range checkpoints {
+		code, status, err := encodeCheckpointQR(checkpoint, options)
+		if err != nil {
+			c.JSON(status, common.GetErrResponse(err))
+			return
+		}
+		if format == formatPDF {
+			pages = append(pages, qrcode.Page{Code: code, Caption: qrCaption(checkpoint, options)})
+			continue
+		}
+
+		w, err := archive.Create(fmt.Sprintf("%02d-checkpoint-%d.%s", checkpoint.Position, checkpoint.ID, image))
+		if err == nil {
+			if image == formatPNG {
+				err = code.WritePNG(w, options.scale)
+			} else {
+				err = code.WriteSVG(w, options.scale)
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+			return
+		}
+	}
+	if format == formatPDF {
+		err = qrcode.WritePDF(&buf, pages)
+	} else {
+		err = archive.Close()
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="quest-%d-qr.%s"`, stored.ID, format))
+	c.Data(http.StatusOK, qrContentTypes[format], buf.Bytes())
+}
+
+func getQROptions(c *gin.Context) (qrOptions, error) {
+	options := qrOptions{}
+	var err error
+	if options.scale, err = getIntQuery(c, scaleQuery, defaultQRScale); err != nil {
+		return options, err
+	}
+	if options.scale <= 0 || options.scale > maxQRScale {
+		return options, fmt.Errorf("scale must be in [1, %d]", maxQRScale)
+	}
+	if value := c.Query(expiresAtQuery); value != "" {
+		if options.expiresAt, err = time.Parse(time.RFC3339, value); err != nil {
+			return options, fmt.Errorf("invalid %s %q", expiresAtQuery, value)
+		}
+		if !options.expiresAt.After(time.Now()) {
+			return options, fmt.Errorf("%s must be in the future", expiresAtQuery)
+		}
+	}
+	if value := c.Query(oneTimeQuery); value != "" {
+		if options.oneTime, err = strconv.ParseBool(value); err != nil {
+			return options, fmt.Errorf("invalid %s %q", oneTimeQuery, value)
+		}
+	}
+	return options, nil
+}
+
+// encodeCheckpointQR signs the code of the checkpoint; on error it also returns the response status.
+func encodeCheckpointQR(checkpoint model.Checkpoint, options qrOptions) (*qrcode.Code, int, error) {
+	if len(checkpoint.QRSecret) == 0 {
+		return nil, http.StatusConflict, fmt.Errorf(
+			"checkpoint %d has no QR secret, save the checkpoints of the quest again", checkpoint.ID,
+		)
+	}
+	payload := proof.QRCode{QuestID: checkpoint.QuestID, CheckpointID: checkpoint.ID, ExpiresAt: options.expiresAt}
+	if options.oneTime {
+		nonce, err := proof.NewNonce()
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		payload.Nonce = nonce
+	}
+	code, err := qrcode.Encode([]byte(proof.SignQR(checkpoint.QRSecret, payload)))
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return code, http.StatusOK, nil
+}
+
+func qrCaption(checkpoint model.Checkpoint, options qrOptions) []string {
+	caption := []string{fmt.Sprintf("Quest %d, checkpoint %d (id %d)", checkpoint.QuestID, checkpoint.Position, checkpoint.ID)}
+	if !options.expiresAt.IsZero() {
+		caption = append(caption, "Valid until "+options.expiresAt.UTC().Format("2006-01-02 15:04 MST"))
+	}
+	if options.oneTime {
+		caption = append(caption, "One-time code")
+	}
+	return caption
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/Sovianum/arquest-server/proof"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type QRTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *QRTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	s.env.questDAO = dao.NewQuestDAO(s.db)
+	s.env.checkpointDAO = dao.NewCheckpointDAO(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+	s.c.Set(UserID, 20)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}, {Key: checkpointParam, Value: "2"}}
+}
+
+func (s *QRTestSuite) TestGetCheckpointQR() {
+	expectStoredQuest(s.mock, 20)
+	expectCheckpoint(s.mock, model.Checkpoint{Type: model.CheckpointQR, QRSecret: []byte("secret")})
+
+	s.c.Request, _ = getRequest(urlSample+"?scale=2", http.MethodGet, nil)
+	s.env.GetCheckpointQR(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Equal("image/png", s.rw.Header().Get("Content-Type"))
+	img, err := png.Decode(s.rw.Body)
+	s.Require().NoError(err)
+	s.Equal(0, img.Bounds().Dx()%2)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *QRTestSuite) TestGetCheckpointQRSVG() {
+	expectStoredQuest(s.mock, 20)
+	expectCheckpoint(s.mock, model.Checkpoint{Type: model.CheckpointQR, QRSecret: []byte("secret")})
+
+	expiresAt := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	s.c.Request, _ = getRequest(urlSample+"?format=svg&one_time=true&expires_at="+expiresAt, http.MethodGet, nil)
+	s.env.GetCheckpointQR(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Equal("image/svg+xml", s.rw.Header().Get("Content-Type"))
+	s.True(strings.HasPrefix(s.rw.Body.String(), "<svg"))
+}
+
+func (s *QRTestSuite) TestGetCheckpointQROfOtherType() {
+	expectStoredQuest(s.mock, 20)
+	expectCheckpoint(s.mock, model.Checkpoint{Type: model.CheckpointARMarker, MarkerID: "statue"})
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetCheckpointQR(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+}
+
+func (s *QRTestSuite) TestGetCheckpointQRWithoutSecret() {
+	expectStoredQuest(s.mock, 20)
+	expectCheckpoint(s.mock, model.Checkpoint{Type: model.CheckpointQR})
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetCheckpointQR(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
+}
+
+func (s *QRTestSuite) TestGetCheckpointQRBadOptions() {
+	for _, query := range []string{"?format=gif", "?scale=100", "?expires_at=2000-01-01T00:00:00Z", "?one_time=maybe"} {
+		s.SetupTest()
+		expectStoredQuest(s.mock, 20)
+
+		s.c.Request, _ = getRequest(urlSample+query, http.MethodGet, nil)
+		s.env.GetCheckpointQR(s.c)
+
+		s.Equal(http.StatusBadRequest, s.rw.Code, query)
+	}
+}
+
+func (s *QRTestSuite) TestGetCheckpointQRForeignQuest() {
+	expectStoredQuest(s.mock, 21)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetCheckpointQR(s.c)
+
+	s.Equal(http.StatusForbidden, s.rw.Code)
+}
+
+func (s *QRTestSuite) expectQRCheckpoints() {
+	s.mock.
+		ExpectQuery("SELECT .+ AND type = 'qr'").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(checkpointSecretColumns).
+			AddRow(2, 3, 1, "a", "", model.CheckpointQR, false, nil, nil, nil, "", []byte("secret"), nil, nil).
+			AddRow(5, 3, 3, "b", "", model.CheckpointQR, false, nil, nil, nil, "", []byte("secret"), nil, nil))
+}
+
+func (s *QRTestSuite) TestGetQuestQRCodesPDF() {
+	expectStoredQuest(s.mock, 20)
+	s.expectQRCheckpoints()
+
+	s.c.Request, _ = getRequest(urlSample+"?one_time=1", http.MethodGet, nil)
+	s.env.GetQuestQRCodes(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Equal("application/pdf", s.rw.Header().Get("Content-Type"))
+	s.Contains(s.rw.Body.String(), "/Count 2")
+	s.Contains(s.rw.Body.String(), "(One-time code)")
+}
+
+func (s *QRTestSuite) TestGetQuestQRCodesZip() {
+	expectStoredQuest(s.mock, 20)
+	s.expectQRCheckpoints()
+
+	s.c.Request, _ = getRequest(urlSample+"?format=zip&image=svg", http.MethodGet, nil)
+	s.env.GetQuestQRCodes(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	reader, err := zip.NewReader(bytes.NewReader(s.rw.Body.Bytes()), int64(s.rw.Body.Len()))
+	s.Require().NoError(err)
+	s.Require().Len(reader.File, 2)
+	s.Equal("01-checkpoint-2.svg", reader.File[0].Name)
+	s.Equal("03-checkpoint-5.svg", reader.File[1].Name)
+}
+
+func (s *QRTestSuite) TestGetQuestQRCodesWithoutQRCheckpoints() {
+	expectStoredQuest(s.mock, 20)
+	s.mock.ExpectQuery("SELECT .+ AND type = 'qr'").WillReturnRows(sqlmock.NewRows(checkpointSecretColumns))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetQuestQRCodes(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+}
+
+func (s *QRTestSuite) TestReachCheckpointWithUsedCode() {
+	secret := []byte("secret")
+	expectStoredQuest(s.mock, 1)
+	expectCheckpoint(s.mock, model.Checkpoint{Type: model.CheckpointQR, QRSecret: secret})
+	expectMissed(s.mock, 0)
+	s.mock.
+		ExpectQuery("SELECT user_id FROM qr_code_use").
+		WithArgs("nonce").
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(21))
+	expectFailure(s.mock, proof.ReasonUsedQR)
+
+	payload := proof.SignQR(secret, proof.QRCode{QuestID: 3, CheckpointID: 2, Nonce: "nonce"})
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"qr": "`+payload+`"}`))
+	s.env.ReachCheckpoint(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+	s.Contains(s.rw.Body.String(), proof.ReasonUsedQR)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestQRTestSuite(t *testing.T) {
+	suite.Run(t, new(QRTestSuite))
+}
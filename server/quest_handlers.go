@@ -3,22 +3,102 @@ package server
 import (
 	"fmt"
 	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/geo"
+	"github.com/Sovianum/arquest-server/model"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
-func (env *Env) GetAllQuests(c *gin.Context) {
-	quests, err := env.questDAO.GetAllQuests()
+const (
+	questArchiveURLTemplate = "/api/v1/quests/%d/archive"
+
+	tagsQuery        = "tags"
+	difficultyQuery  = "difficulty"
+	minDurationQuery = "min_duration"
+	maxDurationQuery = "max_duration"
+	minRatingQuery   = "min_rating"
+	searchQuery      = "q"
+	cursorQuery      = "cursor"
+)
+
+// listingQueries are the parameters of the paged quest listing.
+var listingQueries = []string{
+	tagsQuery, difficultyQuery, minDurationQuery, maxDurationQuery, minRatingQuery, searchQuery, cursorQuery,
+	sortQuery, limitQuery, latQuery, lonQuery,
+}
+
+var (
+	notQuestAuthorErr = fmt.Errorf("only the author of the quest can change it")
+	noVersionErr      = fmt.Errorf("\"version\" field required")
+)
+
+// ListQuests returns a page of quests. The quests are filtered by tags, difficulty, duration,
+// minimal rating and a full-text search query, and sorted by the sort query: newest first by
+// default or, with a search query, the best matches first. The next page is requested with
+// the cursor returned in the previous one and the same other parameters. Without any of these
+// parameters all quests are returned as a plain array, as before the listing was paged.
+func (env *Env) ListQuests(c *gin.Context) {
+	if !hasListingQuery(c) {
+		env.getAllQuests(c)
+		return
+	}
+	filter, err := getQuestFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	page, dbErr := env.questDAO.ListQuests(filter)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	for i := range page.Quests {
+		page.Quests[i].DataPath = getQuestDataURL(page.Quests[i].ID)
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(page))
+}
+
+func (env *Env) getAllQuests(c *gin.Context) {
+	quests, dbErr := env.questDAO.GetAllQuests()
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
 		return
 	}
 	for i := range quests {
-		quests[i].DataPath = getQuestDataUrl(env.conf.Logic.QuestDataTemplate, quests[i].ID)
+		quests[i].DataPath = getQuestDataURL(quests[i].ID)
 	}
 	c.JSON(http.StatusOK, common.GetDataResponse(quests))
 }
 
+func hasListingQuery(c *gin.Context) bool {
+	query := c.Request.URL.Query()
+	for _, name := range listingQueries {
+		if _, ok := query[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (env *Env) GetQuest(c *gin.Context) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	quest, dbErr := env.questDAO.GetQuest(questID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	quest.DataPath = getQuestDataURL(quest.ID)
+	c.JSON(http.StatusOK, common.GetDataResponse(quest))
+}
+
+// GetFinishedQuests lists the quests completed by the current user with the timing and the
+// score of each run.
 func (env *Env) GetFinishedQuests(c *gin.Context) {
 	id := c.GetInt(UserID)
 	quests, err := env.questDAO.GetFinishedQuests(id)
@@ -29,6 +109,193 @@ func (env *Env) GetFinishedQuests(c *gin.Context) {
 	c.JSON(http.StatusOK, common.GetDataResponse(quests))
 }
 
-func getQuestDataUrl(template string, questID int) string {
-	return fmt.Sprintf(template, questID)
+func getQuestFilter(c *gin.Context) (model.QuestFilter, error) {
+	filter := model.QuestFilter{
+		Tags:         splitQuery(c, tagsQuery),
+		Difficulties: splitQuery(c, difficultyQuery),
+		Query:        strings.TrimSpace(c.Query(searchQuery)),
+	}
+	for _, difficulty := range filter.Difficulties {
+		if !model.IsDifficulty(difficulty) {
+			return filter, fmt.Errorf("invalid %s %q", difficultyQuery, difficulty)
+		}
+	}
+
+	var err error
+	if filter.MinDuration, err = getIntQuery(c, minDurationQuery, 0); err != nil || filter.MinDuration < 0 {
+		return filter, fmt.Errorf("invalid %s %q", minDurationQuery, c.Query(minDurationQuery))
+	}
+	if filter.MaxDuration, err = getIntQuery(c, maxDurationQuery, 0); err != nil || filter.MaxDuration < 0 {
+		return filter, fmt.Errorf("invalid %s %q", maxDurationQuery, c.Query(maxDurationQuery))
+	}
+	if filter.MaxDuration != 0 && filter.MaxDuration < filter.MinDuration {
+		return filter, fmt.Errorf("%s must not be less than %s", maxDurationQuery, minDurationQuery)
+	}
+	noRating := 0.
+	if filter.MinRating, err = getFloatQuery(c, minRatingQuery, &noRating); err != nil {
+		return filter, err
+	}
+
+	if c.Query(latQuery) != "" || c.Query(lonQuery) != "" {
+		var center geo.Point
+		if center.Lat, err = getFloatQuery(c, latQuery, nil); err != nil {
+			return filter, err
+		}
+		if center.Lon, err = getFloatQuery(c, lonQuery, nil); err != nil {
+			return filter, err
+		}
+		if err := center.Validate(); err != nil {
+			return filter, err
+		}
+		filter.Center = &center
+	}
+
+	defaultSort := model.QuestSortNewest
+	if filter.Query != "" {
+		defaultSort = model.QuestSortRelevance
+	}
+	filter.Sort = c.DefaultQuery(sortQuery, defaultSort)
+	switch {
+	case !model.IsQuestSort(filter.Sort):
+		return filter, fmt.Errorf("%s must be one of: %s, %s, %s, %s, %s", sortQuery, model.QuestSortNewest,
+			model.QuestSortRating, model.QuestSortPopularity, model.QuestSortDistance, model.QuestSortRelevance)
+	case filter.Sort == model.QuestSortDistance && filter.Center == nil:
+		return filter, fmt.Errorf("%s=%s requires %s and %s", sortQuery, filter.Sort, latQuery, lonQuery)
+	case filter.Sort == model.QuestSortRelevance && filter.Query == "":
+		return filter, fmt.Errorf("%s=%s requires %s", sortQuery, filter.Sort, searchQuery)
+	}
+
+	if value := c.Query(cursorQuery); value != "" {
+		if filter.Cursor, err = model.ParseQuestCursor(value); err != nil {
+			return filter, err
+		}
+		if filter.Cursor.Sort != filter.Sort {
+			return filter, fmt.Errorf("the cursor belongs to a listing with another %s", sortQuery)
+		}
+	}
+	if filter.Limit, err = getIntQuery(c, limitQuery, model.QuestsDefaultLimit); err != nil {
+		return filter, err
+	}
+	if filter.Limit <= 0 || filter.Limit > model.QuestsMaxLimit {
+		return filter, fmt.Errorf("%s must be in [1, %d]", limitQuery, model.QuestsMaxLimit)
+	}
+	return filter, nil
+}
+
+// splitQuery returns the non-empty comma-separated values of the query parameter.
+func splitQuery(c *gin.Context, name string) []string {
+	var values []string
+	for _, value := range strings.Split(c.Query(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (env *Env) CreateQuest(c *gin.Context) {
+	var quest model.Quest
+	if err := c.BindJSON(&quest); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := quest.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(err))
+		return
+	}
+	quest.AuthorID = c.GetInt(UserID)
+
+	created, err := env.questDAO.CreateQuest(quest)
+	if err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusCreated, common.GetDataResponse(created))
+}
+
+// UpdateQuest requires the version the author has read; a newer version in the database
+// means somebody else has changed the quest meanwhile and the request fails with 409.
+func (env *Env) UpdateQuest(c *gin.Context) {
+	stored, ok := env.getManagedQuest(c)
+	if !ok {
+		return
+	}
+
+	var quest model.Quest
+	if err := c.BindJSON(&quest); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if quest.Version == 0 {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(noVersionErr))
+		return
+	}
+	if err := quest.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(err))
+		return
+	}
+	quest.ID = stored.ID
+	quest.AuthorID = stored.AuthorID
+	quest.Rating = stored.Rating
+	quest.PublishedVersion = stored.PublishedVersion
+	quest.ArchiveSize = stored.ArchiveSize
+	quest.ArchiveSHA256 = stored.ArchiveSHA256
+
+	updated, err := env.questDAO.UpdateQuest(quest)
+	if err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(updated))
+}
+
+func (env *Env) DeleteQuest(c *gin.Context) {
+	stored, ok := env.getManagedQuest(c)
+	if !ok {
+		return
+	}
+	if err := env.questDAO.DeleteQuest(stored.ID); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
+
+// getManagedQuest loads the quest from the id path parameter and checks that the
+// current user may change it. On failure it writes the response itself and returns false.
+func (env *Env) getManagedQuest(c *gin.Context) (model.Quest, bool) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return model.Quest{}, false
+	}
+	quest, dbErr := env.questDAO.GetQuest(questID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return model.Quest{}, false
+	}
+	if !canManageQuest(c, quest) {
+		c.JSON(http.StatusForbidden, common.GetErrResponse(notQuestAuthorErr))
+		return model.Quest{}, false
+	}
+	return quest, true
+}
+
+// canManageQuest reports whether the current user is the author of the quest or an admin.
+func canManageQuest(c *gin.Context, quest model.Quest) bool {
+	return quest.AuthorID == c.GetInt(UserID) || isAdmin(c)
+}
+
+func getIntParam(c *gin.Context, name string) (int, error) {
+	value, err := strconv.Atoi(c.Param(name))
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, c.Param(name))
+	}
+	return value, nil
+}
+
+// getQuestDataURL returns the address of the quest archive. Downloads always go through
+// DownloadQuestArchive so that they are authorized per user.
+func getQuestDataURL(questID int) string {
+	return fmt.Sprintf(questArchiveURLTemplate, questID)
 }
@@ -2,6 +2,7 @@ package server
 
 import (
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"fmt"
 	"github.com/Sovianum/arquest-server/common"
@@ -9,14 +10,29 @@ import (
 	"github.com/Sovianum/arquest-server/model"
 	"github.com/Sovianum/arquest-server/mylog"
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/suite"
 	"gopkg.in/DATA-DOG/go-sqlmock.v1"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
+var questColumnNames = []string{
+	"id", "name", "description", "rating", "author_id", "version", "published_version", "archive_size", "archive_sha256",
+	"start_lat", "start_lon", "area_min_lat", "area_min_lon", "area_max_lat", "area_max_lon",
+	"min_team_size", "max_team_size", "tags", "difficulty", "duration_minutes",
+}
+
+// questRow completes the given leading quest columns with an empty location, no team play
+// and no listing fields.
+func questRow(values ...driver.Value) []driver.Value {
+	return append(values, nil, nil, nil, nil, nil, nil, nil, nil, nil, "", 0)
+}
+
 type QuestTestSuite struct {
 	suite.Suite
 	user *model.User
@@ -49,54 +65,131 @@ func (s *QuestTestSuite) SetupTest() {
 	s.c, _ = gin.CreateTestContext(s.rw)
 }
 
-func (s *QuestTestSuite) TestAllQuestsSuccess() {
+var listedQuestColumnNames = append(append([]string{}, questColumnNames...), "distance", "sort_key")
+
+func (s *QuestTestSuite) TestListQuestsSuccess() {
 	s.mock.
 		ExpectQuery("SELECT id, name").
+		WithArgs(
+			pq.Array([]string{}), pq.Array([]string{}), 0, 0, 0., "", nil, nil, model.QuestSortNewest, false, 0., 0, 2,
+		).
 		WillReturnRows(
-			sqlmock.NewRows([]string{"id", "name", "description", "rating"}).
-				AddRow(1, "n1", "d1", 1.),
+			sqlmock.NewRows(listedQuestColumnNames).
+				AddRow(append(questRow(2, "n2", "d2", 1., nil, 1, 0, 0, ""), nil, 200.)...).
+				AddRow(append(questRow(1, "n1", "d1", 1., nil, 1, 0, 0, ""), nil, 100.)...),
 		)
-	s.env.GetAllQuests(s.c)
+	s.c.Request, _ = getRequest("/api/v1/quests?limit=1", http.MethodGet, strings.NewReader(""))
+	s.env.ListQuests(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	resp := struct {
+		Data model.QuestPage `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Require().Len(resp.Data.Quests, 1)
+	s.Equal(
+		model.Quest{ID: 2, Name: "n2", Description: "d2", Rating: 1, Version: 1, DataPath: getQuestDataURL(2)},
+		resp.Data.Quests[0].Quest,
+	)
+	s.Equal(model.QuestCursor{Sort: model.QuestSortNewest, Key: 200, ID: 2}.Encode(), resp.Data.NextCursor)
+}
 
-	resp := common.ResponseMsg{}
-	data := s.rw.Body.Bytes()
-	json.Unmarshal(data, &resp)
+func (s *QuestTestSuite) TestListQuestsFiltered() {
+	cursor := model.QuestCursor{Sort: model.QuestSortDistance, Key: -150, ID: 4}.Encode()
+	s.mock.
+		ExpectQuery("SELECT id, name").
+		WithArgs(
+			pq.Array([]string{"walk", "history"}), pq.Array([]string{model.DifficultyEasy, model.DifficultyMedium}),
+			30, 120, 4., "музей", 55.75, 37.61, model.QuestSortDistance, true, -150., 4, 21,
+		).
+		WillReturnRows(
+			sqlmock.NewRows(listedQuestColumnNames).
+				AddRow(append(questRow(3, "n3", "d3", 4.5, nil, 1, 0, 0, ""), 300., -300.)...),
+		)
+	s.c.Request, _ = getRequest(
+		"/api/v1/quests?tags=walk,history&difficulty=easy,medium&min_duration=30&max_duration=120&min_rating=4"+
+			"&q=%D0%BC%D1%83%D0%B7%D0%B5%D0%B9&lat=55.75&lon=37.61&sort=distance&cursor="+cursor,
+		http.MethodGet, strings.NewReader(""),
+	)
+	s.env.ListQuests(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"distance":300}]`)
+	s.NotContains(s.rw.Body.String(), "next_cursor")
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *QuestTestSuite) TestListQuestsBadQuery() {
+	cursor := model.QuestCursor{Sort: model.QuestSortRating, Key: 4, ID: 4}.Encode()
+	for _, query := range []string{
+		"sort=oldest",
+		"sort=distance",
+		"sort=relevance",
+		"lat=55.75",
+		"lat=95&lon=37.61",
+		"difficulty=extreme",
+		"min_duration=-5",
+		"min_duration=60&max_duration=30",
+		"min_rating=high",
+		"limit=0",
+		"limit=101",
+		"cursor=garbage",
+		"cursor=" + cursor,
+	} {
+		s.rw = httptest.NewRecorder()
+		s.c, _ = gin.CreateTestContext(s.rw)
+		s.c.Request, _ = getRequest("/api/v1/quests?"+query, http.MethodGet, strings.NewReader(""))
+		s.env.ListQuests(s.c)
+
+		s.Equal(http.StatusBadRequest, s.rw.Code, query)
+	}
+	s.NoError(s.mock.ExpectationsWereMet())
+}
 
+func (s *QuestTestSuite) TestAllQuestsSuccess() {
+	s.mock.
+		ExpectQuery("SELECT id, name.+ FROM quest WHERE deleted_at IS NULL").
+		WillReturnRows(
+			sqlmock.NewRows(questColumnNames).
+				AddRow(questRow(1, "n1", "d1", 1., nil, 1, 0, 0, "")...),
+		)
+	s.c.Request, _ = getRequest("/api/v1/quests", http.MethodGet, strings.NewReader(""))
+	s.env.ListQuests(s.c)
+
+	resp := common.ResponseMsg{}
+	json.Unmarshal(s.rw.Body.Bytes(), &resp)
 	s.Require().Nil(resp.ErrMsg)
 
 	quests, err := getResponseQuests(resp)
 	s.Require().NoError(err)
-
 	s.Equal(
-		[]model.Quest{
-			{ID: 1, Name: "n1", Description: "d1", Rating: 1.},
-		},
+		[]model.Quest{{ID: 1, Name: "n1", Description: "d1", Rating: 1.}},
 		quests,
 	)
+	s.Contains(s.rw.Body.String(), `"data_path":"`+getQuestDataURL(1)+`"`)
 	s.Equal(http.StatusOK, s.rw.Code)
 }
 
 func (s *QuestTestSuite) TestAllQuestsEmpty() {
 	s.mock.
-		ExpectQuery("SELECT id, name").
+		ExpectQuery("SELECT id, name.+ FROM quest WHERE deleted_at IS NULL").
 		WillReturnRows(
-			sqlmock.NewRows([]string{"id", "name", "description", "rating"}),
+			sqlmock.NewRows(questColumnNames),
 		)
-	s.env.GetAllQuests(s.c)
+	s.c.Request, _ = getRequest("/api/v1/quests", http.MethodGet, strings.NewReader(""))
+	s.env.ListQuests(s.c)
 
 	resp := common.ResponseMsg{}
-	data := s.rw.Body.Bytes()
-	json.Unmarshal(data, &resp)
-
+	json.Unmarshal(s.rw.Body.Bytes(), &resp)
 	s.Require().Nil(resp.ErrMsg)
 
 	quests, err := getResponseQuests(resp)
 	s.Require().NoError(err)
-
 	s.Equal(
 		[]model.Quest{},
 		quests,
 	)
+	s.Contains(s.rw.Body.String(), `"data":[]`)
 	s.Equal(http.StatusOK, s.rw.Code)
 }
 
@@ -104,7 +197,19 @@ func (s *QuestTestSuite) TestAllQuestsError() {
 	s.mock.
 		ExpectQuery("SELECT id, name").
 		WillReturnError(fmt.Errorf("fail"))
-	s.env.GetAllQuests(s.c)
+	s.c.Request, _ = getRequest("/api/v1/quests", http.MethodGet, strings.NewReader(""))
+	s.env.ListQuests(s.c)
+
+	s.Equal(http.StatusInternalServerError, s.rw.Code)
+	s.NotContains(s.rw.Body.String(), "next_cursor")
+}
+
+func (s *QuestTestSuite) TestListQuestsError() {
+	s.mock.
+		ExpectQuery("SELECT id, name").
+		WillReturnError(fmt.Errorf("fail"))
+	s.c.Request, _ = getRequest("/api/v1/quests?sort=newest", http.MethodGet, strings.NewReader(""))
+	s.env.ListQuests(s.c)
 
 	resp := common.ResponseMsg{}
 	data := s.rw.Body.Bytes()
@@ -118,13 +223,17 @@ func (s *QuestTestSuite) TestAllQuestsError() {
 func (s *QuestTestSuite) TestFinishedQuestsSuccess() {
 	s.c.Set(UserID, s.user.Id)
 	s.mock.
-		ExpectQuery("SELECT q.id AS id, q.name AS name, q.description").
+		ExpectQuery("SELECT id.+ quest_attempt").
 		WithArgs(s.user.Id).
 		WillReturnRows(
-			sqlmock.NewRows([]string{"id", "name", "description", "rating"}).
-				AddRow(1, "n1", "d1", 1.),
+			sqlmock.NewRows(append(
+				append([]string{}, questColumnNames...),
+				"started_at", "finished_at", "elapsed_s", "hints_used", "hint_penalty", "optional_reached", "score",
+			)).
+				AddRow(append(questRow(1, "n1", "d1", 1., nil, 1, 0, 0, ""), nil, time.Now(), 0, 2, 300, 0, 700)...),
 		)
 	s.env.GetFinishedQuests(s.c)
+	s.Contains(s.rw.Body.String(), `"hints_used":2,"hint_penalty":300,"optional_reached":0,"score":700`)
 
 	resp := common.ResponseMsg{}
 	data := s.rw.Body.Bytes()
@@ -147,9 +256,9 @@ func (s *QuestTestSuite) TestFinishedQuestsSuccess() {
 func (s *QuestTestSuite) TestFinishedQuestsEmpty() {
 	s.c.Set(UserID, s.user.Id)
 	s.mock.
-		ExpectQuery("SELECT q.id AS id, q.name AS name, q.description").
+		ExpectQuery("SELECT id.+ quest_attempt").
 		WithArgs(s.user.Id).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "description", "rating"}))
+		WillReturnRows(sqlmock.NewRows(questColumnNames))
 	s.env.GetFinishedQuests(s.c)
 
 	resp := common.ResponseMsg{}
@@ -184,6 +293,149 @@ func (s *QuestTestSuite) TestFinishedQuestsError() {
 	s.Equal(http.StatusInternalServerError, s.rw.Code)
 }
 
+func (s *QuestTestSuite) expectStoredQuest(authorID int) {
+	s.mock.
+		ExpectQuery("SELECT id, name, description, rating, author_id, version").
+		WithArgs(3).
+		WillReturnRows(
+			sqlmock.NewRows(questColumnNames).
+				AddRow(questRow(3, "n1", "d1", 4., authorID, 2, 0, 0, "")...),
+		)
+}
+
+func (s *QuestTestSuite) TestCreateQuestSuccess() {
+	s.c.Set(UserID, s.user.Id)
+	s.mock.
+		ExpectQuery("INSERT INTO quest").
+		WithArgs(
+			"n1", "d1", s.user.Id, nil, nil, nil, nil, nil, nil, nil, nil, pq.Array([]string{"walk"}), model.DifficultyEasy, 45,
+		).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version"}).AddRow(3, 1))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(
+		`{"name": "n1", "description": "d1", "rating": 5, "tags": ["walk"], "difficulty": "easy", "duration_minutes": 45}`,
+	))
+	s.env.CreateQuest(s.c)
+
+	s.Require().Equal(http.StatusCreated, s.rw.Code)
+	resp := struct {
+		Data model.Quest `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Equal(model.Quest{
+		ID: 3, Name: "n1", Description: "d1", AuthorID: s.user.Id, Version: 1,
+		Tags: []string{"walk"}, Difficulty: model.DifficultyEasy, DurationMinutes: 45,
+	}, resp.Data)
+}
+
+func (s *QuestTestSuite) TestCreateQuestInvalid() {
+	s.c.Set(UserID, s.user.Id)
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"name": "`+strings.Repeat("n", 51)+`"}`))
+	s.env.CreateQuest(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+}
+
+func (s *QuestTestSuite) TestCreateQuestInvalidTags() {
+	s.c.Set(UserID, s.user.Id)
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"name": "n1", "tags": ["Walk Fast"]}`))
+	s.env.CreateQuest(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+}
+
+func (s *QuestTestSuite) TestUpdateQuestSuccess() {
+	s.c.Set(UserID, s.user.Id)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+	s.expectStoredQuest(s.user.Id)
+	s.mock.
+		ExpectQuery("UPDATE quest SET\\s+name").
+		WithArgs("n2", "d2", 3, 2, nil, nil, nil, nil, nil, nil, nil, nil, pq.Array([]string{}), "", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPut, strings.NewReader(`{"name": "n2", "description": "d2", "version": 2}`))
+	s.env.UpdateQuest(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	resp := struct {
+		Data model.Quest `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Equal(model.Quest{ID: 3, Name: "n2", Description: "d2", Rating: 4, AuthorID: s.user.Id, Version: 3}, resp.Data)
+}
+
+func (s *QuestTestSuite) TestUpdateQuestConflict() {
+	s.c.Set(UserID, s.user.Id)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+	s.expectStoredQuest(s.user.Id)
+	s.mock.
+		ExpectQuery("UPDATE quest SET\\s+name").
+		WithArgs("n2", "d2", 3, 1, nil, nil, nil, nil, nil, nil, nil, nil, pq.Array([]string{}), "", 0).
+		WillReturnError(sql.ErrNoRows)
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(1))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPut, strings.NewReader(`{"name": "n2", "description": "d2", "version": 1}`))
+	s.env.UpdateQuest(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
+}
+
+func (s *QuestTestSuite) TestUpdateQuestNoVersion() {
+	s.c.Set(UserID, s.user.Id)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+	s.expectStoredQuest(s.user.Id)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPut, strings.NewReader(`{"name": "n2"}`))
+	s.env.UpdateQuest(s.c)
+
+	s.Equal(http.StatusBadRequest, s.rw.Code)
+}
+
+func (s *QuestTestSuite) TestUpdateForeignQuest() {
+	s.c.Set(UserID, s.user.Id)
+	s.c.Set(UserRoles, []string{model.RoleAuthor})
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+	s.expectStoredQuest(s.user.Id + 1)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPut, strings.NewReader(`{"name": "n2", "version": 2}`))
+	s.env.UpdateQuest(s.c)
+
+	s.Equal(http.StatusForbidden, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *QuestTestSuite) TestDeleteQuestByAdmin() {
+	s.c.Set(UserID, s.user.Id)
+	s.c.Set(UserRoles, []string{model.RoleAdmin})
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+	s.expectStoredQuest(s.user.Id + 1)
+	s.mock.
+		ExpectExec("UPDATE quest SET deleted_at").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodDelete, strings.NewReader(""))
+	s.env.DeleteQuest(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *QuestTestSuite) TestGetDeletedQuest() {
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+	s.mock.
+		ExpectQuery("SELECT id, name, description, rating, author_id, version").
+		WithArgs(3).
+		WillReturnError(sql.ErrNoRows)
+
+	s.env.GetQuest(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+}
+
 func TestQuestTestSuite(t *testing.T) {
 	suite.Run(t, new(QuestTestSuite))
 }
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const (
+	offsetQuery   = "offset"
+	languageQuery = "language"
+	sortQuery     = "sort"
+)
+
+var notReplierErr = fmt.Errorf("only the author of the quest or of the review can reply to it")
+
+// GetQuestReviews returns a page of the reviews of the quest, newest first or, with
+// sort=helpful, the ones with most helpful votes first. The language query keeps the
+// reviews with that language tag only.
+func (env *Env) GetQuestReviews(c *gin.Context) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	filter, err := getReviewFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if _, err := env.questDAO.GetQuest(questID); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+
+	page, dbErr := env.reviewDAO.GetReviews(questID, filter)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	for i := range page.Reviews {
+		setReviewURLs(&page.Reviews[i])
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(page))
+}
+
+// SaveQuestReview writes the review of the current user attached to the mark the user gave
+// the quest; a second call edits the same review. Reviews flagged by the automatic filter are
+// hidden until a moderator approves them.
+func (env *Env) SaveQuestReview(c *gin.Context) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	var review model.Review
+	if err := c.BindJSON(&review); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := review.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(err))
+		return
+	}
+	review.QuestID = questID
+	review.UserID = c.GetInt(UserID)
+	flags := env.filter.Check(review.Text, review.Language)
+	review.Hidden = len(flags) > 0
+
+	saved, dbErr := env.reviewDAO.SaveReview(review)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	env.queueFlagged(model.ContentReview, saved.ID, saved.UserID, saved.Text, flags)
+	setReviewURLs(&saved)
+	c.JSON(http.StatusOK, common.GetDataResponse(saved))
+}
+
+func (env *Env) DeleteQuestReview(c *gin.Context) {
+	questID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := env.reviewDAO.DeleteReview(c.GetInt(UserID), questID); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
+
+func (env *Env) VoteReviewHelpful(c *gin.Context) {
+	env.setHelpful(c, true)
+}
+
+func (env *Env) UnvoteReviewHelpful(c *gin.Context) {
+	env.setHelpful(c, false)
+}
+
+func (env *Env) setHelpful(c *gin.Context, helpful bool) {
+	reviewID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := env.reviewDAO.SetHelpful(c.GetInt(UserID), reviewID, helpful); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
+
+// ReplyToReview adds a message to the thread under the review. The thread is a conversation
+// between the author of the quest and the author of the review, so nobody else can write there.
+func (env *Env) ReplyToReview(c *gin.Context) {
+	reviewID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	var reply model.ReviewReply
+	if err := c.BindJSON(&reply); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := reply.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(err))
+		return
+	}
+
+	review, dbErr := env.reviewDAO.GetReview(reviewID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	quest, dbErr := env.questDAO.GetQuest(review.QuestID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	userID := c.GetInt(UserID)
+	if userID != quest.AuthorID && userID != review.UserID {
+		c.JSON(http.StatusForbidden, common.GetErrResponse(notReplierErr))
+		return
+	}
+
+	reply.ReviewID = reviewID
+	reply.UserID = userID
+	flags := env.filter.Check(reply.Text, review.Language)
+	reply.Hidden = len(flags) > 0
+	created, dbErr := env.reviewDAO.AddReply(reply)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	env.queueFlagged(model.ContentReply, created.ID, userID, created.Text, flags)
+	c.JSON(http.StatusCreated, common.GetDataResponse(created))
+}
+
+func getReviewFilter(c *gin.Context) (model.ReviewFilter, error) {
+	filter := model.ReviewFilter{Language: c.Query(languageQuery), Sort: c.DefaultQuery(sortQuery, model.ReviewSortRecent)}
+	if filter.Sort != model.ReviewSortRecent && filter.Sort != model.ReviewSortHelpful {
+		return filter, fmt.Errorf("%s must be %q or %q", sortQuery, model.ReviewSortRecent, model.ReviewSortHelpful)
+	}
+
+	var err error
+	if filter.Limit, err = getIntQuery(c, limitQuery, model.ReviewsDefaultLimit); err != nil {
+		return filter, err
+	}
+	if filter.Limit <= 0 || filter.Limit > model.ReviewsMaxLimit {
+		return filter, fmt.Errorf("%s must be in [1, %d]", limitQuery, model.ReviewsMaxLimit)
+	}
+	if filter.Offset, err = getIntQuery(c, offsetQuery, 0); err != nil {
+		return filter, err
+	}
+	if filter.Offset < 0 {
+		return filter, fmt.Errorf("%s must not be negative", offsetQuery)
+	}
+	return filter, nil
+}
+
+func setReviewURLs(review *model.Review) {
+	for i := range review.Photos {
+		setPhotoURL(&review.Photos[i])
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var reviewColumnNames = []string{
+	"id", "quest_id", "user_id", "login", "mark", "text", "language", "helpful", "created_at", "updated_at", "hidden",
+}
+
+type ReviewTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *ReviewTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	s.env.questDAO = dao.NewQuestDAO(s.db)
+	s.env.reviewDAO = dao.NewReviewDAO(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+	s.c.Set(UserID, 20)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+}
+
+// expectReview expects the review 5 of the quest 3 written by the user to be read.
+func (s *ReviewTestSuite) expectReview(userID int) {
+	s.mock.
+		ExpectQuery("SELECT .+ FROM review AS r .+ WHERE r.id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(reviewColumnNames).AddRow(5, 3, userID, "player", 4., "nice", "", 0, time.Now(), time.Now(), false))
+	s.mock.
+		ExpectQuery("SELECT rp.review_id").
+		WithArgs(pq.Array([]int{5})).
+		WillReturnRows(sqlmock.NewRows([]string{"review_id", "id", "content_type", "size", "sha256"}).AddRow(5, 8, "image/png", 100, "abc"))
+	s.mock.
+		ExpectQuery("SELECT rr.id").
+		WithArgs(pq.Array([]int{5})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "review_id", "user_id", "login", "text", "created_at", "hidden"}))
+}
+
+func (s *ReviewTestSuite) TestGetQuestReviews() {
+	expectStoredQuest(s.mock, 1)
+	s.mock.ExpectQuery("SELECT count").WithArgs(3, "").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.
+		ExpectQuery("SELECT .+ FROM review AS r").
+		WithArgs(3, "", false, 10, 0).
+		WillReturnRows(sqlmock.NewRows(reviewColumnNames).AddRow(5, 3, 20, "player", 4., "nice", "", 0, time.Now(), time.Now(), false))
+	s.mock.ExpectQuery("SELECT rp.review_id").WillReturnRows(
+		sqlmock.NewRows([]string{"review_id", "id", "content_type", "size", "sha256"}).AddRow(5, 8, "image/png", 100, "abc"),
+	)
+	s.mock.ExpectQuery("SELECT rr.id").WillReturnRows(sqlmock.NewRows([]string{"id", "review_id", "user_id", "login", "text", "created_at", "hidden"}))
+
+	s.c.Request, _ = getRequest("/api/v1/quests/3/reviews?limit=10", http.MethodGet, nil)
+	s.env.GetQuestReviews(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"total":1,"limit":10,"offset":0`)
+	s.Contains(s.rw.Body.String(), `"photos":[{"id":8,"url":"/api/v1/photos/8"`)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ReviewTestSuite) TestGetQuestReviewsBadQuery() {
+	for _, query := range []string{"sort=best", "limit=0", "limit=101", "offset=-1"} {
+		rw := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(rw)
+		c.Params = s.c.Params
+		c.Request, _ = getRequest("/api/v1/quests/3/reviews?"+query, http.MethodGet, nil)
+		s.env.GetQuestReviews(c)
+
+		s.Equal(http.StatusBadRequest, rw.Code, query)
+	}
+}
+
+func (s *ReviewTestSuite) TestSaveInvalidReview() {
+	s.c.Request, _ = getRequest("/api/v1/quests/3/review", http.MethodPut, strings.NewReader(`{"text":"nice","language":"not a tag"}`))
+	s.env.SaveQuestReview(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+}
+
+func (s *ReviewTestSuite) TestSaveReviewNotMarked() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT COALESCE\\(marked, FALSE\\)").WithArgs(20, 3).WillReturnRows(sqlmock.NewRows([]string{"marked"}).AddRow(false))
+	s.mock.ExpectRollback()
+
+	s.c.Request, _ = getRequest("/api/v1/quests/3/review", http.MethodPut, strings.NewReader(`{"text":"nice"}`))
+	s.env.SaveQuestReview(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
+	s.Contains(s.rw.Body.String(), "mark the quest before reviewing it")
+}
+
+func (s *ReviewTestSuite) TestVoteOwnReview() {
+	s.c.Params = gin.Params{{Key: idParam, Value: "5"}}
+	s.mock.ExpectQuery("SELECT user_id FROM review").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(20))
+
+	s.c.Request, _ = getRequest("/api/v1/reviews/5/helpful", http.MethodPut, nil)
+	s.env.VoteReviewHelpful(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+}
+
+func (s *ReviewTestSuite) TestReplyToReviewAsQuestAuthor() {
+	s.c.Params = gin.Params{{Key: idParam, Value: "5"}}
+	s.expectReview(21)
+	expectStoredQuest(s.mock, 20)
+	s.mock.
+		ExpectQuery("WITH rr AS \\(INSERT INTO review_reply").
+		WithArgs(5, 20, "thanks", false).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "review_id", "user_id", "login", "text", "created_at", "hidden"}).
+			AddRow(9, 5, 20, "author", "thanks", time.Now(), false))
+
+	s.c.Request, _ = getRequest("/api/v1/reviews/5/replies", http.MethodPost, strings.NewReader(`{"text":"thanks"}`))
+	s.env.ReplyToReview(s.c)
+
+	s.Require().Equal(http.StatusCreated, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"id":9,"review_id":5,"user_id":20,"login":"author"`)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ReviewTestSuite) TestReplyToForeignReview() {
+	s.c.Params = gin.Params{{Key: idParam, Value: "5"}}
+	s.expectReview(21)
+	expectStoredQuest(s.mock, 1)
+
+	s.c.Request, _ = getRequest("/api/v1/reviews/5/replies", http.MethodPost, strings.NewReader(`{"text":"me too"}`))
+	s.env.ReplyToReview(s.c)
+
+	s.Equal(http.StatusForbidden, s.rw.Code)
+}
+
+func TestReviewTestSuite(t *testing.T) {
+	suite.Run(t, new(ReviewTestSuite))
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+const (
+	idParam   = "id"
+	roleParam = "role"
+)
+
+var invalidRoleErr = fmt.Errorf("role must be one of: %s, %s, %s, %s",
+	model.RolePlayer, model.RoleAuthor, model.RoleModerator, model.RoleAdmin,
+)
+
+func (env *Env) GetUserRoles(c *gin.Context) {
+	userID, ok := env.getExistingUserParam(c)
+	if !ok {
+		return
+	}
+	roles, err := env.roleDAO.GetRoles(userID)
+	if err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(roles))
+}
+
+func (env *Env) GrantUserRole(c *gin.Context) {
+	userID, ok := env.getExistingUserParam(c)
+	if !ok {
+		return
+	}
+	var req model.RoleRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if !model.IsValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(invalidRoleErr))
+		return
+	}
+
+	if err := env.roleDAO.GrantRole(userID, req.Role); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	env.logger.Infof("user %d granted role %s to user %d", c.GetInt(UserID), req.Role, userID)
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
+
+func (env *Env) RevokeUserRole(c *gin.Context) {
+	userID, ok := env.getExistingUserParam(c)
+	if !ok {
+		return
+	}
+	role := c.Param(roleParam)
+	if !model.IsValidRole(role) {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(invalidRoleErr))
+		return
+	}
+	if role == model.RoleAdmin && userID == c.GetInt(UserID) {
+		c.JSON(http.StatusConflict, common.GetErrResponse(fmt.Errorf("you can not revoke your own admin role")))
+		return
+	}
+
+	if err := env.roleDAO.RevokeRole(userID, role); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	env.logger.Infof("user %d revoked role %s from user %d", c.GetInt(UserID), role, userID)
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
+
+// getExistingUserParam writes the error response itself and returns false if
+// the id path parameter is malformed or the user does not exist.
+func (env *Env) getExistingUserParam(c *gin.Context) (int, bool) {
+	userID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return 0, false
+	}
+	exists, dbErr := env.userDAO.ExistsById(userID)
+	if dbErr != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(dbErr))
+		return 0, false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, common.GetErrResponse(fmt.Errorf("user not found")))
+		return 0, false
+	}
+	return userID, true
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const (
+	adminID  = 1
+	targetID = 2
+)
+
+type RoleHandlersTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *RoleHandlersTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+	s.c.Set(UserID, adminID)
+	s.c.Set(UserRoles, []string{model.RoleAdmin})
+}
+
+func (s *RoleHandlersTestSuite) setParams(id, role string) {
+	s.c.Params = gin.Params{{Key: idParam, Value: id}}
+	if role != "" {
+		s.c.Params = append(s.c.Params, gin.Param{Key: roleParam, Value: role})
+	}
+}
+
+func (s *RoleHandlersTestSuite) expectUserExists(id int, exists bool) {
+	cnt := 0
+	if exists {
+		cnt = 1
+	}
+	s.mock.
+		ExpectQuery("SELECT count").
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"cnt"}).AddRow(cnt))
+}
+
+func (s *RoleHandlersTestSuite) TestGetRoles() {
+	s.setParams("2", "")
+	s.expectUserExists(targetID, true)
+	s.mock.
+		ExpectQuery("SELECT role FROM user_role").
+		WithArgs(targetID).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(model.RolePlayer))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, strings.NewReader(""))
+	s.env.GetUserRoles(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	resp := struct {
+		Data []string `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Equal([]string{model.RolePlayer}, resp.Data)
+}
+
+func (s *RoleHandlersTestSuite) TestGrantRole() {
+	s.setParams("2", "")
+	s.expectUserExists(targetID, true)
+	s.mock.
+		ExpectExec("INSERT INTO user_role").
+		WithArgs(targetID, model.RoleAuthor).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"role": "author"}`))
+	s.env.GrantUserRole(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *RoleHandlersTestSuite) TestGrantUnknownRole() {
+	s.setParams("2", "")
+	s.expectUserExists(targetID, true)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"role": "god"}`))
+	s.env.GrantUserRole(s.c)
+
+	s.Equal(http.StatusBadRequest, s.rw.Code)
+}
+
+func (s *RoleHandlersTestSuite) TestGrantUserNotFound() {
+	s.setParams("2", "")
+	s.expectUserExists(targetID, false)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"role": "author"}`))
+	s.env.GrantUserRole(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+}
+
+func (s *RoleHandlersTestSuite) TestInvalidUserID() {
+	s.setParams("abc", "")
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, strings.NewReader(""))
+	s.env.GetUserRoles(s.c)
+
+	s.Equal(http.StatusBadRequest, s.rw.Code)
+}
+
+func (s *RoleHandlersTestSuite) TestRevokeRole() {
+	s.setParams("2", model.RoleModerator)
+	s.expectUserExists(targetID, true)
+	s.mock.
+		ExpectExec("DELETE FROM user_role").
+		WithArgs(targetID, model.RoleModerator).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodDelete, strings.NewReader(""))
+	s.env.RevokeUserRole(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *RoleHandlersTestSuite) TestRevokeOwnAdminRole() {
+	s.setParams("1", model.RoleAdmin)
+	s.expectUserExists(adminID, true)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodDelete, strings.NewReader(""))
+	s.env.RevokeUserRole(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestRoleHandlersTestSuite(t *testing.T) {
+	suite.Run(t, new(RoleHandlersTestSuite))
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/Sovianum/arquest-server/scenario"
+	"github.com/gin-gonic/gin"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type transitionRequest struct {
+	Transition string `json:"transition" binding:"required"`
+}
+
+// SetQuestScenario replaces the scenario of the quest with the YAML or JSON document in the
+// request body. The format is taken from the format query parameter or the Content-Type
+// header and defaults to YAML. Invalid scenarios are rejected with 422 and the reason.
+func (env *Env) SetQuestScenario(c *gin.Context) {
+	stored, ok := env.getManagedQuest(c)
+	if !ok {
+		return
+	}
+	format := c.Query(formatQuery)
+	if format == "" {
+		format = scenario.FormatYAML
+		if strings.Contains(c.ContentType(), scenario.FormatJSON) {
+			format = scenario.FormatJSON
+		}
+	}
+	if format != scenario.FormatYAML && format != scenario.FormatJSON {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(
+			fmt.Errorf("format must be %s or %s", scenario.FormatYAML, scenario.FormatJSON),
+		))
+		return
+	}
+
+	source, err := ioutil.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, scenario.MaxSourceSize+1))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, common.GetErrResponse(
+			fmt.Errorf("scenario is larger than %d bytes", scenario.MaxSourceSize),
+		))
+		return
+	}
+	parsed, err := scenario.Parse(source, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := parsed.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(err))
+		return
+	}
+
+	saved, dbErr := env.scenarioDAO.SetScenario(
+		model.QuestScenario{QuestID: stored.ID, Format: format, Source: string(source)}, parsed.CheckpointIDs(),
+	)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(saved))
+}
+
+func (env *Env) GetQuestScenario(c *gin.Context) {
+	stored, ok := env.getManagedQuest(c)
+	if !ok {
+		return
+	}
+	saved, err := env.scenarioDAO.GetScenario(stored.ID)
+	if err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(saved))
+}
+
+// DeleteQuestScenario removes the scenario, so the quest is finished by its checkpoints only.
+func (env *Env) DeleteQuestScenario(c *gin.Context) {
+	stored, ok := env.getManagedQuest(c)
+	if !ok {
+		return
+	}
+	if err := env.scenarioDAO.DeleteScenario(stored.ID); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
+
+// GetScenarioState returns the state of the current user in the quest scenario together
+// with the transitions out of it and the conditions of each transition that do not hold yet.
+func (env *Env) GetScenarioState(c *gin.Context) {
+	run, ok := env.loadScenarioRun(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(run.scenario.View(run.player, run.facts)))
+}
+
+// MakeScenarioTransition moves the current user along the transition if its conditions
+// hold and returns the new state. Transitions out of other states give 409 with the reason.
+func (env *Env) MakeScenarioTransition(c *gin.Context) {
+	var request transitionRequest
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	run, ok := env.loadScenarioRun(c)
+	if !ok {
+		return
+	}
+
+	next, err := run.scenario.Apply(run.player, request.Transition, run.facts)
+	if err == scenario.ErrUnknownTransition {
+		c.JSON(http.StatusNotFound, common.GetErrResponse(fmt.Errorf("transition %q not found", request.Transition)))
+		return
+	} else if err != nil {
+		c.JSON(http.StatusConflict, common.GetErrResponse(err))
+		return
+	}
+	if err := env.scenarioDAO.SaveState(c.GetInt(UserID), run.questID, next); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(run.scenario.View(next, run.facts)))
+}
+
+// scenarioRun is the quest scenario together with the state of the current user in it.
+type scenarioRun struct {
+	questID  int
+	scenario *scenario.Scenario
+	player   model.ScenarioState
+	facts    scenario.Facts
+}
+
+// loadScenarioRun reads the quest scenario, the state of the current user in it and the
+// checkpoints the user has reached. On failure it writes the response itself and returns false.
+func (env *Env) loadScenarioRun(c *gin.Context) (scenarioRun, bool) {
+	run := scenarioRun{}
+	var err error
+	if run.questID, err = getIntParam(c, idParam); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return run, false
+	}
+	saved, dbErr := env.scenarioDAO.GetScenario(run.questID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return run, false
+	}
+	if run.scenario, err = scenario.Parse([]byte(saved.Source), saved.Format); err != nil {
+		env.logger.Errorf("stored scenario of quest %d is invalid: %v", run.questID, err)
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+		return run, false
+	}
+
+	userID := c.GetInt(UserID)
+	if run.player, dbErr = env.scenarioDAO.GetState(userID, run.questID); dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return run, false
+	}
+	progress, dbErr := env.checkpointDAO.GetProgress(userID, run.questID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return run, false
+	}
+	run.facts = scenario.Facts{Reached: make(map[int]bool), Now: time.Now()}
+	for _, checkpoint := range progress.Checkpoints {
+		run.facts.Reached[checkpoint.ID] = checkpoint.Reached
+	}
+	return run, true
+}
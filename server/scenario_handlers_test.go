@@ -0,0 +1,205 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/Sovianum/arquest-server/scenario"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const scenarioSample = `
+version: 1
+start: gate
+items: [key]
+states:
+  - id: gate
+    transitions:
+      - id: open
+        to: out
+        when: [{checkpoint: 2}]
+        give: [key]
+  - id: out
+    final: true
+`
+
+type ScenarioTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *ScenarioTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	s.env.questDAO = dao.NewQuestDAO(s.db)
+	s.env.checkpointDAO = dao.NewCheckpointDAO(s.db)
+	s.env.scenarioDAO = dao.NewScenarioDAO(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+	s.c.Set(UserID, 20)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+}
+
+func (s *ScenarioTestSuite) TestSetQuestScenario() {
+	expectStoredQuest(s.mock, 20)
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM quest WHERE").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	s.mock.
+		ExpectQuery("SELECT id FROM quest_checkpoint").
+		WithArgs(3, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	s.mock.
+		ExpectQuery("INSERT INTO quest_scenario").
+		WithArgs(3, scenario.FormatYAML, scenarioSample, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"updated_at"}).AddRow(time.Now()))
+	s.mock.ExpectCommit()
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPut, strings.NewReader(scenarioSample))
+	s.env.SetQuestScenario(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ScenarioTestSuite) TestSetQuestScenarioInvalid() {
+	for _, tc := range []struct {
+		source string
+		code   int
+	}{
+		{"version: 1\nstates: [\n", http.StatusBadRequest},
+		{strings.Replace(scenarioSample, "to: out", "to: nowhere", 1), http.StatusUnprocessableEntity},
+	} {
+		s.SetupTest()
+		expectStoredQuest(s.mock, 20)
+
+		s.c.Request, _ = getRequest(urlSample, http.MethodPut, strings.NewReader(tc.source))
+		s.env.SetQuestScenario(s.c)
+
+		s.Equal(tc.code, s.rw.Code, tc.source)
+	}
+}
+
+func (s *ScenarioTestSuite) TestSetQuestScenarioJSON() {
+	expectStoredQuest(s.mock, 20)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPut, strings.NewReader(scenarioSample))
+	s.c.Request.Header.Set("Content-Type", "application/json")
+	s.env.SetQuestScenario(s.c)
+
+	s.Equal(http.StatusBadRequest, s.rw.Code)
+	s.Contains(s.rw.Body.String(), "invalid json scenario")
+}
+
+func (s *ScenarioTestSuite) expectScenarioRun(stateRows *sqlmock.Rows, reached bool) {
+	s.mock.
+		ExpectQuery("SELECT quest_id, format, source").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"quest_id", "format", "source", "updated_at"}).
+			AddRow(3, scenario.FormatYAML, scenarioSample, time.Now()))
+	if stateRows != nil {
+		s.mock.ExpectQuery("SELECT state, items, timers").WithArgs(20, 3).WillReturnRows(stateRows)
+	} else {
+		s.mock.ExpectQuery("SELECT state, items, timers").WithArgs(20, 3).WillReturnError(sql.ErrNoRows)
+	}
+	var reachedAt interface{}
+	if reached {
+		reachedAt = time.Now()
+	}
+	expectLatestAttempt(s.mock, "")
+	s.mock.
+		ExpectQuery("SELECT .+ reached_at").
+		WillReturnRows(sqlmock.NewRows(checkpointProgressColumns).
+			AddRow(2, 3, 1, "a", "", model.CheckpointQR, false, nil, nil, nil, "", reachedAt, 0, 0))
+}
+
+func (s *ScenarioTestSuite) TestGetScenarioState() {
+	s.expectScenarioRun(nil, false)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetScenarioState(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	var view scenario.View
+	resp := common.ResponseMsg{Data: &view}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Equal("gate", view.State)
+	s.Equal([]scenario.Action{{Transition: "open", To: "out", Unmet: []string{"checkpoint 2 is not reached"}}}, view.Actions)
+}
+
+func (s *ScenarioTestSuite) TestGetScenarioStateWithoutScenario() {
+	s.mock.ExpectQuery("SELECT quest_id, format, source").WithArgs(3).WillReturnError(sql.ErrNoRows)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodGet, nil)
+	s.env.GetScenarioState(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+}
+
+func (s *ScenarioTestSuite) TestMakeScenarioTransition() {
+	s.expectScenarioRun(nil, true)
+	s.mock.ExpectBegin()
+	expectActiveAttempt(s.mock)
+	s.mock.
+		ExpectExec("INSERT INTO scenario_state").
+		WithArgs(7, 20, 3, "out", sqlmock.AnyArg(), []byte("{}"), true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"transition": "open"}`))
+	s.env.MakeScenarioTransition(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"final":true`)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *ScenarioTestSuite) TestMakeScenarioTransitionNotAllowed() {
+	s.expectScenarioRun(nil, false)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"transition": "open"}`))
+	s.env.MakeScenarioTransition(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
+	s.Contains(s.rw.Body.String(), "checkpoint 2 is not reached")
+}
+
+func (s *ScenarioTestSuite) TestMakeScenarioTransitionFromFinalState() {
+	s.expectScenarioRun(sqlmock.NewRows([]string{"state", "items", "timers", "final", "version"}).
+		AddRow("out", "{key}", "{}", true, 1), true)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"transition": "open"}`))
+	s.env.MakeScenarioTransition(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
+}
+
+func (s *ScenarioTestSuite) TestMakeScenarioTransitionUnknown() {
+	s.expectScenarioRun(nil, true)
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"transition": "fly"}`))
+	s.env.MakeScenarioTransition(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+}
+
+func TestScenarioTestSuite(t *testing.T) {
+	suite.Run(t, new(ScenarioTestSuite))
+}
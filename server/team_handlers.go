@@ -0,0 +1,90 @@
+package server
+
+import (
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// inviteCodeBytes gives invite codes of 8 characters
+const inviteCodeBytes = 6
+
+// CreateTeam creates a team with a new invite code; the current user becomes its first member.
+func (env *Env) CreateTeam(c *gin.Context) {
+	var team model.Team
+	if err := c.BindJSON(&team); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := team.Validate(); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, common.GetErrResponse(err))
+		return
+	}
+	code, err := newRandomString(inviteCodeBytes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(err))
+		return
+	}
+	team.InviteCode = code
+
+	created, dbErr := env.teamDAO.CreateTeam(c.GetInt(UserID), team)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	env.queueFlagged(model.ContentTeamName, created.ID, 0, created.Name, env.filter.Check(created.Name, ""))
+	c.JSON(http.StatusCreated, common.GetDataResponse(created))
+}
+
+// GetTeam shows the team with its members to the members only.
+func (env *Env) GetTeam(c *gin.Context) {
+	teamID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	team, dbErr := env.teamDAO.GetTeam(c.GetInt(UserID), teamID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(team))
+}
+
+func (env *Env) GetTeams(c *gin.Context) {
+	teams, err := env.teamDAO.GetTeams(c.GetInt(UserID))
+	if err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(teams))
+}
+
+// JoinTeam adds the current user to the team with the invite code from the body.
+func (env *Env) JoinTeam(c *gin.Context) {
+	var invite model.TeamInvite
+	if err := c.BindJSON(&invite); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	team, err := env.teamDAO.JoinTeam(c.GetInt(UserID), invite.InviteCode)
+	if err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(team))
+}
+
+func (env *Env) LeaveTeam(c *gin.Context) {
+	teamID, err := getIntParam(c, idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := env.teamDAO.LeaveTeam(c.GetInt(UserID), teamID); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"database/sql"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type TeamTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *TeamTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	s.env.questDAO = dao.NewQuestDAO(s.db)
+	s.env.attemptDAO = dao.NewAttemptDAO(s.db)
+	s.env.teamDAO = dao.NewTeamDAO(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+	s.c.Set(UserID, 20)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+}
+
+// expectTeamQuest expects the quest 3 played by teams of 2 to 4 members to be read.
+func (s *TeamTestSuite) expectTeamQuest() {
+	s.mock.
+		ExpectQuery("SELECT id, name, description, rating, author_id, version").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows(questColumnNames).
+			AddRow(3, "n1", "d1", 4., 1, 2, 0, 0, "", nil, nil, nil, nil, nil, nil, 2, 4, nil, "", 0))
+}
+
+func (s *TeamTestSuite) TestCreateTeam() {
+	s.mock.
+		ExpectQuery("INSERT INTO team").
+		WithArgs("owls", sqlmock.AnyArg(), 20).
+		WillReturnRows(sqlmock.NewRows([]string{"team_id"}).AddRow(4))
+	s.mock.
+		ExpectQuery("SELECT t.id, t.name").
+		WithArgs(4, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "invite_code", "created_at"}).AddRow(4, "owls", "abcdefgh", time.Now()))
+	s.mock.
+		ExpectQuery("SELECT u.id, u.login").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "login", "joined_at"}).AddRow(20, "login", time.Now()))
+
+	s.c.Request, _ = getRequest("/api/v1/teams", http.MethodPost, strings.NewReader(`{"name":"owls"}`))
+	s.env.CreateTeam(s.c)
+
+	s.Require().Equal(http.StatusCreated, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"id":4,"name":"owls","invite_code":"abcdefgh"`)
+	s.Contains(s.rw.Body.String(), `"members":[{"user_id":20,"login":"login"`)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TeamTestSuite) TestCreateTeamWithoutName() {
+	s.c.Request, _ = getRequest("/api/v1/teams", http.MethodPost, strings.NewReader(`{"name":" "}`))
+	s.env.CreateTeam(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+}
+
+func (s *TeamTestSuite) TestJoinPlayingTeam() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM team WHERE invite_code").WithArgs("abcdefgh").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	s.mock.ExpectQuery("SELECT count\\(\\*\\) FROM quest_attempt").WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.ExpectRollback()
+
+	s.c.Request, _ = getRequest("/api/v1/user/teams", http.MethodPost, strings.NewReader(`{"invite_code":"abcdefgh"}`))
+	s.env.JoinTeam(s.c)
+
+	s.Equal(http.StatusConflict, s.rw.Code)
+	s.Contains(s.rw.Body.String(), "the team is playing a quest")
+}
+
+func (s *TeamTestSuite) TestLeaveTeam() {
+	s.c.Params = gin.Params{{Key: idParam, Value: "4"}}
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT id FROM team WHERE id").WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	s.mock.ExpectQuery("SELECT count\\(\\*\\) FROM quest_attempt").WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.ExpectExec("DELETE FROM team_member").WithArgs(4, 20).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	s.c.Request, _ = getRequest("/api/v1/user/teams/4", http.MethodDelete, nil)
+	s.env.LeaveTeam(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TeamTestSuite) TestStartTeamAttempt() {
+	s.expectTeamQuest()
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT t.id FROM team").WithArgs(4, 20).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	s.mock.ExpectQuery("WHERE team_id = \\$1 AND quest_id = \\$2 AND outcome = 'active'").WithArgs(4, 3).WillReturnRows(sqlmock.NewRows(attemptColumns))
+	s.mock.ExpectQuery("SELECT count\\(\\*\\), count\\(\\*\\) FILTER").WithArgs(4, 3).WillReturnRows(sqlmock.NewRows([]string{"size", "playing"}).AddRow(3, 0))
+	s.mock.
+		ExpectQuery("INSERT INTO quest_attempt \\(user_id, quest_id, team_id\\)").
+		WithArgs(20, 3, 4).
+		WillReturnRows(sqlmock.NewRows(attemptColumns).AddRow(8, 3, 4, "active", nil, time.Now(), nil, 0, 0, 0, 0, 0))
+	s.mock.ExpectCommit()
+
+	s.c.Request, _ = getRequest(urlSample+"?team=4", http.MethodPost, http.NoBody)
+	s.env.StartAttempt(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Contains(s.rw.Body.String(), `"id":8,"quest_id":3,"team_id":4,"outcome":"active"`)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TeamTestSuite) TestStartSoloAttemptOfTeamQuest() {
+	s.expectTeamQuest()
+
+	s.c.Request, _ = getRequest(urlSample, http.MethodPost, http.NoBody)
+	s.env.StartAttempt(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+	s.Contains(s.rw.Body.String(), "teams of at least 2 members")
+}
+
+func (s *TeamTestSuite) TestStartTeamAttemptOfSoloQuest() {
+	expectStoredQuest(s.mock, 1)
+
+	s.c.Request, _ = getRequest(urlSample+"?team=4", http.MethodPost, http.NoBody)
+	s.env.StartAttempt(s.c)
+
+	s.Equal(http.StatusUnprocessableEntity, s.rw.Code)
+}
+
+func TestTeamTestSuite(t *testing.T) {
+	suite.Run(t, new(TeamTestSuite))
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	tokenIdBytes      = 16
+	refreshTokenBytes = 32
+)
+
+var (
+	invalidRefreshErr = fmt.Errorf("refresh token is invalid, expired or revoked")
+	reusedRefreshErr  = fmt.Errorf("refresh token has already been used, all sessions of this login are closed")
+)
+
+func (env *Env) RefreshTokenPost(c *gin.Context) {
+	var req model.RefreshRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if req.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("no refresh token")))
+		return
+	}
+
+	stored, dbErr := env.tokenDAO.GetRefreshToken(hashRefreshToken(req.RefreshToken))
+	if dbErr != nil {
+		if dbErr.Code() == http.StatusNotFound {
+			c.JSON(http.StatusUnauthorized, common.GetErrResponse(invalidRefreshErr))
+		} else {
+			c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		}
+		return
+	}
+	if stored.Revoked || stored.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusUnauthorized, common.GetErrResponse(invalidRefreshErr))
+		return
+	}
+	if stored.Used {
+		env.handleRefreshReuse(c, stored)
+		return
+	}
+	ban, banErr := env.moderationDAO.GetBan(stored.UserID)
+	if banErr != nil {
+		c.JSON(banErr.Code(), common.GetErrResponse(banErr))
+		return
+	} else if ban != nil {
+		c.JSON(http.StatusForbidden, common.GetErrResponse(bannedErr(ban)))
+		return
+	}
+	if err := env.tokenDAO.MarkRefreshTokenUsed(stored.ID); err != nil {
+		if err.Code() == http.StatusConflict { // concurrent refresh with the same token
+			env.handleRefreshReuse(c, stored)
+		} else {
+			c.JSON(err.Code(), common.GetErrResponse(err))
+		}
+		return
+	}
+
+	user, userErr := env.userDAO.GetUserById(stored.UserID)
+	if userErr != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(userErr))
+		return
+	}
+
+	tokens, tokenErr := env.issueTokenPair(user.Id, user.Login, stored.FamilyID)
+	if tokenErr != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(tokenErr))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(tokens))
+}
+
+func (env *Env) UserLogoutPost(c *gin.Context) {
+	userID := c.GetInt(UserID)
+
+	var req model.LogoutRequest
+	if err := c.ShouldBindWith(&req, binding.JSON); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+
+	if req.All {
+		if err := env.tokenDAO.RevokeUserTokens(userID); err != nil {
+			c.JSON(err.Code(), common.GetErrResponse(err))
+			return
+		}
+	} else if req.RefreshToken != "" {
+		stored, err := env.tokenDAO.GetRefreshToken(hashRefreshToken(req.RefreshToken))
+		if err != nil && err.Code() != http.StatusNotFound {
+			c.JSON(err.Code(), common.GetErrResponse(err))
+			return
+		}
+		if stored != nil && stored.UserID == userID {
+			if err := env.tokenDAO.RevokeFamily(stored.FamilyID); err != nil {
+				c.JSON(err.Code(), common.GetErrResponse(err))
+				return
+			}
+		}
+	}
+
+	if err := env.tokenDAO.RevokeAccessToken(c.GetString(TokenID), c.GetTime(TokenExpiry)); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusOK, common.GetEmptyResponse())
+}
+
+// PurgeExpiredTokens periodically removes refresh tokens and revocation records
+// which can no longer be presented. It never returns.
+func (env *Env) PurgeExpiredTokens(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := env.tokenDAO.PurgeExpired(); err != nil {
+			env.logger.Errorf("failed to purge expired tokens: %v", err)
+		}
+	}
+}
+
+// issueTokenPair creates an access token and a refresh token bound to it.
+// Empty familyID starts a new token family (a new login session).
+// Roles are read from the database so that refreshing picks up granted and revoked roles.
+func (env *Env) issueTokenPair(userID int, login string, familyID string) (model.TokenPair, error) {
+	if familyID == "" {
+		var err error
+		if familyID, err = newRandomString(tokenIdBytes); err != nil {
+			return model.TokenPair{}, err
+		}
+	}
+
+	roles, rolesErr := env.roleDAO.GetRoles(userID)
+	if rolesErr != nil {
+		return model.TokenPair{}, rolesErr
+	}
+	access, err := env.generateAccessToken(userID, login, roles)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+	refresh, err := newRandomString(refreshTokenBytes)
+	if err != nil {
+		return model.TokenPair{}, err
+	}
+
+	dbErr := env.tokenDAO.SaveRefreshToken(model.RefreshToken{
+		UserID:          userID,
+		FamilyID:        familyID,
+		TokenHash:       hashRefreshToken(refresh),
+		AccessJTI:       access.jti,
+		AccessExpiresAt: access.expiresAt,
+		ExpiresAt:       time.Now().Add(env.conf.Auth.GetRefreshTTL()),
+	})
+	if dbErr != nil {
+		return model.TokenPair{}, dbErr
+	}
+
+	return model.TokenPair{
+		AccessToken:  access.token,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(env.conf.Auth.GetAccessTTL().Seconds()),
+	}, nil
+}
+
+func (env *Env) handleRefreshReuse(c *gin.Context, stored *model.RefreshToken) {
+	env.logger.Warningf("reuse of refresh token of user %d detected, revoking family %s", stored.UserID, stored.FamilyID)
+	if err := env.tokenDAO.RevokeFamily(stored.FamilyID); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	c.JSON(http.StatusUnauthorized, common.GetErrResponse(reusedRefreshErr))
+}
+
+func hashRefreshToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+func newRandomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
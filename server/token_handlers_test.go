@@ -0,0 +1,259 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	refreshToken = "refresh"
+	familyID     = "family"
+)
+
+type TokenTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *TokenTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+}
+
+func (s *TokenTestSuite) expectStoredToken(used, revoked bool, expiresAt time.Time) {
+	s.mock.
+		ExpectQuery("SELECT id, user_id, family_id").
+		WithArgs(hashRefreshToken(refreshToken)).
+		WillReturnRows(
+			sqlmock.NewRows([]string{
+				"id", "user_id", "family_id", "token_hash", "access_jti", "access_expires_at", "expires_at", "used", "revoked",
+			}).AddRow(
+				5, 20, familyID, hashRefreshToken(refreshToken), "jti", time.Now(), expiresAt, used, revoked,
+			),
+		)
+}
+
+// expectBan expects the ban of the user to be looked up.
+func expectBan(mock sqlmock.Sqlmock, userID int, banned bool) {
+	rows := sqlmock.NewRows([]string{"user_id", "moderator_id", "reason", "created_at"})
+	if banned {
+		rows.AddRow(userID, 2, "spam", time.Now())
+	}
+	mock.ExpectQuery("SELECT user_id, .+ FROM user_ban").WithArgs(userID).WillReturnRows(rows)
+}
+
+func (s *TokenTestSuite) refresh() {
+	var err error
+	s.c.Request, err = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"refresh_token": "`+refreshToken+`"}`))
+	s.Require().NoError(err)
+	s.env.RefreshTokenPost(s.c)
+}
+
+func (s *TokenTestSuite) TestRefreshSuccess() {
+	s.expectStoredToken(false, false, time.Now().Add(time.Hour))
+	expectBan(s.mock, 20, false)
+	s.mock.
+		ExpectExec("UPDATE refresh_token SET used_at").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.
+		ExpectQuery("SELECT id, login").
+		WithArgs(20).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"id", "login", "password", "age", "sex", "about"}).
+				AddRow(20, "login", "pass", 100, model.MALE, "about"),
+		)
+	s.mock.
+		ExpectQuery("SELECT role FROM user_role").
+		WithArgs(20).
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(model.RoleAuthor))
+	s.mock.
+		ExpectExec("INSERT INTO refresh_token").
+		WithArgs(20, familyID, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	s.refresh()
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	resp := struct {
+		Data model.TokenPair `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.NotEmpty(resp.Data.AccessToken)
+	s.NotEmpty(resp.Data.RefreshToken)
+	s.NotEqual(refreshToken, resp.Data.RefreshToken)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TokenTestSuite) TestRefreshReuseRevokesFamily() {
+	s.expectStoredToken(true, false, time.Now().Add(time.Hour))
+	s.mock.ExpectBegin()
+	s.mock.
+		ExpectExec("INSERT INTO revoked_token").
+		WithArgs(familyID).
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	s.mock.
+		ExpectExec("UPDATE refresh_token SET revoked").
+		WithArgs(familyID).
+		WillReturnResult(sqlmock.NewResult(1, 2))
+	s.mock.ExpectCommit()
+
+	s.refresh()
+
+	s.Equal(http.StatusUnauthorized, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TokenTestSuite) TestRefreshConcurrentUseRevokesFamily() {
+	s.expectStoredToken(false, false, time.Now().Add(time.Hour))
+	expectBan(s.mock, 20, false)
+	s.mock.
+		ExpectExec("UPDATE refresh_token SET used_at").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("INSERT INTO revoked_token").WillReturnResult(sqlmock.NewResult(1, 2))
+	s.mock.ExpectExec("UPDATE refresh_token SET revoked").WillReturnResult(sqlmock.NewResult(1, 2))
+	s.mock.ExpectCommit()
+
+	s.refresh()
+
+	s.Equal(http.StatusUnauthorized, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TokenTestSuite) TestRefreshBanned() {
+	s.expectStoredToken(false, false, time.Now().Add(time.Hour))
+	expectBan(s.mock, 20, true)
+
+	s.refresh()
+
+	s.Equal(http.StatusForbidden, s.rw.Code)
+	s.Contains(s.rw.Body.String(), "your account is banned: spam")
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TokenTestSuite) TestRefreshExpired() {
+	s.expectStoredToken(false, false, time.Now().Add(-time.Hour))
+
+	s.refresh()
+
+	s.Equal(http.StatusUnauthorized, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TokenTestSuite) TestRefreshRevoked() {
+	s.expectStoredToken(false, true, time.Now().Add(time.Hour))
+
+	s.refresh()
+
+	s.Equal(http.StatusUnauthorized, s.rw.Code)
+}
+
+func (s *TokenTestSuite) TestRefreshUnknown() {
+	s.mock.
+		ExpectQuery("SELECT id, user_id, family_id").
+		WillReturnError(sql.ErrNoRows)
+
+	s.refresh()
+
+	s.Equal(http.StatusUnauthorized, s.rw.Code)
+}
+
+func (s *TokenTestSuite) TestRefreshEmpty() {
+	var err error
+	s.c.Request, err = getRequest(urlSample, http.MethodPost, strings.NewReader(`{}`))
+	s.Require().NoError(err)
+	s.env.RefreshTokenPost(s.c)
+
+	s.Equal(http.StatusBadRequest, s.rw.Code)
+}
+
+func (s *TokenTestSuite) TestLogoutCurrentSession() {
+	expiry := time.Now().Add(time.Minute)
+	s.c.Set(UserID, 20)
+	s.c.Set(TokenID, "jti")
+	s.c.Set(TokenExpiry, expiry)
+
+	s.expectStoredToken(false, false, time.Now().Add(time.Hour))
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("INSERT INTO revoked_token").WithArgs(familyID).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectExec("UPDATE refresh_token SET revoked").WithArgs(familyID).WillReturnResult(sqlmock.NewResult(1, 1))
+	s.mock.ExpectCommit()
+	s.mock.
+		ExpectExec("INSERT INTO revoked_token").
+		WithArgs("jti", expiry).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	var err error
+	s.c.Request, err = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"refresh_token": "`+refreshToken+`"}`))
+	s.Require().NoError(err)
+	s.env.UserLogoutPost(s.c)
+
+	resp := common.ResponseMsg{}
+	json.Unmarshal(s.rw.Body.Bytes(), &resp)
+	s.Nil(resp.ErrMsg)
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TokenTestSuite) TestLogoutAll() {
+	s.c.Set(UserID, 20)
+	s.c.Set(TokenID, "jti")
+	s.c.Set(TokenExpiry, time.Now())
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("INSERT INTO revoked_token").WithArgs(20).WillReturnResult(sqlmock.NewResult(1, 3))
+	s.mock.ExpectExec("UPDATE refresh_token SET revoked").WithArgs(20).WillReturnResult(sqlmock.NewResult(1, 3))
+	s.mock.ExpectCommit()
+	s.mock.ExpectExec("INSERT INTO revoked_token").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	var err error
+	s.c.Request, err = getRequest(urlSample, http.MethodPost, strings.NewReader(`{"all": true}`))
+	s.Require().NoError(err)
+	s.env.UserLogoutPost(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *TokenTestSuite) TestLogoutEmptyBody() {
+	s.c.Set(UserID, 20)
+	s.c.Set(TokenID, "jti")
+	s.c.Set(TokenExpiry, time.Now())
+
+	s.mock.ExpectExec("INSERT INTO revoked_token").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	var err error
+	s.c.Request, err = getRequest(urlSample, http.MethodPost, strings.NewReader(""))
+	s.Require().NoError(err)
+	s.env.UserLogoutPost(s.c)
+
+	s.Equal(http.StatusOK, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func TestTokenTestSuite(t *testing.T) {
+	suite.Run(t, new(TokenTestSuite))
+}
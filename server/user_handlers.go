@@ -13,5 +13,11 @@ func (env *Env) UserGetSelfInfo(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, common.GetErrResponse(dbErr))
 		return
 	}
+	roles, rolesErr := env.roleDAO.GetRoles(userId)
+	if rolesErr != nil {
+		c.JSON(http.StatusInternalServerError, common.GetErrResponse(rolesErr))
+		return
+	}
+	dbUser.Roles = roles
 	c.JSON(http.StatusOK, common.GetDataResponse(dbUser))
 }
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Sovianum/arquest-server/common"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+const (
+	versionParam = "version"
+	fromQuery    = "from"
+
+	questFileURLTemplate = "/api/v1/quests/%d/files/%s"
+)
+
+var (
+	unpublishedVersionErr = fmt.Errorf("quest version not found")
+)
+
+// GetQuestVersions lists the quest versions. The author and admins see drafts too, other
+// users only the published version.
+func (env *Env) GetQuestVersions(c *gin.Context) {
+	quest, ok := env.getDownloadableQuest(c)
+	if !ok {
+		return
+	}
+	versions, dbErr := env.versionDAO.GetVersions(quest.ID)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	if !canManageQuest(c, quest) {
+		published := make([]model.QuestVersion, 0, 1)
+		for _, version := range versions {
+			if version.Published {
+				published = append(published, version)
+			}
+		}
+		versions = published
+	}
+	c.JSON(http.StatusOK, common.GetDataResponse(versions))
+}
+
+// GetQuestVersion returns the version with its file list. Drafts are reported as missing
+// to users who can not manage the quest.
+func (env *Env) GetQuestVersion(c *gin.Context) {
+	quest, ok := env.getDownloadableQuest(c)
+	if !ok {
+		return
+	}
+	versionNum, err := getIntParam(c, versionParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	version, dbErr := env.versionDAO.GetVersion(quest.ID, versionNum)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	if !version.Published && !canManageQuest(c, quest) {
+		c.JSON(http.StatusNotFound, common.GetErrResponse(unpublishedVersionErr))
+		return
+	}
+	setFileURLs(quest.ID, version.Files)
+	c.JSON(http.StatusOK, common.GetDataResponse(version))
+}
+
+// PublishQuestVersion makes one of the stored versions current. Publishing an older
+// version rolls the quest back without uploading it again.
+func (env *Env) PublishQuestVersion(c *gin.Context) {
+	quest, ok := env.getManagedQuest(c)
+	if !ok {
+		return
+	}
+	versionNum, err := getIntParam(c, versionParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(err))
+		return
+	}
+	if err := env.versionDAO.PublishVersion(quest.ID, versionNum); err != nil {
+		c.JSON(err.Code(), common.GetErrResponse(err))
+		return
+	}
+	version, dbErr := env.versionDAO.GetVersion(quest.ID, versionNum)
+	if dbErr != nil {
+		c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		return
+	}
+	version.Files = nil
+	c.JSON(http.StatusOK, common.GetDataResponse(version))
+}
+
+// GetQuestDiff tells a client with version "from" installed (0 or missing for a fresh
+// install) which files to download and delete to get the published version.
+func (env *Env) GetQuestDiff(c *gin.Context) {
+	quest, ok := env.getDownloadableQuest(c)
+	if !ok {
+		return
+	}
+	from, err := strconv.Atoi(c.DefaultQuery(fromQuery, "0"))
+	if err != nil || from < 0 {
+		c.JSON(http.StatusBadRequest, common.GetErrResponse(fmt.Errorf("invalid %s %q", fromQuery, c.Query(fromQuery))))
+		return
+	}
+
+	to, dbErr := env.versionDAO.GetPublishedVersion(quest.ID)
+	if dbErr != nil {
+		if dbErr.Code() == http.StatusNotFound {
+			c.JSON(http.StatusNotFound, common.GetErrResponse(noArchiveErr))
+		} else {
+			c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+		}
+		return
+	}
+
+	var installed model.QuestVersion
+	switch from {
+	case 0:
+	case to.Version:
+		installed = to
+	default:
+		if installed, dbErr = env.versionDAO.GetVersion(quest.ID, from); dbErr != nil {
+			c.JSON(dbErr.Code(), common.GetErrResponse(dbErr))
+			return
+		}
+	}
+
+	diff := model.QuestDiff{From: from, To: to.Version}
+	diff.Added, diff.Changed, diff.Removed = model.DiffFiles(installed.Files, to.Files)
+	if len(to.Files) == 0 || (from != 0 && len(installed.Files) == 0) {
+		// versions uploaded before versioning have no file lists
+		diff.Full = true
+		diff.Added, diff.Changed, diff.Removed = []model.QuestFile{}, []model.QuestFile{}, []model.QuestFile{}
+	}
+	setFileURLs(quest.ID, diff.Added)
+	setFileURLs(quest.ID, diff.Changed)
+	c.JSON(http.StatusOK, common.GetDataResponse(diff))
+}
+
+func setFileURLs(questID int, files []model.QuestFile) {
+	for i := range files {
+		files[i].URL = fmt.Sprintf(questFileURLTemplate, questID, files[i].SHA256)
+	}
+}
@@ -0,0 +1,307 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/Sovianum/arquest-server/dao"
+	"github.com/Sovianum/arquest-server/model"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+	"gopkg.in/DATA-DOG/go-sqlmock.v1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type VersionTestSuite struct {
+	suite.Suite
+	db   *sql.DB
+	env  *Env
+	mock sqlmock.Sqlmock
+	c    *gin.Context
+	rw   *httptest.ResponseRecorder
+}
+
+func (s *VersionTestSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	s.Require().NoError(err)
+
+	s.env = getEnv(s.db)
+	s.env.questDAO = dao.NewQuestDAO(s.db)
+	gin.SetMode(gin.ReleaseMode)
+
+	s.rw = httptest.NewRecorder()
+	s.c, _ = gin.CreateTestContext(s.rw)
+	s.c.Set(UserID, 20)
+	s.c.Params = gin.Params{{Key: idParam, Value: "3"}}
+}
+
+func (s *VersionTestSuite) expectStoredQuest() {
+	s.mock.
+		ExpectQuery("SELECT id, name, description, rating, author_id, version").
+		WithArgs(3).
+		WillReturnRows(
+			sqlmock.NewRows(questColumnNames).
+				AddRow(questRow(3, "n1", "d1", 4., 20, 2, 2, 10, "sha")...),
+		)
+}
+
+func (s *VersionTestSuite) expectDraftQuest() {
+	s.mock.
+		ExpectQuery("SELECT id, name, description, rating, author_id, version").
+		WithArgs(3).
+		WillReturnRows(
+			sqlmock.NewRows(questColumnNames).
+				AddRow(questRow(3, "n1", "d1", 4., 20, 1, 0, 10, "sha")...),
+		)
+}
+
+// expectVersion expects a version query followed by the query of its files given as path, hash pairs.
+func (s *VersionTestSuite) expectVersion(version int, published bool, files ...string) {
+	s.mock.
+		ExpectQuery("SELECT v.quest_id").
+		WillReturnRows(sqlmock.NewRows(versionColumns).AddRow(3, version, "", "key", 10, "sha", 20, time.Now(), published))
+	rows := sqlmock.NewRows([]string{"path", "size", "sha256"})
+	for i := 0; i < len(files); i += 2 {
+		rows.AddRow(files[i], 1, files[i+1])
+	}
+	s.mock.ExpectQuery("SELECT path, size, sha256").WithArgs(3, version).WillReturnRows(rows)
+}
+
+func (s *VersionTestSuite) diff(from string) model.QuestDiff {
+	s.c.Request, _ = http.NewRequest(http.MethodGet, urlSample+"?from="+from, nil)
+	s.env.GetQuestDiff(s.c)
+
+	resp := struct {
+		Data model.QuestDiff `json:"data"`
+	}{}
+	json.Unmarshal(s.rw.Body.Bytes(), &resp)
+	return resp.Data
+}
+
+func (s *VersionTestSuite) TestDiff() {
+	s.expectStoredQuest()
+	s.expectVersion(2, true, "a", "1", "b", "22", "d", "4")
+	s.expectVersion(1, false, "a", "1", "b", "2", "c", "3")
+
+	diff := s.diff("1")
+
+	s.Require().Equal(http.StatusOK, s.rw.Code, s.rw.Body.String())
+	s.Equal(1, diff.From)
+	s.Equal(2, diff.To)
+	s.False(diff.Full)
+	s.Equal([]model.QuestFile{{Path: "d", Size: 1, SHA256: "4", URL: "/api/v1/quests/3/files/4"}}, diff.Added)
+	s.Equal([]model.QuestFile{{Path: "b", Size: 1, SHA256: "22", URL: "/api/v1/quests/3/files/22"}}, diff.Changed)
+	s.Equal([]model.QuestFile{{Path: "c", Size: 1, SHA256: "3"}}, diff.Removed)
+}
+
+func (s *VersionTestSuite) TestDiffUpToDate() {
+	s.expectStoredQuest()
+	s.expectVersion(2, true, "a", "1")
+
+	diff := s.diff("2")
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Empty(diff.Added)
+	s.Empty(diff.Changed)
+	s.Empty(diff.Removed)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *VersionTestSuite) TestDiffFreshInstall() {
+	s.expectStoredQuest()
+	s.expectVersion(2, true, "a", "1", "b", "2")
+
+	diff := s.diff("0")
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.Len(diff.Added, 2)
+}
+
+func (s *VersionTestSuite) TestDiffFromLegacyVersion() {
+	s.expectStoredQuest()
+	s.expectVersion(2, true, "a", "1")
+	s.expectVersion(1, false)
+
+	diff := s.diff("1")
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	s.True(diff.Full)
+	s.Empty(diff.Added)
+}
+
+func (s *VersionTestSuite) TestDiffUnknownVersion() {
+	s.expectStoredQuest()
+	s.expectVersion(2, true, "a", "1")
+	s.mock.ExpectQuery("SELECT v.quest_id").WithArgs(3, 9).WillReturnRows(sqlmock.NewRows(versionColumns))
+
+	s.diff("9")
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+}
+
+func (s *VersionTestSuite) TestDiffInvalidFrom() {
+	s.expectStoredQuest()
+
+	s.diff("-1")
+
+	s.Equal(http.StatusBadRequest, s.rw.Code)
+}
+
+func (s *VersionTestSuite) TestGetVersions() {
+	s.expectStoredQuest()
+	s.mock.
+		ExpectQuery("SELECT v.quest_id").
+		WithArgs(3).
+		WillReturnRows(
+			sqlmock.NewRows(versionColumns).
+				AddRow(3, 2, "second", "key", 10, "sha", 20, time.Now(), true).
+				AddRow(3, 1, "first", "key", 10, "sha", 20, time.Now(), false),
+		)
+
+	s.c.Request, _ = http.NewRequest(http.MethodGet, urlSample, nil)
+	s.env.GetQuestVersions(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	resp := struct {
+		Data []model.QuestVersion `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Require().Len(resp.Data, 2)
+	s.Equal("second", resp.Data[0].Changelog)
+}
+
+func (s *VersionTestSuite) TestGetVersionsForeign() {
+	s.c.Set(UserID, 21)
+	s.expectStoredQuest()
+	s.mock.
+		ExpectQuery("SELECT v.quest_id").
+		WithArgs(3).
+		WillReturnRows(
+			sqlmock.NewRows(versionColumns).
+				AddRow(3, 3, "draft", "key", 10, "sha", 20, time.Now(), false).
+				AddRow(3, 2, "second", "key", 10, "sha", 20, time.Now(), true),
+		)
+
+	s.c.Request, _ = http.NewRequest(http.MethodGet, urlSample, nil)
+	s.env.GetQuestVersions(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	resp := struct {
+		Data []model.QuestVersion `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Require().Len(resp.Data, 1)
+	s.Equal(2, resp.Data[0].Version)
+}
+
+func (s *VersionTestSuite) TestGetVersionsAnonymous() {
+	s.c.Set(UserID, 0)
+	s.expectStoredQuest()
+
+	s.c.Request, _ = http.NewRequest(http.MethodGet, urlSample, nil)
+	s.env.GetQuestVersions(s.c)
+
+	s.Equal(http.StatusForbidden, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *VersionTestSuite) TestGetVersionsForeignDraftQuest() {
+	s.c.Set(UserID, 21)
+	s.expectDraftQuest()
+
+	s.c.Request, _ = http.NewRequest(http.MethodGet, urlSample, nil)
+	s.env.GetQuestVersions(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *VersionTestSuite) TestGetVersion() {
+	s.c.Params = append(s.c.Params, gin.Param{Key: versionParam, Value: "1"})
+	s.expectStoredQuest()
+	s.expectVersion(1, false, "a", "1")
+
+	s.c.Request, _ = http.NewRequest(http.MethodGet, urlSample, nil)
+	s.env.GetQuestVersion(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code)
+	resp := struct {
+		Data model.QuestVersion `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Equal([]model.QuestFile{{Path: "a", Size: 1, SHA256: "1", URL: "/api/v1/quests/3/files/1"}}, resp.Data.Files)
+}
+
+func (s *VersionTestSuite) TestGetVersionAnonymous() {
+	s.c.Params = append(s.c.Params, gin.Param{Key: versionParam, Value: "2"})
+	s.c.Set(UserID, 0)
+	s.expectStoredQuest()
+
+	s.c.Request, _ = http.NewRequest(http.MethodGet, urlSample, nil)
+	s.env.GetQuestVersion(s.c)
+
+	s.Equal(http.StatusForbidden, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *VersionTestSuite) TestGetVersionForeignDraft() {
+	s.c.Params = append(s.c.Params, gin.Param{Key: versionParam, Value: "3"})
+	s.c.Set(UserID, 21)
+	s.expectStoredQuest()
+	s.expectVersion(3, false, "a", "1")
+
+	s.c.Request, _ = http.NewRequest(http.MethodGet, urlSample, nil)
+	s.env.GetQuestVersion(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+	s.NotContains(s.rw.Body.String(), `"files"`)
+}
+
+func (s *VersionTestSuite) TestGetVersionForeignDraftQuest() {
+	s.c.Params = append(s.c.Params, gin.Param{Key: versionParam, Value: "1"})
+	s.c.Set(UserID, 21)
+	s.expectDraftQuest()
+
+	s.c.Request, _ = http.NewRequest(http.MethodGet, urlSample, nil)
+	s.env.GetQuestVersion(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *VersionTestSuite) TestRollback() {
+	s.c.Params = append(s.c.Params, gin.Param{Key: versionParam, Value: "1"})
+	s.expectStoredQuest()
+	s.mock.ExpectExec("UPDATE quest AS q SET").WithArgs(3, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.expectVersion(1, true, "a", "1")
+
+	s.c.Request, _ = http.NewRequest(http.MethodPost, urlSample, nil)
+	s.env.PublishQuestVersion(s.c)
+
+	s.Require().Equal(http.StatusOK, s.rw.Code, s.rw.Body.String())
+	resp := struct {
+		Data model.QuestVersion `json:"data"`
+	}{}
+	s.Require().NoError(json.Unmarshal(s.rw.Body.Bytes(), &resp))
+	s.Equal(1, resp.Data.Version)
+	s.True(resp.Data.Published)
+	s.NoError(s.mock.ExpectationsWereMet())
+}
+
+func (s *VersionTestSuite) TestRollbackUnknownVersion() {
+	s.c.Params = append(s.c.Params, gin.Param{Key: versionParam, Value: "7"})
+	s.expectStoredQuest()
+	s.mock.ExpectExec("UPDATE quest AS q SET").WithArgs(3, 7).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	s.c.Request, _ = http.NewRequest(http.MethodPost, urlSample, nil)
+	s.env.PublishQuestVersion(s.c)
+
+	s.Equal(http.StatusNotFound, s.rw.Code)
+}
+
+func TestVersionTestSuite(t *testing.T) {
+	suite.Run(t, new(VersionTestSuite))
+}
@@ -0,0 +1,59 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+)
+
+const (
+	ecCoordinateSize = 32 // P-256
+)
+
+// JSONWebKeySet is the RFC 7517 document served at /.well-known/jwks.json
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	Y         string `json:"y,omitempty"`
+}
+
+func newJSONWebKey(key *Key) JSONWebKey {
+	result := JSONWebKey{
+		KeyID:     key.ID,
+		Use:       "sig",
+		Algorithm: key.Method.Alg(),
+	}
+	switch public := key.public.(type) {
+	case *rsa.PublicKey:
+		result.KeyType = "RSA"
+		result.N = encodeBigInt(public.N, 0)
+		result.E = encodeBigInt(big.NewInt(int64(public.E)), 0)
+	case *ecdsa.PublicKey:
+		result.KeyType = "EC"
+		result.Curve = "P-256"
+		result.X = encodeBigInt(public.X, ecCoordinateSize)
+		result.Y = encodeBigInt(public.Y, ecCoordinateSize)
+	}
+	return result
+}
+
+func encodeBigInt(i *big.Int, size int) string {
+	b := i.Bytes()
+	if len(b) < size {
+		padded := make([]byte, size)
+		copy(padded[size-len(b):], b)
+		b = padded
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
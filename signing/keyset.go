@@ -0,0 +1,214 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/elliptic"
+	"fmt"
+	"github.com/Sovianum/arquest-server/config"
+	"github.com/dgrijalva/jwt-go"
+	"io/ioutil"
+	"time"
+)
+
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+
+	kidHeader = "kid"
+)
+
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+// NewKey parses a PEM encoded RSA or P-256 private key for the given algorithm.
+func NewKey(id, alg string, pemData []byte) (*Key, error) {
+	if id == "" {
+		return nil, fmt.Errorf("signing key has no kid")
+	}
+
+	switch alg {
+	case RS256:
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", id, err)
+		}
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, private: private, public: &private.PublicKey}, nil
+
+	case ES256:
+		private, err := jwt.ParseECPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", id, err)
+		}
+		if private.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("key %s: ES256 requires a P-256 key", id)
+		}
+		return &Key{ID: id, Method: jwt.SigningMethodES256, private: private, public: &private.PublicKey}, nil
+
+	default:
+		return nil, fmt.Errorf("key %s: unsupported algorithm %q", id, alg)
+	}
+}
+
+// NewPublicKey parses a PEM encoded RSA or P-256 public key. Such a key only verifies
+// tokens, so it serves retired keys whose private part is already destroyed.
+func NewPublicKey(id, alg string, pemData []byte) (*Key, error) {
+	if id == "" {
+		return nil, fmt.Errorf("signing key has no kid")
+	}
+
+	switch alg {
+	case RS256:
+		public, err := jwt.ParseRSAPublicKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", id, err)
+		}
+		return &Key{ID: id, Method: jwt.SigningMethodRS256, public: public}, nil
+
+	case ES256:
+		public, err := jwt.ParseECPublicKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", id, err)
+		}
+		if public.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("key %s: ES256 requires a P-256 key", id)
+		}
+		return &Key{ID: id, Method: jwt.SigningMethodES256, public: public}, nil
+
+	default:
+		return nil, fmt.Errorf("key %s: unsupported algorithm %q", id, alg)
+	}
+}
+
+// KeySet signs tokens with the active key and verifies tokens signed by any known key.
+// Without asymmetric keys it falls back to HS256 with the shared secret; once asymmetric
+// keys are configured HS256 tokens are only accepted until hmacUntil.
+type KeySet struct {
+	active    *Key
+	keys      map[string]*Key
+	order     []string
+	hmacKey   []byte
+	hmacUntil time.Time
+}
+
+func NewKeySet(conf config.AuthConfig) (*KeySet, error) {
+	keys := make([]*Key, 0, len(conf.SigningKeys))
+	for _, keyConf := range conf.SigningKeys {
+		key, err := loadKey(keyConf)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	var hmacUntil time.Time
+	if conf.HS256GraceUntil != "" {
+		var err error
+		if hmacUntil, err = time.Parse(time.RFC3339, conf.HS256GraceUntil); err != nil {
+			return nil, fmt.Errorf("invalid hs256_grace_until: %v", err)
+		}
+	}
+	return NewKeySetFromKeys(conf.ActiveKeyID, keys, conf.GetTokenKey(), hmacUntil)
+}
+
+// loadKey reads the key from exactly one of its private and public key files.
+func loadKey(conf config.SigningKeyConfig) (*Key, error) {
+	if (conf.PrivateKeyFile == "") == (conf.PublicKeyFile == "") {
+		return nil, fmt.Errorf("key %s: exactly one of private_key_file and public_key_file required", conf.ID)
+	}
+	newKey, file := NewKey, conf.PrivateKeyFile
+	if conf.PublicKeyFile != "" {
+		newKey, file = NewPublicKey, conf.PublicKeyFile
+	}
+	pemData, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %v", conf.ID, err)
+	}
+	return newKey(conf.ID, conf.Algorithm, pemData)
+}
+
+func NewKeySetFromKeys(activeID string, keys []*Key, hmacKey []byte, hmacUntil time.Time) (*KeySet, error) {
+	result := &KeySet{
+		keys:      make(map[string]*Key),
+		hmacKey:   hmacKey,
+		hmacUntil: hmacUntil,
+	}
+	for _, key := range keys {
+		if _, ok := result.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate kid %s", key.ID)
+		}
+		result.keys[key.ID] = key
+		result.order = append(result.order, key.ID)
+	}
+
+	if activeID != "" {
+		active, ok := result.keys[activeID]
+		if !ok {
+			return nil, fmt.Errorf("active key %s is not configured", activeID)
+		}
+		if active.private == nil {
+			return nil, fmt.Errorf("active key %s has no private key", activeID)
+		}
+		result.active = active
+	} else if len(keys) > 0 {
+		return nil, fmt.Errorf("signing keys are configured but none is active")
+	}
+
+	if result.active == nil && len(hmacKey) == 0 {
+		return nil, fmt.Errorf("neither signing keys nor token key are configured")
+	}
+	return result, nil
+}
+
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if ks.active == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ks.hmacKey)
+	}
+	token := jwt.NewWithClaims(ks.active.Method, claims)
+	token.Header[kidHeader] = ks.active.ID
+	return token.SignedString(ks.active.private)
+}
+
+// Keyfunc is passed to jwt.Parse to pick the verification key of a token.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if !ks.hmacAllowed(time.Now()) {
+			return nil, fmt.Errorf("HS256 tokens are no longer accepted")
+		}
+		if token.Method != jwt.SigningMethodHS256 {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return ks.hmacKey, nil
+	}
+
+	kid, _ := token.Header[kidHeader].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %v for key %s", token.Header["alg"], kid)
+	}
+	return key.public, nil
+}
+
+func (ks *KeySet) hmacAllowed(now time.Time) bool {
+	if len(ks.hmacKey) == 0 {
+		return false
+	}
+	if ks.active == nil {
+		return true
+	}
+	return now.Before(ks.hmacUntil)
+}
+
+func (ks *KeySet) JWKS() JSONWebKeySet {
+	result := JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(ks.order))}
+	for _, id := range ks.order {
+		result.Keys = append(result.Keys, newJSONWebKey(ks.keys[id]))
+	}
+	return result
+}
@@ -0,0 +1,238 @@
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/Sovianum/arquest-server/config"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/suite"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+)
+
+const (
+	hmacSecret = "secret"
+)
+
+type KeySetTestSuite struct {
+	suite.Suite
+	rsaPEM       []byte
+	rsaPublicPEM []byte
+	ecPEM        []byte
+	rsaKey       *Key
+	ecKey        *Key
+}
+
+func (s *KeySetTestSuite) SetupSuite() {
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	s.Require().NoError(err)
+	s.rsaPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaPrivate)})
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaPrivate.PublicKey)
+	s.Require().NoError(err)
+	s.rsaPublicPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaPublic})
+
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	s.Require().NoError(err)
+	ecBytes, err := x509.MarshalECPrivateKey(ecPrivate)
+	s.Require().NoError(err)
+	s.ecPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecBytes})
+
+	s.rsaKey, err = NewKey("rsa-1", RS256, s.rsaPEM)
+	s.Require().NoError(err)
+	s.ecKey, err = NewKey("ec-1", ES256, s.ecPEM)
+	s.Require().NoError(err)
+}
+
+func (s *KeySetTestSuite) claims() jwt.MapClaims {
+	return jwt.MapClaims{"id": 1, "exp": time.Now().Add(time.Minute).Unix()}
+}
+
+func (s *KeySetTestSuite) TestSignVerifyActive() {
+	for _, active := range []string{s.rsaKey.ID, s.ecKey.ID} {
+		ks, err := NewKeySetFromKeys(active, []*Key{s.rsaKey, s.ecKey}, nil, time.Time{})
+		s.Require().NoError(err)
+
+		tokenString, err := ks.Sign(s.claims())
+		s.Require().NoError(err)
+
+		token, err := jwt.Parse(tokenString, ks.Keyfunc)
+		s.Require().NoError(err)
+		s.Equal(active, token.Header["kid"])
+	}
+}
+
+func (s *KeySetTestSuite) TestRetiredKeyStillVerifies() {
+	old, err := NewKeySetFromKeys(s.rsaKey.ID, []*Key{s.rsaKey}, nil, time.Time{})
+	s.Require().NoError(err)
+	tokenString, err := old.Sign(s.claims())
+	s.Require().NoError(err)
+
+	rotated, err := NewKeySetFromKeys(s.ecKey.ID, []*Key{s.ecKey, s.rsaKey}, nil, time.Time{})
+	s.Require().NoError(err)
+	_, err = jwt.Parse(tokenString, rotated.Keyfunc)
+	s.NoError(err)
+
+	removed, err := NewKeySetFromKeys(s.ecKey.ID, []*Key{s.ecKey}, nil, time.Time{})
+	s.Require().NoError(err)
+	_, err = jwt.Parse(tokenString, removed.Keyfunc)
+	s.Error(err)
+}
+
+func (s *KeySetTestSuite) TestHMACFallback() {
+	ks, err := NewKeySetFromKeys("", nil, []byte(hmacSecret), time.Time{})
+	s.Require().NoError(err)
+
+	tokenString, err := ks.Sign(s.claims())
+	s.Require().NoError(err)
+	token, err := jwt.Parse(tokenString, ks.Keyfunc)
+	s.Require().NoError(err)
+	s.Equal(jwt.SigningMethodHS256, token.Method)
+}
+
+func (s *KeySetTestSuite) TestHMACGraceWindow() {
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, s.claims()).SignedString([]byte(hmacSecret))
+	s.Require().NoError(err)
+
+	inGrace, err := NewKeySetFromKeys(s.rsaKey.ID, []*Key{s.rsaKey}, []byte(hmacSecret), time.Now().Add(time.Hour))
+	s.Require().NoError(err)
+	_, err = jwt.Parse(legacy, inGrace.Keyfunc)
+	s.NoError(err)
+
+	afterGrace, err := NewKeySetFromKeys(s.rsaKey.ID, []*Key{s.rsaKey}, []byte(hmacSecret), time.Now().Add(-time.Hour))
+	s.Require().NoError(err)
+	_, err = jwt.Parse(legacy, afterGrace.Keyfunc)
+	s.Error(err)
+}
+
+func (s *KeySetTestSuite) TestAlgorithmMismatch() {
+	ks, err := NewKeySetFromKeys(s.rsaKey.ID, []*Key{s.rsaKey}, nil, time.Time{})
+	s.Require().NoError(err)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS512, s.claims())
+	token.Header["kid"] = s.rsaKey.ID
+	rsaPrivate, _ := jwt.ParseRSAPrivateKeyFromPEM(s.rsaPEM)
+	tokenString, err := token.SignedString(rsaPrivate)
+	s.Require().NoError(err)
+
+	_, err = jwt.Parse(tokenString, ks.Keyfunc)
+	s.Error(err)
+}
+
+func (s *KeySetTestSuite) TestInvalidConfigurations() {
+	_, err := NewKeySetFromKeys("missing", []*Key{s.rsaKey}, nil, time.Time{})
+	s.Error(err)
+
+	_, err = NewKeySetFromKeys("", []*Key{s.rsaKey}, nil, time.Time{})
+	s.Error(err)
+
+	_, err = NewKeySetFromKeys("", nil, nil, time.Time{})
+	s.Error(err)
+
+	_, err = NewKeySetFromKeys(s.rsaKey.ID, []*Key{s.rsaKey, s.rsaKey}, nil, time.Time{})
+	s.Error(err)
+
+	_, err = NewKey("rsa", ES256, s.rsaPEM)
+	s.Error(err)
+
+	_, err = NewKey("rsa", "HS256", s.rsaPEM)
+	s.Error(err)
+}
+
+func (s *KeySetTestSuite) TestFromConfig() {
+	f, err := ioutil.TempFile("", "key")
+	s.Require().NoError(err)
+	defer os.Remove(f.Name())
+	f.Write(s.ecPEM)
+	f.Close()
+
+	ks, err := NewKeySet(config.AuthConfig{
+		TokenKey:        hmacSecret,
+		SigningKeys:     []config.SigningKeyConfig{{ID: "ec", Algorithm: ES256, PrivateKeyFile: f.Name()}},
+		ActiveKeyID:     "ec",
+		HS256GraceUntil: "2100-01-01T00:00:00Z",
+	})
+	s.Require().NoError(err)
+	s.True(ks.hmacAllowed(time.Now()))
+
+	_, err = NewKeySet(config.AuthConfig{TokenKey: hmacSecret, HS256GraceUntil: "tomorrow"})
+	s.Error(err)
+}
+
+func (s *KeySetTestSuite) tempFile(data []byte) string {
+	f, err := ioutil.TempFile("", "key")
+	s.Require().NoError(err)
+	f.Write(data)
+	f.Close()
+	return f.Name()
+}
+
+func (s *KeySetTestSuite) TestFromConfigRetiredPublicKey() {
+	ecFile, rsaFile := s.tempFile(s.ecPEM), s.tempFile(s.rsaPublicPEM)
+	defer os.Remove(ecFile)
+	defer os.Remove(rsaFile)
+
+	old, err := NewKeySetFromKeys(s.rsaKey.ID, []*Key{s.rsaKey}, nil, time.Time{})
+	s.Require().NoError(err)
+	oldToken, err := old.Sign(s.claims())
+	s.Require().NoError(err)
+
+	keys := []config.SigningKeyConfig{
+		{ID: "ec", Algorithm: ES256, PrivateKeyFile: ecFile},
+		{ID: s.rsaKey.ID, Algorithm: RS256, PublicKeyFile: rsaFile},
+	}
+	ks, err := NewKeySet(config.AuthConfig{SigningKeys: keys, ActiveKeyID: "ec"})
+	s.Require().NoError(err)
+	s.Nil(ks.keys[s.rsaKey.ID].private)
+
+	_, err = jwt.Parse(oldToken, ks.Keyfunc)
+	s.NoError(err)
+	tokenString, err := ks.Sign(s.claims())
+	s.Require().NoError(err)
+	token, err := jwt.Parse(tokenString, ks.Keyfunc)
+	s.Require().NoError(err)
+	s.Equal("ec", token.Header["kid"])
+	s.Len(ks.JWKS().Keys, 2)
+
+	_, err = NewKeySet(config.AuthConfig{SigningKeys: keys, ActiveKeyID: s.rsaKey.ID})
+	s.Error(err)
+
+	keys[1].PrivateKeyFile = ecFile
+	_, err = NewKeySet(config.AuthConfig{SigningKeys: keys, ActiveKeyID: "ec"})
+	s.Error(err)
+}
+
+func (s *KeySetTestSuite) TestJWKS() {
+	ks, err := NewKeySetFromKeys(s.rsaKey.ID, []*Key{s.rsaKey, s.ecKey}, nil, time.Time{})
+	s.Require().NoError(err)
+
+	jwks := ks.JWKS()
+	s.Require().Len(jwks.Keys, 2)
+
+	rsaJWK := jwks.Keys[0]
+	s.Equal("RSA", rsaJWK.KeyType)
+	s.Equal("rsa-1", rsaJWK.KeyID)
+	s.Equal(RS256, rsaJWK.Algorithm)
+	n, err := base64.RawURLEncoding.DecodeString(rsaJWK.N)
+	s.Require().NoError(err)
+	s.Equal(0, new(big.Int).SetBytes(n).Cmp(s.rsaKey.public.(*rsa.PublicKey).N))
+	s.Equal("AQAB", rsaJWK.E)
+
+	ecJWK := jwks.Keys[1]
+	s.Equal("EC", ecJWK.KeyType)
+	s.Equal("P-256", ecJWK.Curve)
+	x, err := base64.RawURLEncoding.DecodeString(ecJWK.X)
+	s.Require().NoError(err)
+	s.Len(x, 32)
+}
+
+func TestKeySetTestSuite(t *testing.T) {
+	suite.Run(t, new(KeySetTestSuite))
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type fsStorage struct {
+	dir     string
+	baseURL string
+}
+
+// NewFS stores objects as files in dir; URL is baseURL followed by the key,
+// so dir is expected to be served under baseURL.
+func NewFS(dir, baseURL string) (Storage, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("storage directory is not set")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fsStorage{dir: dir, baseURL: baseURL}, nil
+}
+
+func (s *fsStorage) Put(key string, r io.ReadSeeker, size int64) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(s.dir, ".put-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once the file is renamed
+	defer tmp.Close()
+
+	if n, err := io.Copy(tmp, io.LimitReader(r, size)); err != nil {
+		return err
+	} else if n != size {
+		return fmt.Errorf("expected %d bytes, got %d", size, n)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *fsStorage) Open(key string) (Object, Info, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, Info{}, ErrNotFound
+	} else if err != nil {
+		return nil, Info{}, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Info{}, err
+	}
+	return f, Info{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (s *fsStorage) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fsStorage) URL(key string) (string, error) {
+	return s.baseURL + key, nil
+}
+
+// path maps a key to a file under dir. Keys are slash separated; segments starting
+// with a dot are rejected, which also keeps keys away from temporary files.
+func (s *fsStorage) path(key string) (string, error) {
+	if strings.ContainsAny(key, "\\\x00") {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || strings.HasPrefix(segment, ".") {
+			return "", fmt.Errorf("invalid storage key %q", key)
+		}
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/Sovianum/arquest-server/config"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	maxErrorBody = 1 << 10
+)
+
+type s3Storage struct {
+	client     *http.Client
+	endpoint   *url.URL
+	bucket     string
+	prefix     string
+	pathStyle  bool
+	presignTTL time.Duration
+	signer     signer
+	now        func() time.Time
+}
+
+// NewS3 talks to an S3-compatible service with plain HTTP requests signed by Signature Version 4.
+func NewS3(conf config.S3StorageConfig) (Storage, error) {
+	if conf.Bucket == "" {
+		return nil, fmt.Errorf("s3 bucket is not set")
+	}
+	endpoint, err := url.Parse(conf.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %v", err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %q", conf.Endpoint)
+	}
+	return &s3Storage{
+		client:     http.DefaultClient,
+		endpoint:   endpoint,
+		bucket:     conf.Bucket,
+		prefix:     conf.Prefix,
+		pathStyle:  conf.PathStyle,
+		presignTTL: conf.GetPresignTTL(),
+		signer:     signer{accessKey: conf.AccessKey, secretKey: conf.SecretKey, region: conf.Region},
+		now:        time.Now,
+	}, nil
+}
+
+func (s *s3Storage) Put(key string, r io.ReadSeeker, size int64) error {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.LimitReader(r, size)); err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(key).String(), ioutil.NopCloser(io.LimitReader(r, size)))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := s.do(req, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Storage) Open(key string) (Object, Info, error) {
+	req, err := http.NewRequest(http.MethodHead, s.objectURL(key).String(), nil)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	resp.Body.Close()
+
+	info := Info{Size: resp.ContentLength}
+	if modTime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = modTime
+	}
+	return &s3Object{storage: s, key: key, size: info.Size}, info, nil
+}
+
+func (s *s3Storage) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(key).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req, emptyPayloadHash)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Storage) URL(key string) (string, error) {
+	return s.signer.presign(http.MethodGet, s.objectURL(key), s.presignTTL, s.now()).String(), nil
+}
+
+func (s *s3Storage) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	objectPath := s.prefix + key
+	if s.pathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + objectPath
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + objectPath
+	}
+	u.RawPath = ""
+	return &u
+}
+
+// do signs and sends req. Responses other than 2xx are closed and turned into errors.
+func (s *s3Storage) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	s.signer.sign(req, payloadHash, s.now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	return nil, fmt.Errorf("s3 %s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+}
+
+// s3Object reads an object lazily with ranged GET requests, restarting the request after seeks.
+type s3Object struct {
+	storage *s3Storage
+	key     string
+	size    int64
+	pos     int64
+	body    io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.pos >= o.size {
+		return 0, io.EOF
+	}
+	if o.body == nil {
+		req, err := http.NewRequest(http.MethodGet, o.storage.objectURL(o.key).String(), nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", o.pos))
+		resp, err := o.storage.do(req, emptyPayloadHash)
+		if err != nil {
+			return 0, err
+		}
+		if resp.StatusCode != http.StatusPartialContent && !(resp.StatusCode == http.StatusOK && o.pos == 0) {
+			resp.Body.Close()
+			return 0, fmt.Errorf("s3 ignored range request for %s", o.key)
+		}
+		o.body = resp.Body
+	}
+	n, err := o.body.Read(p)
+	o.pos += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = o.pos + offset
+	case io.SeekEnd:
+		pos = o.size + offset
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if pos < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	if pos != o.pos {
+		o.closeBody()
+		o.pos = pos
+	}
+	return pos, nil
+}
+
+func (o *s3Object) Close() error {
+	return o.closeBody()
+}
+
+func (o *s3Object) closeBody() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
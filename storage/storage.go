@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Sovianum/arquest-server/config"
+	"io"
+	"time"
+)
+
+const (
+	FSType = "fs"
+	S3Type = "s3"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Object is an opened stored object. Seeking is cheap, so it can be passed to http.ServeContent.
+type Object interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage keeps quest assets under flat string keys.
+type Storage interface {
+	// Put stores size bytes of r under key, replacing the previous object atomically.
+	Put(key string, r io.ReadSeeker, size int64) error
+	// Open returns ErrNotFound if there is no object under key.
+	Open(key string) (Object, Info, error)
+	// Delete does not fail if there is no object under key.
+	Delete(key string) error
+	// URL returns the address clients download the object from. It may expire.
+	URL(key string) (string, error)
+}
+
+func New(conf config.StorageConfig) (Storage, error) {
+	switch conf.Type {
+	case FSType, "":
+		return NewFS(conf.FS.Dir, conf.FS.BaseURL)
+	case S3Type:
+		return NewS3(conf.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", conf.Type)
+	}
+}
@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"bytes"
+	"github.com/Sovianum/arquest-server/config"
+	"github.com/Sovianum/arquest-server/storage/s3test"
+	"github.com/stretchr/testify/suite"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	bucket    = "quests"
+	accessKey = "access"
+	content   = "0123456789abcdef"
+)
+
+// StorageTestSuite runs the same checks against every backend.
+type StorageTestSuite struct {
+	suite.Suite
+	newStorage func() Storage
+	storage    Storage
+	cleanup    func()
+}
+
+func (s *StorageTestSuite) SetupTest() {
+	s.storage = s.newStorage()
+}
+
+func (s *StorageTestSuite) TearDownTest() {
+	s.cleanup()
+}
+
+func (s *StorageTestSuite) put(key, data string) {
+	s.Require().NoError(s.storage.Put(key, strings.NewReader(data), int64(len(data))))
+}
+
+func (s *StorageTestSuite) TestPutOpen() {
+	s.put("1", content)
+
+	obj, info, err := s.storage.Open("1")
+	s.Require().NoError(err)
+	defer obj.Close()
+	s.Equal(int64(len(content)), info.Size)
+	s.False(info.ModTime.IsZero())
+
+	data, err := ioutil.ReadAll(obj)
+	s.Require().NoError(err)
+	s.Equal(content, string(data))
+}
+
+func (s *StorageTestSuite) TestReplace() {
+	s.put("1", content)
+	s.put("1", "new")
+
+	obj, info, err := s.storage.Open("1")
+	s.Require().NoError(err)
+	defer obj.Close()
+	data, _ := ioutil.ReadAll(obj)
+	s.Equal("new", string(data))
+	s.Equal(int64(3), info.Size)
+}
+
+func (s *StorageTestSuite) TestSeek() {
+	s.put("1", content)
+
+	obj, _, err := s.storage.Open("1")
+	s.Require().NoError(err)
+	defer obj.Close()
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(obj, buf)
+	s.Require().NoError(err)
+	s.Equal("0123", string(buf))
+
+	pos, err := obj.Seek(10, io.SeekStart)
+	s.Require().NoError(err)
+	s.Equal(int64(10), pos)
+	_, err = io.ReadFull(obj, buf)
+	s.Require().NoError(err)
+	s.Equal("abcd", string(buf))
+
+	pos, err = obj.Seek(-2, io.SeekEnd)
+	s.Require().NoError(err)
+	s.Equal(int64(len(content)-2), pos)
+	rest, err := ioutil.ReadAll(obj)
+	s.Require().NoError(err)
+	s.Equal("ef", string(rest))
+}
+
+func (s *StorageTestSuite) TestNestedKey() {
+	s.put("1/v2/scene.json", "{}")
+
+	obj, _, err := s.storage.Open("1/v2/scene.json")
+	s.Require().NoError(err)
+	obj.Close()
+}
+
+func (s *StorageTestSuite) TestNotFound() {
+	_, _, err := s.storage.Open("missing")
+	s.Equal(ErrNotFound, err)
+}
+
+func (s *StorageTestSuite) TestDelete() {
+	s.put("1", content)
+	s.Require().NoError(s.storage.Delete("1"))
+
+	_, _, err := s.storage.Open("1")
+	s.Equal(ErrNotFound, err)
+	s.NoError(s.storage.Delete("1"))
+}
+
+func (s *StorageTestSuite) TestURL() {
+	_, err := s.storage.URL("1")
+	s.NoError(err)
+}
+
+func TestFSStorage(t *testing.T) {
+	var dir string
+	suite.Run(t, &StorageTestSuite{
+		newStorage: func() Storage {
+			var err error
+			dir, err = ioutil.TempDir("", "storage")
+			if err != nil {
+				t.Fatal(err)
+			}
+			storage, err := NewFS(dir, "/data/quests/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return storage
+		},
+		cleanup: func() { os.RemoveAll(dir) },
+	})
+}
+
+func TestS3Storage(t *testing.T) {
+	var server *s3test.Server
+	suite.Run(t, &StorageTestSuite{
+		newStorage: func() Storage {
+			server = s3test.NewServer(bucket, accessKey)
+			storage, err := NewS3(s3Conf(server.URL))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return storage
+		},
+		cleanup: func() { server.Close() },
+	})
+}
+
+func s3Conf(endpoint string) config.S3StorageConfig {
+	return config.S3StorageConfig{
+		Endpoint:  endpoint,
+		Region:    "us-east-1",
+		Bucket:    bucket,
+		Prefix:    "assets/",
+		AccessKey: accessKey,
+		SecretKey: "secret",
+		PathStyle: true,
+	}
+}
+
+func TestFSRejectsUnsafeKeys(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "storage")
+	defer os.RemoveAll(dir)
+	storage, _ := NewFS(dir, "/data/quests/")
+
+	for _, key := range []string{"", "../1", "a/../../1", ".put-1", "/1", "a//b", `a\b`} {
+		if err := storage.Put(key, bytes.NewReader(nil), 0); err == nil {
+			t.Errorf("key %q accepted", key)
+		}
+	}
+}
+
+func TestFSURL(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "storage")
+	defer os.RemoveAll(dir)
+	storage, _ := NewFS(dir, "/data/quests/")
+
+	u, err := storage.URL("3")
+	if err != nil || u != "/data/quests/3" {
+		t.Errorf("unexpected url %q, %v", u, err)
+	}
+}
+
+func TestS3PresignedURL(t *testing.T) {
+	server := s3test.NewServer(bucket, accessKey)
+	defer server.Close()
+	storage, _ := NewS3(s3Conf(server.URL))
+	if err := storage.Put("3", strings.NewReader(content), int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Object("assets/3"); !ok {
+		t.Fatal("object is not stored under the prefix")
+	}
+
+	presigned, err := storage.URL("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(presigned)
+	if u.Query().Get("X-Amz-Expires") != "3600" {
+		t.Errorf("unexpected expiration %q", u.Query().Get("X-Amz-Expires"))
+	}
+
+	resp, err := http.Get(presigned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(data) != content {
+		t.Errorf("unexpected response %d %q", resp.StatusCode, data)
+	}
+
+	s3Storage := storage.(*s3Storage)
+	s3Storage.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	expired, _ := storage.URL("3")
+	resp, err = http.Get(expired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expired url accepted: %d", resp.StatusCode)
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	if _, err := New(config.StorageConfig{Type: "ftp"}); err == nil {
+		t.Error("unknown storage type accepted")
+	}
+	if _, err := New(config.StorageConfig{Type: S3Type, S3: config.S3StorageConfig{Endpoint: "http://localhost"}}); err == nil {
+		t.Error("s3 storage without bucket accepted")
+	}
+}
@@ -2,6 +2,12 @@ package utils
 
 import "flag"
 
+const (
+	MigrateCommand = "migrate"
+	RoleCommand    = "role"
+	RatingCommand  = "rating"
+)
+
 func NewFlags(defaultConfig string) *Flags {
 	return &Flags{
 		defaultConfig: defaultConfig,
@@ -10,10 +16,22 @@ func NewFlags(defaultConfig string) *Flags {
 
 type Flags struct {
 	Config        string
+	Command       string
+	CommandArgs   []string
 	defaultConfig string
 }
 
+// Parse reads flags followed by an optional subcommand, e.g. `-c ard.conf.json migrate up`.
 func (f *Flags) Parse() {
 	flag.StringVar(&f.Config, "c", f.defaultConfig, "path to config file")
+	flag.Usage = func() {
+		flag.CommandLine.Output().Write([]byte("Usage: main [-c config] [migrate up|down [steps]|status] [role grant|revoke <login> <role>] [rating recompute]\n"))
+		flag.PrintDefaults()
+	}
 	flag.Parse()
+
+	if args := flag.Args(); len(args) > 0 {
+		f.Command = args[0]
+		f.CommandArgs = args[1:]
+	}
 }